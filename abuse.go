@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// States of an abuse report
+const (
+	reportOpen      = "open"
+	reportDisabled  = "disabled"
+	reportDismissed = "dismissed"
+)
+
+// abuseReasons are the categories a report can pick
+var abuseReasons = []string{"malware", "phishing", "copyright", "illegal", "other"}
+
+// Bounds on what one report may contain
+const (
+	maxReportDetails  = 2000
+	maxReportContact  = 200
+	maxOpenReportsPer = 20
+)
+
+// abuseReport is a complaint about a shared download link
+type abuseReport struct {
+	ID         string     `json:"id"`
+	Archive    string     `json:"archive"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Contact    string     `json:"contact,omitempty"`
+	ReporterIP string     `json:"reporterIp"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	State      string     `json:"state"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+
+	// Creator of the reported archive, for banning
+	Owner     string `json:"owner,omitempty"`
+	CreatorIP string `json:"creatorIp,omitempty"`
+	APIKey    string `json:"apiKey,omitempty"`
+}
+
+// ban blocks a client address or API key from creating archives
+type ban struct {
+	Kind      string    `json:"kind"` // "ip" or "key"
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// abuseState is the on-disk form of the reports and bans
+type abuseState struct {
+	Reports map[string]abuseReport `json:"reports"`
+	Bans    map[string]ban         `json:"bans"`
+}
+
+var (
+	abuseReports = make(map[string]abuseReport)
+	bans         = make(map[string]ban)
+	abuseMutex   = &sync.Mutex{}
+)
+
+var errReportNotFound = errors.New("report not found")
+
+// abuseStatePath is the file reports and bans are kept in
+func abuseStatePath() string {
+	return filepath.Join(config.DataDir, "abuse.json")
+}
+
+// banKey is the map key of a ban
+func banKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// saveAbuseState writes reports and bans so they survive a restart
+func saveAbuseState() {
+	abuseMutex.Lock()
+	data, err := json.MarshalIndent(abuseState{Reports: abuseReports, Bans: bans}, "", "  ")
+	abuseMutex.Unlock()
+	if err == nil {
+		err = os.MkdirAll(config.DataDir, 0o750)
+	}
+	if err == nil {
+		tmp := abuseStatePath() + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, abuseStatePath())
+		}
+	}
+	if err != nil {
+		log.Printf("Could not save abuse reports: %v", err)
+	}
+}
+
+// loadAbuseState restores reports and bans from the data directory
+func loadAbuseState() error {
+	data, err := os.ReadFile(abuseStatePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading abuse reports: %w", err)
+	}
+	var state abuseState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing abuse reports: %w", err)
+	}
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	for id, r := range state.Reports {
+		abuseReports[id] = r
+	}
+	for k, b := range state.Bans {
+		bans[k] = b
+	}
+	log.Printf("Restored %d abuse reports and %d bans", len(abuseReports), len(bans))
+	return nil
+}
+
+// banned reports whether the request comes from a banned address or API key
+func banned(c echo.Context) bool {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	if _, ok := bans[banKey("ip", c.RealIP())]; ok {
+		return true
+	}
+	if key := currentAPIKey(c); key != "" {
+		_, ok := bans[banKey("key", key)]
+		return ok
+	}
+	return false
+}
+
+// refuseBanned is middleware that stops banned clients from creating
+// anything; downloads and the admin API are unaffected
+func refuseBanned(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || strings.HasPrefix(r.URL.Path, "/admin/") {
+			return next(c)
+		}
+		if !banned(c) {
+			return next(c)
+		}
+		log.Printf("Refused %s %s from banned client %s", r.Method, r.URL.Path, c.RealIP())
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			return echo.NewHTTPError(http.StatusForbidden, "This address or API key has been blocked")
+		}
+		return htmlError(c, http.StatusForbidden, "Error: This address or API key has been blocked")
+	}
+}
+
+// handleReport shows the abuse report form for a shared link and records
+// the report it posts
+func handleReport(c echo.Context) error {
+	if c.Request().Method == http.MethodGet && c.QueryParam("reported") != "" {
+		return renderPage(c, "report.html")
+	}
+	name, rec, ok := sharedArchive(c.Param("token"))
+	if !ok && rec.Disabled == "" {
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+	if c.Request().Method == http.MethodGet {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return renderPage(c, "report.html")
+	}
+
+	reason := c.FormValue("reason")
+	if !slices.Contains(abuseReasons, reason) {
+		return htmlError(c, http.StatusBadRequest, "Error: Please choose a reason")
+	}
+	details := strings.TrimSpace(c.FormValue("details"))
+	contact := strings.TrimSpace(c.FormValue("contact"))
+	if len(details) > maxReportDetails || len(contact) > maxReportContact {
+		return htmlError(c, http.StatusBadRequest, "Error: Report too long")
+	}
+
+	abuseMutex.Lock()
+	open := 0
+	for _, r := range abuseReports {
+		if r.Archive == name && r.State == reportOpen {
+			open++
+		}
+	}
+	if open >= maxOpenReportsPer {
+		abuseMutex.Unlock()
+		log.Printf("Dropping report for %s: %d reports already open", name, open)
+		return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?reported=1")
+	}
+	report := abuseReport{
+		ID:         newJobID(),
+		Archive:    name,
+		Reason:     reason,
+		Details:    details,
+		Contact:    contact,
+		ReporterIP: c.RealIP(),
+		CreatedAt:  time.Now(),
+		State:      reportOpen,
+		Owner:      rec.Owner,
+		CreatorIP:  rec.ClientIP,
+		APIKey:     rec.APIKey,
+	}
+	abuseReports[report.ID] = report
+	abuseMutex.Unlock()
+	saveAbuseState()
+
+	log.Printf("Abuse report %s for %s: %s", report.ID, name, reason)
+	recordAudit(auditEvent{Archive: name, Action: auditReported, ClientIP: c.RealIP(), Reason: reason})
+
+	if c.Request().Header.Get("HX-Request") != "" {
+		return htmlSuccess(c, "Thank you. The report has been sent to the operators.")
+	}
+	return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?reported=1")
+}
+
+// setArchiveDisabled disables or re-enables the link of a stored archive
+func setArchiveDisabled(name, reason string) bool {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	rec, ok := tempFileStore[name]
+	if !ok {
+		return false
+	}
+	rec.Disabled = reason
+	tempFileStore[name] = rec
+	return true
+}
+
+// resolveReport marks report id and every other open report about the same
+// archive with state
+func resolveReport(id, state string) (abuseReport, error) {
+	abuseMutex.Lock()
+	defer abuseMutex.Unlock()
+	report, ok := abuseReports[id]
+	if !ok {
+		return abuseReport{}, errReportNotFound
+	}
+	now := time.Now()
+	for rid, r := range abuseReports {
+		if rid == id || (r.Archive == report.Archive && r.State == reportOpen) {
+			r.State, r.ResolvedAt = state, &now
+			abuseReports[rid] = r
+		}
+	}
+	return abuseReports[id], nil
+}
+
+// handleAdminListReports lists abuse reports, newest first, optionally
+// filtered by state
+func handleAdminListReports(c echo.Context) error {
+	state := c.QueryParam("state")
+	abuseMutex.Lock()
+	out := make([]abuseReport, 0, len(abuseReports))
+	for _, r := range abuseReports {
+		if state == "" || r.State == state {
+			out = append(out, r)
+		}
+	}
+	abuseMutex.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return c.JSON(http.StatusOK, out)
+}
+
+// disableRequest is the optional body of a takedown
+type disableRequest struct {
+	// Also block the creator's address and API key from creating archives
+	Ban    bool   `json:"ban"`
+	Reason string `json:"reason"`
+}
+
+// handleAdminDisableReport takes down the reported link, optionally banning
+// whoever created it
+func handleAdminDisableReport(c echo.Context) error {
+	var req disableRequest
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body")
+		}
+	}
+	report, err := resolveReport(c.Param("id"), reportDisabled)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = report.Reason
+	}
+	if !setArchiveDisabled(report.Archive, reason) {
+		log.Printf("Reported archive %s is already gone", report.Archive)
+	}
+
+	if req.Ban {
+		abuseMutex.Lock()
+		now := time.Now()
+		if report.CreatorIP != "" {
+			bans[banKey("ip", report.CreatorIP)] = ban{Kind: "ip", Value: report.CreatorIP, Reason: reason, CreatedAt: now}
+		}
+		if report.APIKey != "" {
+			bans[banKey("key", report.APIKey)] = ban{Kind: "key", Value: report.APIKey, Reason: reason, CreatedAt: now}
+		}
+		abuseMutex.Unlock()
+	}
+	saveAbuseState()
+
+	log.Printf("Link to %s disabled after report %s (ban: %t)", report.Archive, report.ID, req.Ban)
+	recordAudit(auditEvent{Archive: report.Archive, Action: auditDisabled, User: currentUser(c), ClientIP: c.RealIP(), Reason: reason})
+	return c.JSON(http.StatusOK, report)
+}
+
+// handleAdminDismissReport closes a report without acting on the link
+func handleAdminDismissReport(c echo.Context) error {
+	report, err := resolveReport(c.Param("id"), reportDismissed)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	saveAbuseState()
+	return c.JSON(http.StatusOK, report)
+}
+
+// handleAdminEnableArchive brings a disabled link back
+func handleAdminEnableArchive(c echo.Context) error {
+	id := c.Param("id")
+	if !setArchiveDisabled(id, "") {
+		return echo.NewHTTPError(http.StatusNotFound, "archive not found")
+	}
+	log.Printf("Link to %s enabled again", id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleAdminListBans lists the blocked addresses and API keys
+func handleAdminListBans(c echo.Context) error {
+	abuseMutex.Lock()
+	out := make([]ban, 0, len(bans))
+	for _, b := range bans {
+		out = append(out, b)
+	}
+	abuseMutex.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleAdminAddBan blocks an address or API key by hand
+func handleAdminAddBan(c echo.Context) error {
+	var b ban
+	if err := c.Bind(&b); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body")
+	}
+	if (b.Kind != "ip" && b.Kind != "key") || b.Value == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, `kind must be "ip" or "key" and value is required`)
+	}
+	b.CreatedAt = time.Now()
+	abuseMutex.Lock()
+	bans[banKey(b.Kind, b.Value)] = b
+	abuseMutex.Unlock()
+	saveAbuseState()
+	return c.JSON(http.StatusCreated, b)
+}
+
+// handleAdminDeleteBan lifts a ban
+func handleAdminDeleteBan(c echo.Context) error {
+	key := banKey(c.Param("kind"), c.Param("value"))
+	abuseMutex.Lock()
+	_, ok := bans[key]
+	delete(bans, key)
+	abuseMutex.Unlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "ban not found")
+	}
+	saveAbuseState()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminArchive is the JSON representation of a stored archive
+type adminArchive struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+	AgeSeconds int64     `json:"ageSeconds"`
+}
+
+// adminPurgeResult summarizes a cleanup request
+type adminPurgeResult struct {
+	Deleted    []string `json:"deleted"`
+	FreedBytes int64    `json:"freedBytes"`
+}
+
+// requireAdmin guards the /admin routes. Callers are admitted with the admin
+// bearer token, or with a session whose SSO groups include an admin group.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok &&
+			config.AdminToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1 {
+			return next(c)
+		}
+		if isAdminSession(c) {
+			return next(c)
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "admin access required")
+	}
+}
+
+// isAdminSession reports whether the logged-in user belongs to an admin group
+func isAdminSession(c echo.Context) bool {
+	for _, g := range currentGroups(c) {
+		if slices.Contains(config.OIDCAdminGroups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdminListArchives lists every archive currently held on disk
+func handleAdminListArchives(c echo.Context) error {
+	now := time.Now()
+	archives := listArchives()
+	out := make([]adminArchive, 0, len(archives))
+	for _, a := range archives {
+		out = append(out, adminArchive{
+			ID:         a.Name,
+			Path:       a.Path,
+			Size:       a.Size,
+			CreatedAt:  a.CreatedAt,
+			ExpiresAt:  a.ExpiresAt,
+			AgeSeconds: int64(now.Sub(a.CreatedAt).Seconds()),
+		})
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleAdminDeleteArchive removes a single archive by its download name
+func handleAdminDeleteArchive(c echo.Context) error {
+	id := c.Param("id")
+	rec, ok := takeArchive(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "archive not found")
+	}
+
+	removeArchiveFile(id, rec.Path)
+	auditDeletion(c, id, "admin")
+	return c.JSON(http.StatusOK, adminPurgeResult{Deleted: []string{id}, FreedBytes: rec.Size})
+}
+
+// handleAdminPurgeArchives removes every archive older than the olderThan duration
+func handleAdminPurgeArchives(c echo.Context) error {
+	olderThan := c.QueryParam("olderThan")
+	if olderThan == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "olderThan is required, e.g. olderThan=1h")
+	}
+	age, err := time.ParseDuration(olderThan)
+	if err != nil || age < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "olderThan must be a duration such as 30m or 24h")
+	}
+
+	result := adminPurgeResult{Deleted: []string{}}
+	for _, a := range takeArchivesOlderThan(time.Now().Add(-age)) {
+		removeArchiveFile(a.Name, a.Path)
+		auditDeletion(c, a.Name, "admin purge")
+		result.Deleted = append(result.Deleted, a.Name)
+		result.FreedBytes += a.Size
+	}
+
+	log.Printf("Admin purge removed %d archives older than %s", len(result.Deleted), age)
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// reservedBytes tracks disk space promised to archives still being built,
+// so concurrent uploads can't each pass the check and overrun the budget together
+var (
+	reservedBytes int64
+	reserveMutex  = &sync.Mutex{}
+)
+
+// reserveDisk admits an upload of size bytes against the disk budget and the
+// free space in the temp directory. The returned release func must be called
+// once the archive is stored or abandoned.
+func reserveDisk(size int64) (release func(), err error) {
+	reserveMutex.Lock()
+	defer reserveMutex.Unlock()
+
+	if err := checkDiskAdmission(size + reservedBytes); err != nil {
+		return nil, err
+	}
+
+	reservedBytes += size
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			reserveMutex.Lock()
+			reservedBytes -= size
+			reserveMutex.Unlock()
+		})
+	}, nil
+}
+
+// checkDiskAdmission reports whether size more bytes can be written to the
+// temp volume with the most free space. Refusals are published as disk.low.
+func checkDiskAdmission(size int64) error {
+	err := diskAdmission(size)
+	if err != nil {
+		publish(event{Kind: eventDiskLow, Text: fmt.Sprintf("Refused to store %s: %v", bytes.Format(size), err), key: "disk"})
+	}
+	return err
+}
+
+// diskAdmission checks size more bytes against the disk budget and free space
+func diskAdmission(size int64) error {
+	if config.DiskBudget > 0 {
+		held := storedBytes()
+		if held+size > config.DiskBudget {
+			return fmt.Errorf("server storage is full (%s of %s in use), please try again later",
+				bytes.Format(held), bytes.Format(config.DiskBudget))
+		}
+	}
+
+	free, err := mostFreeDisk()
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not check free disk space: %w", err)
+	}
+	if free-size < config.MinFreeDisk {
+		return fmt.Errorf("not enough free disk space on the server for %s, please try again later",
+			bytes.Format(size))
+	}
+	return nil
+}
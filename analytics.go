@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// downloadEvent is one completed (or aborted) archive download
+type downloadEvent struct {
+	Archive   string    `json:"archive"`
+	Time      time.Time `json:"time"`
+	Bytes     int64     `json:"bytes"`
+	UserAgent string    `json:"userAgent"`
+	ClientIP  string    `json:"clientIp"`
+}
+
+// analytics keeps every download event in memory and appends it to a JSON
+// lines file in the data directory so reports survive restarts
+var (
+	analyticsEvents []downloadEvent
+	analyticsFile   *os.File
+	analyticsMutex  = &sync.Mutex{}
+)
+
+// analyticsPath is the events file inside the data directory
+func analyticsPath() string {
+	return filepath.Join(config.DataDir, "analytics.jsonl")
+}
+
+// openAnalytics loads previously recorded events and opens the file for appending
+func openAnalytics() error {
+	if err := os.MkdirAll(config.DataDir, 0o750); err != nil {
+		return fmt.Errorf("creating data dir: %w", err)
+	}
+
+	f, err := os.OpenFile(analyticsPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening analytics file: %w", err)
+	}
+
+	var events []downloadEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev downloadEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			log.Printf("Skipping malformed analytics line: %v", err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return fmt.Errorf("reading analytics file: %w", err)
+	}
+
+	analyticsMutex.Lock()
+	analyticsEvents = events
+	analyticsFile = f
+	analyticsMutex.Unlock()
+
+	log.Printf("Loaded %d analytics events from %s", len(events), analyticsPath())
+	return nil
+}
+
+// recordDownload stores a download event in memory and on disk
+func recordDownload(ev downloadEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Error encoding analytics event: %v", err)
+		return
+	}
+
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	analyticsEvents = append(analyticsEvents, ev)
+	if analyticsFile == nil {
+		return
+	}
+	if _, err := analyticsFile.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing analytics event: %v", err)
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// usageMonth aggregates download activity for one calendar month
+type usageMonth struct {
+	Month          string         `json:"month"`
+	Downloads      int            `json:"downloads"`
+	BytesServed    int64          `json:"bytesServed"`
+	UniqueArchives int            `json:"uniqueArchives"`
+	UserAgents     map[string]int `json:"userAgents"`
+}
+
+// usageArchive aggregates download activity for one archive
+type usageArchive struct {
+	Archive        string    `json:"archive"`
+	Downloads      int       `json:"downloads"`
+	BytesServed    int64     `json:"bytesServed"`
+	LastDownloaded time.Time `json:"lastDownloaded"`
+}
+
+// usageReport is the response of the usage report endpoint
+type usageReport struct {
+	Months   []usageMonth   `json:"months"`
+	Archives []usageArchive `json:"archives,omitempty"`
+}
+
+// handleUsageReport aggregates download events by month. The optional from and
+// to query parameters (YYYY-MM) bound the range; archives=true adds per-archive totals.
+func handleUsageReport(c echo.Context) error {
+	from, err := parseReportMonth(c.QueryParam("from"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be a month such as 2024-01")
+	}
+	to, err := parseReportMonth(c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "to must be a month such as 2024-12")
+	}
+
+	months := make(map[string]*usageMonth)
+	monthArchives := make(map[string]map[string]bool)
+	archives := make(map[string]*usageArchive)
+
+	analyticsMutex.Lock()
+	for _, ev := range analyticsEvents {
+		key := ev.Time.UTC().Format("2006-01")
+		if (from != "" && key < from) || (to != "" && key > to) {
+			continue
+		}
+
+		m, ok := months[key]
+		if !ok {
+			m = &usageMonth{Month: key, UserAgents: make(map[string]int)}
+			months[key] = m
+			monthArchives[key] = make(map[string]bool)
+		}
+		m.Downloads++
+		m.BytesServed += ev.Bytes
+		m.UserAgents[ev.UserAgent]++
+		monthArchives[key][ev.Archive] = true
+
+		a, ok := archives[ev.Archive]
+		if !ok {
+			a = &usageArchive{Archive: ev.Archive}
+			archives[ev.Archive] = a
+		}
+		a.Downloads++
+		a.BytesServed += ev.Bytes
+		if ev.Time.After(a.LastDownloaded) {
+			a.LastDownloaded = ev.Time
+		}
+	}
+	analyticsMutex.Unlock()
+
+	report := usageReport{Months: make([]usageMonth, 0, len(months))}
+	for key, m := range months {
+		m.UniqueArchives = len(monthArchives[key])
+		report.Months = append(report.Months, *m)
+	}
+	sort.Slice(report.Months, func(i, j int) bool {
+		return report.Months[i].Month < report.Months[j].Month
+	})
+
+	if c.QueryParam("archives") == "true" {
+		for _, a := range archives {
+			report.Archives = append(report.Archives, *a)
+		}
+		sort.Slice(report.Archives, func(i, j int) bool {
+			return report.Archives[i].LastDownloaded.After(report.Archives[j].LastDownloaded)
+		})
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// parseReportMonth validates an optional YYYY-MM query value
+func parseReportMonth(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	t, err := time.Parse("2006-01", v)
+	if err != nil {
+		return "", errors.New("invalid month")
+	}
+	return t.Format("2006-01"), nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// compressJobResponse acknowledges an archive job queued through the JSON API
+type compressJobResponse struct {
+	JobID     string `json:"jobId"`
+	StatusURL string `json:"statusUrl"`
+
+	// The caller's quota usage, when any quota applies to them
+	Quota *quotaStatusResponse `json:"quota,omitempty"`
+}
+
+// jobStatusResponse is a job snapshot plus its download link once it is done
+type jobStatusResponse struct {
+	job
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	LandingURL  string `json:"landingUrl,omitempty"`
+	DeleteURL   string `json:"deleteUrl,omitempty"`
+
+	// Archive size and link expiry while the archive is still stored
+	Size      int64      `json:"size,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// corsMiddleware lets pages on the BULK_CORS_ORIGINS origins call the /api
+// routes, answering preflight requests itself
+func corsMiddleware() echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		Skipper: func(c echo.Context) bool {
+			return !strings.HasPrefix(c.Request().URL.Path, "/api/")
+		},
+		AllowOrigins:     config.CORSOrigins,
+		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization, "X-Download-Password", "X-Upload-ID", headerIdempotencyKey, headerAPIKey, headerCaptchaToken},
+		ExposeHeaders:    config.CORSExposeHeaders,
+		AllowCredentials: config.CORSCredentials,
+		MaxAge:           int(config.CORSMaxAge.Seconds()),
+	})
+}
+
+// handleAPICompress queues an archive of the uploaded files and returns the
+// job to poll. Files are copied out of the request first, since the
+// multipart temp files disappear once the handler returns.
+func handleAPICompress(c echo.Context) error {
+	if n := c.Request().ContentLength; n > 0 {
+		release, err := reserveDisk(n)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		defer release()
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not process form data")
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No files selected")
+	}
+	failures := &batchFailures{strict: formBool(c, "strict", false)}
+	if files, err = failures.dropOversized(files); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "None of the files could be added")
+	}
+	if err := checkUploadLimits(files); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ttl, err := parseExpiry(c.FormValue("expires"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	comment := c.FormValue("comment")
+	if len(comment) > maxArchiveComment {
+		return echo.NewHTTPError(http.StatusBadRequest, "Archive comment too long")
+	}
+	note, err := parseNote(c.FormValue("note"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	priority, err := parsePriority(c.FormValue("priority"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	opts := archiveOptions{
+		Owner:    currentUser(c),
+		APIKey:   currentAPIKey(c),
+		Priority: priority,
+		ClientIP: c.RealIP(),
+		Password: c.FormValue("link_password"),
+		Dedup:    formBool(c, "dedup", config.DedupUploads),
+		Flatten:  formBool(c, "flatten", config.Flatten),
+		Comment:  comment,
+		Note:     note,
+		Metadata: formBool(c, "metadata", false),
+		TTL:      ttl,
+		Strict:   failures.strict,
+		Failed:   failures.results,
+	}
+
+	// A retry with the same Idempotency-Key gets the job the first attempt started
+	commit := func(string) {}
+	if key := c.Request().Header.Get(headerIdempotencyKey); key != "" {
+		if len(key) > maxIdempotencyKey {
+			return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key too long")
+		}
+		existing, claimed, err := claimIdempotencyKey(idempotencyScope(c, key), requestFingerprint(form))
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		case err != nil:
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case existing != "":
+			log.Printf("API job %s: replayed for a retried request", existing)
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return acceptedJob(c, existing)
+		}
+		commit = claimed
+	}
+
+	releaseQuota, err := admitQuota(c, c.Request().ContentLength)
+	if err != nil {
+		commit("")
+		return echo.NewHTTPError(quotaErrorStatus(err), err.Error())
+	}
+	entries, _, cleanup, err := collectSources(c.Request().Context(), []Source{uploadSource{files: files, spool: true}}, config.MaxUploadSize, failures.fail)
+	if err != nil {
+		commit("")
+		releaseQuota()
+		log.Printf("Error spooling API upload: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error storing uploaded data")
+	}
+
+	id := createJob(len(entries), opts)
+	commit(id)
+	log.Printf("API job %s: compressing %d files", id, len(entries))
+	go runArchiveJob(id, entries, opts, func() {
+		cleanup()
+		releaseQuota()
+	})
+	return acceptedJob(c, id)
+}
+
+// acceptedJob answers 202 Accepted pointing at the job's status URL
+func acceptedJob(c echo.Context, id string) error {
+	statusURL := "/api/v1/jobs/" + id
+	c.Response().Header().Set(echo.HeaderLocation, statusURL)
+	resp := compressJobResponse{JobID: id, StatusURL: statusURL}
+	if p := requestPrincipal(c); quotaFor(p) != (quotaLimits{}) {
+		status := quotaStatus(p)
+		resp.Quota = &status
+	}
+	return c.JSON(http.StatusAccepted, resp)
+}
+
+// spoolUploads copies uploaded files to TempDir and returns entries reading
+// them, with a cleanup func that deletes the copies
+func spoolUploads(files []*multipart.FileHeader) ([]archiveEntry, func(), error) {
+	var spooled []string
+	cleanup := func() {
+		for _, path := range spooled {
+			os.Remove(path)
+		}
+	}
+
+	entries := make([]archiveEntry, 0, len(files))
+	for _, fh := range files {
+		src, err := fh.Open()
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		dst, err := os.CreateTemp(spillDir(), "api-upload-*")
+		if err != nil {
+			src.Close()
+			cleanup()
+			return nil, nil, err
+		}
+		spooled = append(spooled, dst.Name())
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+
+		path := dst.Name()
+		entries = append(entries, archiveEntry{
+			Name: fh.Filename,
+			Size: fh.Size,
+			Open: func() (io.ReadCloser, error) { return os.Open(path) },
+		})
+	}
+	return entries, cleanup, nil
+}
+
+// handleAPIJobStatus reports the progress of a job, with the download and
+// deletion links once the archive is ready
+func handleAPIJobStatus(c echo.Context) error {
+	j, _, ok := getJob(c.Param("id"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Job %s not found", c.Param("id")))
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, jobStatus(j))
+}
+
+// jobStatus adds the archive's links, size and expiry to a job snapshot
+func jobStatus(j job) jobStatusResponse {
+	resp := jobStatusResponse{job: j}
+	if j.State == jobDone && j.Archive != "" {
+		resp.DownloadURL = downloadPath(j.Archive)
+		resp.LandingURL = landingPath(j.Archive)
+		resp.DeleteURL = deletePath(j.Archive)
+		if rec, ok := getArchive(j.Archive); ok {
+			resp.Size = rec.Size
+			if !rec.ExpiresAt.IsZero() {
+				resp.ExpiresAt = &rec.ExpiresAt
+			}
+		}
+	}
+	return resp
+}
+
+// Page sizes for job listings
+const (
+	defaultJobsPerPage = 20
+	maxJobsPerPage     = 100
+)
+
+// jobListResponse is one page of the caller's jobs
+type jobListResponse struct {
+	Jobs    []jobStatusResponse `json:"jobs"`
+	Page    int                 `json:"page"`
+	PerPage int                 `json:"perPage"`
+	Total   int                 `json:"total"`
+	NextURL string              `json:"nextUrl,omitempty"`
+}
+
+// handleAPIJobList returns the caller's jobs, newest first, optionally
+// filtered by state. Anonymous callers see the jobs started from their address.
+func handleAPIJobList(c echo.Context) error {
+	status := jobState(c.QueryParam("status"))
+	switch status {
+	case "", jobQueued, jobRunning, jobDone, jobFailed:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid status filter")
+	}
+	page, err := queryInt(c, "page", 1)
+	if err != nil || page < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+	}
+	perPage, err := queryInt(c, "perPage", defaultJobsPerPage)
+	if err != nil || perPage < 1 || perPage > maxJobsPerPage {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("perPage must be between 1 and %d", maxJobsPerPage))
+	}
+
+	owner, clientIP := currentUser(c), c.RealIP()
+	jobs := listJobs(func(j job) bool {
+		if j.owner != owner || (owner == "" && j.clientIP != clientIP) {
+			return false
+		}
+		return status == "" || j.State == status
+	})
+
+	// Checked before multiplying so a huge page can't overflow the offset. An
+	// empty list still has page 1.
+	lastPage := max(1, (len(jobs)+perPage-1)/perPage)
+	if page > lastPage {
+		return echo.NewHTTPError(http.StatusBadRequest, "Page is past the last page")
+	}
+
+	resp := jobListResponse{Jobs: []jobStatusResponse{}, Page: page, PerPage: perPage, Total: len(jobs)}
+	start := min((page-1)*perPage, len(jobs))
+	end := min(start+perPage, len(jobs))
+	for _, j := range jobs[start:end] {
+		resp.Jobs = append(resp.Jobs, jobStatus(j))
+	}
+	if end < len(jobs) {
+		next := c.Request().URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		resp.NextURL = c.Request().URL.Path + "?" + next.Encode()
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, resp)
+}
+
+// queryInt parses an integer query parameter, returning def when it is absent
+func queryInt(c echo.Context, name string, def int) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
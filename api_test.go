@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAPIJobListPages(t *testing.T) {
+	const owner = "pages-test"
+	e := echo.New()
+	listPage := func(page, perPage int) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/jobs?page=%d&perPage=%d", page, perPage), nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set(userContextKey, owner)
+		err := handleAPIJobList(c)
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			return he.Code
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	// No jobs yet: page 1 is empty rather than past the end
+	tests := []struct{ page, perPage, want int }{
+		{1, 20, http.StatusOK},
+		{2, 20, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := listPage(tt.page, tt.perPage); got != tt.want {
+			t.Errorf("no jobs, page %d of %d: status %d, want %d", tt.page, tt.perPage, got, tt.want)
+		}
+	}
+
+	for range 20 {
+		createJob(1, archiveOptions{Owner: owner})
+	}
+	tests = []struct{ page, perPage, want int }{
+		{1, 20, http.StatusOK},
+		{2, 20, http.StatusBadRequest},
+		{2, 19, http.StatusOK},
+		{3, 19, http.StatusBadRequest},
+		{4, 5, http.StatusOK},
+		{5, 5, http.StatusBadRequest},
+		{1 << 62, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := listPage(tt.page, tt.perPage); got != tt.want {
+			t.Errorf("20 jobs, page %d of %d: status %d, want %d", tt.page, tt.perPage, got, tt.want)
+		}
+	}
+}
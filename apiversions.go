@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiVersion is one version of the JSON API, served under /api/<name>. A new
+// version gets its own routes and request and response types, so older
+// clients keep the wire format they were written against until their version
+// is retired.
+type apiVersion struct {
+	name   string
+	routes func(g *echo.Group, gate, upload []echo.MiddlewareFunc)
+
+	// From BULK_API_DEPRECATIONS; zero while the version is supported
+	deprecated time.Time
+	sunset     time.Time
+}
+
+// apiVersions lists the versions of the JSON API, oldest first
+var apiVersions = []*apiVersion{
+	{name: "v1", routes: apiV1Routes},
+}
+
+// setupAPIVersions applies BULK_API_DEPRECATIONS, whose entries have the form
+// version:deprecation-date[:sunset-date] with dates as YYYY-MM-DD
+func setupAPIVersions() error {
+	for _, entry := range config.APIDeprecations {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("invalid API deprecation %q, want version:date[:sunset]", entry)
+		}
+		var v *apiVersion
+		for _, candidate := range apiVersions {
+			if candidate.name == parts[0] {
+				v = candidate
+			}
+		}
+		if v == nil {
+			return fmt.Errorf("unknown API version %q in BULK_API_DEPRECATIONS", parts[0])
+		}
+		var err error
+		if v.deprecated, err = time.Parse(time.DateOnly, parts[1]); err != nil {
+			return fmt.Errorf("invalid deprecation date for API %s: %w", v.name, err)
+		}
+		if len(parts) == 3 {
+			if v.sunset, err = time.Parse(time.DateOnly, parts[2]); err != nil {
+				return fmt.Errorf("invalid sunset date for API %s: %w", v.name, err)
+			}
+			if v.sunset.Before(v.deprecated) {
+				return fmt.Errorf("API %s sunset is before its deprecation", v.name)
+			}
+		}
+	}
+	return nil
+}
+
+// mountAPI adds every version of the JSON API to e
+func mountAPI(e *echo.Echo, gate, upload []echo.MiddlewareFunc) {
+	for _, v := range apiVersions {
+		v.routes(e.Group("/api/"+v.name, v.lifecycle), gate, upload)
+	}
+}
+
+// successor returns the next version after v, or nil for the newest
+func (v *apiVersion) successor() *apiVersion {
+	for i, candidate := range apiVersions {
+		if candidate == v && i+1 < len(apiVersions) {
+			return apiVersions[i+1]
+		}
+	}
+	return nil
+}
+
+// lifecycle is middleware that marks responses from a deprecated version with
+// Deprecation, Sunset and successor Link headers, and answers 410 Gone once
+// the sunset date has passed
+func (v *apiVersion) lifecycle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if v.deprecated.IsZero() {
+			return next(c)
+		}
+		h := c.Response().Header()
+		h.Set("Deprecation", "@"+strconv.FormatInt(v.deprecated.Unix(), 10))
+		if next := v.successor(); next != nil {
+			h.Add("Link", fmt.Sprintf(`</api/%s/>; rel="successor-version"`, next.name))
+		}
+		if !v.sunset.IsZero() {
+			h.Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+			if !time.Now().Before(v.sunset) {
+				return echo.NewHTTPError(http.StatusGone, fmt.Sprintf("API %s was retired on %s", v.name, v.sunset.Format(time.DateOnly)))
+			}
+		}
+		return next(c)
+	}
+}
+
+// apiV1Routes registers the first version of the JSON API
+func apiV1Routes(g *echo.Group, gate, upload []echo.MiddlewareFunc) {
+	g.POST("/compress", handleAPICompress, upload...)
+	g.GET("/jobs", handleAPIJobList, gate...)
+	g.GET("/jobs/:id", handleAPIJobStatus, gate...)
+	g.GET("/quota", handleAPIQuota, gate...)
+	g.GET("/capabilities", handleCapabilities)
+	g.PUT("/files/:name", handlePutFile, upload...)
+	g.POST("/uploads", handleCreateSession, upload...)
+	g.GET("/uploads/:id", handleGetSession, gate...)
+	g.DELETE("/uploads/:id", handleDiscardSession, gate...)
+	g.POST("/uploads/:id/files", handleAddSessionFiles, upload...)
+	g.GET("/uploads/:id/socket", handleUploadSocket, upload...)
+	g.DELETE("/uploads/:id/files/:name", handleRemoveSessionFile, gate...)
+	g.POST("/uploads/:id/finalize", handleFinalizeUpload, upload...)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// archiveEntry is one file to be written into an archive
+type archiveEntry struct {
+	Name string
+	Size int64
+	Open func() (io.ReadCloser, error)
+
+	// Modification time and permissions recorded in the archive; the current
+	// time and 0644 are used when unset
+	ModTime time.Time
+	Mode    os.FileMode
+
+	// Where the entry came from, such as the URL it was fetched from
+	Source string
+}
+
+// archiveError pairs a message safe to show users with the underlying cause
+type archiveError struct {
+	msg string
+	err error
+}
+
+func (e *archiveError) Error() string { return e.msg + ": " + e.err.Error() }
+func (e *archiveError) Unwrap() error { return e.err }
+
+// archiveOptions carries per-request settings for building an archive
+type archiveOptions struct {
+	// Username recorded as the archive's owner
+	Owner string
+
+	// Scheduling class of the background job building the archive
+	Priority jobPriority
+
+	// Password required to download the archive; empty for an open link
+	Password string
+
+	// Store files with identical content only once
+	Dedup bool
+
+	// Unpack uploaded ZIPs into the new archive instead of nesting them
+	Merge bool
+
+	// Put every file at the archive root instead of keeping its folders
+	Flatten bool
+
+	// Original filename to path inside the archive; unmapped files keep their name
+	Paths map[string]string
+
+	// ZIP archive comment
+	Comment string
+
+	// Note for recipients, embedded as NOTE.txt and shown before downloading
+	Note string
+
+	// Embed a metadata.json entry describing the archive
+	Metadata bool
+
+	// Job the archive is built for, recorded in metadata.json; generated when empty
+	JobID string
+
+	// Base of the download name; derived from the entries when empty
+	BaseName string
+
+	// How long the download link lives; BULK_ARCHIVE_TTL when zero
+	TTL time.Duration
+
+	// Address of the client that requested the archive, for the audit trail
+	ClientIP string
+
+	// API key the request was made with, so abuse bans can name it
+	APIKey string
+
+	// Entries were already reviewed, so they skip quarantine screening
+	Screened bool
+
+	// Abort on the first file that can't be read instead of leaving it out
+	Strict bool
+
+	// Files already left out of the batch, reported along with the rest
+	Failed []fileResult
+}
+
+// archiveResult describes a successfully created archive
+type archiveResult struct {
+	// Download name under which the archive is registered
+	Name string
+
+	// Files left out because their content matched an earlier entry
+	Duplicates []duplicateEntry
+
+	// Flagged files held back for review instead of being archived
+	Quarantined []quarantinedFile
+
+	// What became of each file: added, skipped as a duplicate or for review,
+	// or failed because it couldn't be read
+	Files []fileResult
+}
+
+// progressFunc is called after each entry is written, with the number of
+// entries done so far and the name of the entry just written
+type progressFunc func(done int, name string)
+
+// createArchive writes entries into a new ZIP in the temp directory and
+// registers it in the store. The partial file is removed on failure.
+func createArchive(ctx context.Context, entries []archiveEntry, opts archiveOptions, progress progressFunc) (archiveResult, error) {
+	result := archiveResult{Files: append([]fileResult(nil), opts.Failed...)}
+
+	// Name the archive after the original selection, before any entries are dropped
+	zipFilename := archiveName(entries, opts, ".zip")
+	defer releaseArchiveName(zipFilename)
+
+	if opts.Merge {
+		merged, closeSources, err := mergeArchives(entries)
+		if err != nil {
+			return result, err
+		}
+		defer closeSources()
+		entries = merged
+	}
+
+	// Reads give up once the build runs past BULK_JOB_TIMEOUT
+	ctx, cancel := buildContext(ctx)
+	defer cancel()
+	entries = entriesWithContext(ctx, entries)
+	defer func() {
+		if result.Name == "" && errors.Is(ctx.Err(), context.Canceled) {
+			log.Printf("Stopped building %s: the client went away", zipFilename)
+		}
+	}()
+
+	if len(opts.Paths) > 0 {
+		remapped, err := remapEntries(entries, opts.Paths)
+		if err != nil {
+			return result, err
+		}
+		entries = remapped
+	}
+
+	// Clean names up front so duplicate listings and metadata match the archive
+	entries = append([]archiveEntry(nil), entries...)
+	for i := range entries {
+		entries[i].Name = fileEntryName(entries[i].Name)
+	}
+	if opts.Flatten {
+		entries = flattenEntries(entries)
+	}
+
+	// Files that can't be read are left out unless the batch is strict
+	failures := &batchFailures{strict: opts.Strict}
+
+	// Site-configured stages filter, scan, transform and hash files first
+	chain, err := newFileChain()
+	if err != nil {
+		return result, &archiveError{"Error setting up file stages", err}
+	}
+	defer chain.cleanup()
+	processing := &batchFailures{strict: opts.Strict}
+	entries, skipped, err := chain.process(ctx, entries, processing.fail)
+	result.Files = append(result.Files, skipped...)
+	result.Files = append(result.Files, processing.results...)
+	if err != nil {
+		return result, err
+	}
+
+	// Flagged files wait for an admin instead of failing the whole batch
+	entries, held, err := screenEntries(ctx, entries, zipFilename, opts)
+	if err != nil {
+		return result, err
+	}
+	result.Quarantined = held
+	for _, q := range held {
+		result.Files = append(result.Files, fileResult{Name: q.Name, Status: fileSkipped, Reason: "held for review: " + q.Reason})
+	}
+	defer func() {
+		if result.Name != "" {
+			holdQuarantined(held)
+		} else {
+			discardQuarantined(held)
+		}
+	}()
+
+	if opts.Dedup {
+		unique, dups := dedupEntries(entries)
+		if len(dups) > 0 {
+			log.Printf("Deduplicated %d identical files", len(dups))
+			entries = unique
+			result.Duplicates = dups
+			for _, d := range dups {
+				result.Files = append(result.Files, fileResult{Name: d.Name, Status: fileSkipped, Reason: "duplicate of " + d.SameAs})
+			}
+		}
+	}
+
+	selected := entries
+	entries = append(entries, chain.finish(selected)...)
+	if len(result.Duplicates) > 0 {
+		entries = append(entries, duplicatesManifestEntry(result.Duplicates))
+	}
+
+	if opts.Note != "" {
+		entries = append(entries, noteEntry(opts.Note))
+	}
+
+	if opts.Metadata {
+		if opts.JobID == "" {
+			opts.JobID = newJobID()
+		}
+		meta, err := metadataEntry(entries, opts)
+		if err != nil {
+			return result, &archiveError{"Error writing archive metadata", err}
+		}
+		entries = append(entries, meta)
+	}
+
+	// Small archives are built and served from memory
+	if config.MemoryArchiveMax > 0 && entriesSize(entries) <= config.MemoryArchiveMax {
+		if err := createMemoryArchive(zipFilename, entries, opts, progress, failures.fail); err != nil {
+			result.Files = append(result.Files, failures.results...)
+			return result, err
+		}
+		result.Files = append(result.Files, addedResults(selected, failures.results)...)
+		auditCreation(zipFilename, withoutFailed(entries, failures.results), opts)
+		result.Name = zipFilename
+		return result, nil
+	}
+
+	// Create a temporary file to store the ZIP
+	tempFile, err := os.CreateTemp(spillDir(), "archive-*.zip")
+	if err != nil {
+		log.Printf("Error creating temp file: %v", err)
+		return result, &archiveError{"Error creating temporary file", err}
+	}
+	defer tempFile.Close()
+
+	tempFilePath := tempFile.Name()
+	w, key, err := sealArchive(tempFile)
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFilePath)
+		return result, &archiveError{"Error encrypting archive", err}
+	}
+	if err := writeZip(w, entries, opts.Comment, progress, failures.fail); err != nil {
+		tempFile.Close()
+		os.Remove(tempFilePath)
+		result.Files = append(result.Files, failures.results...)
+		return result, err
+	}
+
+	if err := registerArchive(zipFilename, tempFile, key, opts); err != nil {
+		tempFile.Close()
+		os.Remove(tempFilePath)
+		return result, err
+	}
+
+	log.Printf("ZIP created successfully: %s (path: %s)", zipFilename, tempFilePath)
+	result.Files = append(result.Files, addedResults(selected, failures.results)...)
+	auditCreation(zipFilename, withoutFailed(entries, failures.results), opts)
+	result.Name = zipFilename
+	return result, nil
+}
+
+// registerArchive records a finished archive file in the store under name,
+// along with the key it was sealed with, if any
+func registerArchive(name string, file *os.File, key string, opts archiveOptions) error {
+	rec, err := newArchiveRecord(opts)
+	if err != nil {
+		return err
+	}
+
+	// Record the archive size so the disk budget accounts for it
+	if info, err := file.Stat(); err == nil {
+		rec.Size = info.Size()
+	}
+
+	// Store the temp file path in map for retrieval
+	rec.Path = file.Name()
+	rec.Key = key
+	putArchive(name, rec)
+	return nil
+}
+
+// newArchiveRecord starts a store record for a new archive with its owner,
+// password hash and expiry filled in from opts
+func newArchiveRecord(opts archiveOptions) (archiveRecord, error) {
+	// Only a hash of the link password is kept
+	var passwordHash string
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return archiveRecord{}, &archiveError{"Error protecting download link", err}
+		}
+		passwordHash = string(hash)
+	}
+
+	now := time.Now()
+	ttl := config.ArchiveTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+	return archiveRecord{
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+		Owner:        opts.Owner,
+		ClientIP:     opts.ClientIP,
+		APIKey:       opts.APIKey,
+		Note:         opts.Note,
+		PasswordHash: passwordHash,
+	}, nil
+}
+
+// writeZip adds every entry to a ZIP archive with the given comment written to w.
+// Entries that can't be opened are left out when failed allows it; otherwise
+// they abort the archive.
+func writeZip(w io.Writer, entries []archiveEntry, comment string, progress progressFunc, failed failFunc) error {
+	// Create a new ZIP archive
+	zipWriter := zip.NewWriter(w)
+	if err := zipWriter.SetComment(comment); err != nil {
+		return &archiveError{"Archive comment too long", err}
+	}
+
+	// Deflate entries on several cores when there is more than one
+	if config.CompressWorkers > 1 && len(entries) > 1 {
+		return writeZipParallel(zipWriter, entries, config.CompressWorkers, progress, failed)
+	}
+
+	// Add each file to the ZIP archive
+	skipped := 0
+	for i, entry := range entries {
+		log.Printf("Processing file %d: %s", i+1, entry.Name)
+
+		// Open the current uploaded file
+		src, err := entry.Open()
+		if err != nil {
+			log.Printf("Error opening file %s: %v", entry.Name, err)
+			if failed != nil && failed(entry.Name, errors.New("could not be read")) {
+				skipped++
+				continue
+			}
+			zipWriter.Close() // Close the zip writer before returning
+			return &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), err}
+		}
+
+		// Create a new file inside the ZIP archive, keeping its timestamp and mode
+		zipFile, err := zipWriter.CreateHeader(entryHeader(entry))
+		if err != nil {
+			log.Printf("Error creating zip entry for %s: %v", entry.Name, err)
+			src.Close()
+			zipWriter.Close() // Close the zip writer before returning
+			return &archiveError{fmt.Sprintf("Error adding %s to ZIP", entry.Name), err}
+		}
+
+		// Copy the uploaded file data to the ZIP file
+		if _, err := copyPooled(zipFile, src); err != nil {
+			log.Printf("Error copying data for %s: %v", entry.Name, err)
+			src.Close()
+			zipWriter.Close() // Close the zip writer before returning
+			return &archiveError{fmt.Sprintf("Error copying %s data", entry.Name), err}
+		}
+
+		src.Close() // Close the file after processing
+
+		if progress != nil {
+			progress(i+1, entry.Name)
+		}
+	}
+	if skipped > 0 && skipped == len(entries) {
+		zipWriter.Close()
+		return &archiveError{"None of the files could be read", errors.New("every entry failed")}
+	}
+
+	// Close the ZIP writer to finalize the archive
+	if err := zipWriter.Close(); err != nil {
+		log.Printf("Error closing zip writer: %v", err)
+		return &archiveError{"Error finalizing ZIP archive", err}
+	}
+	return nil
+}
+
+// entryHeader builds the ZIP file header for entry
+func entryHeader(entry archiveEntry) *zip.FileHeader {
+	fh := &zip.FileHeader{Name: fileEntryName(entry.Name), Method: zip.Deflate, Modified: entry.ModTime}
+	if fh.Modified.IsZero() {
+		fh.Modified = time.Now()
+	}
+	mode := entry.Mode
+	if mode == 0 {
+		mode = 0o644
+	}
+	fh.SetMode(mode)
+	return fh
+}
+
+// archiveBaseName returns the base of a download name made by the default
+// naming template, or the name without its extension otherwise
+func archiveBaseName(name string) string {
+	base := strings.TrimSuffix(name, ".zip")
+	if len(base) > 16 && base[len(base)-16] == '_' {
+		if _, err := time.Parse("20060102_150405", base[len(base)-15:]); err == nil {
+			return base[:len(base)-16]
+		}
+	}
+	return base
+}
+
+// archiveErrorMessage returns the user-facing message for an archive failure
+func archiveErrorMessage(err error) string {
+	if isTimeout(err) {
+		return "Building the archive took too long"
+	}
+	var ae *archiveError
+	if errors.As(err, &ae) {
+		return ae.msg
+	}
+	return "Error creating archive"
+}
+
+// archiveErrorStatus returns the HTTP status for an archive failure: uploads
+// that tripped the input archive limits are the client's fault
+func archiveErrorStatus(err error) int {
+	if isLimitError(err) {
+		return http.StatusBadRequest
+	}
+	if isTimeout(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// benchShapes are the uploads the archive benchmarks measure: many small
+// files, a typical batch of documents and a few large ones
+var benchShapes = []struct {
+	files, size int
+}{
+	{1000, 1 << 10},
+	{100, 64 << 10},
+	{8, 4 << 20},
+}
+
+// benchEntries returns files entries of size bytes each, sharing one payload
+func benchEntries(files, size int) []archiveEntry {
+	data := benchPayload(size)
+	entries := make([]archiveEntry, files)
+	for i := range entries {
+		entries[i] = archiveEntry{
+			Name: fmt.Sprintf("dir%d/file%d.txt", i%10, i),
+			Size: int64(size),
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+		}
+	}
+	return entries
+}
+
+// benchName labels a sub-benchmark by upload shape
+func benchName(files, size int) string {
+	return fmt.Sprintf("files=%d/size=%dKB", files, size>>10)
+}
+
+func BenchmarkWriteZip(b *testing.B) {
+	defer func(n int) { config.CompressWorkers = n }(config.CompressWorkers)
+	for _, shape := range benchShapes {
+		entries := benchEntries(shape.files, shape.size)
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/workers=%d", benchName(shape.files, shape.size), workers), func(b *testing.B) {
+				config.CompressWorkers = workers
+				b.SetBytes(int64(shape.files * shape.size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := writeZip(io.Discard, entries, "", nil, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCreateArchive runs the whole pipeline, from entries to a stored
+// archive on disk, removing each archive outside the timed section
+func BenchmarkCreateArchive(b *testing.B) {
+	for _, shape := range benchShapes {
+		entries := benchEntries(shape.files, shape.size)
+		b.Run(benchName(shape.files, shape.size), func(b *testing.B) {
+			b.SetBytes(int64(shape.files * shape.size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				result, err := createArchive(context.Background(), entries, archiveOptions{}, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if rec, ok := takeArchive(result.Name); ok {
+					removeArchiveFile(result.Name, rec.Path)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// TestParallelZipHeaders checks the parallel writer flags names the same
+// way the sequential one does, so readers decode non-ASCII names as UTF-8
+func TestParallelZipHeaders(t *testing.T) {
+	defer func(n int) { config.CompressWorkers = n }(config.CompressWorkers)
+	var entries []archiveEntry
+	for _, name := range []string{"Café.txt", "日本.txt", "plain.txt", `back\slash~.txt`} {
+		entries = append(entries, archiveEntry{
+			Name: name,
+			Size: 4,
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("data")), nil },
+		})
+	}
+
+	headers := make(map[int]map[string]uint16)
+	for _, workers := range []int{1, 4} {
+		config.CompressWorkers = workers
+		var buf bytes.Buffer
+		if err := writeZip(&buf, entries, "", nil, nil); err != nil {
+			t.Fatalf("writeZip with %d workers: %v", workers, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("reading archive written with %d workers: %v", workers, err)
+		}
+		headers[workers] = make(map[string]uint16)
+		for _, f := range zr.File {
+			// Only the UTF-8 bit; sequential entries also use a data descriptor
+			headers[workers][f.Name] = f.Flags & 0x800
+		}
+	}
+	for _, e := range entries {
+		name := fileEntryName(e.Name)
+		sequential, ok := headers[1][name]
+		if !ok {
+			t.Fatalf("%q missing from the sequential archive", name)
+		}
+		if parallel := headers[4][name]; parallel != sequential {
+			t.Errorf("%q has UTF-8 flag %#x in parallel, %#x sequentially", name, parallel, sequential)
+		}
+	}
+	if headers[4][fileEntryName("Café.txt")] == 0 {
+		t.Errorf("Café.txt lacks the UTF-8 flag")
+	}
+}
+
+// FuzzZipEntryNames writes files with arbitrary names the way createArchive
+// does and checks the result reads back with safe, distinct names
+func FuzzZipEntryNames(f *testing.F) {
+	f.Add("a.txt", "a.txt", "a (2).txt", true)
+	f.Add("dir/a", `dir\a`, "DIR/a", false)
+	f.Add("CON", "con.txt", "../../x", true)
+	f.Add("folder/", "folder/", "x\x00y", false)
+	f.Add(strings.Repeat("n", 300), strings.Repeat("n", 300), strings.Repeat("n", 255), true)
+	f.Fuzz(func(t *testing.T, a, b, c string, flatten bool) {
+		var entries []archiveEntry
+		for i, name := range []string{a, b, c} {
+			content := fmt.Sprint(i)
+			entries = append(entries, archiveEntry{
+				Name: fileEntryName(name),
+				Size: int64(len(content)),
+				Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil },
+			})
+		}
+		if flatten {
+			entries = flattenEntries(entries)
+		}
+
+		var buf bytes.Buffer
+		if err := writeZip(&buf, entries, "", nil, nil); err != nil {
+			t.Fatalf("writeZip(%q, %q, %q): %v", a, b, c, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("reading archive of %q, %q, %q: %v", a, b, c, err)
+		}
+		if len(zr.File) != len(entries) {
+			t.Fatalf("archive has %d members, want %d", len(zr.File), len(entries))
+		}
+		seen := make(map[string]bool)
+		for _, f := range zr.File {
+			name, err := safeMemberName(f.Name)
+			if err != nil || name != f.Name {
+				t.Fatalf("member %q reads back as %q, %v", f.Name, name, err)
+			}
+			if flatten && seen[f.Name] {
+				t.Fatalf("flattened names %q, %q, %q collide on %q", a, b, c, f.Name)
+			}
+			seen[f.Name] = true
+		}
+	})
+}
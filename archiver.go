@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archiver writes one archive format to an underlying writer. Members are
+// added in order and the output is complete once Finalize returns; the
+// underlying writer is left open.
+type Archiver interface {
+	AddEntry(m archiveMember, r io.Reader) error
+	Finalize() error
+	ContentType() string
+	Extension() string
+}
+
+// archiveAborter is implemented by archivers holding resources, such as a
+// scratch directory, to release when an archive is abandoned before Finalize
+type archiveAborter interface {
+	Abort()
+}
+
+// archiveMember describes a file added to an Archiver
+type archiveMember struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Comment string
+}
+
+// archiverOptions are the settings an archive format may accept
+type archiverOptions struct {
+	// Compression level within the format's range; -1 uses its default
+	Level int
+
+	// Encrypts the output; only formats with passwords accept one
+	Password string
+}
+
+// archiveFormat is a registered output format and how to create its writers
+type archiveFormat struct {
+	name        string
+	extension   string
+	contentType string
+
+	// Accepted compression levels; a format without levels has minLevel > maxLevel
+	minLevel, maxLevel int
+	passwords          bool
+
+	open func(f *archiveFormat, w io.Writer, opts archiverOptions) (Archiver, error)
+}
+
+// archiveFormats holds every output format by name. Formats that need
+// something from the environment, like 7z, are added at startup.
+var archiveFormats = map[string]*archiveFormat{
+	"zip": {name: "zip", extension: ".zip", contentType: "application/zip",
+		minLevel: 0, maxLevel: 9, passwords: true, open: newZipArchiver},
+	"tar": {name: "tar", extension: ".tar", contentType: "application/x-tar",
+		minLevel: 1, maxLevel: 0, open: newTarArchiver},
+	"tar.gz": {name: "tar.gz", extension: ".tar.gz", contentType: "application/gzip",
+		minLevel: 0, maxLevel: 9, open: newTarArchiver},
+	"tar.zst": {name: "tar.zst", extension: ".tar.zst", contentType: "application/zstd",
+		minLevel: 1, maxLevel: 22, open: newTarArchiver},
+}
+
+// registerArchiveFormat makes f available under its name
+func registerArchiveFormat(f *archiveFormat) {
+	archiveFormats[f.name] = f
+}
+
+// formatByExtension returns the format whose extension name ends with,
+// preferring the longest match so .tar.gz isn't taken for .gz
+func formatByExtension(name string) (*archiveFormat, bool) {
+	var best *archiveFormat
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.extension) && (best == nil || len(f.extension) > len(best.extension)) {
+			best = f
+		}
+	}
+	return best, best != nil
+}
+
+// acceptsLevel reports whether level is valid for the format
+func (f *archiveFormat) acceptsLevel(level int) bool {
+	return level >= f.minLevel && level <= f.maxLevel
+}
+
+// check validates opts against what the format supports
+func (f *archiveFormat) check(opts archiverOptions) error {
+	if opts.Level >= 0 && !f.acceptsLevel(opts.Level) {
+		return fmt.Errorf("Invalid compression level %q for %s", fmt.Sprint(opts.Level), f.name)
+	}
+	if opts.Password != "" && !f.passwords {
+		return fmt.Errorf("Passwords are only supported for ZIP output")
+	}
+	return nil
+}
+
+// newArchiver starts an archive of format f written to w
+func (f *archiveFormat) newArchiver(w io.Writer, opts archiverOptions) (Archiver, error) {
+	if err := f.check(opts); err != nil {
+		return nil, err
+	}
+	return f.open(f, w, opts)
+}
+
+// ContentType is the media type archives of the format are served with
+func (f *archiveFormat) ContentType() string { return f.contentType }
+
+// Extension is the file name suffix of the format, dot included
+func (f *archiveFormat) Extension() string { return f.extension }
+
+// zipArchiver writes ZIP archives, optionally encrypted with WinZip AES-256
+type zipArchiver struct {
+	*archiveFormat
+	zw       *zip.Writer
+	method   uint16
+	level    int
+	password string
+}
+
+func newZipArchiver(f *archiveFormat, w io.Writer, opts archiverOptions) (Archiver, error) {
+	level := opts.Level
+	if level < 0 {
+		level = flate.DefaultCompression
+	}
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	method := uint16(zip.Deflate)
+	if level == 0 {
+		method = zip.Store
+	}
+	return &zipArchiver{archiveFormat: f, zw: zw, method: method, level: level, password: opts.Password}, nil
+}
+
+func (a *zipArchiver) AddEntry(m archiveMember, r io.Reader) error {
+	fh := zip.FileHeader{Name: m.Name, Modified: m.ModTime, Comment: m.Comment}
+	fh.SetMode(m.Mode)
+	if a.password != "" {
+		return writeEncryptedEntry(a.zw, fh, r, a.password, a.method, a.level)
+	}
+	fh.Method = a.method
+	dst, err := a.zw.CreateHeader(&fh)
+	if err == nil {
+		_, err = copyPooled(dst, r)
+	}
+	return err
+}
+
+func (a *zipArchiver) Finalize() error {
+	return a.zw.Close()
+}
+
+// tarArchiver writes tarballs, plain or through gzip or zstd
+type tarArchiver struct {
+	*archiveFormat
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func newTarArchiver(f *archiveFormat, w io.Writer, opts archiverOptions) (Archiver, error) {
+	var compressor io.WriteCloser
+	var err error
+	switch f.name {
+	case "tar.gz":
+		level := opts.Level
+		if level < 0 {
+			level = gzip.DefaultCompression
+		}
+		compressor, err = gzip.NewWriterLevel(w, level)
+	case "tar.zst":
+		level := zstd.SpeedDefault
+		if opts.Level > 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		compressor, err = zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := &tarArchiver{archiveFormat: f, compressor: compressor}
+	if compressor != nil {
+		a.tw = tar.NewWriter(compressor)
+	} else {
+		a.tw = tar.NewWriter(w)
+	}
+	return a, nil
+}
+
+func (a *tarArchiver) AddEntry(m archiveMember, r io.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     m.Name,
+		Size:     m.Size,
+		Mode:     int64(m.Mode.Perm()),
+		ModTime:  m.ModTime,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := copyPooled(a.tw, r)
+	return err
+}
+
+func (a *tarArchiver) Finalize() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
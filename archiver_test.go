@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testMembers are written to every format in the round trip tests
+var testMembers = []struct {
+	name, content string
+}{
+	{"readme.txt", "hello"},
+	{"docs/report.csv", "a,b,c\n1,2,3\n"},
+	{"empty", ""},
+}
+
+// buildArchive writes testMembers with the named format and options
+func buildArchive(t *testing.T, format string, opts archiverOptions) []byte {
+	t.Helper()
+	f, ok := archiveFormats[format]
+	if !ok {
+		t.Fatalf("format %s is not registered", format)
+	}
+	var buf bytes.Buffer
+	a, err := f.newArchiver(&buf, opts)
+	if err != nil {
+		t.Fatalf("newArchiver: %v", err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, m := range testMembers {
+		err := a.AddEntry(archiveMember{Name: m.name, Size: int64(len(m.content)), Mode: 0o644, ModTime: modTime},
+			bytes.NewReader([]byte(m.content)))
+		if err != nil {
+			t.Fatalf("AddEntry %s: %v", m.name, err)
+		}
+	}
+	if err := a.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readTar returns the members of a tarball by name
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", hdr.Name, err)
+		}
+		got[hdr.Name] = string(data)
+	}
+}
+
+// readZip returns the members of a ZIP by name
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading zip: %v", err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+		got[f.Name] = string(b)
+	}
+	return got
+}
+
+func TestArchiverRoundTrip(t *testing.T) {
+	readers := map[string]func(*testing.T, []byte) map[string]string{
+		"zip": readZip,
+		"tar": func(t *testing.T, data []byte) map[string]string {
+			return readTar(t, bytes.NewReader(data))
+		},
+		"tar.gz": func(t *testing.T, data []byte) map[string]string {
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("gzip: %v", err)
+			}
+			return readTar(t, zr)
+		},
+		"tar.zst": func(t *testing.T, data []byte) map[string]string {
+			zr, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("zstd: %v", err)
+			}
+			defer zr.Close()
+			return readTar(t, zr)
+		},
+	}
+	for format, read := range readers {
+		t.Run(format, func(t *testing.T) {
+			got := read(t, buildArchive(t, format, archiverOptions{Level: -1}))
+			if len(got) != len(testMembers) {
+				t.Fatalf("got %d members, want %d", len(got), len(testMembers))
+			}
+			for _, m := range testMembers {
+				if got[m.name] != m.content {
+					t.Errorf("%s = %q, want %q", m.name, got[m.name], m.content)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiverStoredZip(t *testing.T) {
+	data := buildArchive(t, "zip", archiverOptions{Level: 0})
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Method != zip.Store {
+			t.Errorf("%s uses method %d, want stored", f.Name, f.Method)
+		}
+	}
+}
+
+func TestArchiverOptions(t *testing.T) {
+	tests := []struct {
+		format string
+		opts   archiverOptions
+		ok     bool
+	}{
+		{"zip", archiverOptions{Level: 9, Password: "secret"}, true},
+		{"zip", archiverOptions{Level: 10}, false},
+		{"tar", archiverOptions{Level: -1}, true},
+		{"tar", archiverOptions{Level: 1}, false},
+		{"tar.gz", archiverOptions{Level: 0}, true},
+		{"tar.gz", archiverOptions{Level: -1, Password: "secret"}, false},
+		{"tar.zst", archiverOptions{Level: 22}, true},
+		{"tar.zst", archiverOptions{Level: 0}, false},
+	}
+	for _, tt := range tests {
+		err := archiveFormats[tt.format].check(tt.opts)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s %+v: err = %v, want ok %v", tt.format, tt.opts, err, tt.ok)
+		}
+	}
+}
+
+func TestFormatByExtension(t *testing.T) {
+	tests := map[string]string{
+		"photos.zip":     "application/zip",
+		"photos.tar":     "application/x-tar",
+		"photos.tar.gz":  "application/gzip",
+		"photos.tar.zst": "application/zstd",
+	}
+	for name, want := range tests {
+		f, ok := formatByExtension(name)
+		if !ok || f.ContentType() != want {
+			t.Errorf("formatByExtension(%q) = %v, %v; want %s", name, f, ok, want)
+		}
+	}
+	if _, ok := formatByExtension("notes.txt"); ok {
+		t.Error("formatByExtension matched notes.txt")
+	}
+}
+
+func BenchmarkArchiver(b *testing.B) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.zst"} {
+		for _, shape := range benchShapes {
+			data := benchPayload(shape.size)
+			b.Run(format+"/"+benchName(shape.files, shape.size), func(b *testing.B) {
+				b.SetBytes(int64(shape.files * shape.size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					a, err := archiveFormats[format].newArchiver(io.Discard, archiverOptions{Level: -1})
+					if err != nil {
+						b.Fatal(err)
+					}
+					for n := 0; n < shape.files; n++ {
+						m := archiveMember{Name: fmt.Sprintf("file%d.txt", n), Size: int64(shape.size), Mode: 0o644, ModTime: modTime}
+						if err := a.AddEntry(m, bytes.NewReader(data)); err != nil {
+							b.Fatal(err)
+						}
+					}
+					if err := a.Finalize(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Audit actions
+const (
+	auditCreated    = "created"
+	auditDownloaded = "downloaded"
+	auditDeleted    = "deleted"
+	auditRestored   = "restored"
+	auditReported   = "reported"
+	auditDisabled   = "disabled"
+
+	auditQuarantined = "quarantined"
+	auditApproved    = "approved"
+	auditRejected    = "rejected"
+)
+
+// auditEvent is one entry of the audit trail
+type auditEvent struct {
+	Time     time.Time `json:"time"`
+	Archive  string    `json:"archive"`
+	Action   string    `json:"action"`
+	User     string    `json:"user,omitempty"`
+	ClientIP string    `json:"clientIp,omitempty"`
+	Files    []string  `json:"files,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// The audit trail is an append-only JSON lines file, only rewritten by purges
+var (
+	auditFile  *os.File
+	auditMutex = &sync.Mutex{}
+)
+
+// auditPath is the audit file, BULK_AUDIT_LOG or audit.jsonl in the data directory
+func auditPath() string {
+	if config.AuditLog != "" {
+		return config.AuditLog
+	}
+	return filepath.Join(config.DataDir, "audit.jsonl")
+}
+
+// openAuditLog opens the audit file for appending unless BULK_AUDIT_LOG is off
+func openAuditLog() error {
+	if config.AuditLog == "off" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(auditPath()), 0o750); err != nil {
+		return fmt.Errorf("creating audit log dir: %w", err)
+	}
+	f, err := os.OpenFile(auditPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	auditMutex.Lock()
+	auditFile = f
+	auditMutex.Unlock()
+	log.Printf("Writing audit trail to %s", auditPath())
+	return nil
+}
+
+// recordAudit appends ev to the audit trail
+func recordAudit(ev auditEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Error encoding audit event: %v", err)
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile == nil {
+		return
+	}
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit event: %v", err)
+	}
+}
+
+// auditCreation records a new archive with the names of its entries
+func auditCreation(name string, entries []archiveEntry, opts archiveOptions) {
+	files := make([]string, len(entries))
+	for i, e := range entries {
+		files[i] = e.Name
+	}
+	recordAudit(auditEvent{
+		Archive:  name,
+		Action:   auditCreated,
+		User:     opts.Owner,
+		ClientIP: opts.ClientIP,
+		Files:    files,
+	})
+}
+
+// auditDeletion records an archive removed before or instead of being downloaded
+func auditDeletion(c echo.Context, name, reason string) {
+	ev := auditEvent{Archive: name, Action: auditDeleted, Reason: reason}
+	if c != nil {
+		ev.User = currentUser(c)
+		ev.ClientIP = c.RealIP()
+	}
+	recordAudit(ev)
+}
+
+// auditRestore records a downloaded archive brought back within its restore window
+func auditRestore(c echo.Context, name, reason string) {
+	recordAudit(auditEvent{
+		Archive:  name,
+		Action:   auditRestored,
+		User:     currentUser(c),
+		ClientIP: c.RealIP(),
+		Reason:   reason,
+	})
+}
+
+// handleAdminAudit returns audit events, oldest first. The archive and action
+// query parameters filter them, from and to (RFC 3339) bound the time range
+// and limit keeps only the most recent events.
+func handleAdminAudit(c echo.Context) error {
+	var from, to time.Time
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "from must be a time such as 2024-01-02T15:04:05Z")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to must be a time such as 2024-01-02T15:04:05Z")
+		}
+	}
+	limit := 0
+	if v := c.QueryParam("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+	archive, action := c.QueryParam("archive"), c.QueryParam("action")
+
+	f, err := os.Open(auditPath())
+	if os.IsNotExist(err) {
+		return c.JSON(http.StatusOK, []auditEvent{})
+	}
+	if err != nil {
+		log.Printf("Error opening audit log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not read audit log")
+	}
+	defer f.Close()
+
+	events := []auditEvent{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if (archive != "" && ev.Archive != archive) || (action != "" && ev.Action != action) ||
+			(!from.IsZero() && ev.Time.Before(from)) || (!to.IsZero() && ev.Time.After(to)) {
+			continue
+		}
+		events = append(events, ev)
+		if limit > 0 && len(events) > limit {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not read audit log")
+	}
+	return c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// capabilitiesResponse describes what this server accepts so clients can
+// adapt instead of hardcoding limits. Sizes are bytes and a 0 limit means
+// unlimited.
+type capabilitiesResponse struct {
+	Limits   capabilityLimits   `json:"limits"`
+	Expiry   capabilityExpiry   `json:"expiry"`
+	Formats  capabilityFormats  `json:"formats"`
+	Features capabilityFeatures `json:"features"`
+}
+
+type capabilityLimits struct {
+	MaxUploadSize int64 `json:"maxUploadSize"`
+	MaxFileSize   int64 `json:"maxFileSize"`
+	MaxFiles      int   `json:"maxFiles"`
+	MaxComment    int   `json:"maxCommentLength"`
+	MaxNote       int   `json:"maxNoteLength"`
+}
+
+// capabilityExpiry gives link lifetimes in seconds
+type capabilityExpiry struct {
+	Default int64 `json:"defaultSeconds"`
+	Min     int64 `json:"minSeconds"`
+	Max     int64 `json:"maxSeconds"`
+}
+
+type capabilityFormats struct {
+	Archive    []string `json:"archive"`
+	Recompress []string `json:"recompress"`
+	Merge      []string `json:"merge"`
+}
+
+type capabilityFeatures struct {
+	LoginRequired    bool     `json:"loginRequired"`
+	SingleSignOn     bool     `json:"singleSignOn"`
+	LinkPasswords    bool     `json:"linkPasswords"`
+	ZipPasswords     bool     `json:"zipPasswords"`
+	EncryptionAtRest bool     `json:"encryptionAtRest"`
+	Dedup            bool     `json:"dedup"`
+	URLFetch         bool     `json:"urlFetch"`
+	FetchSchemes     []string `json:"fetchSchemes"`
+	Crawl            bool     `json:"crawl"`
+	Scrape           bool     `json:"scrape"`
+	UploadSessions   bool     `json:"uploadSessions"`
+	CloudProviders   []string `json:"cloudProviders"`
+	DeliveryTargets  []string `json:"deliveryTargets"`
+
+	// CAPTCHA provider anonymous uploads must pass, empty for none
+	Captcha string `json:"captcha,omitempty"`
+}
+
+// handleCapabilities returns the limits, formats and features of this server
+func handleCapabilities(c echo.Context) error {
+	return c.JSON(http.StatusOK, capabilitiesResponse{
+		Limits: capabilityLimits{
+			MaxUploadSize: config.MaxUploadSize,
+			MaxFileSize:   config.MaxFileSize,
+			MaxFiles:      config.MaxFiles,
+			MaxComment:    maxArchiveComment,
+			MaxNote:       maxArchiveNote,
+		},
+		Expiry: capabilityExpiry{
+			Default: int64(config.ArchiveTTL.Seconds()),
+			Min:     int64(config.ArchiveTTLMin.Seconds()),
+			Max:     int64(config.ArchiveTTLMax.Seconds()),
+		},
+		Formats: capabilityFormats{
+			Archive:    []string{"zip"},
+			Recompress: sortedKeys(archiveFormats),
+			Merge:      mergeInputFormats(),
+		},
+		Features: capabilityFeatures{
+			LoginRequired:    config.RequireLogin,
+			SingleSignOn:     oidcProvider != nil,
+			LinkPasswords:    true,
+			ZipPasswords:     true,
+			EncryptionAtRest: config.EncryptAtRest,
+			Dedup:            config.DedupUploads,
+			URLFetch:         true,
+			FetchSchemes:     sortedKeys(fetchSchemes),
+			Crawl:            true,
+			Scrape:           true,
+			UploadSessions:   true,
+			CloudProviders:   sortedKeys(cloudConnectors),
+			DeliveryTargets:  sortedKeys(deliveryTargets),
+			Captcha:          config.CaptchaProvider,
+		},
+	})
+}
+
+// sortedKeys returns the keys of m in order, never nil so they encode as []
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
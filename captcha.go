@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Supported CAPTCHA providers
+const (
+	captchaHCaptcha  = "hcaptcha"
+	captchaTurnstile = "turnstile"
+)
+
+// headerCaptchaToken lets scripted clients without a form send the token
+const headerCaptchaToken = "X-Captcha-Token"
+
+// captchaProvider describes how one provider's tokens are checked and its
+// widget embedded
+type captchaProvider struct {
+	verifyURL string
+	field     string // form field the widget fills in
+	script    string
+	widget    string // CSS class of the widget element
+	origins   string // hosts the widget loads from, for the CSP
+}
+
+var captchaProviders = map[string]captchaProvider{
+	captchaHCaptcha: {
+		verifyURL: "https://api.hcaptcha.com/siteverify",
+		field:     "h-captcha-response",
+		script:    "https://js.hcaptcha.com/1/api.js",
+		widget:    "h-captcha",
+		origins:   "https://hcaptcha.com https://*.hcaptcha.com",
+	},
+	captchaTurnstile: {
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		field:     "cf-turnstile-response",
+		script:    "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		widget:    "cf-turnstile",
+		origins:   "https://challenges.cloudflare.com",
+	},
+}
+
+// captchaClient calls the provider's verification endpoint
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// setupCaptcha checks the CAPTCHA settings and, unless BULK_CSP was changed,
+// lets the provider's widget through the default Content-Security-Policy
+func setupCaptcha() error {
+	if config.CaptchaProvider == "" {
+		return nil
+	}
+	p, ok := captchaProviders[config.CaptchaProvider]
+	if !ok {
+		return fmt.Errorf("unknown CAPTCHA provider %q, want hcaptcha or turnstile", config.CaptchaProvider)
+	}
+	if config.CaptchaSiteKey == "" || config.CaptchaSecret == "" {
+		return fmt.Errorf("BULK_CAPTCHA_PROVIDER needs BULK_CAPTCHA_SITE_KEY and BULK_CAPTCHA_SECRET")
+	}
+	if config.ContentSecurityPolicy == defaultCSP {
+		config.ContentSecurityPolicy = strings.Replace(defaultCSP, "https://unpkg.com;", "https://unpkg.com "+p.origins+";", 1) +
+			"; frame-src " + p.origins + "; connect-src 'self' " + p.origins
+	}
+	ui.CaptchaProvider = config.CaptchaProvider
+	ui.CaptchaSiteKey = config.CaptchaSiteKey
+	ui.CaptchaScript = p.script
+	ui.CaptchaWidget = p.widget
+	return nil
+}
+
+// verifyCaptcha asks the provider whether token was solved for remoteIP
+func verifyCaptcha(ctx context.Context, token, remoteIP string) (bool, error) {
+	p := captchaProviders[config.CaptchaProvider]
+	form := url.Values{"secret": {config.CaptchaSecret}, "response": {token}, "remoteip": {remoteIP}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	resp, err := captchaClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("verification endpoint answered %s", resp.Status)
+	}
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("decoding verification response: %w", err)
+	}
+	if !result.Success {
+		log.Printf("CAPTCHA rejected for %s: %v", remoteIP, result.ErrorCodes)
+	}
+	return result.Success, nil
+}
+
+// requireCaptcha is middleware that makes anonymous requests prove they were
+// sent by a person. Logged-in users and API key clients skip it, and so do
+// raw uploads and session routes adding to a started upload, which passed
+// the check when it began.
+func requireCaptcha(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if config.CaptchaProvider == "" || currentUser(c) != "" {
+			return next(c)
+		}
+		r := c.Request()
+		if r.Method == http.MethodPut && (r.Header.Get("X-Upload-ID") != "" || c.QueryParam("upload") != "") {
+			return next(c)
+		}
+		if strings.Contains(c.Path(), "/uploads/:id/") {
+			return next(c)
+		}
+
+		token := r.Header.Get(headerCaptchaToken)
+		if token == "" {
+			token = c.FormValue(captchaProviders[config.CaptchaProvider].field)
+		}
+		api := strings.HasPrefix(r.URL.Path, "/api/")
+		if token == "" {
+			if api {
+				return echo.NewHTTPError(http.StatusForbidden, "CAPTCHA token required")
+			}
+			return htmlError(c, http.StatusForbidden, "Error: Please complete the CAPTCHA")
+		}
+		ok, err := verifyCaptcha(r.Context(), token, c.RealIP())
+		if err != nil {
+			log.Printf("CAPTCHA verification failed: %v", err)
+			if api {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "Could not verify CAPTCHA")
+			}
+			return htmlError(c, http.StatusServiceUnavailable, "Error: Could not verify the CAPTCHA, please try again")
+		}
+		if !ok {
+			if api {
+				return echo.NewHTTPError(http.StatusForbidden, "CAPTCHA verification failed")
+			}
+			return htmlError(c, http.StatusForbidden, "Error: CAPTCHA verification failed, please try again")
+		}
+		return next(c)
+	}
+}
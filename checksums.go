@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// checksumAlgos are the digests a fetch list may give for a file
+var checksumAlgos = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+// errChecksumMismatch is returned when a fetched file doesn't match its listed digest
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// fileChecksum is a digest a fetched file must have
+type fileChecksum struct {
+	algo string
+	sum  string
+}
+
+// parseChecksum reads an algo:hex token such as sha256:9f86d0...; ok is false
+// when the token isn't a checksum at all
+func parseChecksum(token string) (c fileChecksum, ok bool, err error) {
+	algo, sum, found := strings.Cut(token, ":")
+	newHash, known := checksumAlgos[strings.ToLower(algo)]
+	if !found || !known {
+		return fileChecksum{}, false, nil
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*newHash().Size() {
+		return fileChecksum{}, true, fmt.Errorf("Invalid %s checksum: %s", algo, sum)
+	}
+	return fileChecksum{algo: strings.ToLower(algo), sum: sum}, true, nil
+}
+
+func (c fileChecksum) String() string {
+	return c.algo + ":" + c.sum
+}
+
+// verify hashes the file at path and compares it with the expected digest
+func (c fileChecksum) verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := checksumAlgos[c.algo]()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != c.sum {
+		return fmt.Errorf("%w: %s is %s, expected %s", errChecksumMismatch, c.algo, got, c.sum)
+	}
+	return nil
+}
+
+// fetchTarget is one line of a fetch list: a file's URL, its mirrors and the
+// digests its content must match
+type fetchTarget struct {
+	mirrors   []string
+	checksums []fileChecksum
+}
+
+// parseFetchTarget splits a fetch list line into URLs and checksums
+func parseFetchTarget(line string) (fetchTarget, error) {
+	var t fetchTarget
+	for _, token := range strings.Fields(line) {
+		c, ok, err := parseChecksum(token)
+		if err != nil {
+			return t, err
+		}
+		if ok {
+			t.checksums = append(t.checksums, c)
+			continue
+		}
+		t.mirrors = append(t.mirrors, token)
+	}
+	if len(t.mirrors) == 0 {
+		return t, fmt.Errorf("Invalid URL: %s", line)
+	}
+	return t, nil
+}
+
+// verifyFetched checks a fetched file against the target's checksums,
+// removing its spool file when it doesn't match
+func (t fetchTarget) verifyFetched(f fetchedFile) error {
+	for _, c := range t.checksums {
+		if err := c.verify(f.Path); err != nil {
+			if f.temp {
+				os.Remove(f.Path)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package client calls the bulk-download JSON API. The request and response
+// types and one method per operation are generated from api/openapi.json
+// into api.gen.go; this file holds the transport they share.
+//
+//	c := client.New("https://zip.example.com")
+//	c.APIKey = os.Getenv("BULK_API_KEY")
+//	job, err := c.Compress(ctx, nil, &client.CompressForm{
+//		Files: []client.File{{Name: "report.pdf", Content: f}},
+//	})
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client sends requests to one bulk-download server
+type Client struct {
+	// Server root, such as https://zip.example.com
+	BaseURL string
+
+	// Sent as X-API-Key when set
+	APIKey string
+
+	// Used for all requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// websocketURL turns the http or https scheme of base into ws or wss
+func websocketURL(base string) string {
+	if rest, ok := strings.CutPrefix(base, "http"); ok {
+		return "ws" + rest
+	}
+	return base
+}
+
+// Error is a response outside the 2xx range
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("bulk-download: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("bulk-download: %d %s", e.StatusCode, e.Message)
+}
+
+// File is one file of a multipart upload
+type File struct {
+	Name    string
+	Content io.Reader
+}
+
+// request is one API call as built by a generated method
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+
+	// Raw body, or a multipart form written by form
+	body        io.Reader
+	contentType string
+	form        func(*multipart.Writer) error
+}
+
+// do sends req and decodes a JSON response into out, unless out is nil
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	target := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	body, contentType := req.body, req.contentType
+	if req.form != nil {
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			err := req.form(mw)
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		body, contentType = pr, mw.FormDataContentType()
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		r.Header.Set("X-API-Key", c.APIKey)
+	}
+	r.Header.Set("Accept", "application/json")
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&msg) == nil {
+			apiErr.Message = msg.Message
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+// writeFiles adds files to a multipart form under field
+func writeFiles(mw *multipart.Writer, field string, files []File) error {
+	for _, f := range files {
+		part, err := mw.CreateFormFile(field, f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, f.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// trustedProxies may name the clients behind them in BULK_CLIENT_IP_HEADER
+var trustedProxies ipList
+
+// setupTrustedProxies reads BULK_TRUSTED_PROXIES and the header they use
+func setupTrustedProxies() error {
+	list, err := parseIPList("BULK_TRUSTED_PROXIES", config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	switch http.CanonicalHeaderKey(config.ClientIPHeader) {
+	case echo.HeaderXForwardedFor, echo.HeaderXRealIP:
+		config.ClientIPHeader = http.CanonicalHeaderKey(config.ClientIPHeader)
+	default:
+		return fmt.Errorf("BULK_CLIENT_IP_HEADER must be X-Forwarded-For or X-Real-IP, not %q", config.ClientIPHeader)
+	}
+	trustedProxies = list
+	if len(trustedProxies) > 0 {
+		log.Printf("Taking client addresses from %s sent by %s", config.ClientIPHeader, strings.Join(config.TrustedProxies, ", "))
+	}
+	return nil
+}
+
+// extractClientIP is the server's IP extractor, so c.RealIP() gives the
+// same address to rate limits, bans, audit records and the access log
+func extractClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if addr, ok := clientAddr(r, host); ok {
+		return addr.String()
+	}
+	return host
+}
+
+// clientAddr works out who sent r through the connecting host. Only a
+// trusted proxy's header is believed, and in X-Forwarded-For only up to the
+// last hop that isn't a trusted proxy, since anything to its left was
+// written by the client itself.
+func clientAddr(r *http.Request, host string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !trustedProxies.contains(addr) {
+		return addr, true
+	}
+
+	if config.ClientIPHeader == echo.HeaderXRealIP {
+		if sent, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(echo.HeaderXRealIP))); err == nil {
+			return sent.Unmap(), true
+		}
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(r.Header.Values(echo.HeaderXForwardedFor), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !trustedProxies.contains(addr) {
+			break
+		}
+	}
+	return addr, true
+}
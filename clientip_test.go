@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestExtractClientIP(t *testing.T) {
+	saved, savedHeader := trustedProxies, config.ClientIPHeader
+	defer func() { trustedProxies, config.ClientIPHeader = saved, savedHeader }()
+	proxies, err := parseIPList("BULK_TRUSTED_PROXIES", []string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies = proxies
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		forward []string
+		realIP  string
+		want    string
+	}{
+		{"untrusted peer forging X-Forwarded-For", echo.HeaderXForwardedFor, "203.0.113.9:4000", []string{"198.51.100.7"}, "", "203.0.113.9"},
+		{"untrusted peer forging X-Real-IP", echo.HeaderXRealIP, "203.0.113.9:4000", nil, "198.51.100.7", "203.0.113.9"},
+		{"trusted proxy without header", echo.HeaderXForwardedFor, "10.0.0.1:4000", nil, "", "10.0.0.1"},
+		{"one trusted hop", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"several trusted hops", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"198.51.100.7, 10.2.0.1, 10.3.0.1"}, "", "198.51.100.7"},
+		{"client-written entries left of the client", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"192.0.2.66, 198.51.100.7, 10.2.0.1"}, "", "198.51.100.7"},
+		{"hops split over headers", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"198.51.100.7", "10.2.0.1"}, "", "198.51.100.7"},
+		{"only trusted hops", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"10.2.0.1"}, "", "10.2.0.1"},
+		{"unparsable hop stops the walk", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"198.51.100.7, unknown"}, "", "10.0.0.1"},
+		{"unparsable entry left of the client", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"unknown, 198.51.100.7"}, "", "198.51.100.7"},
+		{"X-Real-IP ignored in X-Forwarded-For mode", echo.HeaderXForwardedFor, "10.0.0.1:4000", nil, "198.51.100.7", "10.0.0.1"},
+		{"X-Real-IP from trusted proxy", echo.HeaderXRealIP, "10.0.0.1:4000", []string{"192.0.2.66"}, "198.51.100.7", "198.51.100.7"},
+		{"unparsable X-Real-IP", echo.HeaderXRealIP, "10.0.0.1:4000", nil, "unknown", "10.0.0.1"},
+		{"mapped untrusted peer", echo.HeaderXForwardedFor, "[::ffff:203.0.113.9]:4000", []string{"198.51.100.7"}, "", "203.0.113.9"},
+		{"mapped trusted peer", echo.HeaderXForwardedFor, "[::ffff:10.0.0.1]:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"mapped hops", echo.HeaderXForwardedFor, "10.0.0.1:4000", []string{"::ffff:198.51.100.7, ::ffff:10.2.0.1"}, "", "198.51.100.7"},
+		{"mapped X-Real-IP", echo.HeaderXRealIP, "10.0.0.1:4000", nil, "::ffff:198.51.100.7", "198.51.100.7"},
+		{"IPv6 trusted proxy", echo.HeaderXForwardedFor, "[2001:db8::1]:4000", []string{"2001:db8::42"}, "", "2001:db8::42"},
+		{"IPv6 untrusted peer", echo.HeaderXForwardedFor, "[2001:db8::2]:4000", []string{"2001:db8::42"}, "", "2001:db8::2"},
+	}
+	for _, tt := range tests {
+		config.ClientIPHeader = tt.header
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.forward {
+			r.Header.Add(echo.HeaderXForwardedFor, v)
+		}
+		if tt.realIP != "" {
+			r.Header.Set(echo.HeaderXRealIP, tt.realIP)
+		}
+		if got := extractClientIP(r); got != tt.want {
+			t.Errorf("%s: client %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	list := func(items ...string) ipList {
+		l, err := parseIPList("test", items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	tests := []struct {
+		name        string
+		addr        string
+		allow, deny ipList
+		want        bool
+	}{
+		{"no lists", "198.51.100.7", nil, nil, true},
+		{"allowed", "10.1.2.3", list("10.0.0.0/8"), nil, true},
+		{"outside allow", "198.51.100.7", list("10.0.0.0/8"), nil, false},
+		{"denied", "198.51.100.7", nil, list("198.51.100.0/24"), false},
+		{"not denied", "198.51.100.7", nil, list("203.0.113.9"), true},
+		{"deny wins over allow", "10.9.9.9", list("10.0.0.0/8"), list("10.9.9.9"), false},
+		{"deny network wins over allowed address", "10.9.9.9", list("10.9.9.9"), list("10.0.0.0/8"), false},
+		{"mapped address in IPv4 allow", "::ffff:10.1.2.3", list("10.0.0.0/8"), nil, true},
+		{"mapped address in IPv4 deny", "::ffff:10.9.9.9", nil, list("10.9.9.9"), false},
+		{"mapped entry in list", "10.9.9.9", nil, list("::ffff:10.9.9.9"), false},
+		{"IPv6 outside IPv4 allow", "2001:db8::1", list("10.0.0.0/8"), nil, false},
+	}
+	for _, tt := range tests {
+		if got := ipAllowed(netip.MustParseAddr(tt.addr), tt.allow, tt.deny); got != tt.want {
+			t.Errorf("%s: ipAllowed(%s) = %v, want %v", tt.name, tt.addr, got, tt.want)
+		}
+	}
+}
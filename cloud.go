@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	gbytes "github.com/labstack/gommon/bytes"
+	"golang.org/x/oauth2"
+)
+
+// Cookies identifying the browser's cloud connections and the in-flight OAuth state
+const (
+	cloudCookie      = "bulk_cloud"
+	cloudStateCookie = "bulk_cloud_state"
+)
+
+// cloudFile is a file or folder listed from a cloud drive
+type cloudFile struct {
+	ID      string
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Folder  bool
+}
+
+// cloudConnector lets users pick files from a cloud storage provider, which
+// are then fetched server-side with the user's OAuth token
+type cloudConnector struct {
+	// Short name used in routes and file references, e.g. "gdrive"
+	ID    string
+	Title string
+	OAuth oauth2.Config
+
+	// List returns the contents of folder; "" is the top level
+	List func(ctx context.Context, client *http.Client, folder string) ([]cloudFile, error)
+
+	// Download opens the file with the given ID
+	Download func(ctx context.Context, client *http.Client, id string) (cloudFile, io.ReadCloser, error)
+}
+
+// cloudConnectors holds the configured providers by ID
+var cloudConnectors = map[string]*cloudConnector{}
+
+// cloudConnection holds the OAuth tokens one browser has granted, by provider
+type cloudConnection struct {
+	Tokens  map[string]*oauth2.Token
+	Expires time.Time
+}
+
+// cloudConnections maps the bulk_cloud cookie value to its tokens. Tokens are
+// only kept in memory, so users reconnect after a restart.
+var (
+	cloudConnections = make(map[string]*cloudConnection)
+	cloudMutex       sync.Mutex
+)
+
+// setupCloudConnectors registers the providers that have OAuth credentials configured
+func setupCloudConnectors() {
+	if config.GDriveClientID != "" {
+		cloudConnectors["gdrive"] = &cloudConnector{
+			ID:    "gdrive",
+			Title: "Google Drive",
+			OAuth: oauth2.Config{
+				ClientID:     config.GDriveClientID,
+				ClientSecret: config.GDriveClientSecret,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+					TokenURL: "https://oauth2.googleapis.com/token",
+				},
+				Scopes: []string{"https://www.googleapis.com/auth/drive.readonly"},
+			},
+			List:     listDrive,
+			Download: downloadDrive,
+		}
+	}
+	if config.DropboxAppKey != "" {
+		cloudConnectors["dropbox"] = &cloudConnector{
+			ID:    "dropbox",
+			Title: "Dropbox",
+			OAuth: oauth2.Config{
+				ClientID:     config.DropboxAppKey,
+				ClientSecret: config.DropboxAppSecret,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+					TokenURL: "https://api.dropboxapi.com/oauth2/token",
+				},
+			},
+			List:     listDropbox,
+			Download: downloadDropbox,
+		}
+	}
+	for id := range cloudConnectors {
+		log.Printf("Cloud connector enabled: %s", id)
+	}
+}
+
+// oauthConfig returns the connector's OAuth settings with the callback URL for this request
+func (cc *cloudConnector) oauthConfig(c echo.Context) *oauth2.Config {
+	cfg := cc.OAuth
+	cfg.RedirectURL = absoluteURL(c, "/connect/"+cc.ID+"/callback")
+	return &cfg
+}
+
+// cloudToken returns the browser's token for provider, if it has connected it
+func cloudToken(c echo.Context, provider string) (*oauth2.Token, bool) {
+	cookie, err := c.Cookie(cloudCookie)
+	if err != nil {
+		return nil, false
+	}
+	cloudMutex.Lock()
+	defer cloudMutex.Unlock()
+	conn, ok := cloudConnections[cookie.Value]
+	if !ok || time.Now().After(conn.Expires) {
+		return nil, false
+	}
+	token, ok := conn.Tokens[provider]
+	return token, ok
+}
+
+// saveCloudToken stores token for the browser, issuing a connection cookie if needed
+func saveCloudToken(c echo.Context, provider string, token *oauth2.Token) {
+	cloudMutex.Lock()
+	defer cloudMutex.Unlock()
+
+	now := time.Now()
+	for id, conn := range cloudConnections {
+		if now.After(conn.Expires) {
+			delete(cloudConnections, id)
+		}
+	}
+
+	var id string
+	if cookie, err := c.Cookie(cloudCookie); err == nil && cloudConnections[cookie.Value] != nil {
+		id = cookie.Value
+	} else {
+		id = randomToken(16)
+		cloudConnections[id] = &cloudConnection{Tokens: make(map[string]*oauth2.Token)}
+	}
+	conn := cloudConnections[id]
+	conn.Tokens[provider] = token
+	conn.Expires = now.Add(config.SessionTTL)
+
+	c.SetCookie(&http.Cookie{
+		Name:     cloudCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  conn.Expires,
+		HttpOnly: true,
+		Secure:   len(config.TLSDomains) > 0,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handleCloudOptions returns a browse button for each configured provider
+func handleCloudOptions(c echo.Context) error {
+	if len(cloudConnectors) == 0 {
+		return c.NoContent(http.StatusOK)
+	}
+	var connectors []*cloudConnector
+	for _, id := range []string{"gdrive", "dropbox"} {
+		if cc, ok := cloudConnectors[id]; ok {
+			connectors = append(connectors, cc)
+		}
+	}
+	return c.Render(http.StatusOK, "cloud_options", connectors)
+}
+
+// handleCloudConnect redirects the browser to the provider to grant access
+func handleCloudConnect(c echo.Context) error {
+	cc, ok := cloudConnectors[c.Param("provider")]
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "Error: Unknown cloud provider")
+	}
+
+	state := randomToken(16)
+	c.SetCookie(&http.Cookie{
+		Name:     cloudStateCookie,
+		Value:    state,
+		Path:     "/connect",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   len(config.TLSDomains) > 0,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, cc.oauthConfig(c).AuthCodeURL(state))
+}
+
+// handleCloudCallback stores the token granted by the provider
+func handleCloudCallback(c echo.Context) error {
+	cc, ok := cloudConnectors[c.Param("provider")]
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "Error: Unknown cloud provider")
+	}
+
+	stateCookie, err := c.Cookie(cloudStateCookie)
+	if err != nil || stateCookie.Value == "" || stateCookie.Value != c.QueryParam("state") {
+		return htmlError(c, http.StatusBadRequest, "Error: Connection expired, please try again")
+	}
+	if errParam := c.QueryParam("error"); errParam != "" {
+		log.Printf("%s returned error: %s (%s)", cc.Title, errParam, c.QueryParam("error_description"))
+		return htmlError(c, http.StatusUnauthorized, "Error: %s access was not granted", cc.Title)
+	}
+
+	token, err := cc.oauthConfig(c).Exchange(c.Request().Context(), c.QueryParam("code"))
+	if err != nil {
+		log.Printf("%s code exchange failed: %v", cc.Title, err)
+		return htmlError(c, http.StatusUnauthorized, "Error: Could not connect to %s", cc.Title)
+	}
+	saveCloudToken(c, cc.ID, token)
+	log.Printf("Browser connected to %s", cc.Title)
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+// handleCloudFiles lists a folder of the provider as checkboxes for the upload form
+func handleCloudFiles(c echo.Context) error {
+	cc, ok := cloudConnectors[c.Param("provider")]
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "Error: Unknown cloud provider")
+	}
+	token, ok := cloudToken(c, cc.ID)
+	if !ok {
+		return c.Render(http.StatusOK, "cloud_connect", cc)
+	}
+
+	ctx := c.Request().Context()
+	files, err := cc.List(ctx, cc.OAuth.Client(ctx, token), c.QueryParam("folder"))
+	if err != nil {
+		log.Printf("Listing %s failed: %v", cc.Title, err)
+		return htmlError(c, http.StatusBadGateway, "Error: Could not list %s files", cc.Title)
+	}
+
+	return c.Render(http.StatusOK, "cloud_files", map[string]interface{}{
+		"ID":       cc.ID,
+		"Title":    cc.Title,
+		"InFolder": c.QueryParam("folder") != "",
+		"Files":    files,
+	})
+}
+
+// fetchCloudFiles downloads the picked provider:id references with the
+// browser's tokens into spool files, skipping downloads that fail when failed
+// allows it. The returned cleanup func removes them.
+func fetchCloudFiles(c echo.Context, refs []string, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	var spooled []string
+	cleanup := func() {
+		for _, p := range spooled {
+			os.Remove(p)
+		}
+	}
+
+	entries := make([]archiveEntry, 0, len(refs))
+	for _, ref := range refs {
+		provider, id, _ := strings.Cut(ref, ":")
+		cc, ok := cloudConnectors[provider]
+		if !ok || id == "" {
+			cleanup()
+			return nil, nil, fmt.Errorf("Invalid cloud file %q", ref)
+		}
+		token, ok := cloudToken(c, cc.ID)
+		if !ok {
+			cleanup()
+			return nil, nil, fmt.Errorf("Not connected to %s", cc.Title)
+		}
+
+		limit := budget
+		if config.MaxFileSize > 0 && config.MaxFileSize < limit {
+			limit = config.MaxFileSize
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), config.FetchTimeout)
+		f, body, err := cc.Download(ctx, cc.OAuth.Client(ctx, token), id)
+		var spoolPath string
+		var n int64
+		if err == nil {
+			spoolPath, n, _, err = spoolBody(body, spillDir(), limit)
+			body.Close()
+		}
+		cancel()
+		if err != nil {
+			if errors.Is(err, errFetchTooLarge) {
+				err = fmt.Errorf("%s file %s is too large (max %s)", cc.Title, f.Name, gbytes.Format(limit))
+			} else {
+				err = fmt.Errorf("Error fetching %s from %s: %v", f.Name, cc.Title, err)
+			}
+			name := f.Name
+			if name == "" {
+				name = ref
+			}
+			if failed != nil && failed(name, err) {
+				continue
+			}
+			cleanup()
+			return nil, nil, err
+		}
+		spooled = append(spooled, spoolPath)
+		budget -= n
+		log.Printf("Fetched %s from %s (%s)", f.Name, cc.Title, gbytes.Format(n))
+
+		entries = append(entries, archiveEntry{
+			Name: f.Name,
+			Size: n,
+			Open: func() (io.ReadCloser, error) { return os.Open(spoolPath) },
+
+			ModTime: f.ModTime,
+			Source:  ref,
+		})
+	}
+	return entries, cleanup, nil
+}
+
+// driveFile is a file resource of the Google Drive v3 API
+type driveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	Size         int64     `json:"size,string"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+// Google Drive folders and native documents, which can't be downloaded as-is
+const (
+	driveFolderType = "application/vnd.google-apps.folder"
+	driveAppsPrefix = "application/vnd.google-apps."
+)
+
+// listDrive lists a Google Drive folder, skipping native Google documents
+func listDrive(ctx context.Context, client *http.Client, folder string) ([]cloudFile, error) {
+	if folder == "" {
+		folder = "root"
+	}
+	q := url.Values{
+		"q":        {fmt.Sprintf("'%s' in parents and trashed = false", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(folder))},
+		"fields":   {"files(id,name,mimeType,size,modifiedTime)"},
+		"orderBy":  {"folder,name"},
+		"pageSize": {"1000"},
+	}
+	var resp struct {
+		Files []driveFile `json:"files"`
+	}
+	if err := cloudJSON(ctx, client, http.MethodGet, "https://www.googleapis.com/drive/v3/files?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	files := make([]cloudFile, 0, len(resp.Files))
+	for _, f := range resp.Files {
+		folder := f.MimeType == driveFolderType
+		if !folder && strings.HasPrefix(f.MimeType, driveAppsPrefix) {
+			continue
+		}
+		files = append(files, cloudFile{ID: f.ID, Name: f.Name, Size: f.Size, ModTime: f.ModifiedTime, Folder: folder})
+	}
+	return files, nil
+}
+
+// downloadDrive opens the content of a Google Drive file
+func downloadDrive(ctx context.Context, client *http.Client, id string) (cloudFile, io.ReadCloser, error) {
+	base := "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(id)
+	var meta driveFile
+	if err := cloudJSON(ctx, client, http.MethodGet, base+"?fields=id,name,mimeType,size,modifiedTime", nil, &meta); err != nil {
+		return cloudFile{Name: id}, nil, err
+	}
+	f := cloudFile{ID: meta.ID, Name: meta.Name, Size: meta.Size, ModTime: meta.ModifiedTime}
+	if strings.HasPrefix(meta.MimeType, driveAppsPrefix) {
+		return f, nil, fmt.Errorf("Google documents can't be downloaded as files")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"?alt=media", nil)
+	if err != nil {
+		return f, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return f, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return f, nil, fmt.Errorf("Google Drive returned %s", resp.Status)
+	}
+	return f, resp.Body, nil
+}
+
+// dropboxEntry is a file or folder metadata object of the Dropbox API
+type dropboxEntry struct {
+	Tag            string    `json:".tag"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+// listDropbox lists a Dropbox folder
+func listDropbox(ctx context.Context, client *http.Client, folder string) ([]cloudFile, error) {
+	var resp struct {
+		Entries []dropboxEntry `json:"entries"`
+	}
+	body := map[string]string{"path": folder}
+	if err := cloudJSON(ctx, client, http.MethodPost, "https://api.dropboxapi.com/2/files/list_folder", body, &resp); err != nil {
+		return nil, err
+	}
+
+	files := make([]cloudFile, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		if e.Tag != "file" && e.Tag != "folder" {
+			continue
+		}
+		files = append(files, cloudFile{ID: e.ID, Name: e.Name, Size: e.Size, ModTime: e.ServerModified, Folder: e.Tag == "folder"})
+	}
+	return files, nil
+}
+
+// downloadDropbox opens the content of a Dropbox file
+func downloadDropbox(ctx context.Context, client *http.Client, id string) (cloudFile, io.ReadCloser, error) {
+	f := cloudFile{ID: id, Name: id}
+	arg, err := json.Marshal(map[string]string{"path": id})
+	if err != nil {
+		return f, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://content.dropboxapi.com/2/files/download", nil)
+	if err != nil {
+		return f, nil, err
+	}
+	req.Header.Set("Dropbox-API-Arg", string(arg))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return f, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return f, nil, fmt.Errorf("Dropbox returned %s", resp.Status)
+	}
+
+	var meta dropboxEntry
+	if err := json.Unmarshal([]byte(resp.Header.Get("Dropbox-API-Result")), &meta); err == nil {
+		f = cloudFile{ID: meta.ID, Name: meta.Name, Size: meta.Size, ModTime: meta.ServerModified}
+	}
+	return f, resp.Body, nil
+}
+
+// cloudJSON sends a provider API request with an optional JSON body and decodes the JSON response into out
+func cloudJSON(ctx context.Context, client *http.Client, method, rawURL string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
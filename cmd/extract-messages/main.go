@@ -0,0 +1,153 @@
+// Command extract-messages collects the translatable UI messages of
+// bulk-download into a JSON catalog. It picks up string literals passed to
+// htmlError, htmlBatchError, htmlSuccess, translate and tr, archiveError
+// messages and capitalized errors.New texts in the Go sources, plus
+// {{t "..."}} calls in the templates.
+//
+// Run it from the repository root:
+//
+//	go run ./cmd/extract-messages -o locales/de.json
+//
+// Existing translations in the output file are kept and messages no longer
+// used are dropped, so the same command refreshes a catalog.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"unicode"
+)
+
+// messageArgs maps helper functions to the index of their message argument
+var messageArgs = map[string]int{
+	"htmlError":      2,
+	"htmlBatchError": 3,
+	"htmlSuccess":    1,
+	"translate":      1,
+	"tr":             1,
+}
+
+// templateCall matches {{t "message" ...}} in a template
+var templateCall = regexp.MustCompile(`\{\{-?\s*t\s+("(?:[^"\\]|\\.)*")`)
+
+func main() {
+	out := flag.String("o", "locales/messages.json", "catalog file to write")
+	src := flag.String("src", ".", "directory with the Go sources")
+	templates := flag.String("templates", "templates", "directory with the templates")
+	flag.Parse()
+
+	messages := make(map[string]bool)
+	if err := extractGo(*src, messages); err != nil {
+		log.Fatal(err)
+	}
+	if err := extractTemplates(*templates, messages); err != nil {
+		log.Fatal(err)
+	}
+
+	catalog := make(map[string]string)
+	if data, err := os.ReadFile(*out); err == nil {
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			log.Fatalf("%s: %v", *out, err)
+		}
+	}
+	merged := make(map[string]string, len(messages))
+	for msg := range messages {
+		merged[msg] = catalog[msg]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(merged); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(*out), 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %d messages to %s", len(merged), *out)
+}
+
+// extractGo adds the messages found in the package sources in dir
+func extractGo(dir string, messages map[string]bool) error {
+	fset := token.NewFileSet()
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				switch fun := n.Fun.(type) {
+				case *ast.Ident:
+					if i, ok := messageArgs[fun.Name]; ok && i < len(n.Args) {
+						addLiteral(n.Args[i], messages, false)
+					}
+				case *ast.SelectorExpr:
+					if pkg, ok := fun.X.(*ast.Ident); ok && pkg.Name == "errors" && fun.Sel.Name == "New" && len(n.Args) == 1 {
+						addLiteral(n.Args[0], messages, true)
+					}
+				}
+			case *ast.CompositeLit:
+				if typ, ok := n.Type.(*ast.Ident); ok && typ.Name == "archiveError" && len(n.Elts) > 0 {
+					addLiteral(n.Elts[0], messages, false)
+				}
+			}
+			return true
+		})
+	}
+	return nil
+}
+
+// extractTemplates adds the {{t "..."}} messages of the templates under dir
+func extractTemplates(dir string, messages map[string]bool) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".html" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range templateCall.FindAllSubmatch(data, -1) {
+			if msg, err := strconv.Unquote(string(m[1])); err == nil {
+				messages[msg] = true
+			}
+		}
+		return nil
+	})
+}
+
+// addLiteral records expr if it is a string literal. Internal errors start
+// lower case, so capitalized skips those when set.
+func addLiteral(expr ast.Expr, messages map[string]bool, capitalized bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	msg, err := strconv.Unquote(lit.Value)
+	if err != nil || msg == "" || msg == "%s" {
+		return
+	}
+	if capitalized && !unicode.IsUpper([]rune(msg)[0]) {
+		return
+	}
+	messages[msg] = true
+}
@@ -0,0 +1,511 @@
+// Command gen-client generates the Go types and methods of the client
+// package from the OpenAPI document. It understands the subset of OpenAPI 3
+// the document uses: named object and string enum schemas, arrays, $ref
+// parameters, JSON responses, and multipart or raw request bodies.
+//
+// Run it from the repository root:
+//
+//	go run ./cmd/gen-client -spec api/openapi.json -o client/api.gen.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type spec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*schema    `json:"schemas"`
+		Parameters map[string]*parameter `json:"parameters"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string       `json:"operationId"`
+	Summary     string       `json:"summary"`
+	Parameters  []*parameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Ref     string `json:"$ref"`
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref         string             `json:"$ref"`
+	Type        string             `json:"type"`
+	Format      string             `json:"format"`
+	Description string             `json:"description"`
+	Enum        []string           `json:"enum"`
+	Required    []string           `json:"required"`
+	Properties  map[string]*schema `json:"properties"`
+	Items       *schema            `json:"items"`
+
+	// Property order as written in the document
+	order []string
+}
+
+// UnmarshalJSON keeps the order of properties so generated structs follow
+// the document
+func (s *schema) UnmarshalJSON(data []byte) error {
+	type plain schema
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var raw struct {
+		Properties json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil || raw.Properties == nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw.Properties))
+	dec.Token()
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		s.order = append(s.order, key.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initialisms are written in capitals in Go names
+var initialisms = map[string]string{"id": "ID", "url": "URL", "sha256": "SHA256", "api": "API", "ip": "IP"}
+
+// goName turns a JSON, form or header name into an exported Go name
+func goName(name string) string {
+	name = strings.TrimPrefix(name, "X-")
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	for i, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && i > 0 && len(word) > 0 && !unicode.IsUpper(word[len(word)-1]):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+
+	var b strings.Builder
+	for _, w := range words {
+		if v, ok := initialisms[strings.ToLower(w)]; ok {
+			b.WriteString(v)
+			continue
+		}
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String()
+}
+
+// refName returns the component a $ref points to
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+type generator struct {
+	spec    *spec
+	buf     bytes.Buffer
+	forms   map[string]bool
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// goType returns the Go type for a schema; optional objects and times become
+// pointers so they can be left out
+func (g *generator) goType(s *schema, required bool) (string, error) {
+	if s.Ref != "" {
+		name := refName(s.Ref)
+		target, ok := g.spec.Components.Schemas[name]
+		if !ok {
+			return "", fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		if target.Type == "object" && !required {
+			return "*" + name, nil
+		}
+		return name, nil
+	}
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			g.imports["time"] = true
+			if required {
+				return "time.Time", nil
+			}
+			return "*time.Time", nil
+		case "binary":
+			return "File", nil
+		}
+		return "string", nil
+	case "integer":
+		if s.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		elem, err := g.goType(s.Items, true)
+		return "[]" + elem, err
+	}
+	return "", fmt.Errorf("unsupported schema type %q", s.Type)
+}
+
+// comment writes text as a doc comment
+func (g *generator) comment(indent, text string) {
+	if text != "" {
+		g.printf("%s// %s\n", indent, text)
+	}
+}
+
+// schemaType writes the declaration of one component schema
+func (g *generator) schemaType(name string, s *schema) error {
+	g.comment("", s.Description)
+	if s.Type == "string" && len(s.Enum) > 0 {
+		g.printf("type %s string\n\nconst (\n", name)
+		for _, v := range s.Enum {
+			g.printf("\t%s%s %s = %q\n", name, goName(v), name, v)
+		}
+		g.printf(")\n\n")
+		return nil
+	}
+	if s.Type != "object" {
+		return fmt.Errorf("schema %s: only objects and string enums are supported", name)
+	}
+
+	required := make(map[string]bool)
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	form := g.forms[name]
+	g.printf("type %s struct {\n", name)
+	for _, prop := range s.order {
+		p := s.Properties[prop]
+		typ, err := g.goType(p, required[prop])
+		if err != nil {
+			return fmt.Errorf("schema %s, property %s: %w", name, prop, err)
+		}
+		if form {
+			// Unset booleans leave the server's default in place
+			if typ == "bool" {
+				typ = "*bool"
+			}
+			g.comment("\t", p.Description)
+			g.printf("\t%s %s\n", goName(prop), typ)
+			continue
+		}
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		g.comment("\t", p.Description)
+		g.printf("\t%s %s `json:%q`\n", goName(prop), typ, tag)
+	}
+	g.printf("}\n\n")
+
+	if form {
+		return g.formWriter(name, s)
+	}
+	return nil
+}
+
+// formWriter writes the method adding a form schema's fields to a
+// multipart body, skipping unset ones
+func (g *generator) formWriter(name string, s *schema) error {
+	g.printf("func (f *%s) writeTo(mw *multipart.Writer) error {\n", name)
+	for _, prop := range s.order {
+		p := s.Properties[prop]
+		field := goName(prop)
+		typ, err := g.goType(p, true)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "[]File":
+			g.printf("\tif err := writeFiles(mw, %q, f.%s); err != nil {\n\t\treturn err\n\t}\n", prop, field)
+		case "bool":
+			g.imports["strconv"] = true
+			g.printf("\tif f.%s != nil {\n\t\tif err := mw.WriteField(%q, strconv.FormatBool(*f.%s)); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n", field, prop, field)
+		case "string":
+			g.printf("\tif f.%s != \"\" {\n\t\tif err := mw.WriteField(%q, f.%s); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n", field, prop, field)
+		default:
+			if p.Ref == "" || len(g.spec.Components.Schemas[refName(p.Ref)].Enum) == 0 {
+				return fmt.Errorf("form %s, field %s: unsupported type %s", name, prop, typ)
+			}
+			g.printf("\tif f.%s != \"\" {\n\t\tif err := mw.WriteField(%q, string(f.%s)); err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n", field, prop, field)
+		}
+	}
+	g.printf("\treturn nil\n}\n\n")
+	return nil
+}
+
+// param resolves a $ref parameter
+func (g *generator) param(p *parameter) (*parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	target, ok := g.spec.Components.Parameters[refName(p.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("unknown parameter %s", p.Ref)
+	}
+	return target, nil
+}
+
+// method writes the client method for one operation
+func (g *generator) method(path, httpMethod string, op *operation) error {
+	name := goName(op.OperationID)
+	var pathParams, optional []*parameter
+	for _, ref := range op.Parameters {
+		p, err := g.param(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op.OperationID, err)
+		}
+		if p.In == "path" {
+			pathParams = append(pathParams, p)
+		} else {
+			optional = append(optional, p)
+		}
+	}
+
+	// Query and header parameters are optional and go in a struct
+	if len(optional) > 0 {
+		g.printf("// %sParams are the optional parameters of %s\ntype %sParams struct {\n", name, name, name)
+		for _, p := range optional {
+			typ, err := g.goType(p.Schema, true)
+			if err != nil {
+				return fmt.Errorf("%s, parameter %s: %w", op.OperationID, p.Name, err)
+			}
+			g.printf("\t%s %s\n", goName(p.Name), typ)
+		}
+		g.printf("}\n\n")
+	}
+
+	// A protocol switch can't go through do, so the method only builds the
+	// URL to dial with a WebSocket library
+	if _, ok := op.Responses["101"]; ok {
+		var params []string
+		expr := fmt.Sprintf("%q", path)
+		for _, p := range pathParams {
+			params = append(params, p.Name+" string")
+			expr = strings.Replace(expr, "{"+p.Name+"}", `" + url.PathEscape(`+p.Name+`) + "`, 1)
+		}
+		expr = strings.TrimSuffix(expr, ` + ""`)
+		g.printf("// %sURL returns the WebSocket URL of %s %s, used to %s\n", name, strings.ToUpper(httpMethod), path, strings.ToLower(op.Summary[:1])+op.Summary[1:])
+		g.printf("func (c *Client) %sURL(%s) string {\n\treturn websocketURL(c.BaseURL) + %s\n}\n\n", name, strings.Join(params, ", "), expr)
+		return nil
+	}
+
+	args := []string{"ctx context.Context"}
+	for _, p := range pathParams {
+		args = append(args, p.Name+" string")
+	}
+	if len(optional) > 0 {
+		args = append(args, "params *"+name+"Params")
+	}
+	var body string
+	if op.RequestBody != nil {
+		if c, ok := op.RequestBody.Content["multipart/form-data"]; ok {
+			body = refName(c.Schema.Ref)
+			args = append(args, "form *"+body)
+		} else if _, ok := op.RequestBody.Content["application/octet-stream"]; ok {
+			body = "raw"
+			g.imports["io"] = true
+			args = append(args, "body io.Reader")
+		} else {
+			return fmt.Errorf("%s: unsupported request body", op.OperationID)
+		}
+	}
+
+	var result string
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if !strings.HasPrefix(code, "2") {
+			continue
+		}
+		if c, ok := op.Responses[code].Content["application/json"]; ok {
+			result = refName(c.Schema.Ref)
+			break
+		}
+	}
+
+	g.printf("// %s sends %s %s to %s\n", name, strings.ToUpper(httpMethod), path, strings.ToLower(op.Summary[:1])+op.Summary[1:])
+	if result != "" {
+		g.printf("func (c *Client) %s(%s) (*%s, error) {\n", name, strings.Join(args, ", "), result)
+	} else {
+		g.printf("func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+	}
+
+	// Path parameters are escaped into the path template
+	expr := fmt.Sprintf("%q", path)
+	for _, p := range pathParams {
+		expr = strings.Replace(expr, "{"+p.Name+"}", `" + url.PathEscape(`+p.Name+`) + "`, 1)
+	}
+	expr = strings.TrimSuffix(expr, ` + ""`)
+	g.printf("\treq := request{method: %q, path: %s, query: url.Values{}, header: http.Header{}}\n", strings.ToUpper(httpMethod), expr)
+	if len(optional) > 0 {
+		g.imports["fmt"] = true
+		g.printf("\tif params != nil {\n")
+		for _, p := range optional {
+			field := goName(p.Name)
+			typ, _ := g.goType(p.Schema, true)
+			zero := `""`
+			if typ == "int" || typ == "int64" {
+				zero = "0"
+			}
+			set := "req.query.Set"
+			if p.In == "header" {
+				set = "req.header.Set"
+			}
+			g.printf("\t\tif params.%s != %s {\n\t\t\t%s(%q, fmt.Sprint(params.%s))\n\t\t}\n", field, zero, set, p.Name, field)
+		}
+		g.printf("\t}\n")
+	}
+	switch body {
+	case "":
+	case "raw":
+		g.printf("\treq.body, req.contentType = body, \"application/octet-stream\"\n")
+	default:
+		g.printf("\tif form != nil {\n\t\treq.form = form.writeTo\n\t}\n")
+	}
+	if result == "" {
+		g.printf("\treturn c.do(ctx, req, nil)\n}\n\n")
+		return nil
+	}
+	g.printf("\tvar out %s\n\tif err := c.do(ctx, req, &out); err != nil {\n\t\treturn nil, err\n\t}\n\treturn &out, nil\n}\n\n", result)
+	return nil
+}
+
+func (g *generator) generate(source string) ([]byte, error) {
+	g.imports = map[string]bool{"context": true, "net/http": true, "net/url": true}
+
+	// Multipart bodies get a writer instead of JSON tags
+	g.forms = make(map[string]bool)
+	for _, methods := range g.spec.Paths {
+		for _, op := range methods {
+			if op.RequestBody == nil {
+				continue
+			}
+			if c, ok := op.RequestBody.Content["multipart/form-data"]; ok && c.Schema.Ref != "" {
+				g.forms[refName(c.Schema.Ref)] = true
+				g.imports["mime/multipart"] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(g.spec.Components.Schemas))
+	for name := range g.spec.Components.Schemas {
+		if name != "Error" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := g.schemaType(name, g.spec.Components.Schemas[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]string, 0, len(g.spec.Paths))
+	for path := range g.spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		methods := make([]string, 0, len(g.spec.Paths[path]))
+		for m := range g.spec.Paths[path] {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			if err := g.method(path, m, g.spec.Paths[path][m]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by gen-client from %s; DO NOT EDIT.\n\npackage client\n\nimport (\n", source)
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&src, "\t%q\n", imp)
+	}
+	src.WriteString(")\n\n")
+	src.Write(g.buf.Bytes())
+	return format.Source(src.Bytes())
+}
+
+func main() {
+	specPath := flag.String("spec", "api/openapi.json", "OpenAPI document to read")
+	out := flag.String("o", "client/api.gen.go", "Go file to write")
+	flag.Parse()
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var s spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		log.Fatalf("parsing %s: %v", *specPath, err)
+	}
+	g := &generator{spec: &s}
+	src, err := g.generate(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s", *out)
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// Config holds the server settings, read from BULK_* environment variables
+type Config struct {
+	// Address the HTTP server listens on
+	Addr string
+
+	// Limits on how long a client may take to send its headers and request
+	// body, to receive the response, and to keep an idle connection; 0 disables
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// Externally visible base URL, e.g. https://zip.example.com; derived from
+	// each request when empty
+	PublicURL string
+
+	// Domains to obtain Let's Encrypt certificates for; HTTPS is off when empty
+	TLSDomains []string
+
+	// Address of the HTTPS listener when TLS is enabled
+	TLSAddr string
+
+	// Where issued certificates are cached; defaults to DataDir/autocert
+	TLSCacheDir string
+
+	// Contact email registered with the ACME account
+	TLSEmail string
+
+	// Plain HTTP listener that redirects to HTTPS; "off" disables it
+	HTTPRedirectAddr string
+
+	// Directory where temporary ZIP archives are written
+	TempDir string
+
+	// Extra volumes for temporary files; each file goes to whichever of these
+	// and TempDir has the most free space
+	SpillDirs []string
+
+	// Directory for persistent state such as download analytics
+	DataDir string
+
+	// Audit trail file; defaults to DataDir/audit.jsonl, "off" disables it
+	AuditLog string
+
+	// File the application and access logs go to instead of stderr and stdout
+	LogFile string
+
+	// Size at which the log file is rotated; 0 disables size rotation
+	LogMaxSize int64
+
+	// Period after which the log file is rotated, aligned to UTC; 0 disables it
+	LogRotate time.Duration
+
+	// How many rotated log files are kept, and for how long; 0 means no limit
+	LogKeep   int
+	LogMaxAge time.Duration
+
+	// Chat webhooks events are posted to, as kind=url entries like slack=https://...
+	Notifiers []string
+
+	// Events to notify about, each sent to every notifier or, written as
+	// job.failed=slack+teams, only to those named
+	NotifyEvents []string
+
+	// Least time between notifications of a repeated quota or disk event
+	NotifyCooldown time.Duration
+
+	// Directory with the page templates and the HTMX fragments in its partials folder
+	TemplateDir string
+
+	// Directory with <locale>.json message catalogs
+	LocaleDir string
+
+	// Branding of the pages: title, logo, color theme (auto, light or dark),
+	// accent color and footer text
+	UITitle       string
+	UILogoURL     string
+	UITheme       string
+	UIAccentColor string
+	UIFooter      string
+
+	// How long an archive waits to be downloaded before it is deleted; 0 keeps it forever
+	ArchiveTTL time.Duration
+
+	// Bounds for link lifetimes chosen by uploaders; no upper bound when ArchiveTTLMax is 0
+	ArchiveTTLMin time.Duration
+	ArchiveTTLMax time.Duration
+
+	// Template for download names, e.g. {user}_{date}_{count}files.zip
+	NameTemplate string
+
+	// Minimum free space in TempDir before the service reports not ready
+	MinFreeDisk int64
+
+	// Uploads at most this large are archived in memory rather than in TempDir; 0 disables
+	MemoryArchiveMax int64
+
+	// Combined size of archives held in memory before new ones go to disk
+	MemoryArchiveBudget int64
+
+	// 7z binary used for .7z output; empty disables the format
+	SevenZipPath string
+
+	// How long the 7z binary may run for one archive
+	SevenZipTimeout time.Duration
+
+	// Put files at the archive root by default instead of keeping their folders
+	Flatten bool
+
+	// Files of one ZIP archive compressed at the same time; 1 compresses in order
+	CompressWorkers int
+
+	// Archives built at the same time; further jobs queue by priority. 0 is unlimited
+	JobWorkers int
+
+	// Longest one archive build may take once it has a worker; 0 disables the limit
+	JobTimeout time.Duration
+
+	// Interactive jobs started for each batch job while both are queued
+	InteractiveWeight int
+
+	// Encrypt archive files with a per-archive key kept only in the store
+	EncryptAtRest bool
+
+	// Maximum combined size of archives held on disk; 0 disables the budget
+	DiskBudget int64
+
+	// Store files with identical content only once; off unless enabled here
+	// or asked for by the upload, since duplicates are left out of the archive
+	DedupUploads bool
+
+	// Maximum combined size of all files in one upload
+	MaxUploadSize int64
+
+	// Maximum size of a single uploaded file; 0 disables the limit
+	MaxFileSize int64
+
+	// Form file data kept in memory per request before parts spill to disk
+	MultipartMemory int64
+
+	// Maximum number of files in one upload; 0 disables the limit
+	MaxFiles int
+
+	// Most entries one uploaded archive may contain; 0 disables the limit
+	InputMaxEntries int
+
+	// Most bytes one uploaded archive may decompress to; 0 uses MaxUploadSize
+	InputMaxExpanded int64
+
+	// Highest decompressed-to-compressed ratio of an uploaded archive or any
+	// of its entries; 0 disables the check
+	InputMaxRatio int
+
+	// How long an upload session without new files is kept before it is dropped
+	UploadSessionTTL time.Duration
+
+	// Where flagged files wait for review; quarantine under DataDir when empty
+	QuarantineDir string
+
+	// File extensions, such as .exe, that send a file to quarantine
+	SuspiciousExtensions []string
+
+	// Sniffed content types, such as application/x-msdownload, that send a file to quarantine
+	SuspiciousTypes []string
+
+	// Command run with each file's path appended; a non-zero exit quarantines the file
+	ScanCommand string
+
+	// Processing stages, as name or name:argument, every file passes through before archiving
+	FileStages []string
+
+	// Timeout for fetching a single remote URL
+	FetchTimeout time.Duration
+
+	// Allow fetching from loopback and private network addresses
+	FetchAllowPrivate bool
+
+	// Parallel Range requests used for one large remote file; 1 disables segmenting
+	FetchSegments int
+
+	// Smallest remote file that is fetched in segments
+	FetchSegmentMin int64
+
+	// Retries of a ranged fetch after a transient failure, resuming each segment
+	FetchRetries int
+
+	// Where interrupted ranged fetches are kept for resuming; defaults to DataDir/partials
+	FetchPartialDir string
+
+	// How long an abandoned partial download is kept
+	FetchPartialTTL time.Duration
+
+	// Where fetched payloads are cached; defaults to DataDir/fetchcache
+	FetchCacheDir string
+
+	// Size budget of the fetch cache; 0 disables caching
+	FetchCacheSize int64
+
+	// Most URL fetches one job runs at once, and of those to any one host;
+	// jobs may ask for fewer
+	FetchConcurrency int
+	FetchPerHost     int
+
+	// Pause between requests to the same host; jobs may ask for longer
+	FetchDelay time.Duration
+
+	// Download rate of one job's URL fetches and of all of them combined, in
+	// bytes per second; 0 is unlimited. Jobs may ask for a lower rate.
+	FetchRate       int64
+	FetchRateGlobal int64
+
+	// known_hosts file used to verify SFTP servers; SFTP is refused when empty
+	SFTPKnownHosts string
+
+	// Buckets s3://bucket/key fetch URLs may read from with the S3
+	// credentials below; s3:// URLs are refused when empty
+	FetchS3Buckets []string
+
+	// Private key offered to SFTP servers in addition to any password in the URL
+	SFTPKeyFile string
+
+	// Hosts, optionally with a port, that SFTP fetches offer SFTPKeyFile to;
+	// the SFTP delivery target always gets it
+	SFTPKeyHosts []string
+
+	// Google OAuth client for picking files from Google Drive; disabled when empty
+	GDriveClientID     string
+	GDriveClientSecret string
+
+	// Dropbox app credentials for picking files from Dropbox; disabled when empty
+	DropboxAppKey    string
+	DropboxAppSecret string
+
+	// Remote destinations archives can be pushed to, as s3://bucket/prefix,
+	// sftp://user@host/dir or webdav[s]://user:password@host/path URLs
+	DeliveryTargets []string
+
+	// S3 region and credentials; S3Endpoint switches to path-style requests
+	// against an S3-compatible service
+	S3Region       string
+	S3Endpoint     string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3SessionToken string
+
+	// Directory whose new subdirectories and file batches are archived
+	// automatically; disabled when empty
+	WatchDir string
+
+	// How long a dropped item must go unchanged before it is archived
+	WatchSettle time.Duration
+
+	// Server directories admins may archive on demand with POST /admin/dirs;
+	// the endpoint is off when empty
+	ServerDirs []string
+
+	// Origins allowed to call the /api routes from the browser; CORS is off when empty
+	CORSOrigins []string
+
+	// Response headers cross-origin API callers may read
+	CORSExposeHeaders []string
+
+	// Let cross-origin API calls carry cookies
+	CORSCredentials bool
+
+	// How long browsers may cache a preflight response
+	CORSMaxAge time.Duration
+
+	// Retired API versions as version:deprecation-date[:sunset-date]
+	APIDeprecations []string
+
+	// Require a CSRF token on form posts from browsers
+	CSRF bool
+
+	// Send CSP, nosniff, framing and referrer headers
+	SecureHeaders bool
+
+	// Content-Security-Policy sent with every response
+	ContentSecurityPolicy string
+
+	// Referrer-Policy sent with every response; keeps tokens in links from leaking
+	ReferrerPolicy string
+
+	// Simultaneous download streams across the server; 0 is unlimited
+	MaxDownloads int
+
+	// Simultaneous download streams of any one archive; 0 is unlimited
+	MaxDownloadsPerArchive int
+
+	// How long a download waits for a free slot before getting a 503
+	DownloadQueueWait time.Duration
+
+	// Per-connection download rate in bytes per second; 0 is unlimited
+	DownloadRate int64
+
+	// Combined download rate across all connections in bytes per second; 0 is unlimited
+	DownloadRateGlobal int64
+
+	// Hand downloads to the front end instead of streaming them: "x-accel-redirect"
+	// (nginx), "x-sendfile" (Apache, lighttpd) or "cdn"; empty streams them
+	DownloadOffload string
+
+	// Internal nginx location mapped to TempDir for X-Accel-Redirect
+	AccelPrefix string
+
+	// Base URL of the CDN pulling from TempDir, and the key its links are signed with
+	CDNURL        string
+	CDNSigningKey string
+
+	// How long a signed CDN link stays valid
+	CDNLinkTTL time.Duration
+
+	// How long an offloaded archive stays on disk for the proxy or CDN to read
+	OffloadKeep time.Duration
+
+	// What a download does to the link: delete, ttl or keep
+	AfterDownload string
+
+	// How long a link keeps working after its first download under the ttl policy
+	AfterDownloadTTL time.Duration
+
+	// How long a downloaded archive can still be restored before its file is
+	// removed; 0 removes it right after the download
+	RestoreWindow time.Duration
+
+	// JSON file listing user accounts with bcrypt password hashes
+	UsersFile string
+
+	// JSON file listing API keys by SHA-256 hash and the user each acts as
+	APIKeysFile string
+
+	// Default per-principal quotas: concurrent archive builds, stored bytes
+	// and bytes served per UTC day; 0 is unlimited
+	QuotaJobs      int
+	QuotaStorage   int64
+	QuotaBandwidth int64
+
+	// CAPTCHA anonymous uploads must pass: hcaptcha or turnstile, empty for none
+	CaptchaProvider string
+
+	// Public site key for the widget and the secret for verifying its tokens
+	CaptchaSiteKey string
+	CaptchaSecret  string
+
+	// Key signing session cookies; random per process when empty
+	SessionSecret string
+
+	// How long a login session lasts
+	SessionTTL time.Duration
+
+	// Require a logged-in user for the upload and download pages
+	RequireLogin bool
+
+	// OIDC issuer URL for single sign-on; SSO is disabled when empty
+	OIDCIssuer string
+
+	// OAuth2 client credentials registered with the identity provider
+	OIDCClientID     string
+	OIDCClientSecret string
+
+	// Callback URL registered with the provider, ending in /auth/oidc/callback
+	OIDCRedirectURL string
+
+	// Scopes requested during login
+	OIDCScopes []string
+
+	// ID token claim carrying the user's groups
+	OIDCGroupsClaim string
+
+	// Groups whose members may use the admin API
+	OIDCAdminGroups []string
+
+	// Restrict downloads of archives created by a logged-in user to that user
+	OwnerOnlyDownloads bool
+
+	// Hand out links to the /d/ landing page instead of the direct download
+	LandingPages bool
+
+	// Also accept download names in place of share tokens in links
+	NameLinks bool
+
+	// Address of the gRPC listener; the gRPC API is disabled when empty
+	GRPCAddr string
+
+	// Bearer token gRPC clients must send; unauthenticated when empty
+	GRPCToken string
+
+	// Networks allowed to create archives; everyone when empty
+	UploadAllowIPs []string
+
+	// Networks refused when creating archives, even if also allowed
+	UploadDenyIPs []string
+
+	// Networks allowed to use the admin and debug endpoints; everyone when empty
+	AdminAllowIPs []string
+
+	// Networks refused by the admin and debug endpoints, even if also allowed
+	AdminDenyIPs []string
+
+	// Reverse proxies whose client address header is believed
+	TrustedProxies []string
+
+	// Header trusted proxies name the client in: X-Forwarded-For or X-Real-IP
+	ClientIPHeader string
+
+	// Bearer token required for the /admin API; the API is disabled when empty
+	AdminToken string
+
+	// Serve pprof profiles and /debug/stats to admins
+	DebugEndpoints bool
+}
+
+// config is the active configuration, loaded once at startup
+var config = loadConfig()
+
+// loadConfig reads the configuration from the environment, using defaults
+// for anything that is unset or invalid
+func loadConfig() Config {
+	return Config{
+		Addr:      envString("BULK_ADDR", ":8080"),
+		PublicURL: envString("BULK_PUBLIC_URL", ""),
+
+		ReadHeaderTimeout: envDuration("BULK_READ_HEADER_TIMEOUT", 30*time.Second),
+		ReadTimeout:       envDuration("BULK_READ_TIMEOUT", 30*time.Minute),
+		WriteTimeout:      envDuration("BULK_WRITE_TIMEOUT", 0),
+		IdleTimeout:       envDuration("BULK_IDLE_TIMEOUT", 2*time.Minute),
+
+		TLSDomains:       envList("BULK_TLS_DOMAINS", nil),
+		TLSAddr:          envString("BULK_TLS_ADDR", ":443"),
+		TLSCacheDir:      envString("BULK_TLS_CACHE_DIR", ""),
+		TLSEmail:         envString("BULK_TLS_EMAIL", ""),
+		HTTPRedirectAddr: envString("BULK_HTTP_REDIRECT_ADDR", ":80"),
+
+		TempDir:       envString("BULK_TEMP_DIR", os.TempDir()),
+		SpillDirs:     envList("BULK_SPILL_DIRS", nil),
+		DataDir:       envString("BULK_DATA_DIR", "data"),
+		AuditLog:      envString("BULK_AUDIT_LOG", ""),
+		LogFile:       envString("BULK_LOG_FILE", ""),
+		LogMaxSize:    envBytes("BULK_LOG_MAX_SIZE", 100*1024*1024),
+		LogRotate:     envDuration("BULK_LOG_ROTATE", 24*time.Hour),
+		LogKeep:       envInt("BULK_LOG_KEEP", 7),
+		LogMaxAge:     envDuration("BULK_LOG_MAX_AGE", 0),
+		TemplateDir:   envString("BULK_TEMPLATE_DIR", "templates"),
+		LocaleDir:     envString("BULK_LOCALE_DIR", "locales"),
+		UITitle:       envString("BULK_UI_TITLE", "File to ZIP Converter"),
+		UILogoURL:     envString("BULK_UI_LOGO_URL", ""),
+		UITheme:       envString("BULK_UI_THEME", "auto"),
+		UIAccentColor: envString("BULK_UI_ACCENT_COLOR", ""),
+		UIFooter:      envString("BULK_UI_FOOTER", ""),
+		ArchiveTTL:    envDuration("BULK_ARCHIVE_TTL", 24*time.Hour),
+		ArchiveTTLMin: envDuration("BULK_ARCHIVE_TTL_MIN", 15*time.Minute),
+		ArchiveTTLMax: envDuration("BULK_ARCHIVE_TTL_MAX", 7*24*time.Hour),
+		NameTemplate:  envString("BULK_NAME_TEMPLATE", "{base}_{timestamp}.zip"),
+		MinFreeDisk:   envBytes("BULK_MIN_FREE_DISK", 200*1024*1024),
+		DiskBudget:    envBytes("BULK_DISK_BUDGET", 0),
+
+		Notifiers:      envList("BULK_NOTIFIERS", nil),
+		NotifyEvents:   envList("BULK_NOTIFY_EVENTS", []string{"job.failed", "quota.exceeded", "disk.low"}),
+		NotifyCooldown: envDuration("BULK_NOTIFY_COOLDOWN", 15*time.Minute),
+
+		MemoryArchiveMax:    envBytes("BULK_MEMORY_ARCHIVE_MAX", 10*1024*1024),
+		MemoryArchiveBudget: envBytes("BULK_MEMORY_ARCHIVE_BUDGET", 256*1024*1024),
+		SevenZipPath:        envString("BULK_7Z_PATH", ""),
+		SevenZipTimeout:     envDuration("BULK_7Z_TIMEOUT", 10*time.Minute),
+		Flatten:             envBool("BULK_FLATTEN", false),
+		CompressWorkers:     envInt("BULK_COMPRESS_WORKERS", runtime.NumCPU()),
+		JobWorkers:          envInt("BULK_JOB_WORKERS", runtime.NumCPU()),
+		JobTimeout:          envDuration("BULK_JOB_TIMEOUT", 30*time.Minute),
+		InteractiveWeight:   envInt("BULK_INTERACTIVE_WEIGHT", 4),
+		EncryptAtRest:       envBool("BULK_ENCRYPT_AT_REST", false),
+
+		DedupUploads:     envBool("BULK_DEDUP_UPLOADS", false),
+		MaxUploadSize:    envBytes("BULK_MAX_UPLOAD_SIZE", 100*1024*1024),
+		MaxFileSize:      envBytes("BULK_MAX_FILE_SIZE", 0),
+		MultipartMemory:  envBytes("BULK_MULTIPART_MEMORY", 1024*1024),
+		MaxFiles:         envInt("BULK_MAX_FILES", 0),
+		InputMaxEntries:  envInt("BULK_INPUT_MAX_ENTRIES", 10000),
+		InputMaxExpanded: envBytes("BULK_INPUT_MAX_EXPANDED", 0),
+		InputMaxRatio:    envInt("BULK_INPUT_MAX_RATIO", 100),
+		UploadSessionTTL: envDuration("BULK_UPLOAD_SESSION_TTL", 24*time.Hour),
+
+		QuarantineDir:        envString("BULK_QUARANTINE_DIR", ""),
+		SuspiciousExtensions: envList("BULK_SUSPICIOUS_EXTENSIONS", nil),
+		SuspiciousTypes:      envList("BULK_SUSPICIOUS_TYPES", nil),
+		ScanCommand:          envString("BULK_SCAN_COMMAND", ""),
+		FileStages:           envList("BULK_FILE_STAGES", nil),
+
+		FetchTimeout:      envDuration("BULK_FETCH_TIMEOUT", 5*time.Minute),
+		FetchAllowPrivate: envBool("BULK_FETCH_ALLOW_PRIVATE", false),
+		FetchSegments:     envInt("BULK_FETCH_SEGMENTS", 4),
+		FetchSegmentMin:   envBytes("BULK_FETCH_SEGMENT_MIN", 32*1024*1024),
+		FetchRetries:      envInt("BULK_FETCH_RETRIES", 3),
+		FetchPartialDir:   envString("BULK_FETCH_PARTIAL_DIR", ""),
+		FetchPartialTTL:   envDuration("BULK_FETCH_PARTIAL_TTL", 24*time.Hour),
+		FetchCacheDir:     envString("BULK_FETCH_CACHE_DIR", ""),
+		FetchCacheSize:    envBytes("BULK_FETCH_CACHE_SIZE", 1024*1024*1024),
+		FetchConcurrency:  envInt("BULK_FETCH_CONCURRENCY", 4),
+		FetchPerHost:      envInt("BULK_FETCH_PER_HOST", 2),
+		FetchDelay:        envDuration("BULK_FETCH_DELAY", 0),
+		FetchRate:         envBytes("BULK_FETCH_RATE", 0),
+		FetchRateGlobal:   envBytes("BULK_FETCH_RATE_GLOBAL", 0),
+		SFTPKnownHosts:    envString("BULK_SFTP_KNOWN_HOSTS", ""),
+		FetchS3Buckets:    envList("BULK_FETCH_S3_BUCKETS", nil),
+		SFTPKeyFile:       envString("BULK_SFTP_KEY_FILE", ""),
+		SFTPKeyHosts:      envList("BULK_SFTP_KEY_HOSTS", nil),
+
+		GDriveClientID:     envString("BULK_GDRIVE_CLIENT_ID", ""),
+		GDriveClientSecret: envString("BULK_GDRIVE_CLIENT_SECRET", ""),
+		DropboxAppKey:      envString("BULK_DROPBOX_APP_KEY", ""),
+		DropboxAppSecret:   envString("BULK_DROPBOX_APP_SECRET", ""),
+
+		DeliveryTargets: envList("BULK_DELIVERY_TARGETS", nil),
+		S3Region:        envString("BULK_S3_REGION", envString("AWS_REGION", "us-east-1")),
+		S3Endpoint:      envString("BULK_S3_ENDPOINT", ""),
+		S3AccessKey:     envString("BULK_S3_ACCESS_KEY", envString("AWS_ACCESS_KEY_ID", "")),
+		S3SecretKey:     envString("BULK_S3_SECRET_KEY", envString("AWS_SECRET_ACCESS_KEY", "")),
+		S3SessionToken:  envString("BULK_S3_SESSION_TOKEN", envString("AWS_SESSION_TOKEN", "")),
+
+		WatchDir:    envString("BULK_WATCH_DIR", ""),
+		WatchSettle: envDuration("BULK_WATCH_SETTLE", 30*time.Second),
+		ServerDirs:  envList("BULK_SERVER_DIRS", nil),
+
+		CORSOrigins:       envList("BULK_CORS_ORIGINS", nil),
+		CORSExposeHeaders: envList("BULK_CORS_EXPOSE_HEADERS", []string{"Location", "Content-Disposition", "Retry-After", "Deprecation", "Sunset", "Link"}),
+		CORSCredentials:   envBool("BULK_CORS_CREDENTIALS", false),
+		CORSMaxAge:        envDuration("BULK_CORS_MAX_AGE", 10*time.Minute),
+		APIDeprecations:   envList("BULK_API_DEPRECATIONS", nil),
+
+		CSRF:                  envBool("BULK_CSRF", true),
+		SecureHeaders:         envBool("BULK_SECURE_HEADERS", true),
+		ContentSecurityPolicy: envString("BULK_CSP", defaultCSP),
+		ReferrerPolicy:        envString("BULK_REFERRER_POLICY", "no-referrer"),
+
+		MaxDownloads:           envInt("BULK_MAX_DOWNLOADS", 0),
+		MaxDownloadsPerArchive: envInt("BULK_MAX_DOWNLOADS_PER_ARCHIVE", 4),
+		DownloadQueueWait:      envDuration("BULK_DOWNLOAD_QUEUE_WAIT", 10*time.Second),
+
+		DownloadRate:       envBytes("BULK_DOWNLOAD_RATE", 0),
+		DownloadRateGlobal: envBytes("BULK_DOWNLOAD_RATE_GLOBAL", 0),
+
+		DownloadOffload: envString("BULK_DOWNLOAD_OFFLOAD", ""),
+		AccelPrefix:     envString("BULK_ACCEL_PREFIX", "/internal-archives/"),
+		CDNURL:          envString("BULK_CDN_URL", ""),
+		CDNSigningKey:   envString("BULK_CDN_SIGNING_KEY", ""),
+		CDNLinkTTL:      envDuration("BULK_CDN_LINK_TTL", 15*time.Minute),
+		OffloadKeep:     envDuration("BULK_OFFLOAD_KEEP", time.Hour),
+
+		AfterDownload:    envString("BULK_AFTER_DOWNLOAD", retainDelete),
+		AfterDownloadTTL: envDuration("BULK_AFTER_DOWNLOAD_TTL", time.Hour),
+		RestoreWindow:    envDuration("BULK_RESTORE_WINDOW", 15*time.Minute),
+
+		UsersFile:          envString("BULK_USERS_FILE", ""),
+		APIKeysFile:        envString("BULK_API_KEYS_FILE", ""),
+		QuotaJobs:          envInt("BULK_QUOTA_JOBS", 0),
+		QuotaStorage:       envBytes("BULK_QUOTA_STORAGE", 0),
+		QuotaBandwidth:     envBytes("BULK_QUOTA_BANDWIDTH", 0),
+		CaptchaProvider:    envString("BULK_CAPTCHA_PROVIDER", ""),
+		CaptchaSiteKey:     envString("BULK_CAPTCHA_SITE_KEY", ""),
+		CaptchaSecret:      envString("BULK_CAPTCHA_SECRET", ""),
+		SessionSecret:      envString("BULK_SESSION_SECRET", ""),
+		SessionTTL:         envDuration("BULK_SESSION_TTL", 12*time.Hour),
+		OwnerOnlyDownloads: envBool("BULK_OWNER_ONLY_DOWNLOADS", false),
+		LandingPages:       envBool("BULK_LANDING_PAGES", false),
+		NameLinks:          envBool("BULK_NAME_LINKS", false),
+		RequireLogin:       envBool("BULK_REQUIRE_LOGIN", false),
+
+		OIDCIssuer:       envString("BULK_OIDC_ISSUER", ""),
+		OIDCClientID:     envString("BULK_OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: envString("BULK_OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  envString("BULK_OIDC_REDIRECT_URL", ""),
+		OIDCScopes:       envList("BULK_OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCGroupsClaim:  envString("BULK_OIDC_GROUPS_CLAIM", "groups"),
+		OIDCAdminGroups:  envList("BULK_OIDC_ADMIN_GROUPS", nil),
+
+		GRPCAddr:  envString("BULK_GRPC_ADDR", ""),
+		GRPCToken: envString("BULK_GRPC_TOKEN", ""),
+
+		UploadAllowIPs: envList("BULK_UPLOAD_ALLOW_IPS", nil),
+		UploadDenyIPs:  envList("BULK_UPLOAD_DENY_IPS", nil),
+		AdminAllowIPs:  envList("BULK_ADMIN_ALLOW_IPS", nil),
+		AdminDenyIPs:   envList("BULK_ADMIN_DENY_IPS", nil),
+		TrustedProxies: envList("BULK_TRUSTED_PROXIES", nil),
+		ClientIPHeader: envString("BULK_CLIENT_IP_HEADER", "X-Forwarded-For"),
+
+		AdminToken:     envString("BULK_ADMIN_TOKEN", ""),
+		DebugEndpoints: envBool("BULK_DEBUG_ENDPOINTS", false),
+	}
+}
+
+// envString returns the value of key, or def when unset
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns key parsed as an integer, or def when unset or invalid
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envBytes returns key parsed as a size such as "100MB", or def when unset or invalid
+func envBytes(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := bytes.Parse(v)
+	if err != nil {
+		log.Printf("Invalid size for %s (%q), using default %s", key, v, bytes.Format(def))
+		return def
+	}
+	return n
+}
+
+// envDuration returns key parsed as a duration such as "15m", or def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// envBool returns key parsed as a boolean, or def when unset or invalid
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envList returns key split on commas with blanks removed, or def when unset
+func envList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is a multiple of the 4 KiB page and block size, so reads
+// from files opened with O_DIRECT stay aligned, and large enough to keep
+// syscalls per megabyte low
+const copyBufferSize = 256 << 10
+
+// copyBuffers recycles copy buffers between archive writes and downloads
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// copyPooled is io.Copy with a buffer from copyBuffers. It always goes
+// through the buffer: the ReaderFrom and WriterTo fast paths of the types
+// used here (zip writers, files, response writers) fall back to io.Copy and
+// allocate a fresh 32 KiB buffer on every call.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	bufp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bufp)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *bufp)
+}
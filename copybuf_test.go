@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// benchPayload is compressible enough for deflate to do real work
+func benchPayload(size int) []byte {
+	words := []string{"bulk", "download", "archive", "zip", "file", "entry", "\n"}
+	r := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[r.Intn(len(words))])
+		buf.WriteByte(' ')
+	}
+	return buf.Bytes()[:size]
+}
+
+// plainReader and plainWriter hide the WriterTo and ReaderFrom fast paths of
+// bytes.Reader and io.Discard, as zip entries and responses lack them
+type plainReader struct{ io.Reader }
+type plainWriter struct{ io.Writer }
+
+func BenchmarkCopy(b *testing.B) {
+	data := benchPayload(4 << 20)
+	copies := map[string]func(io.Writer, io.Reader) (int64, error){
+		"io.Copy": func(w io.Writer, r io.Reader) (int64, error) { return io.Copy(w, r) },
+		"pooled":  copyPooled,
+	}
+	for _, name := range []string{"io.Copy", "pooled"} {
+		copyFn := copies[name]
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := copyFn(plainWriter{io.Discard}, plainReader{bytes.NewReader(data)}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
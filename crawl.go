@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/html"
+)
+
+// maxListingPage caps how much of a directory listing page is read
+const maxListingPage = 5 * 1024 * 1024
+
+// maxCrawlDepth caps how many directory levels below the start a crawl follows
+const maxCrawlDepth = 10
+
+// maxCrawlFiles caps the files a crawl may return when MaxFiles is unlimited
+const maxCrawlFiles = 1000
+
+// crawledFile is a file found in a directory listing
+type crawledFile struct {
+	URL string
+
+	// Slash separated path relative to the listing the crawl started at
+	Path string
+}
+
+// crawlFromForm crawls the listing at rawURL using the crawl_depth and
+// crawl_glob form fields
+func crawlFromForm(c echo.Context, rawURL string) ([]crawledFile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL: %s", redactURL(rawURL))
+	}
+
+	depth := 0
+	if v := c.FormValue("crawl_depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 0 || depth > maxCrawlDepth {
+			return nil, fmt.Errorf("Crawl depth must be between 0 and %d", maxCrawlDepth)
+		}
+	}
+	filter, err := parsePathFilter(c.Request().Form["filter"])
+	if err != nil {
+		return nil, err
+	}
+	if glob := strings.TrimSpace(c.FormValue("crawl_glob")); glob != "" {
+		if err := filter.add(glob); err != nil {
+			return nil, fmt.Errorf("Invalid glob %q", glob)
+		}
+	}
+
+	files, err := crawlListing(c.Request().Context(), u.String(), depth, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No matching files found in the listing")
+	}
+	return files, nil
+}
+
+// crawlListing walks an auto-index page (nginx, Apache and similar) and the
+// subdirectory listings below it up to depth levels, returning the files whose
+// relative path the filter keeps. Links outside the starting directory, such
+// as the parent directory or column sort links, are ignored.
+func crawlListing(ctx context.Context, start string, depth int, filter pathFilter) ([]crawledFile, error) {
+	limit := maxCrawlFiles
+	if config.MaxFiles > 0 {
+		limit = config.MaxFiles
+	}
+
+	type page struct {
+		url   string
+		depth int
+	}
+	queue := []page{{start, 0}}
+	visited := map[string]bool{}
+	var root *url.URL
+	var files []crawledFile
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if visited[p.url] {
+			continue
+		}
+		visited[p.url] = true
+
+		base, links, err := fetchListing(ctx, p.url)
+		if err != nil {
+			return nil, fmt.Errorf("Error crawling %s: %v", redactURL(p.url), err)
+		}
+		if root == nil {
+			// The listing's own URL after redirects, as a directory
+			root = base
+			if !strings.HasSuffix(root.Path, "/") {
+				root = root.ResolveReference(&url.URL{Path: "./"})
+			}
+		}
+
+		for _, link := range links {
+			u, err := base.Parse(link)
+			if err != nil || u.RawQuery != "" || u.Host != root.Host || u.Scheme != root.Scheme {
+				continue
+			}
+			u.Fragment = ""
+			rel, ok := strings.CutPrefix(u.Path, root.Path)
+			if !ok || rel == "" || strings.HasPrefix(rel, "/") {
+				continue
+			}
+
+			if strings.HasSuffix(rel, "/") {
+				if p.depth < depth {
+					queue = append(queue, page{u.String(), p.depth + 1})
+				}
+				continue
+			}
+			if !filter.match(rel) {
+				continue
+			}
+			if len(files) >= limit {
+				return nil, fmt.Errorf("Directory listing has more than %d matching files", limit)
+			}
+			files = append(files, crawledFile{URL: u.String(), Path: rel})
+		}
+	}
+	return files, nil
+}
+
+// fetchListing downloads an HTML page and returns its final URL and the href of every link
+func fetchListing(ctx context.Context, rawURL string) (*url.URL, []string, error) {
+	base, ct, body, err := fetchPage(ctx, rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(ct, "text/html") {
+		return nil, nil, fmt.Errorf("not a directory listing (%s)", ct)
+	}
+	links, err := extractLinks(bytes.NewReader(body), map[string]string{"a": "href"})
+	return base, links, err
+}
+
+// fetchPage downloads up to maxListingPage bytes of a page and returns its
+// final URL, content type and body
+func fetchPage(ctx context.Context, rawURL string) (*url.URL, string, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.FetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("User-Agent", robotsAgent)
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxListingPage))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return resp.Request.URL, resp.Header.Get("Content-Type"), body, nil
+}
+
+// extractLinks returns the values of the attributes named by attrs, keyed by
+// tag name, in an HTML document
+func extractLinks(r io.Reader, attrs map[string]string) ([]string, error) {
+	var links []string
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return links, nil
+			}
+			return links, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attr, ok := attrs[string(name)]
+			if !ok {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == attr && len(val) > 0 {
+					links = append(links, string(val))
+				}
+			}
+		}
+	}
+}
+
+// matchGlob reports whether a slash separated name matches pattern, where
+// "**" matches any number of path segments and other segments follow path.Match
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
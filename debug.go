@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// startedAt is when the process started, for the uptime in /debug/stats
+var startedAt = time.Now()
+
+// debugStats is the runtime snapshot served by /debug/stats
+type debugStats struct {
+	Uptime     string `json:"uptime"`
+	GoVersion  string `json:"goVersion"`
+	Goroutines int    `json:"goroutines"`
+	CPUs       int    `json:"cpus"`
+
+	Archives     int                 `json:"archives"`
+	ArchiveBytes int64               `json:"archiveBytes"`
+	Jobs         map[jobState]int    `json:"jobs"`
+	QueuedJobs   map[jobPriority]int `json:"queuedJobs"`
+	Downloads    int64               `json:"activeDownloads"`
+
+	Memory debugMemStats `json:"memory"`
+}
+
+// debugMemStats is the subset of runtime.MemStats useful for spotting growth
+type debugMemStats struct {
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	StackInuse   uint64 `json:"stackInuse"`
+	Sys          uint64 `json:"sys"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+	LastGC       string `json:"lastGC,omitempty"`
+}
+
+// registerDebugRoutes mounts the pprof handlers under /debug/pprof and the
+// stats endpoint, behind the admin check
+func registerDebugRoutes(e *echo.Echo) {
+	debug := e.Group("/debug", restrictIPs(adminAllowIPs, adminDenyIPs), requireAdmin)
+	debug.GET("/stats", handleDebugStats)
+	debug.GET("/pprof/", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	debug.GET("/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	debug.GET("/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	debug.GET("/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	debug.POST("/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	debug.GET("/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+	// Named profiles such as heap, goroutine and allocs are served by Index
+	debug.GET("/pprof/:profile", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+}
+
+// handleDebugStats reports goroutines, store size, jobs and memory statistics
+func handleDebugStats(c echo.Context) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := debugStats{
+		Uptime:       time.Since(startedAt).Round(time.Second).String(),
+		GoVersion:    runtime.Version(),
+		Goroutines:   runtime.NumGoroutine(),
+		CPUs:         runtime.NumCPU(),
+		Archives:     len(listArchives()),
+		ArchiveBytes: storedBytes(),
+		Jobs:         jobCounts(),
+		QueuedJobs:   compressWorkers.queued(),
+		Downloads:    activeDownloads.active.Load(),
+		Memory: debugMemStats{
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			Sys:          m.Sys,
+			TotalAlloc:   m.TotalAlloc,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+	}
+	if m.LastGC > 0 {
+		stats.Memory.LastGC = time.Unix(0, int64(m.LastGC)).UTC().Format(time.RFC3339)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// duplicatesManifest is the entry listing files that were stored only once
+const duplicatesManifest = "DUPLICATES.txt"
+
+// duplicateEntry records a file whose content matched an earlier entry
+type duplicateEntry struct {
+	Name       string `json:"name"`
+	SameAs     string `json:"sameAs"`
+	SavedBytes int64  `json:"savedBytes"`
+}
+
+// dedupEntries drops entries whose content is identical to an earlier entry.
+// Only entries that share a size with another entry are hashed; one that
+// can't be read is kept so writing the archive reports it.
+func dedupEntries(entries []archiveEntry) ([]archiveEntry, []duplicateEntry) {
+	sizeCount := make(map[int64]int)
+	for _, entry := range entries {
+		sizeCount[entry.Size]++
+	}
+
+	seen := make(map[[sha256.Size]byte]string)
+	unique := make([]archiveEntry, 0, len(entries))
+	var dups []duplicateEntry
+	for _, entry := range entries {
+		if sizeCount[entry.Size] < 2 {
+			unique = append(unique, entry)
+			continue
+		}
+
+		sum, err := hashEntry(entry)
+		if err != nil {
+			unique = append(unique, entry)
+			continue
+		}
+		if first, ok := seen[sum]; ok {
+			dups = append(dups, duplicateEntry{Name: entry.Name, SameAs: first, SavedBytes: entry.Size})
+			continue
+		}
+		seen[sum] = entry.Name
+		unique = append(unique, entry)
+	}
+	return unique, dups
+}
+
+// hashEntry returns the SHA-256 of an entry's content
+func hashEntry(entry archiveEntry) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	src, err := entry.Open()
+	if err != nil {
+		return sum, err
+	}
+	defer src.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// duplicatesManifestEntry builds a text entry explaining which files were deduplicated
+func duplicatesManifestEntry(dups []duplicateEntry) archiveEntry {
+	var b strings.Builder
+	b.WriteString("These files had identical content to another file in this archive\n")
+	b.WriteString("and were stored only once:\n\n")
+	for _, d := range dups {
+		fmt.Fprintf(&b, "%s -> %s\n", d.Name, d.SameAs)
+	}
+	content := b.String()
+	return archiveEntry{
+		Name: duplicatesManifest,
+		Size: int64(len(content)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil },
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// deleteToken signs the deletion link for an archive. The creation time ties
+// the token to this archive, so it can't delete a later one reusing the name.
+func deleteToken(name string, rec archiveRecord) string {
+	return signLink("delete", name, strconv.FormatInt(rec.CreatedAt.UnixNano(), 10))
+}
+
+// deletePath returns the signed deletion link for the archive registered
+// under name, or "" when there is none
+func deletePath(name string) string {
+	rec, ok := getArchive(name)
+	if !ok {
+		return ""
+	}
+	return "/delete/" + shareKey(name) + "?token=" + deleteToken(name, rec)
+}
+
+// handleDeleteArchive purges an archive through its signed deletion link.
+// GET shows a confirmation page; POST and DELETE remove the archive.
+func handleDeleteArchive(c echo.Context) error {
+	if c.Request().Method == http.MethodGet && c.QueryParam("deleted") != "" {
+		return renderPage(c, "delete_archive.html")
+	}
+
+	name, rec, ok := sharedArchive(c.Param("filename"))
+	if !ok {
+		// Once downloaded, the deletion link leads to the restore page instead
+		if _, _, trashed := trashedByKey(c.Param("filename")); trashed && c.Request().Method == http.MethodGet {
+			return c.Redirect(http.StatusSeeOther, "/restore/"+url.PathEscape(c.Param("filename"))+"?token="+url.QueryEscape(c.QueryParam("token")))
+		}
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+	if !validLinkSignature(c.QueryParam("token"), "delete", name, strconv.FormatInt(rec.CreatedAt.UnixNano(), 10)) {
+		log.Printf("Invalid deletion token for %s", name)
+		return htmlError(c, http.StatusForbidden, "Error: Invalid deletion link")
+	}
+
+	// Link previews fetch URLs with GET, so deleting needs an explicit confirmation
+	if c.Request().Method == http.MethodGet {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return renderPage(c, "delete_archive.html")
+	}
+
+	if rec, ok := takeArchive(name); ok {
+		removeArchiveFile(name, rec.Path)
+	}
+	log.Printf("Archive deleted by its creator: %s", name)
+	auditDeletion(c, name, "deletion link")
+
+	switch {
+	case c.Request().Method == http.MethodDelete:
+		return c.NoContent(http.StatusNoContent)
+	case c.Request().Header.Get("HX-Request") != "":
+		return htmlSuccess(c, "Archive deleted from the server.")
+	}
+	return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?deleted=1")
+}
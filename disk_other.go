@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+// diskFree is not implemented on this platform
+func diskFree(path string) (int64, error) {
+	return 0, errDiskFreeUnsupported
+}
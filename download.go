@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// handleDownload serves the ZIP file for download
+func handleDownload(c echo.Context) error {
+	filename, rec, exists := sharedArchive(c.Param("filename"))
+	if !exists && rec.Disabled != "" {
+		return htmlError(c, http.StatusGone, "Error: This link has been disabled")
+	}
+
+	log.Printf("Download requested for: %s", c.Param("filename"))
+
+	// Owned archives can be restricted to their creator
+	if exists && config.OwnerOnlyDownloads && rec.Owner != "" && rec.Owner != currentUser(c) {
+		log.Printf("Download of %s refused for non-owner", filename)
+		exists = false
+	}
+
+	// Password-protected links need the password from the prompt form or, for
+	// API clients, the X-Download-Password header
+	if exists && rec.PasswordHash != "" {
+		password := c.Request().Header.Get("X-Download-Password")
+		if password == "" && c.Request().Method == http.MethodPost {
+			password = c.FormValue("password")
+		}
+		if password == "" {
+			c.Response().Header().Set("Cache-Control", "no-store")
+			return c.Render(http.StatusOK, "download_password.html", newLandingData(filename, rec))
+		}
+		if bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(password)) != nil {
+			log.Printf("Wrong password for download of %s", filename)
+			if c.Request().Method == http.MethodPost {
+				return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?error=1")
+			}
+			return htmlError(c, http.StatusUnauthorized, "Error: Incorrect download password")
+		}
+	}
+
+	// Show the uploader's note first; its button posts back to start the download
+	if exists && rec.PasswordHash == "" && wantsNotePage(c, rec) {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Render(http.StatusOK, "landing.html", newLandingData(filename, rec))
+	}
+
+	// Clients that already have this archive get a 304 and keep the link
+	if exists {
+		etag, err := archiveETag(filename, rec)
+		if err != nil {
+			log.Printf("Error hashing %s: %v", filename, err)
+			return htmlError(c, http.StatusInternalServerError, "Error accessing file")
+		}
+		setValidators(c, etag, rec)
+		if notModified(c.Request(), etag, rec.CreatedAt) {
+			log.Printf("Download of %s not modified", filename)
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+
+	// Downloads stop counting against the creator's daily bandwidth once it
+	// is used up, leaving the link usable for tomorrow
+	if exists {
+		if err := checkBandwidthQuota(c, rec); err != nil {
+			log.Printf("Download of %s refused: %v", filename, err)
+			return htmlError(c, http.StatusTooManyRequests, "Error: %s", err)
+		}
+	}
+
+	// Wait for a download slot before claiming the archive, so a busy server
+	// leaves the link usable for a retry
+	if exists {
+		release, err := activeDownloads.acquire(c.Request().Context(), filename)
+		if err != nil {
+			log.Printf("Download of %s refused: %v", filename, err)
+			return downloadsBusy(c)
+		}
+		defer release()
+	}
+
+	// Claim the archive before sending it; under the default policy this
+	// removes it from the store to prevent duplicate downloads
+	if exists {
+		rec, exists = claimDownload(filename)
+	}
+	if !exists {
+		log.Printf("File not found in store: %s", filename)
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+	tempPath := rec.Path
+
+	// Let the front-end proxy or CDN send plain files on disk
+	if rel, ok := offloadPath(rec); ok {
+		return offloadDownload(c, filename, rec, rel)
+	}
+
+	if tempPath == "" {
+		log.Printf("Serving %s from memory", filename)
+	} else {
+		log.Printf("Serving file from: %s", tempPath)
+	}
+
+	// Open the file for reading, decrypting it if it was sealed
+	file, size, err := openArchiveFile(rec)
+	if err != nil {
+		log.Printf("Error opening file for download: %v", err)
+		return htmlError(c, http.StatusInternalServerError, "Error accessing file")
+	}
+
+	// Schedule cleanup after download, keeping the file restorable for a while
+	defer func() {
+		file.Close()
+		finishDownload(filename, rec, config.RestoreWindow)
+	}()
+
+	// Set headers for file download
+	c.Response().Header().Set("Content-Type", archiveContentType(filename))
+	c.Response().Header().Set("Content-Disposition", contentDisposition(filename))
+
+	// Reads are throttled per connection and against the global egress budget.
+	// Unthrottled files on disk go to the socket as they are, so the kernel
+	// can send them without copying through the server.
+	var content io.ReadSeeker = file
+	throttled := newThrottledReader(c.Request().Context(), file,
+		newByteLimiter(config.DownloadRate), globalDownloadLimiter)
+	if throttled != io.Reader(file) {
+		content = throttledFile{Reader: throttled, Seeker: file}
+	}
+	if reusableLinks() {
+		// ServeContent adds Content-Length and answers Range requests by
+		// seeking in the file
+		http.ServeContent(sendfileWriter{c.Response()}, c.Request(), "", rec.CreatedAt, content)
+	} else {
+		// A single-use link is gone once this request ends, so a piece of the
+		// file would leave the rest unreachable: Range is ignored and the
+		// whole archive sent
+		c.Response().Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		c.Response().WriteHeader(http.StatusOK)
+		if _, err := io.Copy(sendfileWriter{c.Response()}, content); err != nil {
+			log.Printf("Download of %s interrupted: %v", filename, err)
+		}
+	}
+
+	// Count the bytes sent for the usage report
+	sent := c.Response().Size
+	recordBandwidth(rec, sent)
+	recordDownload(downloadEvent{
+		Archive:   filename,
+		Time:      time.Now(),
+		Bytes:     sent,
+		UserAgent: c.Request().UserAgent(),
+		ClientIP:  c.RealIP(),
+	})
+	recordAudit(auditEvent{
+		Archive:  filename,
+		Action:   auditDownloaded,
+		User:     currentUser(c),
+		ClientIP: c.RealIP(),
+		Bytes:    sent,
+	})
+	return nil
+}
+
+// throttledFile reads an archive through a rate limiter while seeking the
+// file underneath directly
+type throttledFile struct {
+	io.Reader
+	io.Seeker
+}
+
+// sendfileWriter lets copies into an echo response reach the connection's
+// ReadFrom, which sends files with sendfile; echo.Response only has Write
+type sendfileWriter struct {
+	*echo.Response
+}
+
+func (w sendfileWriter) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := w.Writer.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(w.Response, r)
+	}
+	n, err := rf.ReadFrom(r)
+	w.Size += n
+	return n, err
+}
+
+// downloadPath returns the download link path for an archive
+func downloadPath(name string) string {
+	return "/download/" + shareKey(name)
+}
+
+// archiveContentType returns the media type for an archive download name
+func archiveContentType(filename string) string {
+	if f, ok := formatByExtension(filename); ok {
+		return f.ContentType()
+	}
+	return "application/zip"
+}
+
+// contentDisposition builds an attachment header carrying both a quoted ASCII
+// filename for old clients and a UTF-8 filename* parameter per RFC 6266/5987
+func contentDisposition(filename string) string {
+	var fallback strings.Builder
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r == 0x7f:
+			fallback.WriteByte('_')
+		case r > 0x7e:
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	return `attachment; filename="` + fallback.String() + `"; filename*=UTF-8''` + encodeRFC5987(filename)
+}
+
+// encodeRFC5987 percent-encodes every byte of s that is not an RFC 5987 attr-char
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[ch>>4])
+		b.WriteByte(hex[ch&0x0f])
+	}
+	return b.String()
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves the full router for the end-to-end tests
+var testServer *httptest.Server
+
+// TestMain points the server at a scratch directory and starts it with the
+// same setup main uses
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "bulk-download-test-*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.TempDir = filepath.Join(dir, "tmp")
+	config.DataDir = filepath.Join(dir, "data")
+	config.AuditLog = "off"
+	config.CSRF = false
+	config.MemoryArchiveMax = 0
+	for _, d := range []string{config.TempDir, config.DataDir} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := loadLinkKey(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadCatalogs(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadUISettings(); err != nil {
+		log.Fatal(err)
+	}
+	renderer, err := loadTemplates()
+	if err != nil {
+		log.Fatal(err)
+	}
+	e := newServer(renderer)
+	e.Logger.SetOutput(io.Discard)
+	log.SetOutput(io.Discard)
+	testServer = httptest.NewServer(e)
+
+	code := m.Run()
+	testServer.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testFile is a file sent in an upload
+type testFile struct {
+	name, content string
+}
+
+// postForm sends files and fields to path as a multipart form
+func postForm(t *testing.T, path string, files []testFile, fields map[string]string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for _, f := range files {
+		w, err := mw.CreateFormFile("files", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, f.content)
+	}
+	mw.Close()
+	resp, err := http.Post(testServer.URL+path, mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	return resp
+}
+
+// readBody returns the whole body of resp and closes it
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return string(b)
+}
+
+var (
+	downloadLinkPattern = regexp.MustCompile(`href="(/download/[^"]+)"`)
+	deleteLinkPattern   = regexp.MustCompile(`hx-post="(/delete/[^"]+)"`)
+)
+
+// uploadArchive posts files to /compress and returns the download and
+// deletion links of the archive
+func uploadArchive(t *testing.T, files []testFile) (download, deletion string) {
+	t.Helper()
+	resp := postForm(t, "/compress", files, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", resp.StatusCode, body)
+	}
+	dl := downloadLinkPattern.FindStringSubmatch(body)
+	del := deleteLinkPattern.FindStringSubmatch(body)
+	if dl == nil || del == nil {
+		t.Fatalf("upload: no links in response: %s", body)
+	}
+	return html.UnescapeString(dl[1]), html.UnescapeString(del[1])
+}
+
+// fetchZip downloads the archive at path and returns its members by name
+func fetchZip(t *testing.T, path string) map[string]string {
+	t.Helper()
+	resp, err := http.Get(testServer.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("GET %s: Content-Type %q, want application/zip", path, ct)
+	}
+	zr, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	if err != nil {
+		t.Fatalf("GET %s: not a ZIP: %v", path, err)
+	}
+	members := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+		members[f.Name] = string(b)
+	}
+	return members
+}
+
+// checkMembers fails unless members holds exactly files
+func checkMembers(t *testing.T, members map[string]string, files []testFile) {
+	t.Helper()
+	if len(members) != len(files) {
+		t.Errorf("archive has %d members, want %d: %v", len(members), len(files), members)
+	}
+	for _, f := range files {
+		if got, ok := members[f.name]; !ok {
+			t.Errorf("archive lacks %s", f.name)
+		} else if got != f.content {
+			t.Errorf("%s holds %q, want %q", f.name, got, f.content)
+		}
+	}
+}
+
+// tempArchives lists the archive files in the temp directory
+func tempArchives(t *testing.T) map[string]bool {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(config.TempDir, "archive-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// newTempArchive returns the one archive file not in before
+func newTempArchive(t *testing.T, before map[string]bool) string {
+	t.Helper()
+	var added []string
+	for p := range tempArchives(t) {
+		if !before[p] {
+			added = append(added, p)
+		}
+	}
+	if len(added) != 1 {
+		t.Fatalf("want one new archive file, got %v", added)
+	}
+	return added[0]
+}
+
+// waitForRemoval fails unless the file at p is gone within a second
+func waitForRemoval(t *testing.T, p string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still exists", p)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUploadDownloadCleanup(t *testing.T) {
+	// Without a restore window a downloaded archive is removed right away
+	defer func(d time.Duration) { config.RestoreWindow = d }(config.RestoreWindow)
+	config.RestoreWindow = 0
+
+	files := []testFile{
+		{"readme.txt", "hello"},
+		{"data.csv", "a,b\n1,2\n"},
+		{"empty.bin", ""},
+	}
+	before := tempArchives(t)
+	download, _ := uploadArchive(t, files)
+	archive := newTempArchive(t, before)
+
+	checkMembers(t, fetchZip(t, download), files)
+
+	// The default post-download policy takes the link down and removes the file
+	waitForRemoval(t, archive)
+	resp, err := http.Get(testServer.URL + download)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second download: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestDeletionLink(t *testing.T) {
+	before := tempArchives(t)
+	download, deletion := uploadArchive(t, []testFile{{"a.txt", "a"}})
+	archive := newTempArchive(t, before)
+
+	resp, err := http.Post(testServer.URL+deletion, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete: status %d", resp.StatusCode)
+	}
+	waitForRemoval(t, archive)
+
+	resp, err = http.Get(testServer.URL + download)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("download after delete: status %d, want 404", resp.StatusCode)
+	}
+
+	// A tampered token is refused
+	_, deletion = uploadArchive(t, []testFile{{"b.txt", "b"}})
+	resp, err = http.Post(testServer.URL+deletion+"x", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("delete with bad token: status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	t.Run("no files", func(t *testing.T) {
+		resp := postForm(t, "/compress", nil, map[string]string{"comment": "x"})
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status %d, want 400: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("not a form", func(t *testing.T) {
+		resp, err := http.Post(testServer.URL+"/compress", "text/plain", bytes.NewBufferString("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status %d, want 400: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("comment too long", func(t *testing.T) {
+		long := string(bytes.Repeat([]byte("x"), maxArchiveComment+1))
+		resp := postForm(t, "/compress", []testFile{{"a.txt", "a"}}, map[string]string{"comment": long})
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status %d, want 400: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("oversized files", func(t *testing.T) {
+		defer func(n int64) { config.MaxFileSize = n }(config.MaxFileSize)
+		config.MaxFileSize = 4
+
+		// Files over the limit are left out of the batch and reported
+		files := []testFile{{"small.txt", "abc"}, {"big.txt", "too large"}}
+		resp := postForm(t, "/compress", files, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %s", resp.StatusCode, body)
+		}
+		dl := downloadLinkPattern.FindStringSubmatch(body)
+		if dl == nil {
+			t.Fatalf("no download link: %s", body)
+		}
+		checkMembers(t, fetchZip(t, html.UnescapeString(dl[1])), files[:1])
+
+		// A strict batch fails as a whole
+		resp = postForm(t, "/compress", files, map[string]string{"strict": "true"})
+		body = readBody(t, resp)
+		if resp.StatusCode < 400 || downloadLinkPattern.MatchString(body) {
+			t.Errorf("strict batch: status %d: %s", resp.StatusCode, body)
+		}
+
+		// With nothing left there is no archive at all
+		resp = postForm(t, "/compress", files[1:], nil)
+		body = readBody(t, resp)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("all files too large: status %d, want 422: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("unknown download", func(t *testing.T) {
+		resp, err := http.Get(testServer.URL + "/download/nosuchkey")
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status %d, want 404", resp.StatusCode)
+		}
+	})
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	const n = 8
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files := []testFile{
+				{fmt.Sprintf("file-%d.txt", i), fmt.Sprintf("content of upload %d", i)},
+				{"shared.txt", fmt.Sprint(i)},
+			}
+			download, _ := uploadArchive(t, files)
+			checkMembers(t, fetchZip(t, download), files)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAPIJob(t *testing.T) {
+	files := []testFile{{"one.txt", "1"}, {"two.txt", "2"}}
+	resp := postForm(t, "/api/v1/compress", files, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("compress: status %d: %s", resp.StatusCode, body)
+	}
+	var queued struct {
+		StatusURL string `json:"statusUrl"`
+	}
+	if err := json.Unmarshal([]byte(body), &queued); err != nil || queued.StatusURL == "" {
+		t.Fatalf("compress: bad response %s", body)
+	}
+
+	var status struct {
+		State       string       `json:"state"`
+		DownloadURL string       `json:"downloadUrl"`
+		Error       string       `json:"error"`
+		Files       []fileResult `json:"files"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status.State != "done" {
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", status.State)
+		}
+		time.Sleep(20 * time.Millisecond)
+		resp, err := http.Get(testServer.URL + queued.StatusURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(readBody(t, resp)), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.State == "failed" {
+			t.Fatalf("job failed: %s", status.Error)
+		}
+	}
+	if len(status.Files) != len(files) || !allAdded(status.Files) {
+		t.Errorf("job files %+v", status.Files)
+	}
+	checkMembers(t, fetchZip(t, status.DownloadURL), files)
+
+	resp, err := http.Get(testServer.URL + "/api/v1/jobs/nosuchjob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", resp.StatusCode)
+	}
+}
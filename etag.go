@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// archiveETag returns the strong entity tag for the archive registered under
+// name, hashing its contents the first time and caching the result in the store
+func archiveETag(name string, rec archiveRecord) (string, error) {
+	if rec.ETag != "" {
+		return rec.ETag, nil
+	}
+	file, _, err := openArchiveFile(rec)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+
+	// Only cache it if the archive wasn't replaced while we were hashing
+	storeMutex.Lock()
+	if cur, ok := tempFileStore[name]; ok && cur.Path == rec.Path && cur.Size == rec.Size {
+		cur.ETag = etag
+		tempFileStore[name] = cur
+	}
+	storeMutex.Unlock()
+	return etag, nil
+}
+
+// setValidators adds the ETag and Last-Modified headers for an archive
+func setValidators(c echo.Context, etag string, rec archiveRecord) {
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", rec.CreatedAt.UTC().Format(http.TimeFormat))
+}
+
+// notModified reports whether the request's conditional headers show the
+// client already has this version of the archive
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagListMatches applies the weak comparison If-None-Match uses to a
+// comma-separated list of entity tags
+func etagListMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
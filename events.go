@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventKind names something that happened which operators may want to hear about
+type eventKind string
+
+const (
+	eventJobStarted    eventKind = "job.started"
+	eventJobCompleted  eventKind = "job.completed"
+	eventJobFailed     eventKind = "job.failed"
+	eventQuotaExceeded eventKind = "quota.exceeded"
+	eventDiskLow       eventKind = "disk.low"
+)
+
+// eventKinds lists every event BULK_NOTIFY_EVENTS may route
+var eventKinds = []eventKind{eventJobStarted, eventJobCompleted, eventJobFailed, eventQuotaExceeded, eventDiskLow}
+
+// event is one occurrence published on the bus
+type event struct {
+	Kind eventKind
+	Time time.Time
+
+	// One-line description for people reading the notification
+	Text string
+
+	// Events sharing a key, such as repeated refusals for one principal, are
+	// passed on at most once per BULK_NOTIFY_COOLDOWN; empty is never throttled
+	key string
+}
+
+// eventBacklog is how many events can wait for delivery before new ones are dropped
+const eventBacklog = 256
+
+var (
+	// eventRoutes holds the notifiers each kind of event goes to
+	eventRoutes = make(map[eventKind][]namedNotifier)
+
+	eventQueue = make(chan event, eventBacklog)
+
+	// eventLastSent remembers when each throttled key was last passed on,
+	// until its cooldown is over
+	eventLastSent  = make(map[string]time.Time)
+	eventPruned    time.Time
+	eventSentMutex = &sync.Mutex{}
+)
+
+// setupEvents routes the BULK_NOTIFY_EVENTS kinds to their notifiers and
+// starts delivering. An event without "=" goes to every notifier; one like
+// job.failed=slack+teams only to those named.
+func setupEvents() error {
+	if len(notifiers) == 0 {
+		return nil
+	}
+	for _, item := range config.NotifyEvents {
+		name, targets, routed := strings.Cut(item, "=")
+		kind := eventKind(strings.TrimSpace(name))
+		known := false
+		for _, k := range eventKinds {
+			known = known || k == kind
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q in BULK_NOTIFY_EVENTS", kind)
+		}
+		if !routed {
+			eventRoutes[kind] = append(eventRoutes[kind], notifiers...)
+			continue
+		}
+		for _, target := range strings.Split(targets, "+") {
+			n, ok := notifierByName(strings.TrimSpace(target))
+			if !ok {
+				return fmt.Errorf("event %s in BULK_NOTIFY_EVENTS names unknown notifier %q", kind, target)
+			}
+			eventRoutes[kind] = append(eventRoutes[kind], n)
+		}
+	}
+
+	routed := make([]string, 0, len(eventRoutes))
+	for kind := range eventRoutes {
+		routed = append(routed, string(kind))
+	}
+	sort.Strings(routed)
+	log.Printf("Notifying about %s", strings.Join(routed, ", "))
+	go deliverEvents()
+	return nil
+}
+
+// publish puts ev on the bus without waiting for it to be delivered.
+// Events nobody is notified about are dropped right away.
+func publish(ev event) {
+	if len(eventRoutes[ev.Kind]) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.key != "" && !takeEventSlot(ev.Kind, ev.key, ev.Time) {
+		return
+	}
+	select {
+	case eventQueue <- ev:
+	default:
+		log.Printf("Event backlog full, dropping %s: %s", ev.Kind, ev.Text)
+	}
+}
+
+// takeEventSlot reports whether an event with key may be passed on at now,
+// starting a new cooldown if so
+func takeEventSlot(kind eventKind, key string, now time.Time) bool {
+	eventSentMutex.Lock()
+	defer eventSentMutex.Unlock()
+	// Keys whose cooldown has ended are dropped once per cooldown, so one
+	// entry per refused address doesn't pile up forever
+	if now.Sub(eventPruned) >= config.NotifyCooldown {
+		for k, last := range eventLastSent {
+			if now.Sub(last) >= config.NotifyCooldown {
+				delete(eventLastSent, k)
+			}
+		}
+		eventPruned = now
+	}
+	k := string(kind) + " " + key
+	if last, ok := eventLastSent[k]; ok && now.Sub(last) < config.NotifyCooldown {
+		return false
+	}
+	eventLastSent[k] = now
+	return true
+}
+
+// deliverEvents hands queued events to their notifiers, one at a time so a
+// slow webhook can't pile up connections
+func deliverEvents() {
+	for ev := range eventQueue {
+		for _, n := range eventRoutes[ev.Kind] {
+			if err := n.Notify(ev); err != nil {
+				log.Printf("Notifying %s about %s failed: %v", n.name, ev.Kind, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// expiryPresets are the link lifetimes offered on the upload page, filtered
+// to the configured bounds
+var expiryPresets = []time.Duration{
+	15 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// parseExpiry validates an uploader-chosen link lifetime such as "15m", "36h"
+// or "7d" against BULK_ARCHIVE_TTL_MIN and BULK_ARCHIVE_TTL_MAX. An empty
+// value returns 0, meaning the server default.
+func parseExpiry(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid link lifetime %q", raw)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(raw); err != nil {
+			return 0, fmt.Errorf("Invalid link lifetime %q", raw)
+		}
+	}
+	if !expiryAllowed(d) {
+		if config.ArchiveTTLMax > 0 {
+			return 0, fmt.Errorf("Link lifetime must be between %s and %s",
+				formatExpiry(config.ArchiveTTLMin), formatExpiry(config.ArchiveTTLMax))
+		}
+		return 0, fmt.Errorf("Link lifetime must be at least %s", formatExpiry(config.ArchiveTTLMin))
+	}
+	return d, nil
+}
+
+// expiryAllowed reports whether d lies within the admin-set bounds
+func expiryAllowed(d time.Duration) bool {
+	return d > 0 && d >= config.ArchiveTTLMin && (config.ArchiveTTLMax <= 0 || d <= config.ArchiveTTLMax)
+}
+
+// formatExpiry renders d in the largest whole unit, e.g. "15 minutes" or "7 days"
+func formatExpiry(d time.Duration) string {
+	unit := func(n int64, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return unit(int64(d/(24*time.Hour)), "day")
+	case d >= time.Hour && d%time.Hour == 0:
+		return unit(int64(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return unit(int64(d/time.Minute), "minute")
+	}
+	return d.String()
+}
+
+// handleExpiryOptions renders the link lifetime picker for the upload form
+func handleExpiryOptions(c echo.Context) error {
+	type option struct {
+		Value, Label string
+		Selected     bool
+	}
+	var data struct {
+		Options     []option
+		HaveDefault bool
+	}
+	for _, d := range expiryPresets {
+		if !expiryAllowed(d) {
+			continue
+		}
+		selected := d == config.ArchiveTTL
+		data.HaveDefault = data.HaveDefault || selected
+		data.Options = append(data.Options, option{d.String(), formatExpiry(d), selected})
+	}
+	if len(data.Options) == 0 {
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Render(http.StatusOK, "expiry_options", data)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// fetchedFile is a remote file downloaded for inclusion in an archive
+type fetchedFile struct {
+	URL  string
+	Name string
+	Path string
+	Size int64
+
+	// From the Last-Modified response header; zero when the server sent none
+	ModTime time.Time
+
+	// temp is set when Path is a spool file to delete after use rather than a cache blob
+	temp bool
+}
+
+// errFetchTooLarge is returned when a remote file exceeds the remaining size budget
+var errFetchTooLarge = errors.New("remote file exceeds the size limit")
+
+// fetchClient downloads remote files, refusing private addresses unless
+// allowed. It never goes through a proxy, since the dial check would then
+// only see the proxy's address and not the server being fetched from.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: fetchDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// net.IP.IsPrivate leaves out but the provider's internal hosts may sit in
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// fetchDialControl blocks connections to loopback, private, shared and
+// link-local addresses so the fetcher can't be used to reach internal services
+func fetchDialControl(network, address string, _ syscall.RawConn) error {
+	if config.FetchAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("fetching from %s is not allowed", host)
+	}
+	return nil
+}
+
+// fetchSchemes are the URL schemes the fetcher accepts; sftp and ftp URLs
+// carry their credentials in the user info part
+var fetchSchemes = map[string]bool{"http": true, "https": true, "sftp": true, "ftp": true}
+
+// parseURLList splits a newline separated list of URLs, ignoring blanks and
+// # comments. A line may list mirrors of its file after the first URL and
+// checksums such as sha256:<hex> the file must match, separated by spaces;
+// such lines are kept as one entry.
+func parseURLList(values []string) ([]string, error) {
+	var urls []string
+	for _, v := range values {
+		for _, line := range strings.Split(v, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			t, err := parseFetchTarget(line)
+			if err != nil {
+				return nil, err
+			}
+			fields := make([]string, 0, len(t.mirrors)+len(t.checksums))
+			for _, candidate := range t.mirrors {
+				u, err := url.Parse(candidate)
+				if err != nil || !fetchSchemes[u.Scheme] || u.Host == "" {
+					return nil, fmt.Errorf("Invalid URL: %s", redactURL(candidate))
+				}
+				fields = append(fields, u.String())
+			}
+			for _, c := range t.checksums {
+				fields = append(fields, c.String())
+			}
+			urls = append(urls, strings.Join(fields, " "))
+		}
+	}
+	return urls, nil
+}
+
+// fetchURLs downloads every URL, or one of its mirrors, up to
+// policy.Concurrency at a time, stopping at the first failure unless failed
+// lets it skip the URL. Entries keep the order of urls. The returned cleanup
+// func removes spool files and must be called once the entries are used.
+func fetchURLs(ctx context.Context, urls []string, budget int64, policy fetchPolicy, failed failFunc) ([]archiveEntry, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = withFetchLimiter(ctx, newByteLimiter(policy.Rate))
+
+	var (
+		mu       sync.Mutex
+		fetched  = make([]*fetchedFile, len(urls))
+		firstErr error
+	)
+	cleanup := func() {
+		for _, f := range fetched {
+			if f != nil && f.temp {
+				os.Remove(f.Path)
+			}
+		}
+	}
+
+	// fail records a fetch error, cancelling the rest unless it may be skipped
+	fail := func(rawURL string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr != nil || (failed != nil && failed(redactURL(rawURL), err)) {
+			return
+		}
+		firstErr = err
+		cancel()
+	}
+
+	throttle := newHostThrottle(policy)
+	running := make(chan struct{}, policy.Concurrency)
+	var wg sync.WaitGroup
+	for i, entry := range urls {
+		// Anything after the first URL on a line is a mirror or checksum of it
+		target, err := parseFetchTarget(entry)
+		if err != nil {
+			fail(entry, err)
+			continue
+		}
+		rawURL := target.mirrors[0]
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-running }()
+			mu.Lock()
+			limit := budget
+			mu.Unlock()
+			if config.MaxFileSize > 0 && config.MaxFileSize < limit {
+				limit = config.MaxFileSize
+			}
+			f, err := fetchMirrors(ctx, throttle, target, max(limit, 0), policy.RaceMirrors)
+			if err == nil {
+				// Fetches running side by side share what is left of the budget
+				mu.Lock()
+				if f.Size > budget {
+					err = errFetchTooLarge
+					if f.temp {
+						os.Remove(f.Path)
+					}
+				} else {
+					budget -= f.Size
+					fetched[i] = &f
+				}
+				mu.Unlock()
+			}
+			switch {
+			case err == nil:
+			case errors.Is(err, errFetchTooLarge):
+				fail(rawURL, fmt.Errorf("Remote file %s is too large (max %s)", redactURL(rawURL), bytes.Format(max(limit, 0))))
+			default:
+				fail(rawURL, fmt.Errorf("Error fetching %s: %v", redactURL(rawURL), err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		cleanup()
+		return nil, nil, firstErr
+	}
+
+	entries := make([]archiveEntry, 0, len(urls))
+	for _, f := range fetched {
+		if f == nil {
+			continue
+		}
+		p := f.Path
+		entries = append(entries, archiveEntry{
+			Name: f.Name,
+			Size: f.Size,
+			Open: func() (io.ReadCloser, error) { return os.Open(p) },
+
+			ModTime: f.ModTime,
+			Source:  f.URL,
+		})
+	}
+	return entries, cleanup, nil
+}
+
+// fetchURL downloads rawURL, reusing the cached copy when the server reports
+// it unchanged since its ETag or Last-Modified. At most maxSize bytes are
+// accepted.
+func fetchURL(ctx context.Context, rawURL string, maxSize int64) (fetchedFile, error) {
+	if u, err := url.Parse(rawURL); err == nil {
+		switch u.Scheme {
+		case "sftp":
+			return fetchSFTP(ctx, u, maxSize)
+		case "ftp":
+			return fetchFTP(ctx, u, maxSize)
+		case "s3":
+			return fetchS3(ctx, u, maxSize)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.FetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	req.Header.Set("User-Agent", "bulk-download")
+
+	cached, haveCached := fetchCache.lookup(rawURL)
+	if haveCached && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if haveCached && cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	start := time.Now()
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return fetchedFile{}, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	noteFetch(ctx, func(e *fetchLogEntry) { e.Status = resp.StatusCode })
+
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		if blob, ok := fetchCache.use(rawURL); ok {
+			noteFetch(ctx, func(e *fetchLogEntry) { e.Cached = true })
+			log.Printf("Fetch cache hit for %s (etag %q, last modified %q)", redactURL(rawURL), cached.ETag, cached.LastModified)
+			return fetchedFile{URL: rawURL, Name: cached.Name, Path: blob, Size: cached.Size,
+				ModTime: lastModified(resp)}, nil
+		}
+		// The blob vanished; fall through to an unconditional fetch
+		resp.Body.Close()
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+		if resp, err = fetchClient.Do(req); err != nil {
+			return fetchedFile{}, redactURLError(err)
+		}
+		defer resp.Body.Close()
+		noteFetch(ctx, func(e *fetchLogEntry) { e.Status = resp.StatusCode })
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fetchedFile{}, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return fetchedFile{}, errFetchTooLarge
+	}
+
+	// Cacheable responses are spooled inside the cache so they can be moved into place
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	cacheable := etag != "" || modified != ""
+	spoolDir := spillDir()
+	if dir := fetchCache.directory(); cacheable && dir != "" {
+		spoolDir = dir
+	}
+	var spoolPath, sum string
+	var n int64
+	if rangeable(resp) {
+		spoolPath, n, sum, err = fetchRanged(ctx, rawURL, req, resp, spoolDir)
+	} else {
+		spoolPath, n, sum, err = spoolBody(fetchReader(ctx, resp.Body), spoolDir, maxSize)
+	}
+	if err != nil {
+		return fetchedFile{}, err
+	}
+
+	f := fetchedFile{URL: rawURL, Name: remoteFileName(resp), Path: spoolPath, Size: n,
+		ModTime: lastModified(resp), temp: true}
+	log.Printf("Fetched %s (%s in %s)", redactURL(rawURL), bytes.Format(n), time.Since(start).Round(time.Millisecond))
+
+	// Responses with a validator can be revalidated later, so keep them
+	if cacheable {
+		if blob, err := fetchCache.store(rawURL, etag, modified, f.Name, sum, spoolPath, n); err == nil {
+			f.Path = blob
+			f.temp = false
+		} else if !errors.Is(err, errFetchCacheDisabled) {
+			log.Printf("Error caching %s: %v", redactURL(rawURL), err)
+		}
+	}
+	return f, nil
+}
+
+// spoolBody copies body into a new file in dir, failing with errFetchTooLarge
+// past maxSize. It returns the file path, its size and hex SHA-256.
+func spoolBody(body io.Reader, dir string, maxSize int64) (string, int64, string, error) {
+	spool, err := os.CreateTemp(dir, "fetch-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	spoolPath := spool.Name()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(spool, h), io.LimitReader(body, maxSize+1))
+	if cerr := spool.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > maxSize {
+		err = errFetchTooLarge
+	}
+	if err != nil {
+		os.Remove(spoolPath)
+		return "", 0, "", err
+	}
+	return spoolPath, n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteFileName picks an entry name from Content-Disposition or the URL path
+func remoteFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(params["filename"]); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "" && name != "." && name != "/" {
+		return name
+	}
+	return resp.Request.URL.Hostname()
+}
+
+// lastModified returns the parsed Last-Modified header of resp, or the zero time
+func lastModified(resp *http.Response) time.Time {
+	t, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// redactURL hides any password and the query values in rawURL for logs and
+// error messages, since presigned and token links carry credentials there
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q[k] = []string{"xxxxx"}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactURLError redacts the URL that the HTTP client puts in its errors
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
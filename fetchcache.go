@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errFetchCacheDisabled is returned by store when no cache size is configured
+var errFetchCacheDisabled = errors.New("fetch cache disabled")
+
+// fetchCacheEntry maps a URL to the blob holding its last fetched content
+// and the validators to revalidate it with
+type fetchCacheEntry struct {
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Name         string    `json:"name"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	LastUsed     time.Time `json:"lastUsed"`
+}
+
+// contentCache stores fetched payloads by content hash so overlapping URL
+// lists (and mirrors serving identical files) share one copy on disk
+type contentCache struct {
+	mu      sync.Mutex
+	dir     string
+	entries map[string]fetchCacheEntry
+
+	// URLs whose blobs are never evicted, such as those of scheduled bundles
+	pinned map[string]bool
+}
+
+// fetchCache is the process-wide cache for remote fetches
+var fetchCache = &contentCache{entries: make(map[string]fetchCacheEntry)}
+
+// fetchCacheDir returns the configured cache directory
+func fetchCacheDir() string {
+	if config.FetchCacheDir != "" {
+		return config.FetchCacheDir
+	}
+	return filepath.Join(config.DataDir, "fetchcache")
+}
+
+// openFetchCache loads the cache index from disk
+func openFetchCache() error {
+	if config.FetchCacheSize <= 0 {
+		return nil
+	}
+
+	dir := fetchCacheDir()
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o750); err != nil {
+		return fmt.Errorf("creating fetch cache dir: %w", err)
+	}
+
+	fetchCache.mu.Lock()
+	defer fetchCache.mu.Unlock()
+	fetchCache.dir = dir
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading fetch cache index: %w", err)
+	}
+	if err := json.Unmarshal(data, &fetchCache.entries); err != nil {
+		return fmt.Errorf("parsing fetch cache index: %w", err)
+	}
+	log.Printf("Loaded %d fetch cache entries from %s", len(fetchCache.entries), dir)
+	return nil
+}
+
+// directory returns the cache directory, or "" when the cache is disabled
+func (fc *contentCache) directory() string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.dir
+}
+
+// blobPath is where content with the given hash is stored
+func (fc *contentCache) blobPath(sum string) string {
+	return filepath.Join(fc.dir, "blobs", sum)
+}
+
+// lookup returns the cache entry for url without touching it
+func (fc *contentCache) lookup(url string) (fetchCacheEntry, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.dir == "" {
+		return fetchCacheEntry{}, false
+	}
+	e, ok := fc.entries[url]
+	return e, ok
+}
+
+// use marks url as recently used and returns its blob path if the blob still exists
+func (fc *contentCache) use(url string) (string, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	e, ok := fc.entries[url]
+	if !ok {
+		return "", false
+	}
+	blob := fc.blobPath(e.SHA256)
+	if _, err := os.Stat(blob); err != nil {
+		delete(fc.entries, url)
+		fc.saveLocked()
+		return "", false
+	}
+	e.LastUsed = time.Now()
+	fc.entries[url] = e
+	fc.saveLocked()
+	return blob, true
+}
+
+// pin replaces the set of URLs whose blobs are kept regardless of the size budget
+func (fc *contentCache) pin(urls []string) {
+	pinned := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		pinned[url] = true
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.pinned = pinned
+}
+
+// store moves a freshly fetched spool file into the cache and returns the blob path
+func (fc *contentCache) store(url, etag, lastModified, name, sum, spoolPath string, size int64) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.dir == "" {
+		return "", errFetchCacheDisabled
+	}
+
+	blob := fc.blobPath(sum)
+	if _, err := os.Stat(blob); err == nil {
+		// Identical content is already cached under another URL or ETag
+		os.Remove(spoolPath)
+	} else if err := os.Rename(spoolPath, blob); err != nil {
+		return "", err
+	}
+
+	fc.entries[url] = fetchCacheEntry{ETag: etag, LastModified: lastModified, Name: name, SHA256: sum,
+		Size: size, LastUsed: time.Now()}
+	fc.evictLocked()
+	fc.saveLocked()
+	return blob, nil
+}
+
+// evictLocked drops least recently used entries until the cache fits its size budget
+func (fc *contentCache) evictLocked() {
+	// Blobs shared by several URLs are counted once
+	blobSize := make(map[string]int64)
+	blobUsed := make(map[string]time.Time)
+	blobPinned := make(map[string]bool)
+	for url, e := range fc.entries {
+		blobSize[e.SHA256] = e.Size
+		if fc.pinned[url] {
+			blobPinned[e.SHA256] = true
+		}
+		if e.LastUsed.After(blobUsed[e.SHA256]) {
+			blobUsed[e.SHA256] = e.LastUsed
+		}
+	}
+
+	var total int64
+	sums := make([]string, 0, len(blobSize))
+	for sum, size := range blobSize {
+		total += size
+		sums = append(sums, sum)
+	}
+	sort.Slice(sums, func(i, j int) bool { return blobUsed[sums[i]].Before(blobUsed[sums[j]]) })
+
+	for _, sum := range sums {
+		if total <= config.FetchCacheSize {
+			break
+		}
+		if blobPinned[sum] {
+			continue
+		}
+		for url, e := range fc.entries {
+			if e.SHA256 == sum {
+				delete(fc.entries, url)
+			}
+		}
+		os.Remove(fc.blobPath(sum))
+		total -= blobSize[sum]
+		log.Printf("Evicted fetch cache blob %s", sum)
+	}
+}
+
+// saveLocked writes the cache index to disk
+func (fc *contentCache) saveLocked() {
+	data, err := json.Marshal(fc.entries)
+	if err != nil {
+		log.Printf("Error encoding fetch cache index: %v", err)
+		return
+	}
+	tmp := filepath.Join(fc.dir, "index.json.tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		log.Printf("Error writing fetch cache index: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(fc.dir, "index.json")); err != nil {
+		log.Printf("Error replacing fetch cache index: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// fetchLogEntry is one download attempt made for a job: a URL or one of its
+// mirrors, and how it went
+type fetchLogEntry struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	Status   int       `json:"status,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"durationSeconds"`
+	Retries  int       `json:"retries,omitempty"`
+
+	// Served from the fetch cache after a 304 Not Modified
+	Cached bool   `json:"cached,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// fetchLog collects the download attempts of one job for its owner to inspect
+type fetchLog struct {
+	ID      string          `json:"id"`
+	Created time.Time       `json:"created"`
+	Entries []fetchLogEntry `json:"entries"`
+
+	mu sync.Mutex
+}
+
+var (
+	fetchLogs     = make(map[string]*fetchLog)
+	fetchLogMutex sync.Mutex
+)
+
+// fetchLogContextKey holds the log path of the request's fetches in the Echo
+// context, for the result fragments to link to
+const fetchLogContextKey = "fetchLog"
+
+// headerFetchLog points a /compress response at its fetch log
+const headerFetchLog = "X-Fetch-Log"
+
+// fetchLogKey and fetchAttemptKey hold a job's log and the attempt being
+// made in the context of its fetches
+type (
+	fetchLogKey     struct{}
+	fetchAttemptKey struct{}
+)
+
+// newFetchLog registers an empty log, kept as long as finished jobs are
+func newFetchLog() *fetchLog {
+	now := time.Now()
+	l := &fetchLog{ID: newJobID(), Created: now}
+
+	fetchLogMutex.Lock()
+	defer fetchLogMutex.Unlock()
+	for id, old := range fetchLogs {
+		if now.Sub(old.Created) > jobRetention {
+			delete(fetchLogs, id)
+		}
+	}
+	fetchLogs[l.ID] = l
+	return l
+}
+
+// getFetchLog returns the log with the given ID
+func getFetchLog(id string) (*fetchLog, bool) {
+	fetchLogMutex.Lock()
+	defer fetchLogMutex.Unlock()
+	l, ok := fetchLogs[id]
+	return l, ok
+}
+
+// withFetchLog records the fetches made under ctx in l
+func withFetchLog(ctx context.Context, l *fetchLog) context.Context {
+	return context.WithValue(ctx, fetchLogKey{}, l)
+}
+
+// path is where the log can be read
+func (l *fetchLog) path() string {
+	return "/jobs/" + l.ID + "/log"
+}
+
+// startFetchAttempt begins a log entry for rawURL. The returned func
+// completes it with the outcome once the attempt is over.
+func startFetchAttempt(ctx context.Context, rawURL string) (context.Context, func(f fetchedFile, err error)) {
+	l, _ := ctx.Value(fetchLogKey{}).(*fetchLog)
+	if l == nil {
+		return ctx, func(fetchedFile, error) {}
+	}
+	entry := &fetchLogEntry{Time: time.Now(), URL: redactURL(rawURL)}
+	return context.WithValue(ctx, fetchAttemptKey{}, entry), func(f fetchedFile, err error) {
+		entry.Duration = time.Since(entry.Time).Seconds()
+		entry.Bytes = f.Size
+		if err != nil {
+			entry.Bytes = 0
+			entry.Error = err.Error()
+		}
+		l.mu.Lock()
+		l.Entries = append(l.Entries, *entry)
+		l.mu.Unlock()
+	}
+}
+
+// noteFetch updates the attempt in progress under ctx, if it is being logged
+func noteFetch(ctx context.Context, fn func(e *fetchLogEntry)) {
+	if e, ok := ctx.Value(fetchAttemptKey{}).(*fetchLogEntry); ok {
+		fn(e)
+	}
+}
+
+// handleFetchLog shows the download attempts of a job as a text table, or
+// as JSON for clients that accept it
+func handleFetchLog(c echo.Context) error {
+	l, ok := getFetchLog(c.Param("id"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Job %s not found", c.Param("id")))
+	}
+	l.mu.Lock()
+	snapshot := fetchLog{ID: l.ID, Created: l.Created, Entries: append([]fetchLogEntry{}, l.Entries...)}
+	l.mu.Unlock()
+	sort.SliceStable(snapshot.Entries, func(i, j int) bool { return snapshot.Entries[i].Time.Before(snapshot.Entries[j].Time) })
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if strings.Contains(c.Request().Header.Get("Accept"), echo.MIMEApplicationJSON) {
+		return c.JSON(http.StatusOK, &snapshot)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSTATUS\tBYTES\tDURATION\tRETRIES\tURL\tRESULT")
+	for _, e := range snapshot.Entries {
+		status, result := "-", "ok"
+		if e.Status != 0 {
+			status = fmt.Sprint(e.Status)
+		}
+		if e.Cached {
+			result = "cached"
+		}
+		if e.Error != "" {
+			result = e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.Time.UTC().Format(time.TimeOnly), status,
+			bytes.Format(e.Bytes), time.Duration(e.Duration*float64(time.Second)).Round(time.Millisecond),
+			e.Retries, e.URL, result)
+	}
+	w.Flush()
+	return c.String(http.StatusOK, b.String())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// fetchPolicy bounds how hard one job's URL fetches hit remote servers
+type fetchPolicy struct {
+	// Fetches running at once
+	Concurrency int
+
+	// Of those, how many may go to any one host
+	PerHost int
+
+	// Pause between starting requests to the same host
+	Delay time.Duration
+
+	// Fetch from all mirrors of a file at once instead of one after another
+	RaceMirrors bool
+
+	// Bytes per second all of the job's fetches may download together; 0 is unlimited
+	Rate int64
+}
+
+// defaultFetchPolicy is the policy from BULK_FETCH_CONCURRENCY,
+// BULK_FETCH_PER_HOST, BULK_FETCH_DELAY and BULK_FETCH_RATE
+func defaultFetchPolicy() fetchPolicy {
+	return fetchPolicy{
+		Concurrency: max(config.FetchConcurrency, 1),
+		PerHost:     max(min(config.FetchPerHost, config.FetchConcurrency), 1),
+		Delay:       config.FetchDelay,
+		Rate:        max(config.FetchRate, 0),
+	}
+}
+
+// parseFetchPolicy reads the fetch_concurrency, fetch_per_host, fetch_delay,
+// fetch_rate and mirrors form fields. Jobs may be gentler than the configured policy but
+// not more aggressive.
+func parseFetchPolicy(c echo.Context) (fetchPolicy, error) {
+	p := defaultFetchPolicy()
+	if v := c.FormValue("fetch_concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > p.Concurrency {
+			return p, fmt.Errorf("fetch_concurrency must be between 1 and %d", p.Concurrency)
+		}
+		p.Concurrency = n
+	}
+	if v := c.FormValue("fetch_per_host"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > p.PerHost {
+			return p, fmt.Errorf("fetch_per_host must be between 1 and %d", p.PerHost)
+		}
+		p.PerHost = n
+	}
+	p.PerHost = min(p.PerHost, p.Concurrency)
+	if v := c.FormValue("fetch_delay"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < p.Delay || d > time.Minute {
+			return p, fmt.Errorf("fetch_delay must be a duration between %s and 1m", p.Delay)
+		}
+		p.Delay = d
+	}
+	if v := c.FormValue("fetch_rate"); v != "" {
+		n, err := bytes.Parse(v)
+		if err != nil || n < 1 || (p.Rate > 0 && n > p.Rate) {
+			if p.Rate > 0 {
+				return p, fmt.Errorf("fetch_rate must be a size per second up to %s", bytes.Format(p.Rate))
+			}
+			return p, fmt.Errorf("fetch_rate must be a size per second such as 2MB")
+		}
+		p.Rate = n
+	}
+	switch c.FormValue("mirrors") {
+	case "", "fallback":
+	case "race":
+		p.RaceMirrors = true
+	default:
+		return p, fmt.Errorf("mirrors must be fallback or race")
+	}
+	return p, nil
+}
+
+// hostThrottle enforces a policy's per-host limits across one job's fetches
+type hostThrottle struct {
+	policy fetchPolicy
+	mu     sync.Mutex
+	hosts  map[string]*hostSlots
+}
+
+// hostSlots tracks the fetches to one host
+type hostSlots struct {
+	running chan struct{}
+	next    time.Time
+}
+
+func newHostThrottle(p fetchPolicy) *hostThrottle {
+	return &hostThrottle{policy: p, hosts: make(map[string]*hostSlots)}
+}
+
+// acquire waits for a free connection to rawURL's host and for the delay
+// since the previous request to it. release must be called after the fetch.
+func (t *hostThrottle) acquire(ctx context.Context, rawURL string) (release func(), err error) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	t.mu.Lock()
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostSlots{running: make(chan struct{}, t.policy.PerHost)}
+		t.hosts[host] = h
+	}
+	t.mu.Unlock()
+
+	select {
+	case h.running <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release = func() { <-h.running }
+
+	// Requests to a host start at least Delay apart
+	t.mu.Lock()
+	now := time.Now()
+	start := now
+	if h.next.After(now) {
+		start = h.next
+	}
+	h.next = start.Add(t.policy.Delay)
+	t.mu.Unlock()
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
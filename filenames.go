@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits that keep names extractable on common file systems
+const (
+	maxNameComponent = 255  // bytes in one file or folder name
+	maxEntryName     = 4096 // bytes in a whole path
+)
+
+// sanitizeEntryName makes a user supplied file name safe to store in an
+// archive and show in the UI. It drops control and bidi override
+// characters, normalizes the name to Unicode NFC, treats backslashes as
+// separators and strips drive letters, leading slashes and "." or ".."
+// components so the entry can't land outside the folder it is extracted
+// to. Reserved Windows device names get a "_" prefix, long names are cut
+// to maxNameComponent and paths over maxEntryName lose leading folders. A
+// trailing slash marking a folder is kept.
+func sanitizeEntryName(name string) string {
+	name = norm.NFC.String(strings.Map(func(r rune) rune {
+		switch {
+		case r == '\\':
+			return '/'
+		case unicode.IsControl(r), unicode.Is(unicode.Bidi_Control, r):
+			return -1
+		}
+		return r
+	}, name))
+	// Like safeextract.Clean, anything shaped like a drive prefix counts as one
+	for len(name) > 1 && name[1] == ':' && name[0] < utf8.RuneSelf {
+		name = name[2:]
+	}
+
+	var parts []string
+	size := 0
+	for _, part := range strings.Split(name, "/") {
+		if part != "" && part != "." && part != ".." {
+			if reservedWindowsName(part) {
+				part = "_" + part
+			}
+			part = truncateName(part, "", maxNameComponent)
+			parts = append(parts, part)
+			size += len(part) + 1
+		}
+	}
+	if len(parts) == 0 {
+		return "file"
+	}
+	for size > maxEntryName && len(parts) > 1 {
+		size -= len(parts[0]) + 1
+		parts = parts[1:]
+	}
+	cleaned := strings.Join(parts, "/")
+	if strings.HasSuffix(name, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// reservedWindowsName reports whether Windows treats the file name as a
+// device, such as CON or nul.txt
+func reservedWindowsName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	stem = strings.ToUpper(strings.TrimRight(stem, " "))
+	switch stem {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(stem) == 4 && (strings.HasPrefix(stem, "COM") || strings.HasPrefix(stem, "LPT")) &&
+		stem[3] >= '1' && stem[3] <= '9'
+}
+
+// fileEntryName is sanitizeEntryName for a file with content, which must
+// not end in a slash or ZIP readers take it for a folder
+func fileEntryName(name string) string {
+	return strings.TrimSuffix(sanitizeEntryName(name), "/")
+}
+
+// truncateName inserts suffix before the extension of name and cuts the
+// result to at most n bytes on a character boundary, keeping a short
+// extension and the suffix
+func truncateName(name, suffix string, n int) string {
+	ext := path.Ext(name)
+	if len(name)+len(suffix) <= n {
+		return strings.TrimSuffix(name, ext) + suffix + ext
+	}
+	if len(ext) > 16 {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	cut := max(n-len(suffix)-len(ext), 0)
+	for cut > 0 && !utf8.RuneStart(stem[cut]) {
+		cut--
+	}
+	return stem[:cut] + suffix + ext
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestSanitizeEntryName(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"dir/sub/a.txt", "dir/sub/a.txt"},
+		{"folder/", "folder/"},
+		{`..\..\windows\win.ini`, "windows/win.ini"},
+		{"/etc/passwd", "etc/passwd"},
+		{"C:/Users/a.txt", "Users/a.txt"},
+		{"a:b:c.txt", "c.txt"},
+		{"0\x02:", "file"},
+		{"a\x00b\x1f.txt", "ab.txt"},
+		{"evil\u202Etxt.exe", "eviltxt.exe"},
+		{"e\x00\u0301", "\u00e9"},
+		{"", "file"},
+		{"../..", "file"},
+		{"CON", "_CON"},
+		{"docs/nul.txt", "docs/_nul.txt"},
+		{"com1.tar.gz", "_com1.tar.gz"},
+		{"LPT9", "_LPT9"},
+		{"console.txt", "console.txt"},
+		{"com0", "com0"},
+		{long + ".txt", strings.Repeat("a", maxNameComponent-4) + ".txt"},
+		{long, strings.Repeat("a", maxNameComponent)},
+	}
+	for _, tt := range tests {
+		if got := sanitizeEntryName(tt.name); got != tt.want {
+			t.Errorf("sanitizeEntryName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Overlong paths lose their leading folders but keep the file name
+	deep := strings.Repeat(strings.Repeat("d", 200)+"/", 30) + "keep.txt"
+	got := sanitizeEntryName(deep)
+	if len(got) > maxEntryName || !strings.HasSuffix(got, "/keep.txt") {
+		t.Errorf("sanitizeEntryName(deep path) = %d bytes ending %q", len(got), got[max(len(got)-20, 0):])
+	}
+}
+
+func FuzzSanitizeEntryName(f *testing.F) {
+	for _, seed := range []string{
+		"report.pdf", "dir/sub/", `..\..\x`, "C:\\a", "a:b:c", "/", ".", "",
+		"a\x00b", "\u202Eexe.txt", "e\x00\u0301", "\xff\xfe", "CON.txt", "aux",
+		strings.Repeat("x/", 2100), strings.Repeat("x", 5000),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		got := sanitizeEntryName(name)
+		if got == "" {
+			t.Fatalf("sanitizeEntryName(%q) is empty", name)
+		}
+		if again := sanitizeEntryName(got); again != got {
+			t.Fatalf("sanitizeEntryName(%q) = %q, but that sanitizes to %q", name, got, again)
+		}
+		if !utf8.ValidString(got) || !norm.NFC.IsNormalString(got) {
+			t.Fatalf("sanitizeEntryName(%q) = %q is not NFC UTF-8", name, got)
+		}
+		if len(got) > maxEntryName {
+			t.Fatalf("sanitizeEntryName(%q) is %d bytes long", name, len(got))
+		}
+		for _, r := range got {
+			if r == '\\' || unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) {
+				t.Fatalf("sanitizeEntryName(%q) = %q contains %U", name, got, r)
+			}
+		}
+		if _, err := safeextract.Clean(got); err != nil {
+			t.Fatalf("sanitizeEntryName(%q) = %q is unsafe: %v", name, got, err)
+		}
+		for _, part := range strings.Split(strings.TrimSuffix(got, "/"), "/") {
+			if part == "" || part == "." || part == ".." || len(part) > maxNameComponent {
+				t.Fatalf("sanitizeEntryName(%q) = %q has component %q", name, got, part)
+			}
+			if reservedWindowsName(part) {
+				t.Fatalf("sanitizeEntryName(%q) = %q has reserved component %q", name, got, part)
+			}
+		}
+	})
+}
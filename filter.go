@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"path"
+	"strings"
+)
+
+// pathFilter keeps files whose archive path matches an include pattern, or
+// any file when there are none, unless an exclude pattern matches too.
+// Patterns use matchGlob syntax; a leading "!" marks an exclude.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// parsePathFilter reads patterns from form values holding one per line
+func parsePathFilter(values []string) (pathFilter, error) {
+	var f pathFilter
+	for _, value := range values {
+		for _, line := range strings.Split(value, "\n") {
+			pattern := strings.TrimSpace(line)
+			if pattern == "" {
+				continue
+			}
+			if err := f.add(pattern); err != nil {
+				return pathFilter{}, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// add appends one include or "!" exclude pattern
+func (f *pathFilter) add(pattern string) error {
+	exclude := strings.HasPrefix(pattern, "!")
+	glob := strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/")
+	if glob == "" {
+		return fmt.Errorf("Invalid filter %q", pattern)
+	}
+	for _, segment := range strings.Split(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("Invalid filter %q", pattern)
+		}
+	}
+	if exclude {
+		f.exclude = append(f.exclude, glob)
+	} else {
+		f.include = append(f.include, glob)
+	}
+	return nil
+}
+
+// empty reports whether the filter lets everything through
+func (f pathFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// match reports whether the file at the slash separated name is kept
+func (f pathFilter) match(name string) bool {
+	for _, pattern := range f.exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterUploads drops uploaded files whose archive path, after the paths
+// mapping, the filter leaves out
+func filterUploads(files []*multipart.FileHeader, paths map[string]string, f pathFilter) []*multipart.FileHeader {
+	if f.empty() {
+		return files
+	}
+	kept := files[:0:0]
+	for _, file := range files {
+		if f.match(sanitizeEntryName(mappedName(file.Filename, paths))) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// uniqueNamer returns a function that hands back names unchanged the first
+// time and as "name (2).ext", "name (3).ext" and so on after that, skipping
+// numbers already taken and keeping numbered names within maxNameComponent
+func uniqueNamer() func(name string) string {
+	seen := make(map[string]bool)
+	next := make(map[string]int)
+	return func(name string) string {
+		if !seen[name] {
+			seen[name] = true
+			return name
+		}
+		dir, base := path.Split(strings.TrimSuffix(name, "/"))
+		folder := ""
+		if strings.HasSuffix(name, "/") {
+			folder = "/"
+		}
+		for n := max(next[name], 2); ; n++ {
+			candidate := dir + truncateName(base, fmt.Sprintf(" (%d)", n), maxNameComponent) + folder
+			if !seen[candidate] {
+				seen[candidate] = true
+				next[name] = n + 1
+				return candidate
+			}
+		}
+	}
+}
+
+// flattenEntries moves every entry to the archive root, numbering files
+// that would otherwise collide
+func flattenEntries(entries []archiveEntry) []archiveEntry {
+	unique := uniqueNamer()
+	flat := make([]archiveEntry, len(entries))
+	for i, entry := range entries {
+		entry.Name = unique(path.Base(entry.Name))
+		flat[i] = entry
+	}
+	return flat
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/labstack/gommon/bytes"
+)
+
+// fetchFTP downloads the file at u, an ftp://[user[:password]@]host[:port]/path
+// URL. Anonymous login is used when the URL has no user.
+func fetchFTP(ctx context.Context, u *url.URL, maxSize int64) (fetchedFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.FetchTimeout)
+	defer cancel()
+	start := time.Now()
+
+	// Data connections go through the guarded dialer too, so a PASV reply
+	// can't point the fetcher at an internal address
+	conn, err := ftp.Dial(hostPort(u, "21"),
+		ftp.DialWithContext(ctx),
+		ftp.DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			return fetchDialer.DialContext(ctx, network, address)
+		}))
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	defer conn.Quit()
+	stop := context.AfterFunc(ctx, func() { conn.Quit() })
+	defer stop()
+
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if err := conn.Login(user, password); err != nil {
+		return fetchedFile{}, err
+	}
+
+	if size, err := conn.FileSize(u.Path); err == nil && size > maxSize {
+		return fetchedFile{}, errFetchTooLarge
+	}
+	var modTime time.Time
+	if conn.IsGetTimeSupported() {
+		modTime, _ = conn.GetTime(u.Path)
+	}
+
+	resp, err := conn.Retr(u.Path)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	spoolPath, n, _, err := spoolBody(fetchReader(ctx, resp), spillDir(), maxSize)
+	resp.Close()
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	log.Printf("Fetched %s (%s in %s)", u.Redacted(), bytes.Format(n), time.Since(start).Round(time.Millisecond))
+	return fetchedFile{URL: u.Redacted(), Name: path.Base(u.Path), Path: spoolPath, Size: n,
+		ModTime: modTime, temp: true}, nil
+}
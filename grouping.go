@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Longest archive base name a group label turns into
+const maxGroupLabel = 40
+
+// groupRules split one upload into several archives
+type groupRules struct {
+	// "extension" or "folder"; empty keeps every file together
+	By string
+
+	// Most files in any one archive; 0 is unlimited
+	MaxFiles int
+}
+
+// entryGroup is the files going into one archive of a grouped upload
+type entryGroup struct {
+	Label   string
+	Entries []archiveEntry
+}
+
+// groupArchive describes one archive of a grouped upload on the results page
+type groupArchive struct {
+	Label       string
+	Files       int
+	Size        int64
+	DownloadURL string
+	DeletePath  string
+}
+
+// parseGroupRules reads the group_by and group_max_files form fields
+func parseGroupRules(by, maxFiles string) (groupRules, error) {
+	rules := groupRules{By: by}
+	switch by {
+	case "", "extension", "folder":
+	default:
+		return groupRules{}, fmt.Errorf("Unknown grouping %q (use extension or folder)", by)
+	}
+	if maxFiles = strings.TrimSpace(maxFiles); maxFiles != "" {
+		n, err := strconv.Atoi(maxFiles)
+		if err != nil || n < 0 {
+			return groupRules{}, errors.New("Files per archive must be a positive number")
+		}
+		rules.MaxFiles = n
+	}
+	return rules, nil
+}
+
+// split sorts entries into groups in the order their labels first appear,
+// then cuts each group into archives of at most MaxFiles entries. Folders
+// are those of the archive paths the paths mapping gives the entries.
+func (r groupRules) split(entries []archiveEntry, paths map[string]string) []entryGroup {
+	var groups []entryGroup
+	index := make(map[string]int)
+	for _, entry := range entries {
+		label := r.label(mappedName(entry.Name, paths))
+		i, ok := index[label]
+		if !ok {
+			i = len(groups)
+			index[label] = i
+			groups = append(groups, entryGroup{Label: label})
+		}
+		groups[i].Entries = append(groups[i].Entries, entry)
+	}
+	if r.MaxFiles <= 0 {
+		return groups
+	}
+
+	var chunked []entryGroup
+	for _, g := range groups {
+		if len(g.Entries) <= r.MaxFiles {
+			chunked = append(chunked, g)
+			continue
+		}
+		for part, start := 1, 0; start < len(g.Entries); part, start = part+1, start+r.MaxFiles {
+			end := min(start+r.MaxFiles, len(g.Entries))
+			chunked = append(chunked, entryGroup{
+				Label:   fmt.Sprintf("%s-%d", g.Label, part),
+				Entries: g.Entries[start:end],
+			})
+		}
+	}
+	return chunked
+}
+
+// label names the group of the entry stored at name
+func (r groupRules) label(name string) string {
+	name = sanitizeEntryName(name)
+	var label string
+	switch r.By {
+	case "extension":
+		label = strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+		if label == "" {
+			label = "other"
+		}
+	case "folder":
+		if top, _, ok := strings.Cut(name, "/"); ok {
+			label = top
+		} else {
+			label = "files"
+		}
+	default:
+		label = "archive"
+	}
+	return groupLabel(label)
+}
+
+// groupLabel makes label safe to use as an archive base name
+func groupLabel(label string) string {
+	var b strings.Builder
+	for _, r := range label {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	s := b.String()
+	if len(s) > maxGroupLabel {
+		s = s[:maxGroupLabel]
+	}
+	return s
+}
+
+// createGroupedArchives builds one archive per group and renders a combined
+// results page. If any archive fails, the ones already built are removed so
+// the upload can simply be retried.
+func createGroupedArchives(c echo.Context, groups []entryGroup, opts archiveOptions, failures *batchFailures, deliverTo []string) error {
+	var built []archiveResult
+	var results []fileResult
+	rollback := func() {
+		for _, result := range built {
+			if rec, ok := takeArchive(result.Name); ok {
+				removeArchiveFile(result.Name, rec.Path)
+			}
+		}
+	}
+
+	for i, g := range groups {
+		groupOpts := opts
+		groupOpts.BaseName = g.Label
+		// Files that failed before archiving are listed once, with the first archive
+		if i > 0 {
+			groupOpts.Failed = nil
+		}
+		releaseWorker, err := compressWorkers.acquire(c.Request().Context(), priorityInteractive)
+		if err != nil {
+			rollback()
+			return err
+		}
+		result, err := createArchive(c.Request().Context(), g.Entries, groupOpts, nil)
+		releaseWorker()
+		results = append(results, result.Files...)
+		if err != nil {
+			log.Printf("Grouped archive %s failed: %v", g.Label, err)
+			rollback()
+			if !failures.strict && len(results) > 0 {
+				return htmlBatchError(c, archiveErrorStatus(err), results, "%s", translate(c, archiveErrorMessage(err)))
+			}
+			return htmlError(c, archiveErrorStatus(err), "%s", translate(c, archiveErrorMessage(err)))
+		}
+		built = append(built, result)
+	}
+	log.Printf("Grouped upload produced %d archives", len(built))
+
+	duplicates, quarantined := 0, 0
+	for _, result := range built {
+		duplicates += len(result.Duplicates)
+		quarantined += len(result.Quarantined)
+	}
+	data := successData{
+		Message: tr(c, "%d archives created.", len(built)) + " " + batchSummary(c, results, duplicates, quarantined),
+	}
+	if !allAdded(results) {
+		data.Results = results
+	}
+
+	if len(deliverTo) > 0 {
+		for _, result := range built {
+			locations, err := deliverArchive(c.Request().Context(), result.Name, deliverTo)
+			if err != nil {
+				log.Printf("Delivery of %s failed: %v", result.Name, err)
+				return htmlError(c, http.StatusBadGateway, "Error: %s", err)
+			}
+			data.Delivered = append(data.Delivered, locations...)
+		}
+		if !formBool(c, "keep_local", true) {
+			for _, result := range built {
+				if rec, ok := takeArchive(result.Name); ok {
+					removeArchiveFile(result.Name, rec.Path)
+					auditDeletion(c, result.Name, "delivered without a local copy")
+				}
+			}
+			return c.Render(http.StatusOK, "success", data)
+		}
+	}
+
+	for i, result := range built {
+		rec, _ := getArchive(result.Name)
+		data.Archives = append(data.Archives, groupArchive{
+			Label:       groups[i].Label,
+			Files:       countAdded(result.Files),
+			Size:        rec.Size,
+			DownloadURL: sharePath(result.Name),
+			DeletePath:  deletePath(result.Name),
+		})
+	}
+	return c.Render(http.StatusOK, "success", data)
+}
@@ -0,0 +1,235 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	bulkdownloadv1 "github.com/Michael-Ralph/bulk-download/proto/bulkdownload/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements BulkDownloadService on top of the shared archive pipeline
+type grpcServer struct {
+	bulkdownloadv1.UnimplementedBulkDownloadServiceServer
+}
+
+// startGRPCServer listens on the configured gRPC address until the process exits
+func startGRPCServer() {
+	lis, err := net.Listen("tcp", config.GRPCAddr)
+	if err != nil {
+		log.Printf("gRPC server disabled, could not listen on %s: %v", config.GRPCAddr, err)
+		return
+	}
+
+	s := grpc.NewServer(grpc.ChainStreamInterceptor(grpcAuthInterceptor, grpcIPInterceptor))
+	bulkdownloadv1.RegisterBulkDownloadServiceServer(s, &grpcServer{})
+
+	log.Printf("gRPC server listening on %s", config.GRPCAddr)
+	if err := s.Serve(lis); err != nil {
+		log.Printf("gRPC server stopped: %v", err)
+	}
+}
+
+// grpcAuthInterceptor requires "authorization: Bearer <token>" metadata when a gRPC token is configured
+func grpcAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if config.GRPCToken == "" {
+		return handler(srv, ss)
+	}
+
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(config.GRPCToken)) == 1 {
+			return handler(srv, ss)
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// grpcIPInterceptor holds Compress calls to the upload allow and deny lists,
+// checked against the connecting address since gRPC has no trusted proxies
+func grpcIPInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if info.FullMethod != bulkdownloadv1.BulkDownloadService_Compress_FullMethodName || (len(uploadAllowIPs) == 0 && len(uploadDenyIPs) == 0) {
+		return handler(srv, ss)
+	}
+	clientIP := grpcClientIP(ss.Context())
+	if addr, err := netip.ParseAddr(clientIP); err == nil && ipAllowed(addr, uploadAllowIPs, uploadDenyIPs) {
+		return handler(srv, ss)
+	}
+	log.Printf("Refused gRPC %s from %s: address not allowed", info.FullMethod, clientIP)
+	return status.Error(codes.PermissionDenied, "requests from this address are not allowed")
+}
+
+// Compress spools the streamed files to the temp directory, then builds the
+// archive in the background so the caller can follow it with WatchJob
+func (s *grpcServer) Compress(stream bulkdownloadv1.BulkDownloadService_CompressServer) error {
+	var (
+		entries   []archiveEntry
+		current   *os.File
+		totalSize int64
+		spooled   []string
+	)
+
+	cleanup := func() {
+		for _, path := range spooled {
+			os.Remove(path)
+		}
+	}
+	fail := func(err error) error {
+		if current != nil {
+			current.Close()
+		}
+		cleanup()
+		return err
+	}
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		switch p := req.Payload.(type) {
+		case *bulkdownloadv1.CompressRequest_Start:
+			if p.Start.GetName() == "" {
+				return fail(status.Error(codes.InvalidArgument, "file name is required"))
+			}
+			if config.MaxFiles > 0 && len(entries) >= config.MaxFiles {
+				return fail(status.Errorf(codes.ResourceExhausted, "Too many files (max %d)", config.MaxFiles))
+			}
+			if current != nil {
+				current.Close()
+			}
+			current, err = os.CreateTemp(spillDir(), "grpc-upload-*")
+			if err != nil {
+				log.Printf("Error creating spool file: %v", err)
+				return fail(status.Error(codes.Internal, "Error creating temporary file"))
+			}
+			spooled = append(spooled, current.Name())
+			path := current.Name()
+			entries = append(entries, archiveEntry{
+				Name: p.Start.GetName(),
+				Open: func() (io.ReadCloser, error) { return os.Open(path) },
+			})
+
+		case *bulkdownloadv1.CompressRequest_Chunk:
+			if current == nil {
+				return fail(status.Error(codes.InvalidArgument, "chunk received before any file start"))
+			}
+			entry := &entries[len(entries)-1]
+			entry.Size += int64(len(p.Chunk))
+			totalSize += int64(len(p.Chunk))
+			if config.MaxFileSize > 0 && entry.Size > config.MaxFileSize {
+				return fail(status.Errorf(codes.ResourceExhausted, "File %s is too large", entry.Name))
+			}
+			if totalSize > config.MaxUploadSize {
+				return fail(status.Error(codes.ResourceExhausted, "Total file size too large"))
+			}
+			if _, err := current.Write(p.Chunk); err != nil {
+				log.Printf("Error writing spool file: %v", err)
+				return fail(status.Error(codes.Internal, "Error storing uploaded data"))
+			}
+		}
+	}
+
+	if current != nil {
+		current.Close()
+	}
+	if len(entries) == 0 {
+		cleanup()
+		return status.Error(codes.InvalidArgument, "No files received")
+	}
+
+	// Streams are charged to the client's address, like anonymous uploads
+	clientIP := grpcClientIP(stream.Context())
+	releaseQuota, err := admitPrincipal(principal("", clientIP), totalSize)
+	if err != nil {
+		cleanup()
+		log.Printf("gRPC upload from %s rejected by quota: %v", clientIP, err)
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	opts := archiveOptions{Dedup: config.DedupUploads, Priority: priorityInteractive, ClientIP: clientIP}
+	id := createJob(len(entries), opts)
+	log.Printf("gRPC job %s: compressing %d files", id, len(entries))
+	go runArchiveJob(id, entries, opts, func() {
+		cleanup()
+		releaseQuota()
+	})
+
+	return stream.SendAndClose(&bulkdownloadv1.CompressResponse{JobId: id})
+}
+
+// grpcClientIP returns the address of the peer calling over ctx
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// WatchJob streams the job's progress each time it changes until it finishes
+func (s *grpcServer) WatchJob(req *bulkdownloadv1.WatchJobRequest, stream bulkdownloadv1.BulkDownloadService_WatchJobServer) error {
+	for {
+		j, changed, ok := getJob(req.GetJobId())
+		if !ok {
+			return status.Error(codes.NotFound, "job not found")
+		}
+		if err := stream.Send(jobProgressProto(j)); err != nil {
+			return err
+		}
+		if j.finished() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// jobProgressProto converts a job snapshot to its protobuf form
+func jobProgressProto(j job) *bulkdownloadv1.JobProgress {
+	p := &bulkdownloadv1.JobProgress{
+		JobId:       j.ID,
+		FilesTotal:  int32(j.FilesTotal),
+		FilesDone:   int32(j.FilesDone),
+		CurrentFile: j.CurrentFile,
+		Error:       j.Error,
+	}
+	switch j.State {
+	case jobQueued:
+		p.State = bulkdownloadv1.JobState_JOB_STATE_QUEUED
+	case jobRunning:
+		p.State = bulkdownloadv1.JobState_JOB_STATE_RUNNING
+	case jobDone:
+		p.State = bulkdownloadv1.JobState_JOB_STATE_DONE
+		p.DownloadPath = downloadPath(j.Archive)
+	case jobFailed:
+		p.State = bulkdownloadv1.JobState_JOB_STATE_FAILED
+	}
+	return p
+}
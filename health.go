@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// errDiskFreeUnsupported is returned by diskFree on platforms without a free-space probe
+var errDiskFreeUnsupported = errors.New("free disk space check not supported on this platform")
+
+// healthCheck is a named probe reported by the health endpoints
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// livenessChecks run on /healthz; keep these cheap and local
+var livenessChecks = []healthCheck{
+	{"tempDir", checkTempDirWritable},
+}
+
+// readinessChecks run on /readyz; storage backends append their own probes here
+var readinessChecks = []healthCheck{
+	{"tempDir", checkTempDirWritable},
+	{"diskSpace", checkDiskSpace},
+}
+
+// healthResponse is the JSON body returned by the health endpoints
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// handleHealthz reports whether the process is alive and able to do work
+func handleHealthz(c echo.Context) error {
+	return runHealthChecks(c, livenessChecks)
+}
+
+// handleReadyz reports whether the service should receive traffic
+func handleReadyz(c echo.Context) error {
+	return runHealthChecks(c, readinessChecks)
+}
+
+// runHealthChecks executes checks and responds 200 if all pass, 503 otherwise
+func runHealthChecks(c echo.Context, checks []healthCheck) error {
+	resp := healthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for _, hc := range checks {
+		if err := hc.check(); err != nil {
+			resp.Status = "fail"
+			resp.Checks[hc.name] = err.Error()
+			continue
+		}
+		resp.Checks[hc.name] = "ok"
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, resp)
+}
+
+// checkTempDirWritable creates and removes a small file in every temp directory
+func checkTempDirWritable() error {
+	for _, dir := range tempDirs() {
+		if err := checkDirWritable(dir); err != nil {
+			return fmt.Errorf("temp dir %s not writable: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, "healthcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, werr := f.Write([]byte("ok"))
+	cerr := f.Close()
+	os.Remove(name)
+	if werr != nil {
+		return werr
+	}
+	return cerr
+}
+
+// checkDiskSpace verifies some temp volume has at least MinFreeDisk available
+func checkDiskSpace() error {
+	free, err := mostFreeDisk()
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read free space: %w", err)
+	}
+	if free < config.MinFreeDisk {
+		return fmt.Errorf("only %s free, need %s", bytes.Format(free), bytes.Format(config.MinFreeDisk))
+	}
+	return nil
+}
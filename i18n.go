@@ -0,0 +1,90 @@
+package main
+
+//go:generate go run ./cmd/extract-messages -o locales/messages.json
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// localeContextKey is where negotiateLocale stores the request's locale
+const localeContextKey = "locale"
+
+// Message catalogs map the English source text of a UI message to its
+// translation, one catalog per locale file in BULK_LOCALE_DIR
+var (
+	catalogs      = map[language.Tag]map[string]string{}
+	locales       = []language.Tag{language.English}
+	localeMatcher = language.NewMatcher(locales)
+)
+
+// loadCatalogs reads every <locale>.json in BULK_LOCALE_DIR, such as de.json
+// or pt-BR.json. English is the source language and needs no catalog.
+func loadCatalogs() error {
+	files, err := filepath.Glob(filepath.Join(config.LocaleDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		tag, err := language.Parse(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			// Not a locale, e.g. the extracted messages.json template
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for msg, translated := range messages {
+			if translated == "" {
+				delete(messages, msg)
+			}
+		}
+		if _, seen := catalogs[tag]; !seen {
+			locales = append(locales, tag)
+		}
+		catalogs[tag] = messages
+		log.Printf("Loaded %d %s translations", len(messages), tag)
+	}
+	localeMatcher = language.NewMatcher(locales)
+	return nil
+}
+
+// negotiateLocale is middleware that picks the best available locale for the
+// request's Accept-Language header
+func negotiateLocale(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tags, _, _ := language.ParseAcceptLanguage(c.Request().Header.Get("Accept-Language"))
+		_, i, _ := localeMatcher.Match(tags...)
+		c.Set(localeContextKey, locales[i])
+		c.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+		c.Response().Header().Set("Content-Language", locales[i].String())
+		return next(c)
+	}
+}
+
+// translate returns msg in the request's locale, or unchanged when the
+// catalog has no entry for it
+func translate(c echo.Context, msg string) string {
+	tag, _ := c.Get(localeContextKey).(language.Tag)
+	if translated, ok := catalogs[tag][msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// tr formats a translated message
+func tr(c echo.Context, format string, args ...interface{}) string {
+	return fmt.Sprintf(translate(c, format), args...)
+}
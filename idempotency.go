@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"sort"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// headerIdempotencyKey names the header clients set to make a retried
+// request return the original job
+const headerIdempotencyKey = "Idempotency-Key"
+
+// maxIdempotencyKey bounds the accepted key length
+const maxIdempotencyKey = 255
+
+var (
+	errIdempotencyMismatch = errors.New("Idempotency-Key was already used for a different request")
+	errIdempotencyInFlight = errors.New("a request with this Idempotency-Key is still being processed")
+)
+
+// idempotentRequest remembers the job started for one key
+type idempotentRequest struct {
+	fingerprint string
+	jobID       string // empty while the first request is still being accepted
+}
+
+var (
+	idempotencyKeys  = make(map[string]*idempotentRequest)
+	idempotencyMutex = &sync.Mutex{}
+)
+
+// idempotencyScope keeps keys from different users, or for anonymous
+// callers different addresses, apart
+func idempotencyScope(c echo.Context, key string) string {
+	if user := currentUser(c); user != "" {
+		return "user:" + user + "\x00" + key
+	}
+	return "ip:" + c.RealIP() + "\x00" + key
+}
+
+// requestFingerprint hashes the form values and the name and size of every
+// file, so a reused key can be told apart from a genuine retry
+func requestFingerprint(form *multipart.Form) string {
+	h := sha256.New()
+	keys := make([]string, 0, len(form.Value))
+	for k := range form.Value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "v%q=%q\n", k, form.Value[k])
+	}
+	fields := make([]string, 0, len(form.File))
+	for k := range form.File {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for _, k := range fields {
+		for _, fh := range form.File[k] {
+			fmt.Fprintf(h, "f%q=%q:%d\n", k, fh.Filename, fh.Size)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// claimIdempotencyKey returns the job already started for scope, or claims
+// the key for a new one. commit must then be called with the new job's ID,
+// or with "" to give the key up when no job was started. A key is held for
+// as long as its job is queryable.
+func claimIdempotencyKey(scope, fingerprint string) (jobID string, commit func(jobID string), err error) {
+	idempotencyMutex.Lock()
+	defer idempotencyMutex.Unlock()
+
+	if req, ok := idempotencyKeys[scope]; ok {
+		switch {
+		case req.jobID == "" && req.fingerprint == fingerprint:
+			return "", nil, errIdempotencyInFlight
+		case req.jobID == "":
+			return "", nil, errIdempotencyMismatch
+		}
+		if _, _, known := getJob(req.jobID); known {
+			if req.fingerprint != fingerprint {
+				return "", nil, errIdempotencyMismatch
+			}
+			return req.jobID, nil, nil
+		}
+	}
+	pruneIdempotencyKeysLocked()
+
+	req := &idempotentRequest{fingerprint: fingerprint}
+	idempotencyKeys[scope] = req
+	return "", func(jobID string) {
+		idempotencyMutex.Lock()
+		defer idempotencyMutex.Unlock()
+		if jobID == "" {
+			delete(idempotencyKeys, scope)
+			return
+		}
+		req.jobID = jobID
+	}, nil
+}
+
+// pruneIdempotencyKeysLocked forgets keys whose job is no longer known;
+// idempotencyMutex must be held
+func pruneIdempotencyKeysLocked() {
+	for scope, req := range idempotencyKeys {
+		if req.jobID == "" {
+			continue
+		}
+		if _, _, known := getJob(req.jobID); !known {
+			delete(idempotencyKeys, scope)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package safeextract checks the members of untrusted archives before they
+// are read or written to disk: names that escape the extraction root, links
+// and special files, and archives that decompress to far more than they
+// occupy.
+package safeextract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnsafePath is returned for names that would escape the archive root
+	ErrUnsafePath = errors.New("unsafe path in archive")
+	// ErrLink is returned for symbolic and hard links
+	ErrLink = errors.New("link in archive")
+	// ErrSpecial is returned for devices, pipes, sockets and other non-regular files
+	ErrSpecial = errors.New("special file in archive")
+	// ErrTooManyEntries is returned once an archive has more entries than allowed
+	ErrTooManyEntries = errors.New("too many entries in archive")
+	// ErrTooLarge is returned once the decompressed contents exceed the limit
+	ErrTooLarge = errors.New("archive contents too large")
+	// ErrRatio is returned for data that decompresses beyond the allowed ratio
+	ErrRatio = errors.New("suspicious compression ratio")
+)
+
+// MinRatioSize is how many decompressed bytes an entry or archive may
+// produce before its compression ratio is checked, so small, highly
+// compressible files are not rejected.
+const MinRatioSize = 1 << 20
+
+// Clean normalizes a member name to a slash-separated relative path and
+// rejects absolute paths, drive letters and parent-directory traversal.
+// Backslashes are treated as separators.
+func Clean(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') || strings.ContainsRune(name, 0) {
+		return "", ErrUnsafePath
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrUnsafePath
+	}
+	return cleaned, nil
+}
+
+// Join cleans name and joins it to root, guaranteeing the result stays
+// inside root.
+func Join(root, name string) (string, error) {
+	cleaned, err := Clean(name)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(root, filepath.FromSlash(cleaned))
+	rel, err := filepath.Rel(root, dst)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+	return dst, nil
+}
+
+// CheckMode accepts regular files and rejects links and special files.
+// Directories are neither; callers skip them before calling CheckMode.
+func CheckMode(mode fs.FileMode) error {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return ErrLink
+	case mode.IsDir():
+		return fmt.Errorf("%w: directory", ErrSpecial)
+	case !mode.IsRegular():
+		return ErrSpecial
+	}
+	return nil
+}
+
+// Limits bounds what one archive may expand to. Zero values disable a limit.
+type Limits struct {
+	// Most entries an archive may contain
+	MaxEntries int
+	// Most bytes the archive may decompress to in total
+	MaxTotal int64
+	// Highest decompressed-to-compressed ratio, per entry and for the archive
+	MaxRatio float64
+}
+
+// Guard tracks the entries of one archive against its Limits. Declared sizes
+// are checked by Add before anything is read, and Reader counts the bytes
+// actually produced, since headers can lie. A Guard is safe for concurrent
+// use.
+type Guard struct {
+	limits Limits
+	size   int64
+
+	mu       sync.Mutex
+	entries  int
+	declared int64
+	read     int64
+}
+
+// NewGuard returns a Guard for an archive of size compressed bytes, or 0
+// when the size is not known.
+func NewGuard(limits Limits, size int64) *Guard {
+	return &Guard{limits: limits, size: size}
+}
+
+// Add records one entry with its declared compressed and uncompressed sizes,
+// checking the entry's ratio and the declared total against the archive's
+// size. A compressed size of 0 skips the per-entry ratio check.
+func (g *Guard) Add(compressed, uncompressed int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.entries++
+	if g.limits.MaxEntries > 0 && g.entries > g.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d", ErrTooManyEntries, g.limits.MaxEntries)
+	}
+	if uncompressed < 0 {
+		return ErrTooLarge
+	}
+	g.declared += uncompressed
+	if err := g.checkTotal(g.declared); err != nil {
+		return err
+	}
+	if err := g.checkRatio(compressed, uncompressed); err != nil {
+		return err
+	}
+	return g.checkRatio(g.size, g.declared)
+}
+
+// Reader wraps r so that reading fails once the bytes produced by all of the
+// archive's readers exceed MaxTotal or the archive's ratio.
+func (g *Guard) Reader(r io.Reader) io.Reader {
+	return &guardedReader{g: g, r: r}
+}
+
+// Declared returns the total uncompressed size of the entries added so far
+func (g *Guard) Declared() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.declared
+}
+
+// Read returns the decompressed bytes produced so far through Reader
+func (g *Guard) Read() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.read
+}
+
+func (g *Guard) checkTotal(total int64) error {
+	if g.limits.MaxTotal > 0 && total > g.limits.MaxTotal {
+		return fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, g.limits.MaxTotal)
+	}
+	return nil
+}
+
+func (g *Guard) checkRatio(compressed, uncompressed int64) error {
+	if g.limits.MaxRatio <= 0 || compressed <= 0 || uncompressed <= MinRatioSize {
+		return nil
+	}
+	if float64(uncompressed)/float64(compressed) > g.limits.MaxRatio {
+		return fmt.Errorf("%w: over %g:1", ErrRatio, g.limits.MaxRatio)
+	}
+	return nil
+}
+
+// count adds n bytes read by a guarded reader
+func (g *Guard) count(n int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.read += int64(n)
+	if err := g.checkTotal(g.read); err != nil {
+		return err
+	}
+	return g.checkRatio(g.size, g.read)
+}
+
+type guardedReader struct {
+	g   *Guard
+	r   io.Reader
+	err error
+}
+
+func (r *guardedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	if cerr := r.g.count(n); cerr != nil {
+		r.err = cerr
+		return n, cerr
+	}
+	return n, err
+}
@@ -0,0 +1,217 @@
+package safeextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"a.txt", "a.txt", nil},
+		{"dir/sub/a.txt", "dir/sub/a.txt", nil},
+		{"./dir//a.txt", "dir/a.txt", nil},
+		{"dir/../a.txt", "a.txt", nil},
+		{`dir\a.txt`, "dir/a.txt", nil},
+		{"../a.txt", "", ErrUnsafePath},
+		{"dir/../../a.txt", "", ErrUnsafePath},
+		{`..\..\windows\system32`, "", ErrUnsafePath},
+		{"/etc/passwd", "", ErrUnsafePath},
+		{`\\server\share\a.txt`, "", ErrUnsafePath},
+		{"C:/Windows/a.txt", "", ErrUnsafePath},
+		{`c:\a.txt`, "", ErrUnsafePath},
+		{"..", "", ErrUnsafePath},
+		{".", "", ErrUnsafePath},
+		{"", "", ErrUnsafePath},
+		{"a\x00.txt", "", ErrUnsafePath},
+	}
+	for _, tt := range tests {
+		got, err := Clean(tt.name)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Clean(%q) = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	root := t.TempDir()
+	got, err := Join(root, "dir/a.txt")
+	if err != nil || got != filepath.Join(root, "dir", "a.txt") {
+		t.Errorf("Join(dir/a.txt) = %q, %v", got, err)
+	}
+	for _, name := range []string{"../escape", "a/../../escape", "/abs", `..\escape`} {
+		if got, err := Join(root, name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Join(%q) = %q, %v; want ErrUnsafePath", name, got, err)
+		}
+	}
+}
+
+func TestCheckMode(t *testing.T) {
+	tests := []struct {
+		mode fs.FileMode
+		err  error
+	}{
+		{0o644, nil},
+		{0o755, nil},
+		{fs.ModeSymlink | 0o777, ErrLink},
+		{fs.ModeDevice, ErrSpecial},
+		{fs.ModeNamedPipe, ErrSpecial},
+		{fs.ModeSocket, ErrSpecial},
+		{fs.ModeDir | 0o755, ErrSpecial},
+	}
+	for _, tt := range tests {
+		if err := CheckMode(tt.mode); !errors.Is(err, tt.err) {
+			t.Errorf("CheckMode(%v) = %v; want %v", tt.mode, err, tt.err)
+		}
+	}
+}
+
+// TestZipSlip runs the checks over a crafted archive the way callers do and
+// makes sure only the safe, regular member survives
+func TestZipSlip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"../../evil.sh", "/etc/cron.d/evil", `..\evil.bat`, "ok/file.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "payload")
+	}
+	link := &zip.FileHeader{Name: "ok/link"}
+	link.SetMode(fs.ModeSymlink | 0o777)
+	w, err := zw.CreateHeader(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "/etc/passwd")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	var kept []string
+	for _, f := range zr.File {
+		if CheckMode(f.Mode()) != nil {
+			continue
+		}
+		dst, err := Join(root, f.Name)
+		if err != nil {
+			continue
+		}
+		kept = append(kept, dst)
+	}
+	if len(kept) != 1 || kept[0] != filepath.Join(root, "ok", "file.txt") {
+		t.Errorf("kept %v; want only ok/file.txt", kept)
+	}
+}
+
+func TestGuardEntries(t *testing.T) {
+	g := NewGuard(Limits{MaxEntries: 2}, 0)
+	for i := 0; i < 2; i++ {
+		if err := g.Add(10, 10); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+	}
+	if err := g.Add(10, 10); !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("third entry = %v; want ErrTooManyEntries", err)
+	}
+}
+
+func TestGuardDeclaredTotal(t *testing.T) {
+	g := NewGuard(Limits{MaxTotal: 100}, 0)
+	if err := g.Add(60, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add(50, 50); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Add over total = %v; want ErrTooLarge", err)
+	}
+	if err := NewGuard(Limits{}, 0).Add(0, -1); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("negative size = %v; want ErrTooLarge", err)
+	}
+}
+
+func TestGuardEntryRatio(t *testing.T) {
+	g := NewGuard(Limits{MaxRatio: 100}, 0)
+	// Small entries may compress arbitrarily well
+	if err := g.Add(1, MinRatioSize); err != nil {
+		t.Errorf("small entry: %v", err)
+	}
+	if err := g.Add(MinRatioSize/10, 2*MinRatioSize); err != nil {
+		t.Errorf("20:1 entry: %v", err)
+	}
+	if err := g.Add(1000, 10*MinRatioSize); !errors.Is(err, ErrRatio) {
+		t.Errorf("bomb entry = %v; want ErrRatio", err)
+	}
+	// Unknown compressed size skips the per-entry check
+	if err := g.Add(0, 10*MinRatioSize); err != nil {
+		t.Errorf("unknown compressed size: %v", err)
+	}
+
+	// Streamed formats only know the archive's size, so the declared total is
+	// checked against that
+	g = NewGuard(Limits{MaxRatio: 100}, MinRatioSize/10)
+	if err := g.Add(0, MinRatioSize); err != nil {
+		t.Errorf("10:1 archive: %v", err)
+	}
+	if err := g.Add(0, 20*MinRatioSize); !errors.Is(err, ErrRatio) {
+		t.Errorf("210:1 archive = %v; want ErrRatio", err)
+	}
+}
+
+func TestGuardReaderTotal(t *testing.T) {
+	g := NewGuard(Limits{MaxTotal: 1000}, 0)
+	n, err := io.Copy(io.Discard, g.Reader(strings.NewReader(strings.Repeat("x", 600))))
+	if err != nil || n != 600 {
+		t.Fatalf("first reader: %d, %v", n, err)
+	}
+	// Headers can lie, so the bytes actually read count across readers
+	_, err = io.Copy(io.Discard, g.Reader(strings.NewReader(strings.Repeat("x", 600))))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("second reader = %v; want ErrTooLarge", err)
+	}
+	if g.Read() <= 1000 {
+		t.Errorf("Read() = %d; want the overflowing count", g.Read())
+	}
+}
+
+func TestGuardReaderRatio(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zip.NewWriter(&compressed)
+	w, _ := zw.Create("zeros")
+	w.Write(make([]byte, 16*MinRatioSize))
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	g := NewGuard(Limits{MaxRatio: 100}, int64(compressed.Len()))
+	if _, err := io.Copy(io.Discard, g.Reader(src)); !errors.Is(err, ErrRatio) {
+		t.Errorf("zeros = %v; want ErrRatio", err)
+	}
+
+	// The same stream is fine without a ratio limit
+	g = NewGuard(Limits{}, int64(compressed.Len()))
+	if _, err := io.Copy(io.Discard, g.Reader(bytes.NewReader(make([]byte, 16*MinRatioSize)))); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+}
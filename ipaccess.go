@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ipList is a set of networks from a comma-separated list of CIDRs and
+// plain addresses
+type ipList []netip.Prefix
+
+// parseIPList reads the entries of the setting key, taking a plain address
+// as a network of one
+func parseIPList(key string, items []string) (ipList, error) {
+	var list ipList
+	for _, item := range items {
+		if p, err := netip.ParsePrefix(item); err == nil {
+			list = append(list, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q in %s", item, key)
+		}
+		addr = addr.Unmap()
+		list = append(list, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return list, nil
+}
+
+// contains reports whether addr lies in one of the networks
+func (l ipList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range l {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	uploadAllowIPs, uploadDenyIPs ipList
+	adminAllowIPs, adminDenyIPs   ipList
+)
+
+// setupIPAccess parses the upload and admin access lists
+func setupIPAccess() error {
+	lists := []struct {
+		key   string
+		items []string
+		list  *ipList
+	}{
+		{"BULK_UPLOAD_ALLOW_IPS", config.UploadAllowIPs, &uploadAllowIPs},
+		{"BULK_UPLOAD_DENY_IPS", config.UploadDenyIPs, &uploadDenyIPs},
+		{"BULK_ADMIN_ALLOW_IPS", config.AdminAllowIPs, &adminAllowIPs},
+		{"BULK_ADMIN_DENY_IPS", config.AdminDenyIPs, &adminDenyIPs},
+	}
+	for _, l := range lists {
+		list, err := parseIPList(l.key, l.items)
+		if err != nil {
+			return err
+		}
+		*l.list = list
+	}
+	return nil
+}
+
+// ipAllowed reports whether addr passes allow and deny. The deny list wins;
+// an empty allow list admits everyone it doesn't deny.
+func ipAllowed(addr netip.Addr, allow, deny ipList) bool {
+	if deny.contains(addr) {
+		return false
+	}
+	return len(allow) == 0 || allow.contains(addr)
+}
+
+// restrictIPs is middleware refusing clients outside allow or inside deny
+func restrictIPs(allow, deny ipList) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(allow) == 0 && len(deny) == 0 {
+				return next(c)
+			}
+			r := c.Request()
+			addr, err := netip.ParseAddr(c.RealIP())
+			if err == nil && ipAllowed(addr, allow, deny) {
+				return next(c)
+			}
+			log.Printf("Refused %s %s from %s: address not allowed", r.Method, r.URL.Path, c.RealIP())
+			if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+				return echo.NewHTTPError(http.StatusForbidden, "Requests from this address are not allowed")
+			}
+			return htmlError(c, http.StatusForbidden, "Error: Requests from this address are not allowed")
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobState is the lifecycle stage of a compression job
+type jobState string
+
+const (
+	jobQueued  jobState = "queued"
+	jobRunning jobState = "running"
+	jobDone    jobState = "done"
+	jobFailed  jobState = "failed"
+)
+
+// jobRetention is how long finished jobs stay queryable
+const jobRetention = time.Hour
+
+// job tracks an archive being built in the background
+type job struct {
+	ID          string      `json:"id"`
+	State       jobState    `json:"state"`
+	FilesTotal  int         `json:"filesTotal"`
+	FilesDone   int         `json:"filesDone"`
+	CurrentFile string      `json:"currentFile,omitempty"`
+	Archive     string      `json:"archive,omitempty"`
+	Quarantined int         `json:"quarantined,omitempty"`
+	Priority    jobPriority `json:"priority"`
+
+	// What became of each file once the job has finished
+	Files     []fileResult `json:"files,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+
+	// Who requested the job, kept for data purges
+	owner    string
+	clientIP string
+}
+
+// finished reports whether the job has reached a terminal state
+func (j job) finished() bool {
+	return j.State == jobDone || j.State == jobFailed
+}
+
+// jobSlot holds a job and a channel that is closed whenever it changes
+type jobSlot struct {
+	job     job
+	changed chan struct{}
+}
+
+var (
+	jobStore = make(map[string]*jobSlot)
+	jobMutex = &sync.Mutex{}
+)
+
+// newJobID returns a random identifier for a job
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// createJob registers a queued job for filesTotal files, owned by the
+// uploader in opts so it shows in their listing straight away, and returns its ID
+func createJob(filesTotal int, opts archiveOptions) string {
+	now := time.Now()
+	id := newJobID()
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	pruneJobsLocked(now)
+	jobStore[id] = &jobSlot{
+		job: job{ID: id, State: jobQueued, FilesTotal: filesTotal, Priority: opts.Priority, CreatedAt: now, UpdatedAt: now,
+			owner: opts.Owner, clientIP: opts.ClientIP},
+		changed: make(chan struct{}),
+	}
+	return id
+}
+
+// updateJob applies fn to the job and wakes anyone watching it
+func updateJob(id string, fn func(*job)) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	slot, ok := jobStore[id]
+	if !ok {
+		return
+	}
+	fn(&slot.job)
+	slot.job.UpdatedAt = time.Now()
+	close(slot.changed)
+	slot.changed = make(chan struct{})
+}
+
+// getJob returns a snapshot of the job and a channel closed on its next change
+func getJob(id string) (job, <-chan struct{}, bool) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	slot, ok := jobStore[id]
+	if !ok {
+		return job{}, nil, false
+	}
+	return slot.job, slot.changed, true
+}
+
+// listJobs returns snapshots of the jobs match selects, newest first
+func listJobs(match func(j job) bool) []job {
+	jobMutex.Lock()
+	var jobs []job
+	for _, slot := range jobStore {
+		if match(slot.job) {
+			jobs = append(jobs, slot.job)
+		}
+	}
+	jobMutex.Unlock()
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].CreatedAt.Equal(jobs[b].CreatedAt) {
+			return jobs[a].CreatedAt.After(jobs[b].CreatedAt)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+	return jobs
+}
+
+// jobCounts returns how many known jobs are in each state
+func jobCounts() map[jobState]int {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	counts := make(map[jobState]int)
+	for _, slot := range jobStore {
+		counts[slot.job.State]++
+	}
+	return counts
+}
+
+// purgeJobs forgets every job match selects and returns how many there were
+func purgeJobs(match func(j job) bool) int {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	n := 0
+	for id, slot := range jobStore {
+		if match(slot.job) {
+			close(slot.changed)
+			delete(jobStore, id)
+			n++
+		}
+	}
+	return n
+}
+
+// pruneJobsLocked forgets finished jobs past their retention; jobMutex must be held
+func pruneJobsLocked(now time.Time) {
+	for id, slot := range jobStore {
+		if slot.job.finished() && now.Sub(slot.job.UpdatedAt) > jobRetention {
+			delete(jobStore, id)
+		}
+	}
+}
+
+// runArchiveJob builds an archive for a registered job, recording progress as it goes.
+// cleanup, if set, runs once the entries are no longer needed.
+func runArchiveJob(id string, entries []archiveEntry, opts archiveOptions, cleanup func()) {
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	opts.JobID = id
+
+	// Stay queued until a worker is free for this job's priority class
+	releaseWorker, _ := compressWorkers.acquire(context.Background(), opts.Priority)
+	defer releaseWorker()
+	updateJob(id, func(j *job) { j.State = jobRunning })
+	publish(event{Kind: eventJobStarted, Text: fmt.Sprintf("Job %s started with %d files", id, len(entries))})
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
+	release, err := reserveDisk(totalSize)
+	if err != nil {
+		updateJob(id, func(j *job) { j.State = jobFailed; j.Error = err.Error() })
+		publish(event{Kind: eventJobFailed, Text: fmt.Sprintf("Job %s failed: %v", id, err)})
+		return
+	}
+	defer release()
+
+	result, err := createArchive(context.Background(), entries, opts, func(done int, current string) {
+		updateJob(id, func(j *job) { j.FilesDone = done; j.CurrentFile = current })
+	})
+	if err != nil {
+		updateJob(id, func(j *job) { j.State = jobFailed; j.Error = archiveErrorMessage(err); j.Files = result.Files })
+		publish(event{Kind: eventJobFailed, Text: fmt.Sprintf("Job %s failed: %v", id, err)})
+		return
+	}
+	updateJob(id, func(j *job) {
+		j.State = jobDone
+		j.Archive = result.Name
+		j.Quarantined = len(result.Quarantined)
+		j.Files = result.Files
+		j.CurrentFile = ""
+	})
+	link := downloadPath(result.Name)
+	if config.PublicURL != "" {
+		link = strings.TrimSuffix(config.PublicURL, "/") + link
+	}
+	publish(event{Kind: eventJobCompleted, Text: fmt.Sprintf("Job %s finished: %s with %d files, %s", id, result.Name, countAdded(result.Files), link)})
+}
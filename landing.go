@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxLandingFiles caps how many archive members the landing page lists
+const maxLandingFiles = 200
+
+// landingData fills the landing page shown before a download starts
+type landingData struct {
+	uiSettings
+
+	Name      string
+	Size      int64
+	ExpiresAt time.Time
+	Note      string
+
+	// Members of the archive; left empty for password-protected links so
+	// the contents stay private until the password is given
+	Files     []landingFile
+	MoreFiles int
+	Protected bool
+
+	// Where the confirm button posts to start the download
+	DownloadURL string
+
+	// Whether the link keeps working after a download
+	Reusable bool
+
+	// Where recipients can report the link as abusive
+	ReportURL string
+}
+
+// landingFile is one member listed on the landing page
+type landingFile struct {
+	Name string
+	Size int64
+}
+
+// landingPath returns the landing page path for an archive
+func landingPath(name string) string {
+	return "/d/" + shareKey(name)
+}
+
+// sharePath returns the link handed out for an archive: its landing page
+// with BULK_LANDING_PAGES, otherwise the direct download
+func sharePath(name string) string {
+	if config.LandingPages {
+		return landingPath(name)
+	}
+	return downloadPath(name)
+}
+
+// handleLanding shows an archive's contents, size, expiry and note with a
+// button that starts the download, without using up a single-use link
+func handleLanding(c echo.Context) error {
+	name, rec, ok := sharedArchive(c.Param("name"))
+	if !ok && rec.Disabled != "" {
+		return htmlError(c, http.StatusGone, "Error: This link has been disabled")
+	}
+	if ok && config.OwnerOnlyDownloads && rec.Owner != "" && rec.Owner != currentUser(c) {
+		ok = false
+	}
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Render(http.StatusOK, "landing.html", newLandingData(name, rec))
+}
+
+// newLandingData describes the archive registered under name
+func newLandingData(name string, rec archiveRecord) landingData {
+	data := landingData{
+		uiSettings:  ui,
+		Name:        name,
+		Size:        rec.Size,
+		ExpiresAt:   rec.ExpiresAt,
+		Note:        rec.Note,
+		Protected:   rec.PasswordHash != "",
+		DownloadURL: downloadPath(name),
+		Reusable:    reusableLinks(),
+		ReportURL:   "/report/" + shareKey(name),
+	}
+	if data.Protected || !strings.HasSuffix(name, ".zip") {
+		return data
+	}
+
+	files, err := archiveMembers(rec)
+	if err != nil {
+		log.Printf("Could not list %s for its landing page: %v", name, err)
+		return data
+	}
+	if len(files) > maxLandingFiles {
+		data.MoreFiles = len(files) - maxLandingFiles
+		files = files[:maxLandingFiles]
+	}
+	data.Files = files
+	return data
+}
+
+// archiveMembers reads the file list of a stored ZIP from its central directory
+func archiveMembers(rec archiveRecord) ([]landingFile, error) {
+	src, size, err := openArchiveFile(rec)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	ra, ok := src.(io.ReaderAt)
+	if !ok {
+		return nil, nil
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]landingFile, 0, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, landingFile{Name: f.Name, Size: int64(f.UncompressedSize64)})
+		}
+	}
+	return files, nil
+}
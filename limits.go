@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// checkFileSize rejects a file larger than BULK_MAX_FILE_SIZE
+func checkFileSize(file *multipart.FileHeader) error {
+	if config.MaxFileSize > 0 && file.Size > config.MaxFileSize {
+		return fmt.Errorf("File %s is too large (%s, max %s)",
+			file.Filename, bytes.Format(file.Size), bytes.Format(config.MaxFileSize))
+	}
+	return nil
+}
+
+// checkUploadLimits validates the file count, per-file size and total size of
+// an upload, naming the offending file or limit in the returned error
+func checkUploadLimits(files []*multipart.FileHeader) error {
+	if config.MaxFiles > 0 && len(files) > config.MaxFiles {
+		return fmt.Errorf("Too many files (%d selected, max %d)", len(files), config.MaxFiles)
+	}
+
+	var totalSize int64
+	for _, file := range files {
+		if err := checkFileSize(file); err != nil {
+			return err
+		}
+		totalSize += file.Size
+	}
+
+	if totalSize > config.MaxUploadSize {
+		return fmt.Errorf("Total file size too large (%s, max %s)",
+			bytes.Format(totalSize), bytes.Format(config.MaxUploadSize))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// linkInfoResponse describes a download link without consuming it
+type linkInfoResponse struct {
+	Name             string     `json:"name,omitempty"`
+	Size             int64      `json:"size,omitempty"`
+	ContentType      string     `json:"contentType"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	PasswordRequired bool       `json:"passwordRequired"`
+	Note             string     `json:"note,omitempty"`
+	DownloadURL      string     `json:"downloadUrl"`
+	// Per-connection download limit in bytes per second, zero when unthrottled
+	RateLimit int64 `json:"rateLimit,omitempty"`
+}
+
+// peekDownload resolves a download link for inspection, applying the same
+// visibility rules as the download itself
+func peekDownload(c echo.Context) (string, archiveRecord, bool) {
+	name, rec, ok := sharedArchive(c.Param("filename"))
+	if ok && config.OwnerOnlyDownloads && rec.Owner != "" && rec.Owner != currentUser(c) {
+		ok = false
+	}
+	return name, rec, ok
+}
+
+// linkUnlocked reports whether rec has no link password or the request
+// carries it in X-Download-Password
+func linkUnlocked(c echo.Context, rec archiveRecord) bool {
+	if rec.PasswordHash == "" {
+		return true
+	}
+	password := c.Request().Header.Get("X-Download-Password")
+	return password != "" && bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(password)) == nil
+}
+
+// handleDownloadHead reports the headers a download would send while leaving
+// the single-use link in place
+func handleDownloadHead(c echo.Context) error {
+	name, rec, ok := peekDownload(c)
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+	// Without the password the download would only send its prompt page
+	if !linkUnlocked(c, rec) {
+		h := c.Response().Header()
+		h.Set("Cache-Control", "no-store")
+		h.Set("Content-Type", echo.MIMETextHTMLCharsetUTF8)
+		return c.NoContent(http.StatusOK)
+	}
+	etag, err := archiveETag(name, rec)
+	if err != nil {
+		log.Printf("Error hashing %s: %v", name, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	setValidators(c, etag, rec)
+	if notModified(c.Request(), etag, rec.CreatedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	h := c.Response().Header()
+	h.Set("Content-Type", archiveContentType(name))
+	h.Set("Content-Disposition", contentDisposition(name))
+	h.Set("Content-Length", strconv.FormatInt(rec.Size, 10))
+	// Single-use links can't be fetched in pieces, see handleDownload
+	if reusableLinks() {
+		h.Set("Accept-Ranges", "bytes")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// handleDownloadInfo returns a link's size and metadata as JSON so clients can
+// show it before starting the transfer
+func handleDownloadInfo(c echo.Context) error {
+	name, rec, ok := peekDownload(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Archive not found or expired")
+	}
+	log.Printf("Link info requested for: %s", name)
+
+	resp := linkInfoResponse{
+		Name:             name,
+		Size:             rec.Size,
+		ContentType:      archiveContentType(name),
+		CreatedAt:        rec.CreatedAt,
+		PasswordRequired: rec.PasswordHash != "",
+		Note:             rec.Note,
+		DownloadURL:      downloadPath(name),
+		RateLimit:        config.DownloadRate,
+	}
+	if !rec.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &rec.ExpiresAt
+	}
+	// What's inside stays private until the password is sent
+	if !linkUnlocked(c, rec) {
+		resp.Name, resp.Size, resp.Note = "", 0, ""
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, resp)
+}
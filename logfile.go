@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logStampFormat is the rotation time in the names of rotated log files
+const logStampFormat = "20060102T150405"
+
+// rotatingLog is the service log file. It is renamed aside and started
+// afresh once it would grow past BULK_LOG_MAX_SIZE or a BULK_LOG_ROTATE
+// period ends, and old rotated files are pruned.
+type rotatingLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+
+	// Start of the rotation period the file was written in
+	period time.Time
+}
+
+// serviceLog receives the application and access logs when BULK_LOG_FILE is set
+var serviceLog *rotatingLog
+
+// setupLogFile sends the log to BULK_LOG_FILE instead of stderr
+func setupLogFile() error {
+	if config.LogFile == "" {
+		return nil
+	}
+	l := &rotatingLog{path: config.LogFile}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o750); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	serviceLog = l
+	log.SetOutput(l)
+	return nil
+}
+
+// closeLogFile goes back to logging to stderr and closes the log file
+func closeLogFile() {
+	if serviceLog == nil {
+		return
+	}
+	log.SetOutput(os.Stderr)
+	serviceLog.mu.Lock()
+	defer serviceLog.mu.Unlock()
+	serviceLog.file.Close()
+	serviceLog.file = nil
+}
+
+// open appends to the log file, taking its period from when it was last
+// written so a file left from yesterday rotates on the first line today
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size, l.period = f, info.Size(), logPeriod(info.ModTime())
+	return nil
+}
+
+// logPeriod returns the start of the BULK_LOG_ROTATE period holding t
+func logPeriod(t time.Time) time.Time {
+	if config.LogRotate <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(config.LogRotate)
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.Stderr.Write(p)
+	}
+	now := time.Now()
+	full := config.LogMaxSize > 0 && l.size > 0 && l.size+int64(len(p)) > config.LogMaxSize
+	if full || !logPeriod(now).Equal(l.period) {
+		// The log can't report its own failure, so it goes to stderr
+		if err := l.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rotating %s: %v\n", l.path, err)
+		}
+		if l.file == nil {
+			return os.Stderr.Write(p)
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file after now and starts a new one
+func (l *rotatingLog) rotate(now time.Time) error {
+	l.file.Close()
+	l.file = nil
+	err := os.Rename(l.path, l.rotatedName(now))
+	if oerr := l.open(); oerr != nil {
+		return oerr
+	}
+	// Without the rename the old file carries on until the next period
+	l.period = logPeriod(now)
+	if err != nil {
+		l.size = 0
+		return err
+	}
+	l.prune(now)
+	return nil
+}
+
+// rotatedName returns an unused name like access-20240501T000000.log
+func (l *rotatingLog) rotatedName(now time.Time) string {
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext) + "-" + now.Format(logStampFormat)
+	name := base + ext
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// prune removes rotated files beyond the newest BULK_LOG_KEEP and those
+// older than BULK_LOG_MAX_AGE
+func (l *rotatingLog) prune(now time.Time) {
+	ext := filepath.Ext(l.path)
+	prefix := strings.TrimSuffix(l.path, ext) + "-"
+	matches, _ := filepath.Glob(prefix + "*" + ext)
+
+	type rotated struct {
+		path    string
+		modTime time.Time
+	}
+	var files []rotated
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext)
+		if len(stamp) < len(logStampFormat) {
+			continue
+		}
+		if _, err := time.Parse(logStampFormat, stamp[:len(logStampFormat)]); err != nil {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotated{m, info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	for i, f := range files {
+		tooMany := config.LogKeep > 0 && i >= config.LogKeep
+		tooOld := config.LogMaxAge > 0 && now.Sub(f.modTime) > config.LogMaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(f.path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing old log %s: %v\n", f.path, err)
+			}
+		}
+	}
+}
@@ -1,253 +1,697 @@
 package main
 
 import (
-	"archive/zip"
-	"fmt"
-	"io"
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"sync"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-)
-
-// tempFileStore holds references to generated ZIP files
-var (
-	tempFileStore = make(map[string]string)
-	storeMutex    = &sync.Mutex{}
+	"github.com/labstack/gommon/bytes"
 )
 
 func main() {
-	// Initialize Echo instance
+	// Maintenance commands talk to a running server instead of starting one
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Exit(runPurgeCommand(os.Args[2:]))
+	}
+
+	// Log to a rotated file rather than stderr, if configured
+	if err := setupLogFile(); err != nil {
+		log.Fatalf("Error opening log file: %v", err)
+	}
+
+	// Saved archive keys are wrapped under BULK_SESSION_SECRET
+	if err := setupEncryptAtRest(); err != nil {
+		log.Fatalf("Error in encryption at rest settings: %v", err)
+	}
+
+	// Restore download links that were outstanding at the last shutdown
+	if err := loadStoreState(); err != nil {
+		log.Printf("Could not restore archive store: %v", err)
+	}
+	if err := loadAbuseState(); err != nil {
+		log.Printf("Could not restore abuse reports: %v", err)
+	}
+	if err := loadQuarantine(); err != nil {
+		log.Printf("Could not restore quarantine: %v", err)
+	}
+
+	// Load persisted download analytics
+	if err := openAnalytics(); err != nil {
+		log.Printf("Download analytics will not be persisted: %v", err)
+	}
+
+	// Append-only audit trail of archive creation, downloads and deletion
+	if err := openAuditLog(); err != nil {
+		log.Fatalf("Error opening audit log: %v", err)
+	}
+
+	// Key for signed deletion links
+	if err := loadLinkKey(); err != nil {
+		log.Printf("Deletion links will not survive a restart: %v", err)
+	}
+
+	// Load user accounts for login sessions
+	if err := loadUsers(); err != nil {
+		log.Fatalf("Error loading users: %v", err)
+	}
+	if err := loadAPIKeys(); err != nil {
+		log.Fatalf("Error loading API keys: %v", err)
+	}
+
+	// Discover the SSO identity provider, if configured
+	if err := setupOIDC(context.Background()); err != nil {
+		log.Fatalf("Error setting up OIDC: %v", err)
+	}
+
+	// Cloud storage providers users can pick files from
+	setupCloudConnectors()
+
+	// Remote destinations finished archives can be pushed to
+	if err := setupDelivery(); err != nil {
+		log.Fatalf("Error setting up delivery targets: %v", err)
+	}
+
+	// Form uploads spill into BULK_TEMP_DIR rather than the OS temp dir
+	if err := setupMultipartSpool(); err != nil {
+		log.Fatalf("Error setting up multipart spooling: %v", err)
+	}
+
+	// Extra volumes temporary files are spread over
+	if err := setupSpillDirs(); err != nil {
+		log.Fatalf("Error in spill directory settings: %v", err)
+	}
+
+	// External 7z binary for .7z recompression output
+	if err := setupSevenZip(); err != nil {
+		log.Fatalf("Error setting up 7z output: %v", err)
+	}
+
+	// S3 buckets fetch lists may read from
+	if err := setupS3Fetch(); err != nil {
+		log.Fatalf("Error setting up S3 fetches: %v", err)
+	}
+
+	if err := setupFileStages(); err != nil {
+		log.Fatalf("Error setting up file stages: %v", err)
+	}
+
+	// Chat webhooks told about jobs, quotas and disk space
+	if err := setupNotifiers(); err != nil {
+		log.Fatalf("Error setting up notifiers: %v", err)
+	}
+	if err := setupEvents(); err != nil {
+		log.Fatalf("Error in notification settings: %v", err)
+	}
+
+	// Who may upload and use the admin API, judged by the real client address
+	if err := setupTrustedProxies(); err != nil {
+		log.Fatalf("Error in trusted proxy settings: %v", err)
+	}
+	if err := setupIPAccess(); err != nil {
+		log.Fatalf("Error in IP access settings: %v", err)
+	}
+
+	// Directories admins may archive from the server
+	if err := setupServerDirs(); err != nil {
+		log.Fatalf("Error in server directory settings: %v", err)
+	}
+
+	// How download names are built
+	if err := setupNameTemplate(); err != nil {
+		log.Fatalf("Error in archive naming: %v", err)
+	}
+
+	// What a download does to its link
+	if err := setupRetention(); err != nil {
+		log.Fatalf("Error in post-download policy: %v", err)
+	}
+
+	// Deprecation and sunset dates of old API versions
+	if err := setupAPIVersions(); err != nil {
+		log.Fatalf("Error in API version settings: %v", err)
+	}
+
+	// Proxy or CDN that serves downloads in place of this process
+	if err := setupOffload(); err != nil {
+		log.Fatalf("Error in download offload settings: %v", err)
+	}
+
+	// Load the cache index for remote fetches
+	if err := openFetchCache(); err != nil {
+		log.Printf("Fetch cache disabled: %v", err)
+	}
+
+	// Shared egress limit for all downloads, and ingress limit for all fetches
+	globalDownloadLimiter = newByteLimiter(config.DownloadRateGlobal)
+	globalFetchLimiter = newByteLimiter(config.FetchRateGlobal)
+
+	// Translations of UI messages
+	if err := loadCatalogs(); err != nil {
+		log.Fatalf("Error loading message catalogs: %v", err)
+	}
+
+	// Pages and the HTML fragments returned to HTMX requests
+	if err := loadUISettings(); err != nil {
+		log.Fatalf("Error in UI settings: %v", err)
+	}
+
+	// CAPTCHA for anonymous uploads; after the UI settings it adds its widget to
+	if err := setupCaptcha(); err != nil {
+		log.Fatalf("Error in CAPTCHA settings: %v", err)
+	}
+	renderer, err := loadTemplates()
+	if err != nil {
+		log.Fatalf("Error loading templates: %v", err)
+	}
+
+	// Echo instance with middleware and routes
+	e := newServer(renderer)
+
+	// gRPC API for service-to-service integration
+	if config.GRPCAddr != "" {
+		go startGRPCServer()
+	}
+
+	// Recurring bundle jobs
+	if err := startScheduler(); err != nil {
+		log.Printf("Scheduler disabled: %v", err)
+	}
+
+	// Remove archives nobody downloaded before they expired
+	stopJanitor := make(chan struct{})
+	go runJanitor(time.Minute, stopJanitor)
+
+	// Drop-folder ingestion, stopped together with the janitor
+	if config.WatchDir != "" {
+		if err := startWatchFolder(stopJanitor); err != nil {
+			log.Printf("Watch folder disabled: %v", err)
+		}
+	}
+
+	// Start server
+	go func() {
+		if err := startServer(e); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then let in-flight requests finish and save the store
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error during shutdown: %v", err)
+	}
+	close(stopJanitor)
+	stopScheduler()
+	if err := saveStoreState(); err != nil {
+		log.Printf("Could not save archive store: %v", err)
+	}
+	closeLogFile()
+}
+
+// newServer sets up the Echo instance with its middleware and routes
+func newServer(renderer echo.Renderer) *echo.Echo {
 	e := echo.New()
+	e.Renderer = renderer
+	e.IPExtractor = extractClientIP
+	if serviceLog != nil {
+		e.Logger.SetOutput(serviceLog)
+	}
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(loadSession)
+	e.Use(loadAPIKey)
+	e.Use(refuseBanned)
+	e.Use(negotiateLocale)
+
+	// Set up larger request size limit, matching the configured upload size
+	e.Use(middleware.BodyLimit(bytes.Format(config.MaxUploadSize)))
+	e.Use(spoolMultipart)
+
+	// Cross-origin access to the JSON API for single-page apps
+	if len(config.CORSOrigins) > 0 {
+		e.Use(corsMiddleware())
+	}
 
-	// Set up larger request size limit (100MB)
-	e.Use(middleware.BodyLimit("100MB"))
+	// Browser hardening for the HTML form flows
+	if config.SecureHeaders {
+		e.Use(secureHeaders())
+	}
+	if config.CSRF {
+		e.Use(csrfMiddleware())
+	}
 
 	// Static files
 	e.Static("/static", "static")
 
-	// Routes
-	e.GET("/", serveIndex)
-	e.POST("/compress", handleFileUpload)
-	e.POST("/filename", handleFilename)
-	e.GET("/download/:filename", handleDownload)
+	// Routes, optionally gated behind a login
+	var gate []echo.MiddlewareFunc
+	if config.RequireLogin {
+		gate = append(gate, requireLogin)
+	}
+	upload := append(gate[:len(gate):len(gate)], restrictIPs(uploadAllowIPs, uploadDenyIPs), requireCaptcha)
+	e.GET("/", serveIndex, gate...)
+	e.GET("/config/ui", handleUIConfig)
+	e.POST("/compress", handleFileUpload, upload...)
+	e.POST("/filename", handleFilename, gate...)
+	e.GET("/download/:filename", handleDownload, gate...)
+	e.POST("/download/:filename", handleDownload, gate...)
+	e.HEAD("/download/:filename", handleDownloadHead, gate...)
+	e.GET("/download/:filename/info", handleDownloadInfo, gate...)
+	e.GET("/qr/:filename", handleQRCode, gate...)
+	e.GET("/d/:name", handleLanding, gate...)
+	e.GET("/delete/:filename", handleDeleteArchive)
+	e.POST("/delete/:filename", handleDeleteArchive)
+	e.DELETE("/delete/:filename", handleDeleteArchive)
+	e.GET("/restore/:filename", handleRestoreArchive)
+	e.POST("/restore/:filename", handleRestoreArchive)
+	e.GET("/report/:token", handleReport)
+	e.POST("/report/:token", handleReport)
+	e.POST("/paste", handlePaste, upload...)
+	e.POST("/recompress", handleRecompress, upload...)
+	e.GET("/jobs/:id/log", handleFetchLog, gate...)
+
+	// JSON API for asynchronous jobs, one route group per version
+	mountAPI(e, gate, upload)
+	e.GET("/api/openapi.json", handleOpenAPI)
+
+	// Cloud storage connectors
+	e.GET("/connect/options", handleCloudOptions, gate...)
+	e.GET("/connect/:provider", handleCloudConnect, gate...)
+	e.GET("/connect/:provider/callback", handleCloudCallback, gate...)
+	e.GET("/connect/:provider/files", handleCloudFiles, gate...)
+	e.GET("/deliver/options", handleDeliveryOptions, gate...)
+	e.GET("/expiry/options", handleExpiryOptions, gate...)
+	e.GET("/scheduled/:name", handleScheduledDownload, gate...)
+
+	// Accounts
+	e.GET("/login", serveLogin)
+	e.GET("/login/options", handleLoginOptions)
+	e.POST("/login", handleLogin)
+	e.POST("/logout", handleLogout)
+	e.GET("/my/archives", handleMyArchives)
+	e.GET("/auth/oidc/login", handleOIDCLogin)
+	e.GET("/auth/oidc/callback", handleOIDCCallback)
+
+	// Health probes
+	e.GET("/healthz", handleHealthz)
+	e.GET("/readyz", handleReadyz)
+
+	// Admin API, only enabled when an admin token or admin SSO groups are configured
+	if config.AdminToken != "" || len(config.OIDCAdminGroups) > 0 {
+		admin := e.Group("/admin", restrictIPs(adminAllowIPs, adminDenyIPs), requireAdmin)
+		admin.GET("/archives", handleAdminListArchives)
+		admin.DELETE("/archives", handleAdminPurgeArchives)
+		admin.DELETE("/archives/:id", handleAdminDeleteArchive)
+		admin.GET("/trash", handleAdminListTrash)
+		admin.POST("/trash/:id/restore", handleAdminRestoreArchive)
+		admin.POST("/archives/:id/enable", handleAdminEnableArchive)
+		admin.GET("/reports", handleAdminListReports)
+		admin.POST("/reports/:id/disable", handleAdminDisableReport)
+		admin.POST("/reports/:id/dismiss", handleAdminDismissReport)
+		admin.GET("/bans", handleAdminListBans)
+		admin.POST("/bans", handleAdminAddBan)
+		admin.DELETE("/bans/:kind/:value", handleAdminDeleteBan)
+		admin.GET("/reports/usage", handleUsageReport)
+		admin.GET("/audit", handleAdminAudit)
+		admin.POST("/purge", handleAdminPurge)
+		admin.GET("/quarantine", handleAdminListQuarantine)
+		admin.POST("/quarantine/:id/approve", handleAdminApproveQuarantine)
+		admin.POST("/quarantine/:id/reject", handleAdminRejectQuarantine)
+		admin.GET("/schedules", handleAdminListSchedules)
+		admin.PUT("/schedules/:name", handleAdminPutSchedule)
+		admin.DELETE("/schedules/:name", handleAdminDeleteSchedule)
+		admin.POST("/schedules/:name/run", handleAdminRunSchedule)
+		if len(config.ServerDirs) > 0 {
+			admin.POST("/dirs", handleAdminArchiveDir)
+		}
 
-	// Start server
-	e.Logger.Fatal(e.Start(":8080"))
+		// Profiling and runtime stats, opt-in since profiles expose internals
+		if config.DebugEndpoints {
+			registerDebugRoutes(e)
+		}
+	}
+	return e
 }
 
 // serveIndex renders our main HTML page
 func serveIndex(c echo.Context) error {
-	return c.File("templates/index.html")
+	return renderPage(c, "index.html")
 }
 
 // handleFilename returns the names of the selected files
 func handleFilename(c echo.Context) error {
 	// Get the form with multiple files
+	var list fileListData
 	form, err := c.MultipartForm()
 	if err != nil {
 		log.Printf("Error getting multipart form: %v", err)
-		return c.HTML(http.StatusOK, "No files selected")
+		return c.Render(http.StatusOK, "file_list", list)
 	}
 
-	files, ok := form.File["files"]
-	if !ok || len(files) == 0 {
-		return c.HTML(http.StatusOK, "No files selected")
-	}
-
-	// Create an HTML list of selected files
-	var fileListHTML string
-	if len(files) == 1 {
-		fileListHTML = files[0].Filename
-	} else {
-		fileListHTML = fmt.Sprintf("<strong>%d files selected:</strong><ul class='file-list'>", len(files))
-		for i, file := range files {
-			// Limit display to 5 files to avoid long lists
-			if i >= 5 && len(files) > 6 {
-				fileListHTML += fmt.Sprintf("<li>...and %d more</li>", len(files)-5)
-				break
-			}
-			fileListHTML += fmt.Sprintf("<li>%s</li>", file.Filename)
+	files := form.File["files"]
+	list.Total = len(files)
+	for i, file := range files {
+		// Limit display to 5 files to avoid long lists
+		if i >= 5 && len(files) > 6 {
+			list.More = len(files) - 5
+			break
 		}
-		fileListHTML += "</ul>"
+		list.Names = append(list.Names, sanitizeEntryName(file.Filename))
 	}
+	return c.Render(http.StatusOK, "file_list", list)
+}
 
-	return c.HTML(http.StatusOK, fileListHTML)
+// fileListData fills the selected files fragment
+type fileListData struct {
+	Total int
+	Names []string
+	More  int
 }
 
 // handleFileUpload processes multiple uploaded files and returns a ZIP
 func handleFileUpload(c echo.Context) error {
+	// Reject early when the declared body size alone can't fit on disk
+	if n := c.Request().ContentLength; n > 0 {
+		if err := checkDiskAdmission(n); err != nil {
+			log.Printf("Upload rejected before reading body: %v", err)
+			return htmlError(c, http.StatusInsufficientStorage, "Error: %s", err)
+		}
+	}
+
+	// The uploader's quotas cover the whole build, grouped archives included
+	releaseQuota, err := admitQuota(c, c.Request().ContentLength)
+	if err != nil {
+		log.Printf("Upload rejected by quota: %v", err)
+		return htmlError(c, quotaErrorStatus(err), "Error: %s", err)
+	}
+	defer releaseQuota()
+
 	// Get the form with multiple files
 	form, err := c.MultipartForm()
 	if err != nil {
 		log.Printf("Error getting multipart form: %v", err)
-		return c.HTML(http.StatusBadRequest, "<div class='error'>Error: Could not process form data</div>")
-	}
-
-	files, ok := form.File["files"]
-	if !ok || len(files) == 0 {
-		return c.HTML(http.StatusBadRequest, "<div class='error'>Error: No files selected</div>")
+		return htmlError(c, http.StatusBadRequest, "Error: Could not process form data")
 	}
 
-	log.Printf("Processing %d files", len(files))
+	files := form.File["files"]
 
-	// Check total size of all files (limit to 100MB total)
-	var totalSize int64
-	for _, file := range files {
-		totalSize += file.Size
+	// Optional JSON mapping of upload names to folders inside the archive
+	paths, err := parsePathMap(c.FormValue("paths"))
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
 	}
 
-	if totalSize > 100*1024*1024 {
-		return c.HTML(http.StatusBadRequest, "<div class='error'>Error: Total file size too large (max 100MB)</div>")
+	// Include and exclude globs over archive paths, for folder uploads and crawls
+	filter, err := parsePathFilter(form.Value["filter"])
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+	if kept := filterUploads(files, paths, filter); len(kept) < len(files) {
+		log.Printf("Filter left out %d of %d uploaded files", len(files)-len(kept), len(files))
+		files = kept
 	}
 
-	// Create a temporary file to store the ZIP
-	tempFile, err := os.CreateTemp("", "archive-*.zip")
+	// Remote files to fetch server-side, one URL per line
+	urls, err := parseURLList(form.Value["urls"])
 	if err != nil {
-		log.Printf("Error creating temp file: %v", err)
-		return c.HTML(http.StatusInternalServerError, "<div class='error'>Error creating temporary file</div>")
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
 	}
-	defer tempFile.Close()
 
-	// Create a new ZIP archive
-	zipWriter := zip.NewWriter(tempFile)
-
-	// Add each file to the ZIP archive
-	for i, file := range files {
-		log.Printf("Processing file %d: %s", i+1, file.Filename)
-
-		// Open the current uploaded file
-		src, err := file.Open()
+	// A directory listing to crawl for more URLs; its files keep their folder layout
+	crawledPaths := make(map[string]string)
+	if crawl := strings.TrimSpace(c.FormValue("crawl")); crawl != "" {
+		crawled, err := crawlFromForm(c, crawl)
 		if err != nil {
-			log.Printf("Error opening file %s: %v", file.Filename, err)
-			zipWriter.Close() // Close the zip writer before returning
-			return c.HTML(http.StatusInternalServerError,
-				fmt.Sprintf("<div class='error'>Error opening file: %s</div>", file.Filename))
+			return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+		}
+		for _, f := range crawled {
+			urls = append(urls, f.URL)
+			crawledPaths[f.URL] = f.Path
 		}
+	}
 
-		// Create a new file inside the ZIP archive
-		zipFile, err := zipWriter.Create(file.Filename)
+	// A web page or sitemap whose linked assets matching a pattern are fetched
+	if scrape := strings.TrimSpace(c.FormValue("scrape")); scrape != "" {
+		assets, err := scrapeFromForm(c, scrape)
 		if err != nil {
-			log.Printf("Error creating zip entry for %s: %v", file.Filename, err)
-			src.Close()
-			zipWriter.Close() // Close the zip writer before returning
-			return c.HTML(http.StatusInternalServerError,
-				fmt.Sprintf("<div class='error'>Error adding %s to ZIP</div>", file.Filename))
+			return htmlError(c, http.StatusBadRequest, "Error: %s", err)
 		}
+		urls = append(urls, assets...)
+	}
 
-		// Copy the uploaded file data to the ZIP file
-		if _, err := io.Copy(zipFile, src); err != nil {
-			log.Printf("Error copying data for %s: %v", file.Filename, err)
-			src.Close()
-			zipWriter.Close() // Close the zip writer before returning
-			return c.HTML(http.StatusInternalServerError,
-				fmt.Sprintf("<div class='error'>Error copying %s data</div>", file.Filename))
-		}
+	// Pasted text to include as files
+	snippets, err := snippetEntries(formSnippets(form.Value))
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+
+	// Files picked from connected cloud storage
+	cloudRefs := form.Value["cloud_files"]
 
-		src.Close() // Close the file after processing
+	if len(files) == 0 && len(urls) == 0 && len(snippets) == 0 && len(cloudRefs) == 0 {
+		return htmlError(c, http.StatusBadRequest, "Error: No files selected")
 	}
 
-	// Close the ZIP writer to finalize the archive
-	if err := zipWriter.Close(); err != nil {
-		log.Printf("Error closing zip writer: %v", err)
-		return c.HTML(http.StatusInternalServerError, "<div class='error'>Error finalizing ZIP archive</div>")
+	log.Printf("Processing %d files, %d URLs, %d cloud files and %d snippets",
+		len(files), len(urls), len(cloudRefs), len(snippets))
+
+	// Files that fail are left out and listed unless the batch is strict
+	failures := &batchFailures{strict: formBool(c, "strict", false)}
+
+	files, err = failures.dropOversized(files)
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
 	}
 
-	// Seek to the beginning of the temp file for later reading
-	_, err = tempFile.Seek(0, 0)
+	// Check file count, per-file size and total size against the configured limits
+	if err := checkUploadLimits(files); err != nil {
+		log.Printf("Upload rejected: %v", err)
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+	if n := len(files) + len(urls) + len(cloudRefs) + len(snippets); config.MaxFiles > 0 && n > config.MaxFiles {
+		return htmlError(c, http.StatusBadRequest, "Error: Too many files (%d selected, max %d)", n, config.MaxFiles)
+	}
+
+	// Multipart parts carry no timestamps, so browsers send them separately
+	mtimes, err := parseModTimes(c.FormValue("mtimes"))
 	if err != nil {
-		log.Printf("Error seeking temp file: %v", err)
-		return c.HTML(http.StatusInternalServerError, "<div class='error'>Error preparing download</div>")
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
 	}
 
-	// Generate a unique filename for the download
-	timestamp := time.Now().Format("20060102_150405")
-	var baseFilename string
-	if len(files) == 1 {
-		fileName := files[0].Filename
-		baseFilename = fileName[:len(fileName)-len(filepath.Ext(fileName))]
-	} else {
-		baseFilename = "archive"
+	uploaded := uploadSource{files: files, mtimes: mtimes}
+	pasted := entrySource{kind: "paste", entries: snippets}
+	if uploaded.Size()+pasted.Size() > config.MaxUploadSize {
+		return htmlError(c, http.StatusBadRequest, "Error: Total file size too large (max %s)", bytes.Format(config.MaxUploadSize))
 	}
 
-	zipFilename := fmt.Sprintf("%s_%s.zip", baseFilename, timestamp)
-	tempFilePath := tempFile.Name()
+	// Uploads, fetched, picked and pasted files, in archive order. Remote
+	// sources get what is left of the upload size budget.
+	sources := []Source{uploaded}
+	ctx := c.Request().Context()
+	if len(urls) > 0 {
+		policy, err := parseFetchPolicy(c)
+		if err != nil {
+			return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+		}
 
-	// Store the temp file path in map for retrieval
-	storeMutex.Lock()
-	tempFileStore[zipFilename] = tempFilePath
-	storeMutex.Unlock()
+		// Every attempt is logged for the user to inspect at /jobs/:id/log
+		flog := newFetchLog()
+		c.Set(fetchLogContextKey, flog.path())
+		c.Response().Header().Set(headerFetchLog, flog.path())
+		ctx = withFetchLog(ctx, flog)
+		sources = append(sources, urlSource{urls: urls, policy: policy, paths: crawledPaths})
+	}
+	if len(cloudRefs) > 0 {
+		sources = append(sources, cloudSource{c: c, refs: cloudRefs})
+	}
+	sources = append(sources, pasted)
 
-	log.Printf("ZIP created successfully: %s (path: %s)", zipFilename, tempFilePath)
+	entries, totalSize, cleanup, err := collectSources(ctx, sources, config.MaxUploadSize, failures.fail)
+	if err != nil {
+		var se *sourceError
+		if errors.As(err, &se) {
+			log.Printf("Reading %s files failed: %v", se.kind, err)
+		}
+		return htmlError(c, http.StatusBadGateway, "Error: %s", err)
+	}
+	defer cleanup()
 
-	// For HTMX, prepare download URL
-	downloadURL := fmt.Sprintf("/download/%s", zipFilename)
+	if len(entries) == 0 {
+		return htmlBatchError(c, http.StatusUnprocessableEntity, failures.results, "Error: None of the files could be added")
+	}
 
-	// Return success message with download link and file count
-	var successMessage string
-	if len(files) == 1 {
-		successMessage = "File successfully compressed!"
-	} else {
-		successMessage = fmt.Sprintf("%d files successfully compressed!", len(files))
+	// Reserve room for the archive so concurrent uploads can't overrun the disk budget
+	release, err := reserveDisk(totalSize)
+	if err != nil {
+		log.Printf("Upload rejected: %v", err)
+		return htmlError(c, http.StatusInsufficientStorage, "Error: %s", err)
 	}
+	defer release()
 
-	successHTML := fmt.Sprintf(`
-		<div class="success">
-			%s
-			<a href="%s" class="download-link" hx-boost="false">Download ZIP</a>
-		</div>
-	`, successMessage, downloadURL)
+	// Remote destinations to push the archive to
+	deliverTo, err := parseDeliveryTargets(form.Value["deliver"])
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
 
-	return c.HTML(http.StatusOK, successHTML)
-}
+	// Link lifetime chosen by the uploader within the configured bounds
+	ttl, err := parseExpiry(c.FormValue("expires"))
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
 
-// handleDownload serves the ZIP file for download
-func handleDownload(c echo.Context) error {
-	filename := c.Param("filename")
+	opts := archiveOptions{
+		Owner:    currentUser(c),
+		APIKey:   currentAPIKey(c),
+		ClientIP: c.RealIP(),
+		Password: c.FormValue("link_password"),
+		Dedup:    formBool(c, "dedup", config.DedupUploads),
+		Merge:    formBool(c, "merge", false),
+		Flatten:  formBool(c, "flatten", config.Flatten),
+		Paths:    paths,
+		Comment:  c.FormValue("comment"),
+		Metadata: formBool(c, "metadata", false),
+		TTL:      ttl,
+		Strict:   failures.strict,
+		Failed:   failures.results,
+	}
+	if len(opts.Comment) > maxArchiveComment {
+		return htmlError(c, http.StatusBadRequest, "Error: Archive comment too long")
+	}
+	if opts.Note, err = parseNote(c.FormValue("note")); err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
 
-	log.Printf("Download requested for: %s", filename)
+	// Grouping rules can turn one upload into several archives
+	rules, err := parseGroupRules(c.FormValue("group_by"), c.FormValue("group_max_files"))
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+	if groups := rules.split(entries, opts.Paths); len(groups) > 1 {
+		return createGroupedArchives(c, groups, opts, failures, deliverTo)
+	}
 
-	storeMutex.Lock()
-	tempPath, exists := tempFileStore[filename]
-	if !exists {
-		storeMutex.Unlock()
-		log.Printf("File not found in store: %s", filename)
-		return c.HTML(http.StatusNotFound, "<div class='error'>File not found or expired</div>")
+	releaseWorker, err := compressWorkers.acquire(c.Request().Context(), priorityInteractive)
+	if err != nil {
+		return err
 	}
+	result, err := createArchive(ctx, entries, opts, nil)
+	releaseWorker()
+	results := result.Files
+	if err != nil && !failures.strict && len(results) > 0 {
+		return htmlBatchError(c, archiveErrorStatus(err), results, "%s", translate(c, archiveErrorMessage(err)))
+	}
+	if err != nil {
+		return htmlError(c, archiveErrorStatus(err), "%s", translate(c, archiveErrorMessage(err)))
+	}
+	zipFilename := result.Name
 
-	// Remove from the store immediately to prevent duplicate downloads
-	delete(tempFileStore, filename)
-	storeMutex.Unlock()
+	// Return success message with download link and file count
+	successMessage := batchSummary(c, results, len(result.Duplicates), len(result.Quarantined))
+	if allAdded(results) {
+		results = nil
+	}
 
-	log.Printf("Serving file from: %s", tempPath)
+	if len(deliverTo) > 0 {
+		locations, err := deliverArchive(c.Request().Context(), zipFilename, deliverTo)
+		if err != nil {
+			log.Printf("Delivery of %s failed: %v", zipFilename, err)
+			return htmlError(c, http.StatusBadGateway, "Error: %s", err)
+		}
 
-	// Open the file for reading
-	file, err := os.Open(tempPath)
-	if err != nil {
-		log.Printf("Error opening file for download: %v", err)
-		return c.HTML(http.StatusInternalServerError, "<div class='error'>Error accessing file</div>")
+		// Without a local copy, the remote location replaces the download link
+		if !formBool(c, "keep_local", true) {
+			if rec, ok := takeArchive(zipFilename); ok {
+				removeArchiveFile(zipFilename, rec.Path)
+				auditDeletion(c, zipFilename, "delivered without a local copy")
+			}
+			return c.Render(http.StatusOK, "success", successData{Message: successMessage, Delivered: locations, Results: results})
+		}
+		data := downloadLinkData(c, successMessage, zipFilename, locations)
+		data.Results = results
+		return c.Render(http.StatusOK, "success", data)
 	}
 
-	// Schedule cleanup after download
-	defer func() {
-		file.Close()
-		os.Remove(tempPath)
-		log.Printf("Temp file removed: %s", tempPath)
-	}()
+	data := downloadLinkData(c, successMessage, zipFilename, nil)
+	data.Results = results
+	return c.Render(http.StatusOK, "success", data)
+}
 
-	// Set headers for file download
-	c.Response().Header().Set("Content-Type", "application/zip")
-	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+// batchSummary describes how many files were compressed, stored once,
+// quarantined or left out
+func batchSummary(c echo.Context, results []fileResult, duplicates, quarantined int) string {
+	var summary string
+	if n := countAdded(results); n == 1 {
+		summary = translate(c, "File successfully compressed!")
+	} else {
+		summary = tr(c, "%d files successfully compressed!", n)
+	}
+	if n := duplicates; n == 1 {
+		summary += " " + translate(c, "1 duplicate file was stored only once.")
+	} else if n > 1 {
+		summary += " " + tr(c, "%d duplicate files were stored only once.", n)
+	}
+	if n := quarantined; n == 1 {
+		summary += " " + translate(c, "1 file is held for review and will be added once approved.")
+	} else if n > 1 {
+		summary += " " + tr(c, "%d files are held for review and will be added once approved.", n)
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Status == fileFailed {
+			failed++
+		}
+	}
+	if n := failed; n == 1 {
+		summary += " " + translate(c, "1 file could not be added.")
+	} else if n > 1 {
+		summary += " " + tr(c, "%d files could not be added.", n)
+	}
+	return summary
+}
+
+// downloadLinkData fills the success fragment with the download link and
+// its QR code for the archive registered under name, plus the signed link
+// the creator can use to delete it
+func downloadLinkData(c echo.Context, message, name string, delivered []string) successData {
+	label := translate(c, "Download ZIP")
+	if !strings.HasSuffix(name, ".zip") {
+		label = translate(c, "Download archive")
+	}
+	deleteLink := deletePath(name)
+	return successData{
+		Message:     message,
+		Delivered:   delivered,
+		DownloadURL: sharePath(name),
+		Label:       label,
+		QRURL:       "/qr/" + shareKey(name),
+		DeletePath:  deleteLink,
+		DeleteURL:   absoluteURL(c, deleteLink),
+	}
+}
 
-	// Stream the file to the client
-	return c.Stream(http.StatusOK, "application/zip", file)
+// formBool reads a boolean form field, returning def when it is absent or invalid
+func formBool(c echo.Context, name string, def bool) bool {
+	v, err := strconv.ParseBool(c.FormValue(name))
+	if err != nil {
+		return def
+	}
+	return v
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+
+	gbytes "github.com/labstack/gommon/bytes"
+)
+
+// memoryFile serves an in-memory archive through the same interface as a file
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// entriesSize returns the combined uncompressed size of entries
+func entriesSize(entries []archiveEntry) int64 {
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	return total
+}
+
+// createMemoryArchive builds a small archive in a buffer and registers it
+// without touching the temp directory. If the memory budget is used up the
+// finished buffer is written to disk instead.
+func createMemoryArchive(name string, entries []archiveEntry, opts archiveOptions, progress progressFunc, failed failFunc) error {
+	var buf bytes.Buffer
+	if err := writeZip(&buf, entries, opts.Comment, progress, failed); err != nil {
+		return err
+	}
+	rec, err := newArchiveRecord(opts)
+	if err != nil {
+		return err
+	}
+	rec.Data = buf.Bytes()
+	rec.Size = int64(buf.Len())
+	if putMemoryArchive(name, rec) {
+		log.Printf("ZIP created in memory: %s (%s)", name, gbytes.Format(rec.Size))
+		return nil
+	}
+
+	// Memory budget is used up; keep this one on disk
+	path, key, err := spillArchive(rec.Data)
+	if err != nil {
+		return &archiveError{"Error creating temporary file", err}
+	}
+	rec.Path, rec.Key, rec.Data = path, key, nil
+	putArchive(name, rec)
+	log.Printf("ZIP created successfully: %s (path: %s)", name, path)
+	return nil
+}
+
+// putMemoryArchive registers an in-memory archive unless that would take the
+// archives held in memory past BULK_MEMORY_ARCHIVE_BUDGET
+func putMemoryArchive(name string, rec archiveRecord) bool {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var held int64
+	for _, r := range tempFileStore {
+		if r.Data != nil {
+			held += r.Size
+		}
+	}
+	if held+rec.Size > config.MemoryArchiveBudget {
+		return false
+	}
+	storeArchiveLocked(name, rec)
+	return true
+}
+
+// spillArchive writes an in-memory archive to a temp file, sealing it when
+// encryption at rest is on, and returns the path and key
+func spillArchive(data []byte) (string, string, error) {
+	f, err := os.CreateTemp(spillDir(), "archive-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	w, key, err := sealArchive(f)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", err
+	}
+	return f.Name(), key, nil
+}
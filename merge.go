@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+)
+
+// safeMemberName cleans a member name read from an uploaded archive and
+// rejects absolute paths and parent-directory traversal
+func safeMemberName(name string) (string, error) {
+	cleaned, err := safeextract.Clean(name)
+	if err != nil {
+		return "", err
+	}
+	return sanitizeEntryName(cleaned), nil
+}
+
+// checkedMember checks one member of an uploaded archive, returning false and
+// logging why when it is a link, special file or unsafe path
+func checkedMember(archive, name string, mode fs.FileMode) (string, bool) {
+	err := safeextract.CheckMode(mode)
+	cleaned := ""
+	if err == nil {
+		cleaned, err = safeMemberName(name)
+	}
+	if err != nil {
+		log.Printf("Skipping %q in %s: %v", name, archive, err)
+		return "", false
+	}
+	return cleaned, true
+}
+
+// inputLimits bounds how far one user-supplied archive may expand, given the
+// bytes the request may still use
+func inputLimits(budget int64) safeextract.Limits {
+	if config.InputMaxExpanded > 0 && config.InputMaxExpanded < budget {
+		budget = config.InputMaxExpanded
+	}
+	return safeextract.Limits{
+		MaxEntries: config.InputMaxEntries,
+		MaxTotal:   budget,
+		MaxRatio:   float64(config.InputMaxRatio),
+	}
+}
+
+// isLimitError reports whether err came from a safeextract.Guard
+func isLimitError(err error) bool {
+	return errors.Is(err, safeextract.ErrTooLarge) || errors.Is(err, safeextract.ErrRatio) ||
+		errors.Is(err, safeextract.ErrTooManyEntries)
+}
+
+// limitError describes an input archive that tripped its limits
+func limitError(err error) *archiveError {
+	switch {
+	case errors.Is(err, safeextract.ErrTooManyEntries):
+		return &archiveError{"Archive contains too many files", err}
+	case errors.Is(err, safeextract.ErrRatio):
+		return &archiveError{"Archive expands suspiciously far and looks like an archive bomb", err}
+	}
+	return &archiveError{"Archive contents too large", err}
+}
+
+// mergeArchives replaces every archive entry with the files it contains, so
+// the result is one flat archive rather than archives nested inside a zip. ZIP
+// members are streamed from the source archive when written; tar, rar and 7z
+// inputs are unpacked to scratch files first. The returned func closes the
+// sources and must be called once the entries have been written.
+func mergeArchives(entries []archiveEntry) ([]archiveEntry, func(), error) {
+	var opened []io.Closer
+	cleanup := func() {
+		for _, c := range opened {
+			c.Close()
+		}
+	}
+
+	uniqueName := uniqueNamer()
+
+	var merged []archiveEntry
+	var total int64
+	for _, entry := range entries {
+		kind := archiveKind(entry.Name)
+		if kind == "" {
+			entry.Name = uniqueName(entry.Name)
+			merged = append(merged, entry)
+			total += entry.Size
+			continue
+		}
+		if kind != "zip" {
+			unpacked, dir, err := unpackArchive(entry, kind, config.MaxUploadSize-total)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			opened = append(opened, dir)
+			for _, u := range unpacked {
+				u.Name = uniqueName(u.Name)
+				merged = append(merged, u)
+				total += u.Size
+			}
+			continue
+		}
+
+		src, err := entry.Open()
+		if err != nil {
+			cleanup()
+			return nil, nil, &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), err}
+		}
+		opened = append(opened, src)
+
+		ra, ok := src.(io.ReaderAt)
+		if !ok {
+			cleanup()
+			return nil, nil, &archiveError{fmt.Sprintf("Cannot read %s as an archive", entry.Name), errors.ErrUnsupported}
+		}
+		zr, err := zip.NewReader(ra, entry.Size)
+		if err != nil {
+			cleanup()
+			return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid ZIP archive", entry.Name), err}
+		}
+
+		guard := safeextract.NewGuard(inputLimits(config.MaxUploadSize-total), entry.Size)
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			name, ok := checkedMember(entry.Name, f.Name, f.Mode())
+			if !ok {
+				continue
+			}
+			if err := guard.Add(int64(f.CompressedSize64), int64(f.UncompressedSize64)); err != nil {
+				cleanup()
+				return nil, nil, limitError(err)
+			}
+			total += int64(f.UncompressedSize64)
+
+			f := f
+			merged = append(merged, archiveEntry{
+				Name: uniqueName(name),
+				Size: int64(f.UncompressedSize64),
+				Open: func() (io.ReadCloser, error) {
+					rc, err := f.Open()
+					if err != nil {
+						return nil, err
+					}
+					return guardedMember{guard.Reader(rc), rc}, nil
+				},
+
+				ModTime: f.Modified,
+				Mode:    f.Mode(),
+			})
+		}
+	}
+	return merged, cleanup, nil
+}
+
+// guardedMember reads a member through its archive's safeextract.Guard
+type guardedMember struct {
+	io.Reader
+	member io.Closer
+}
+
+func (m guardedMember) Close() error { return m.member.Close() }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+)
+
+// mergeFuzzNames are the input names FuzzMergeArchive picks from, one per format
+var mergeFuzzNames = []string{"input.zip", "input.tar", "input.tar.gz", "input.rar"}
+
+// bytesEntry is an archive entry reading data, seekable like an uploaded file
+func bytesEntry(name string, data []byte) archiveEntry {
+	return archiveEntry{
+		Name: name,
+		Size: int64(len(data)),
+		Open: func() (io.ReadCloser, error) { return readerAtCloser{bytes.NewReader(data)}, nil },
+	}
+}
+
+type readerAtCloser struct{ *bytes.Reader }
+
+func (readerAtCloser) Close() error { return nil }
+
+// seedZip builds a ZIP holding the given member names
+func seedZip(names ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, _ := zw.Create(name)
+		io.WriteString(w, "content of "+name)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+// seedTar builds a tarball holding the given member names, gzipped if asked
+func seedTar(gzipped bool, names ...string) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		body := "content of " + name
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		io.WriteString(tw, body)
+	}
+	tw.Close()
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+// FuzzMergeArchive feeds arbitrary bytes to the input archive readers and
+// checks that whatever they accept comes out with safe, distinct names
+// sevenZipListing is `7z l -slt` output for one archive holding blocks
+func sevenZipListing(blocks ...string) []byte {
+	out := "Listing archive: in.7z\n\n--\nPath = in.7z\nType = 7z\n\n----------\n"
+	for i, b := range blocks {
+		if i > 0 {
+			out += "\n\n"
+		}
+		out += b
+	}
+	return []byte(out + "\n")
+}
+
+func TestCheck7zListing(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  error
+	}{
+		{"plain file", "Path = docs/a.txt\nFolder = -\nSize = 5\nAttributes = A_ -rw-r--r--", nil},
+		{"folder", "Path = docs\nFolder = +\nSize = 0\nAttributes = D_ drwxr-xr-x", nil},
+		{"windows attributes", "Path = a.txt\nFolder = -\nSize = 5\nAttributes = A", nil},
+		{"parent traversal", "Path = ../../etc/cron.d/x\nFolder = -\nSize = 5\nAttributes = A_ -rw-r--r--", safeextract.ErrUnsafePath},
+		{"absolute", "Path = /etc/passwd\nFolder = -\nSize = 5\nAttributes = A", safeextract.ErrUnsafePath},
+		{"backslash traversal", `Path = ..\evil.txt` + "\nFolder = -\nSize = 5\nAttributes = A", safeextract.ErrUnsafePath},
+		{"traversing folder", "Path = ../out\nFolder = +\nSize = 0\nAttributes = D_ drwxr-xr-x", safeextract.ErrUnsafePath},
+		{"unix symlink", "Path = docs\nFolder = -\nSize = 4\nAttributes = A_ lrwxrwxrwx", safeextract.ErrLink},
+		{"reparse point", "Path = docs\nFolder = -\nSize = 0\nAttributes = AL", safeextract.ErrLink},
+		{"fifo", "Path = pipe\nFolder = -\nSize = 0\nAttributes = A_ prw-r--r--", safeextract.ErrSpecial},
+	}
+	for _, tt := range tests {
+		// A bad block rejects the listing wherever it sits
+		listing := sevenZipListing("Path = first.txt\nFolder = -\nSize = 1\nAttributes = A", tt.block)
+		err := check7zListing(listing, safeextract.NewGuard(safeextract.Limits{}, 1<<20))
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func FuzzMergeArchive(f *testing.F) {
+	names := []string{"a.txt", "dir/b.csv", "../escape", "/abs", `c:\win\x`, "nul\x00.txt", "CON", "a.txt"}
+	f.Add(seedZip(names...), uint8(0))
+	f.Add(seedTar(false, names...), uint8(1))
+	f.Add(seedTar(true, names...), uint8(2))
+	f.Add([]byte("Rar!\x1a\x07\x01\x00"), uint8(3))
+	f.Add([]byte{}, uint8(0))
+	f.Add([]byte("PK\x05\x06"), uint8(0))
+
+	defer func(n, m int64) { config.MaxUploadSize, config.InputMaxExpanded = n, m }(config.MaxUploadSize, config.InputMaxExpanded)
+	config.MaxUploadSize, config.InputMaxExpanded = 1<<20, 0
+
+	f.Fuzz(func(t *testing.T, data []byte, kind uint8) {
+		input := bytesEntry(mergeFuzzNames[int(kind)%len(mergeFuzzNames)], data)
+		merged, cleanup, err := mergeArchives([]archiveEntry{input})
+		if err != nil {
+			return
+		}
+		defer cleanup()
+
+		seen := make(map[string]bool)
+		for _, e := range merged {
+			if name, err := safeMemberName(e.Name); err != nil || name != e.Name {
+				t.Fatalf("member %q of %s reads back as %q, %v", e.Name, input.Name, name, err)
+			}
+			if seen[e.Name] {
+				t.Fatalf("member %q of %s appears twice", e.Name, input.Name)
+			}
+			seen[e.Name] = true
+
+			// Corrupt member data may fail to read, but never past the limits
+			rc, err := e.Open()
+			if err != nil {
+				continue
+			}
+			n, _ := io.Copy(io.Discard, rc)
+			rc.Close()
+			if n > config.MaxUploadSize {
+				t.Fatalf("member %q of %s expanded to %d bytes", e.Name, input.Name, n)
+			}
+		}
+	})
+}
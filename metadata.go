@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// metadataFileName is the entry describing a generated archive
+const metadataFileName = "metadata.json"
+
+// maxArchiveComment is the longest comment the ZIP format can hold
+const maxArchiveComment = 65535
+
+// archiveMetadata is the content of metadata.json
+type archiveMetadata struct {
+	JobID     string         `json:"jobId"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Creator   string         `json:"creator,omitempty"`
+	Comment   string         `json:"comment,omitempty"`
+	Note      string         `json:"note,omitempty"`
+	Files     []metadataFile `json:"files"`
+}
+
+// metadataFile lists one entry of the archive
+type metadataFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// metadataEntry builds a metadata.json entry describing entries
+func metadataEntry(entries []archiveEntry, opts archiveOptions) (archiveEntry, error) {
+	meta := archiveMetadata{
+		JobID:     opts.JobID,
+		CreatedAt: time.Now().UTC(),
+		Creator:   opts.Owner,
+		Comment:   opts.Comment,
+		Note:      opts.Note,
+		Files:     make([]metadataFile, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		meta.Files = append(meta.Files, metadataFile{Name: entry.Name, Size: entry.Size})
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	return archiveEntry{
+		Name: metadataFileName,
+		Size: int64(len(data)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+	}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// fetchMirrors downloads one file from the first of its mirror URLs that
+// works, trying them in order, or from whichever answers first when race is
+// set. The first URL is the primary; errors name it. A copy that doesn't
+// match the target's checksums counts as a failed mirror.
+func fetchMirrors(ctx context.Context, throttle *hostThrottle, t fetchTarget, maxSize int64, race bool) (fetchedFile, error) {
+	mirrors := t.mirrors
+	if race && len(mirrors) > 1 {
+		return raceMirrors(ctx, throttle, t, maxSize)
+	}
+	var errs []error
+	for _, rawURL := range mirrors {
+		f, err := fetchPolitely(ctx, throttle, t, rawURL, maxSize)
+		if err == nil {
+			if rawURL != mirrors[0] {
+				log.Printf("Fetched %s from mirror %s", redactURL(mirrors[0]), redactURL(rawURL))
+			}
+			return f, nil
+		}
+		if ctx.Err() != nil {
+			return fetchedFile{}, err
+		}
+		errs = append(errs, err)
+	}
+	return fetchedFile{}, mirrorError(errs)
+}
+
+// raceMirrors fetches from every mirror at once, keeps the first complete
+// copy and cancels the rest
+func raceMirrors(ctx context.Context, throttle *hostThrottle, t fetchTarget, maxSize int64) (fetchedFile, error) {
+	mirrors := t.mirrors
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		f   fetchedFile
+		err error
+	}
+	results := make(chan result, len(mirrors))
+	for _, rawURL := range mirrors {
+		go func() {
+			f, err := fetchPolitely(ctx, throttle, t, rawURL, maxSize)
+			results <- result{f, err}
+		}()
+	}
+
+	var winner *fetchedFile
+	var errs []error
+	for range mirrors {
+		r := <-results
+		switch {
+		case r.err == nil && winner == nil:
+			winner = &r.f
+			cancel()
+		case r.err == nil:
+			// A copy that finished in the same moment as the winner
+			if r.f.temp {
+				os.Remove(r.f.Path)
+			}
+		case winner == nil:
+			errs = append(errs, r.err)
+		}
+	}
+	if winner == nil {
+		return fetchedFile{}, mirrorError(errs)
+	}
+	if winner.URL != mirrors[0] {
+		log.Printf("Fetched %s from mirror %s", redactURL(mirrors[0]), redactURL(winner.URL))
+	}
+	return *winner, nil
+}
+
+// fetchPolitely fetches rawURL once the throttle allows a request to its host
+// and checks the copy against the target's checksums
+func fetchPolitely(ctx context.Context, throttle *hostThrottle, t fetchTarget, rawURL string, maxSize int64) (fetchedFile, error) {
+	release, err := throttle.acquire(ctx, rawURL)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	defer release()
+	ctx, logged := startFetchAttempt(ctx, rawURL)
+	f, err := fetchURL(ctx, rawURL, maxSize)
+	if err == nil {
+		if err = t.verifyFetched(f); err != nil {
+			log.Printf("Discarding %s: %v", redactURL(rawURL), err)
+		}
+	}
+	logged(f, err)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	return f, nil
+}
+
+// mirrorError reports the first mirror's failure, noting how many others
+// failed too
+func mirrorError(errs []error) error {
+	switch len(errs) {
+	case 1:
+		return errs[0]
+	case 2:
+		return fmt.Errorf("%w (its mirror failed too)", errs[0])
+	}
+	return fmt.Errorf("%w (its %d mirrors failed too)", errs[0], len(errs)-1)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseModTimes decodes a JSON object mapping upload names to modification
+// times, given either as Unix milliseconds (as in File.lastModified) or as
+// RFC 3339 strings
+func parseModTimes(raw string) (map[string]time.Time, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("Invalid mtimes: %v", err)
+	}
+
+	mtimes := make(map[string]time.Time, len(values))
+	for name, v := range values {
+		var ms int64
+		if err := json.Unmarshal(v, &ms); err == nil {
+			mtimes[name] = time.UnixMilli(ms)
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				mtimes[name] = t
+				continue
+			}
+		}
+		return nil, fmt.Errorf("Invalid modification time for %s", name)
+	}
+	return mtimes, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// spoolMultipart parses multipart bodies before anything else reads the form,
+// keeping at most BULK_MULTIPART_MEMORY of file data in memory per request.
+// Larger parts are copied to temp files in BULK_TEMP_DIR as they arrive, and
+// later form reads reuse the parsed result instead of buffering again.
+func spoolMultipart(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			return next(c)
+		}
+		if !strings.HasPrefix(r.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+			return next(c)
+		}
+		// Handlers report a malformed or oversized form when they read it
+		r.ParseMultipartForm(config.MultipartMemory)
+		return next(c)
+	}
+}
+
+// setupMultipartSpool points the standard library's multipart temp files at
+// BULK_TEMP_DIR, since it always writes them to the OS temp directory
+func setupMultipartSpool() error {
+	return os.Setenv("TMPDIR", config.TempDir)
+}
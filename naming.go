@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namePlaceholder matches one {field} of BULK_NAME_TEMPLATE
+var namePlaceholder = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// nameFields lists the placeholders a naming template may use
+var nameFields = map[string]bool{
+	"base": true, "firstfile": true, "user": true, "count": true,
+	"date": true, "time": true, "timestamp": true, "random": true, "ext": true,
+}
+
+// setupNameTemplate checks BULK_NAME_TEMPLATE for unknown placeholders
+func setupNameTemplate() error {
+	if strings.TrimSpace(config.NameTemplate) == "" {
+		return fmt.Errorf("BULK_NAME_TEMPLATE is empty")
+	}
+	for _, m := range namePlaceholder.FindAllStringSubmatch(config.NameTemplate, -1) {
+		if !nameFields[m[1]] {
+			return fmt.Errorf("unknown placeholder %s in BULK_NAME_TEMPLATE", m[0])
+		}
+	}
+	return nil
+}
+
+// archiveName generates the download filename for the entries from
+// BULK_NAME_TEMPLATE. The name always ends in ext, such as ".zip", and a
+// number is added when an archive with the same name is still held. The
+// name stays reserved until releaseArchiveName.
+func archiveName(entries []archiveEntry, opts archiveOptions, ext string) string {
+	first := "archive"
+	if len(entries) > 0 {
+		fileName := path.Base(sanitizeEntryName(entries[0].Name))
+		first = fileName[:len(fileName)-len(filepath.Ext(fileName))]
+	}
+	base := opts.BaseName
+	switch {
+	case base != "":
+	case len(entries) == 1:
+		base = first
+	default:
+		base = "archive"
+	}
+	user := opts.Owner
+	if user == "" {
+		user = "anonymous"
+	}
+
+	now := time.Now()
+	name := namePlaceholder.ReplaceAllStringFunc(config.NameTemplate, func(m string) string {
+		switch m[1 : len(m)-1] {
+		case "base":
+			return nameValue(base)
+		case "firstfile":
+			return nameValue(first)
+		case "user":
+			return nameValue(user)
+		case "count":
+			return strconv.Itoa(len(entries))
+		case "date":
+			return now.Format("20060102")
+		case "time":
+			return now.Format("150405")
+		case "timestamp":
+			return now.Format("20060102_150405")
+		case "random":
+			return randomSuffix()
+		case "ext":
+			return strings.TrimPrefix(ext, ".")
+		}
+		return m
+	})
+	// A template written for ZIP output, like the default, still names
+	// other formats by their own extension
+	name = nameValue(name)
+	lower := strings.ToLower(name)
+	for _, suffix := range []string{ext, ".zip"} {
+		if strings.HasSuffix(lower, suffix) {
+			name = name[:len(name)-len(suffix)]
+			break
+		}
+	}
+	if name == "" {
+		name = "archive"
+	}
+	return unusedArchiveName(name + ext)
+}
+
+// nameValue makes s safe to use in a download name by replacing path
+// separators and control characters
+func nameValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, strings.TrimLeft(s, "."))
+}
+
+// randomSuffix returns eight random hex digits for the {random} placeholder
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// reservedNames are download names handed out by archiveName for archives
+// still being built, guarded by storeMutex
+var reservedNames = make(map[string]bool)
+
+// unusedArchiveName numbers name as name_2.zip, name_3.zip and so on while an
+// archive, a downloaded one that can still be restored, or a build in
+// progress holds it, and reserves the result
+func unusedArchiveName(name string) string {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	ext := archiveExtension(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; ; n++ {
+		_, held := tempFileStore[candidate]
+		_, trashed := trash[candidate]
+		if !held && !trashed && !reservedNames[candidate] {
+			reservedNames[candidate] = true
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", stem, n, ext)
+	}
+}
+
+// releaseArchiveName ends the reservation archiveName made for name. Once
+// the archive is stored its record holds the name instead.
+func releaseArchiveName(name string) {
+	storeMutex.Lock()
+	delete(reservedNames, name)
+	storeMutex.Unlock()
+}
+
+// archiveExtension returns the extension of an archive name, keeping the
+// two parts of compressed tarballs such as .tar.gz together
+func archiveExtension(name string) string {
+	ext := path.Ext(name)
+	if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(name, ext)), ".tar") {
+		ext = name[len(name)-len(ext)-len(".tar"):]
+	}
+	return ext
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// noteFileName is the entry holding the uploader's note
+const noteFileName = "NOTE.txt"
+
+// maxArchiveNote is the longest note an uploader can attach, in bytes
+const maxArchiveNote = 4096
+
+// noteEntry builds the NOTE.txt entry carrying note
+func noteEntry(note string) archiveEntry {
+	content := strings.TrimSpace(note) + "\n"
+	return archiveEntry{
+		Name: noteFileName,
+		Size: int64(len(content)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil },
+	}
+}
+
+// parseNote trims an uploader's note and checks its length
+func parseNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if len(note) > maxArchiveNote {
+		return "", fmt.Errorf("Note too long (max %d bytes)", maxArchiveNote)
+	}
+	return note, nil
+}
+
+// wantsNotePage reports whether a download request should see the archive's
+// note first: browsers opening the link do, scripts asking for the file don't
+func wantsNotePage(c echo.Context, rec archiveRecord) bool {
+	return rec.Note != "" && c.Request().Method == http.MethodGet &&
+		strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML)
+}
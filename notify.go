@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// notifier passes events on to a chat service or similar
+type notifier interface {
+	Notify(ev event) error
+}
+
+// notifierFactory builds a notifier from the webhook URL in BULK_NOTIFIERS
+type notifierFactory func(webhook *url.URL) (notifier, error)
+
+// notifierKinds holds every notifier by name. Site-specific notifiers are
+// added with registerNotifier before setupNotifiers runs.
+var notifierKinds = map[string]notifierFactory{
+	"slack":   newSlackNotifier,
+	"discord": newDiscordNotifier,
+	"teams":   newTeamsNotifier,
+}
+
+// registerNotifier makes a notifier available to BULK_NOTIFIERS under name
+func registerNotifier(name string, factory notifierFactory) {
+	notifierKinds[name] = factory
+}
+
+// namedNotifier is a configured notifier and the name events are routed by
+type namedNotifier struct {
+	name string
+	notifier
+}
+
+// notifiers are the configured notifiers, in BULK_NOTIFIERS order
+var notifiers []namedNotifier
+
+// notifyTimeout bounds each webhook call
+const notifyTimeout = 10 * time.Second
+
+// setupNotifiers reads the kind=url entries of BULK_NOTIFIERS
+func setupNotifiers() error {
+	for _, item := range config.Notifiers {
+		name, raw, _ := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		factory, ok := notifierKinds[name]
+		if !ok {
+			return fmt.Errorf("unknown notifier %q in BULK_NOTIFIERS", name)
+		}
+		if _, dup := notifierByName(name); dup {
+			return fmt.Errorf("more than one %s notifier configured", name)
+		}
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL for the %s notifier", name)
+		}
+		n, err := factory(u)
+		if err != nil {
+			return fmt.Errorf("notifier %s: %v", name, err)
+		}
+		notifiers = append(notifiers, namedNotifier{name: name, notifier: n})
+		log.Printf("Notifier enabled: %s (%s)", name, u.Host)
+	}
+	return nil
+}
+
+// notifierByName returns the configured notifier called name
+func notifierByName(name string) (namedNotifier, bool) {
+	for _, n := range notifiers {
+		if n.name == name {
+			return n, true
+		}
+	}
+	return namedNotifier{}, false
+}
+
+// eventSummary is the line every notifier leads with
+func eventSummary(ev event) string {
+	return fmt.Sprintf("[%s] %s: %s", config.UITitle, ev.Kind, ev.Text)
+}
+
+// postWebhook sends payload as JSON to webhook
+func postWebhook(webhook *url.URL, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL holds the webhook's secret, so only the host is reported
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %v", webhook.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", webhook.Host, resp.Status)
+	}
+	return nil
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	webhook *url.URL
+}
+
+func newSlackNotifier(webhook *url.URL) (notifier, error) {
+	return slackNotifier{webhook: webhook}, nil
+}
+
+// slackEscaper keeps user-controlled text, such as file names, from
+// forming Slack mentions like <!channel> or links
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (n slackNotifier) Notify(ev event) error {
+	return postWebhook(n.webhook, map[string]string{"text": slackEscaper.Replace(eventSummary(ev))})
+}
+
+// discordNotifier posts to a Discord channel webhook
+type discordNotifier struct {
+	webhook *url.URL
+}
+
+// maxDiscordContent is the most characters Discord accepts in a message
+const maxDiscordContent = 2000
+
+func newDiscordNotifier(webhook *url.URL) (notifier, error) {
+	return discordNotifier{webhook: webhook}, nil
+}
+
+func (n discordNotifier) Notify(ev event) error {
+	content := eventSummary(ev)
+	if r := []rune(content); len(r) > maxDiscordContent {
+		content = string(r[:maxDiscordContent-1]) + "…"
+	}
+	// No mentions are parsed, so a file named @everyone pings nobody
+	return postWebhook(n.webhook, map[string]any{
+		"content":          content,
+		"allowed_mentions": map[string][]string{"parse": {}},
+	})
+}
+
+// teamsNotifier posts an Adaptive Card to a Microsoft Teams webhook, as
+// accepted by both Workflows and the older incoming webhook connector
+type teamsNotifier struct {
+	webhook *url.URL
+}
+
+func newTeamsNotifier(webhook *url.URL) (notifier, error) {
+	return teamsNotifier{webhook: webhook}, nil
+}
+
+func (n teamsNotifier) Notify(ev event) error {
+	card := map[string]any{
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"body": []map[string]any{
+			{"type": "TextBlock", "text": fmt.Sprintf("%s: %s", config.UITitle, ev.Kind), "weight": "bolder", "wrap": true},
+			{"type": "TextBlock", "text": ev.Text, "wrap": true},
+		},
+	}
+	return postWebhook(n.webhook, map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Ways of handing a download to something other than the Go process
+const (
+	offloadAccel    = "x-accel-redirect"
+	offloadSendfile = "x-sendfile"
+	offloadCDN      = "cdn"
+)
+
+// setupOffload checks the download offload settings
+func setupOffload() error {
+	switch config.DownloadOffload {
+	case "", offloadAccel, offloadSendfile:
+		return nil
+	case offloadCDN:
+		if config.CDNURL == "" || config.CDNSigningKey == "" {
+			return fmt.Errorf("BULK_DOWNLOAD_OFFLOAD=cdn needs BULK_CDN_URL and BULK_CDN_SIGNING_KEY")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown download offload %q", config.DownloadOffload)
+	}
+}
+
+// offloadPath returns the archive's path relative to TempDir when the
+// configured offload can serve it. Archives held in memory or encrypted at
+// rest always stream through the process, and so do archives on spill
+// volumes unless the proxy is given absolute paths by X-Sendfile.
+func offloadPath(rec archiveRecord) (string, bool) {
+	if config.DownloadOffload == "" || rec.Path == "" || rec.Key != "" {
+		return "", false
+	}
+	dirs := []string{config.TempDir}
+	if config.DownloadOffload == offloadSendfile {
+		dirs = tempDirs()
+	}
+	for _, dir := range dirs {
+		if rel, ok := withinDir(dir, rec.Path); ok {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
+
+// offloadDownload tells the front-end proxy or CDN to send the claimed
+// archive and removes the file once they have had time to read it
+func offloadDownload(c echo.Context, filename string, rec archiveRecord, rel string) error {
+	h := c.Response().Header()
+	h.Set("Content-Type", archiveContentType(filename))
+	h.Set("Content-Disposition", contentDisposition(filename))
+
+	var err error
+	switch config.DownloadOffload {
+	case offloadAccel:
+		log.Printf("Handing %s to the proxy via X-Accel-Redirect", filename)
+		h.Set("X-Accel-Redirect", strings.TrimSuffix(config.AccelPrefix, "/")+"/"+escapePath(rel))
+		err = c.NoContent(http.StatusOK)
+	case offloadSendfile:
+		log.Printf("Handing %s to the proxy via X-Sendfile", filename)
+		h.Set("X-Sendfile", rec.Path)
+		err = c.NoContent(http.StatusOK)
+	case offloadCDN:
+		log.Printf("Redirecting download of %s to the CDN", filename)
+		h.Set("Cache-Control", "no-store")
+		err = c.Redirect(http.StatusFound, cdnURL(rel, filename, time.Now().Add(config.CDNLinkTTL)))
+	}
+
+	switch {
+	case reusableLinks():
+		// The file stays for later downloads and expires with the archive
+	case config.RestoreWindow > 0:
+		finishDownload(filename, rec, max(config.RestoreWindow, config.OffloadKeep))
+	default:
+		path := rec.Path
+		time.AfterFunc(config.OffloadKeep, func() {
+			if os.Remove(path) == nil {
+				log.Printf("Offloaded temp file removed: %s", path)
+			}
+		})
+	}
+
+	recordBandwidth(rec, rec.Size)
+	recordDownload(downloadEvent{
+		Archive:   filename,
+		Time:      time.Now(),
+		Bytes:     rec.Size,
+		UserAgent: c.Request().UserAgent(),
+		ClientIP:  c.RealIP(),
+	})
+	recordAudit(auditEvent{
+		Archive:  filename,
+		Action:   auditDownloaded,
+		User:     currentUser(c),
+		ClientIP: c.RealIP(),
+		Bytes:    rec.Size,
+	})
+	return err
+}
+
+// cdnURL returns a signed CDN link to the file at rel under the CDN base URL,
+// valid until expires. The signature is a hex HMAC-SHA256 over the path, the
+// download name and the expiry time.
+func cdnURL(rel, filename string, expires time.Time) string {
+	path := "/" + escapePath(rel)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(config.CDNSigningKey))
+	mac.Write([]byte(path + "\n" + filename + "\n" + exp))
+
+	q := url.Values{}
+	q.Set("filename", filename)
+	q.Set("expires", exp)
+	q.Set("signature", hex.EncodeToString(mac.Sum(nil)))
+	return strings.TrimSuffix(config.CDNURL, "/") + path + "?" + q.Encode()
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+// Cookies holding the in-flight OIDC state and nonce between redirect and callback
+const (
+	oidcStateCookie = "bulk_oidc_state"
+	oidcNonceCookie = "bulk_oidc_nonce"
+)
+
+// oidcProvider is the configured identity provider, nil when SSO is disabled
+var (
+	oidcProvider *oidc.Provider
+	oidcVerifier *oidc.IDTokenVerifier
+	oidcOAuth    oauth2.Config
+)
+
+// setupOIDC discovers the configured identity provider
+func setupOIDC(ctx context.Context) error {
+	if config.OIDCIssuer == "" {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.OIDCIssuer)
+	if err != nil {
+		return fmt.Errorf("discovering OIDC provider %s: %w", config.OIDCIssuer, err)
+	}
+
+	oidcProvider = provider
+	oidcVerifier = provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID})
+	oidcOAuth = oauth2.Config{
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       config.OIDCScopes,
+	}
+	log.Printf("OIDC login enabled with issuer %s", config.OIDCIssuer)
+	return nil
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// setFlowCookie stores a short-lived value for the OIDC round trip
+func setFlowCookie(c echo.Context, name, value string) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   len(config.TLSDomains) > 0,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handleOIDCLogin redirects the browser to the identity provider
+func handleOIDCLogin(c echo.Context) error {
+	if oidcProvider == nil {
+		return htmlError(c, http.StatusNotFound, "Single sign-on is not configured")
+	}
+
+	state := randomToken(16)
+	nonce := randomToken(16)
+	setFlowCookie(c, oidcStateCookie, state)
+	setFlowCookie(c, oidcNonceCookie, nonce)
+
+	return c.Redirect(http.StatusFound, oidcOAuth.AuthCodeURL(state, oidc.Nonce(nonce)))
+}
+
+// oidcUserPrefix starts the username of every SSO session
+const oidcUserPrefix = "oidc:"
+
+// oidcClaims are the ID token claims used to build a session
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// handleOIDCCallback completes the code flow and starts a session
+func handleOIDCCallback(c echo.Context) error {
+	if oidcProvider == nil {
+		return htmlError(c, http.StatusNotFound, "Single sign-on is not configured")
+	}
+
+	stateCookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || stateCookie.Value == "" || stateCookie.Value != c.QueryParam("state") {
+		return htmlError(c, http.StatusBadRequest, "Error: Login session expired, please try again")
+	}
+	if errParam := c.QueryParam("error"); errParam != "" {
+		log.Printf("OIDC provider returned error: %s (%s)", errParam, c.QueryParam("error_description"))
+		return htmlError(c, http.StatusUnauthorized, "Error: Login was not completed")
+	}
+
+	ctx := c.Request().Context()
+	token, err := oidcOAuth.Exchange(ctx, c.QueryParam("code"))
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Printf("OIDC token response had no id_token")
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+	idToken, err := oidcVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Printf("OIDC ID token verification failed: %v", err)
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+
+	nonceCookie, err := c.Cookie(oidcNonceCookie)
+	if err != nil || nonceCookie.Value != idToken.Nonce {
+		log.Printf("OIDC nonce mismatch")
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("Error decoding OIDC claims: %v", err)
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+	groups := oidcGroups(idToken)
+
+	if claims.Subject == "" {
+		log.Printf("OIDC ID token had no subject")
+		return htmlError(c, http.StatusUnauthorized, "Error: Login failed")
+	}
+	name := claims.PreferredUsername
+	if name == "" {
+		name = claims.Email
+	}
+
+	// SSO users are kept apart from local accounts by the provider's stable
+	// subject, so an IdP account named like a local one can't take it over
+	user := oidcUserPrefix + claims.Subject
+
+	if claims.Email != "" {
+		if err := rememberOIDCEmail(claims.Email, user); err != nil {
+			log.Printf("Could not record the email of %s: %v", user, err)
+		}
+	}
+
+	setFlowCookie(c, oidcStateCookie, "")
+	setFlowCookie(c, oidcNonceCookie, "")
+	startSession(c, user, groups)
+	log.Printf("User logged in via OIDC: %s as %s (groups: %v)", name, user, groups)
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+// oidcGroups reads the configured groups claim, accepting a list or a single string
+func oidcGroups(idToken *oidc.IDToken) []string {
+	var all map[string]any
+	if err := idToken.Claims(&all); err != nil {
+		return nil
+	}
+
+	switch v := all[config.OIDCGroupsClaim].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+// handleLoginOptions returns the SSO button for the login page when SSO is enabled
+func handleLoginOptions(c echo.Context) error {
+	if oidcProvider == nil {
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Render(http.StatusOK, "sso_link", nil)
+}
+
+// oidcEmailsMutex guards the file of SSO users by email
+var oidcEmailsMutex = &sync.Mutex{}
+
+// oidcEmailsPath maps the email claims SSO users logged in with to their
+// usernames, so a purge by email finds users known only by subject
+func oidcEmailsPath() string {
+	return filepath.Join(config.DataDir, "oidc-emails.json")
+}
+
+// readOIDCEmails loads the email map; callers hold oidcEmailsMutex
+func readOIDCEmails() (map[string][]string, error) {
+	emails := make(map[string][]string)
+	data, err := os.ReadFile(oidcEmailsPath())
+	if os.IsNotExist(err) {
+		return emails, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &emails); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", oidcEmailsPath(), err)
+	}
+	return emails, nil
+}
+
+// writeOIDCEmails replaces the email map; callers hold oidcEmailsMutex
+func writeOIDCEmails(emails map[string][]string) error {
+	data, err := json.MarshalIndent(emails, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataDir, 0o750); err != nil {
+		return err
+	}
+	tmp := oidcEmailsPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, oidcEmailsPath())
+}
+
+// rememberOIDCEmail records that user logged in with email
+func rememberOIDCEmail(email, user string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	oidcEmailsMutex.Lock()
+	defer oidcEmailsMutex.Unlock()
+	emails, err := readOIDCEmails()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(emails[email], user) {
+		return nil
+	}
+	emails[email] = append(emails[email], user)
+	return writeOIDCEmails(emails)
+}
+
+// oidcUsersByEmail returns the SSO users that logged in with email
+func oidcUsersByEmail(email string) ([]string, error) {
+	oidcEmailsMutex.Lock()
+	defer oidcEmailsMutex.Unlock()
+	emails, err := readOIDCEmails()
+	if err != nil {
+		return nil, err
+	}
+	return emails[strings.ToLower(strings.TrimSpace(email))], nil
+}
+
+// forgetOIDCLogins drops email and every email recorded for users
+func forgetOIDCLogins(email string, users []string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	oidcEmailsMutex.Lock()
+	defer oidcEmailsMutex.Unlock()
+	emails, err := readOIDCEmails()
+	if err != nil || len(emails) == 0 {
+		return err
+	}
+	delete(emails, email)
+	for e, names := range emails {
+		names = slices.DeleteFunc(names, func(name string) bool { return slices.Contains(users, name) })
+		if len(names) == 0 {
+			delete(emails, e)
+		} else {
+			emails[e] = names
+		}
+	}
+	return writeOIDCEmails(emails)
+}
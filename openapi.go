@@ -0,0 +1,21 @@
+package main
+
+//go:generate go run ./cmd/gen-client -spec api/openapi.json -o client/api.gen.go
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPISpec describes the JSON API. It is written by hand, and the client
+// package is generated from it.
+//
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the OpenAPI document of the JSON API
+func handleOpenAPI(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openAPISpec)
+}
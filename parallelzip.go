@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// Compressed shards larger than this spill from memory to TempDir
+const shardMemoryLimit = 8 << 20
+
+// flateWriters recycles compressors between shards; each holds about 1 MB.
+// archive/zip deflates at level 5, so shards match its output.
+var flateWriters = sync.Pool{
+	New: func() any {
+		fw, _ := flate.NewWriter(io.Discard, 5)
+		return fw
+	},
+}
+
+// zipShard is one entry deflated ahead of being stitched into the archive
+type zipShard struct {
+	header  *zip.FileHeader
+	data    *shardBuffer
+	openErr error
+	err     error
+}
+
+// writeZipParallel is writeZip with entries deflated by up to workers
+// goroutines at once. Shards are written in upload order with CreateRaw, so
+// the archive matches a sequential run; at most workers shards are held at
+// any time.
+func writeZipParallel(zipWriter *zip.Writer, entries []archiveEntry, workers int, progress progressFunc, failed failFunc) error {
+	results := make([]chan zipShard, len(entries))
+	for i := range results {
+		results[i] = make(chan zipShard, 1)
+	}
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	dispatched := make(chan struct{})
+	var wg sync.WaitGroup
+	go func() {
+		defer close(dispatched)
+		for i, entry := range entries {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log.Printf("Processing file %d: %s", i+1, entry.Name)
+				results[i] <- compressShard(entry)
+			}()
+		}
+	}()
+
+	// On failure, stop dispatching and drop shards that finished unwritten
+	next := 0
+	defer func() {
+		close(done)
+		<-dispatched
+		wg.Wait()
+		for _, ch := range results[next:] {
+			select {
+			case shard := <-ch:
+				shard.data.Close()
+			default:
+			}
+		}
+	}()
+
+	skipped := 0
+	for ; next < len(entries); next++ {
+		entry := entries[next]
+		shard := <-results[next]
+		err := writeShard(zipWriter, entry, shard, failed)
+		shard.data.Close()
+		<-slots
+		if errors.Is(err, errShardSkipped) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			zipWriter.Close()
+			return err
+		}
+		if progress != nil {
+			progress(next+1, entry.Name)
+		}
+	}
+	if skipped > 0 && skipped == len(entries) {
+		zipWriter.Close()
+		return &archiveError{"None of the files could be read", errors.New("every entry failed")}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		log.Printf("Error closing zip writer: %v", err)
+		return &archiveError{"Error finalizing ZIP archive", err}
+	}
+	return nil
+}
+
+// errShardSkipped marks an unreadable entry left out of a lenient archive
+var errShardSkipped = errors.New("entry skipped")
+
+// writeShard copies one compressed shard into the archive
+func writeShard(zipWriter *zip.Writer, entry archiveEntry, shard zipShard, failed failFunc) error {
+	if shard.openErr != nil {
+		log.Printf("Error opening file %s: %v", entry.Name, shard.openErr)
+		if failed != nil && failed(entry.Name, errors.New("could not be read")) {
+			return errShardSkipped
+		}
+		return &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), shard.openErr}
+	}
+	if shard.err != nil {
+		log.Printf("Error copying data for %s: %v", entry.Name, shard.err)
+		return &archiveError{fmt.Sprintf("Error copying %s data", entry.Name), shard.err}
+	}
+
+	zipFile, err := zipWriter.CreateRaw(shard.header)
+	if err != nil {
+		log.Printf("Error creating zip entry for %s: %v", entry.Name, err)
+		return &archiveError{fmt.Sprintf("Error adding %s to ZIP", entry.Name), err}
+	}
+	src, err := shard.data.reader()
+	if err == nil {
+		_, err = copyPooled(zipFile, src)
+	}
+	if err != nil {
+		log.Printf("Error copying data for %s: %v", entry.Name, err)
+		return &archiveError{fmt.Sprintf("Error copying %s data", entry.Name), err}
+	}
+	return nil
+}
+
+// compressShard deflates entry the way zip.Writer would, recording the CRC
+// and sizes CreateRaw needs
+func compressShard(entry archiveEntry) zipShard {
+	shard := zipShard{header: entryHeader(entry), data: &shardBuffer{}}
+	src, err := entry.Open()
+	if err != nil {
+		shard.openErr = err
+		return shard
+	}
+	defer src.Close()
+
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(shard.data)
+	crc := crc32.NewIEEE()
+	n, err := copyPooled(io.MultiWriter(fw, crc), src)
+	if cerr := fw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		shard.err = err
+		return shard
+	}
+	prepareRawHeader(shard.header)
+	shard.header.CRC32 = crc.Sum32()
+	shard.header.UncompressedSize64 = uint64(n)
+	shard.header.CompressedSize64 = uint64(shard.data.size)
+	return shard
+}
+
+// prepareRawHeader fills in the version numbers, UTF-8 flag, MS-DOS time
+// fields and extended timestamp that CreateHeader sets but CreateRaw leaves alone
+func prepareRawHeader(fh *zip.FileHeader) {
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | 20
+	fh.ReaderVersion = 20
+
+	nameValid, nameRequires := detectUTF8(fh.Name)
+	commentValid, commentRequires := detectUTF8(fh.Comment)
+	if !fh.NonUTF8 && (nameRequires || commentRequires) && nameValid && commentValid {
+		fh.Flags |= 0x800
+	}
+
+	t := fh.Modified
+	fh.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fh.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+
+	var extra [9]byte
+	binary.LittleEndian.PutUint16(extra[0:], 0x5455) // extended timestamp
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1 // modification time only
+	binary.LittleEndian.PutUint32(extra[5:], uint32(t.Unix()))
+	fh.Extra = append(fh.Extra, extra[:]...)
+}
+
+// detectUTF8 reports whether s is valid UTF-8 and whether it needs the
+// UTF-8 flag, the same way zip.Writer decides: anything outside the ASCII
+// range CP-437 shares does
+func detectUTF8(s string) (valid, require bool) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if !utf8.ValidRune(r) || (r == utf8.RuneError && size == 1) {
+				return false, false
+			}
+			require = true
+		}
+	}
+	return true, require
+}
+
+// shardBuffer holds compressed data in memory, spilling to a temp file once
+// it outgrows shardMemoryLimit
+type shardBuffer struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+	err  error
+}
+
+func (b *shardBuffer) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.size += int64(len(p))
+	if b.file == nil && b.buf.Len()+len(p) <= shardMemoryLimit {
+		return b.buf.Write(p)
+	}
+	if b.file == nil {
+		if b.file, b.err = os.CreateTemp(spillDir(), "shard-*"); b.err != nil {
+			return 0, b.err
+		}
+		if _, b.err = b.file.Write(b.buf.Bytes()); b.err != nil {
+			return 0, b.err
+		}
+		b.buf = bytes.Buffer{}
+	}
+	n, err := b.file.Write(p)
+	b.err = err
+	return n, err
+}
+
+// reader returns the compressed bytes from the start
+func (b *shardBuffer) reader() (io.Reader, error) {
+	if b.file == nil {
+		return &b.buf, nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+// Close releases the spill file, if any
+func (b *shardBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
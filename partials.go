@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// partialSaveInterval is how often a running download checkpoints its progress
+const partialSaveInterval = 2 * time.Second
+
+// errPartialBusy is returned when another fetch holds the partial for a URL
+var errPartialBusy = errors.New("partial download in use")
+
+// partialDownload is a ranged fetch in progress, stored as <key>.part with
+// its progress in <key>.json inside the partials directory
+type partialDownload struct {
+	URL       string    `json:"url"`
+	Validator string    `json:"validator"`
+	Size      int64     `json:"size"`
+	Segments  []segment `json:"segments"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	key     string
+	file    *os.File
+	done    []atomic.Int64
+	resumed bool
+	saveMu  sync.Mutex
+}
+
+var (
+	partialsBusy  = make(map[string]bool)
+	partialsMutex = &sync.Mutex{}
+)
+
+// partialsDir returns the configured directory for partial downloads
+func partialsDir() string {
+	if config.FetchPartialDir != "" {
+		return config.FetchPartialDir
+	}
+	return filepath.Join(config.DataDir, "partials")
+}
+
+// partialKey names the partial files for a URL
+func partialKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:16])
+}
+
+// openPartial claims the partial download for rawURL, resuming the saved one
+// when it belongs to the same version of the file and starting over otherwise
+func openPartial(rawURL, validator string, size int64) (*partialDownload, error) {
+	key := partialKey(rawURL)
+	partialsMutex.Lock()
+	if partialsBusy[key] {
+		partialsMutex.Unlock()
+		return nil, errPartialBusy
+	}
+	partialsBusy[key] = true
+	partialsMutex.Unlock()
+
+	p, err := loadPartial(key, rawURL, validator, size)
+	if err != nil {
+		partialsMutex.Lock()
+		delete(partialsBusy, key)
+		partialsMutex.Unlock()
+		return nil, err
+	}
+	return p, nil
+}
+
+func loadPartial(key, rawURL, validator string, size int64) (*partialDownload, error) {
+	dir := partialsDir()
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, key)
+
+	var saved partialDownload
+	if data, err := os.ReadFile(base + ".json"); err == nil && json.Unmarshal(data, &saved) == nil &&
+		saved.URL == rawURL && saved.Validator == validator && saved.Size == size {
+		if f, err := os.OpenFile(base+".part", os.O_RDWR, 0); err == nil {
+			if st, err := f.Stat(); err == nil && st.Size() == size {
+				p := &partialDownload{URL: rawURL, Validator: validator, Size: size, Segments: saved.Segments,
+					key: key, file: f, resumed: true}
+				p.done = make([]atomic.Int64, len(p.Segments))
+				for i, seg := range p.Segments {
+					p.done[i].Store(min(seg.Done, seg.End-seg.Start+1))
+				}
+				return p, nil
+			}
+			f.Close()
+		}
+	}
+
+	// Nothing usable saved; start a new partial
+	os.Remove(base + ".json")
+	f, err := os.OpenFile(base+".part", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		os.Remove(base + ".part")
+		return nil, err
+	}
+	p := &partialDownload{URL: rawURL, Validator: validator, Size: size, Segments: segmentPlan(size), key: key, file: f}
+	p.done = make([]atomic.Int64, len(p.Segments))
+	return p, nil
+}
+
+// remaining returns how many bytes of segment i are still missing.
+// Progress is only ever read from p.done; Segments[i].Done is the value
+// loaded from disk.
+func (p *partialDownload) remaining(i int) int64 {
+	seg := &p.Segments[i]
+	return seg.End - seg.Start + 1 - p.done[i].Load()
+}
+
+// completed returns how many bytes have been written so far
+func (p *partialDownload) completed() int64 {
+	var n int64
+	for i := range p.done {
+		n += p.done[i].Load()
+	}
+	return n
+}
+
+// save writes the current progress next to the partial file
+func (p *partialDownload) save() {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	// Counted bytes must reach the disk before the progress is written.
+	// Segment workers keep reading p.Segments, so progress goes into a copy.
+	segments := make([]segment, len(p.Segments))
+	for i, seg := range p.Segments {
+		seg.Done = p.done[i].Load()
+		segments[i] = seg
+	}
+	if err := p.file.Sync(); err != nil {
+		log.Printf("Error syncing partial download %s: %v", redactURL(p.URL), err)
+		return
+	}
+	data, err := json.Marshal(&partialDownload{URL: p.URL, Validator: p.Validator, Size: p.Size,
+		Segments: segments, UpdatedAt: time.Now()})
+	if err != nil {
+		return
+	}
+	path := filepath.Join(partialsDir(), p.key+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o640); err != nil {
+		log.Printf("Error saving partial download %s: %v", redactURL(p.URL), err)
+		return
+	}
+	os.Rename(path+".tmp", path)
+}
+
+// discard deletes the partial so the next fetch starts from zero
+func (p *partialDownload) discard() {
+	p.file.Close()
+	base := filepath.Join(partialsDir(), p.key)
+	os.Remove(base + ".part")
+	os.Remove(base + ".json")
+}
+
+// finish hashes the completed download and moves it into dir as a spool file
+func (p *partialDownload) finish(dir string) (string, int64, string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(p.file, 0, p.Size)); err != nil {
+		p.file.Close()
+		return "", 0, "", err
+	}
+	if err := p.file.Close(); err != nil {
+		return "", 0, "", err
+	}
+
+	base := filepath.Join(partialsDir(), p.key)
+	os.Remove(base + ".json")
+	spoolPath := base + ".part"
+	if spool, err := os.CreateTemp(dir, "fetch-*"); err == nil {
+		spool.Close()
+		if err := os.Rename(spoolPath, spool.Name()); err == nil {
+			spoolPath = spool.Name()
+		} else {
+			// Different filesystem; use the file where it is
+			os.Remove(spool.Name())
+		}
+	}
+	return spoolPath, p.Size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// release closes the partial file if still open and lets other fetches claim the URL
+func (p *partialDownload) release() {
+	p.file.Close()
+	partialsMutex.Lock()
+	delete(partialsBusy, p.key)
+	partialsMutex.Unlock()
+}
+
+// prunePartials removes partial downloads not touched within maxAge
+func prunePartials(maxAge time.Duration) {
+	entries, err := os.ReadDir(partialsDir())
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".json"), ".part")
+		partialsMutex.Lock()
+		busy := partialsBusy[key]
+		partialsMutex.Unlock()
+		if !busy {
+			os.Remove(filepath.Join(partialsDir(), e.Name()))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// jobPriority is the scheduling class of a compression job
+type jobPriority string
+
+const (
+	// Uploads someone is waiting on in a browser or API client
+	priorityInteractive jobPriority = "interactive"
+
+	// Scheduled bundles, watch folder ingests and jobs submitted as batch
+	priorityBatch jobPriority = "batch"
+)
+
+// parsePriority reads the priority form field, defaulting to interactive
+func parsePriority(value string) (jobPriority, error) {
+	switch jobPriority(value) {
+	case "", priorityInteractive:
+		return priorityInteractive, nil
+	case priorityBatch:
+		return priorityBatch, nil
+	default:
+		return "", fmt.Errorf("Unknown priority %q (use interactive or batch)", value)
+	}
+}
+
+// workerPool limits how many archives are built at once. When workers are
+// busy, waiting jobs are started by smooth weighted round robin across the
+// priority classes, so interactive jobs go ahead of batch ones in proportion
+// to BULK_INTERACTIVE_WEIGHT without starving batch work.
+type workerPool struct {
+	mu      sync.Mutex
+	busy    int
+	waiting map[jobPriority][]chan struct{}
+	credit  map[jobPriority]int
+}
+
+// compressWorkers is the pool every archive build waits on
+var compressWorkers = &workerPool{
+	waiting: make(map[jobPriority][]chan struct{}),
+	credit:  make(map[jobPriority]int),
+}
+
+// priorityWeight returns how many of a class's jobs start per round
+func priorityWeight(p jobPriority) int {
+	if p == priorityInteractive {
+		return max(config.InteractiveWeight, 1)
+	}
+	return 1
+}
+
+// acquire waits for a free worker and returns a function releasing it.
+// Without BULK_JOB_WORKERS the pool never makes anyone wait.
+func (p *workerPool) acquire(ctx context.Context, prio jobPriority) (func(), error) {
+	if config.JobWorkers <= 0 {
+		return func() {}, nil
+	}
+
+	p.mu.Lock()
+	if p.busy < config.JobWorkers && len(p.waiting[priorityInteractive])+len(p.waiting[priorityBatch]) == 0 {
+		p.busy++
+		p.mu.Unlock()
+		return p.release, nil
+	}
+	ready := make(chan struct{})
+	p.waiting[prio] = append(p.waiting[prio], ready)
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+		return p.release, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, ch := range p.waiting[prio] {
+			if ch == ready {
+				p.waiting[prio] = append(p.waiting[prio][:i], p.waiting[prio][i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// Handed a worker just as we gave up; pass it on
+		p.busy--
+		p.dispatchLocked()
+		return nil, ctx.Err()
+	}
+}
+
+// release frees a worker and starts the next waiting job, if any
+func (p *workerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy--
+	p.dispatchLocked()
+}
+
+// dispatchLocked hands a free worker to the waiting class with the most
+// credit; p.mu must be held
+func (p *workerPool) dispatchLocked() {
+	if p.busy >= config.JobWorkers {
+		return
+	}
+	total := 0
+	var next jobPriority
+	for _, class := range []jobPriority{priorityInteractive, priorityBatch} {
+		if len(p.waiting[class]) == 0 {
+			continue
+		}
+		w := priorityWeight(class)
+		total += w
+		p.credit[class] += w
+		if next == "" || p.credit[class] > p.credit[next] {
+			next = class
+		}
+	}
+	if next == "" {
+		return
+	}
+	p.credit[next] -= total
+	p.busy++
+	ready := p.waiting[next][0]
+	p.waiting[next] = p.waiting[next][1:]
+	close(ready)
+}
+
+// queued reports how many jobs of each class are waiting for a worker
+func (p *workerPool) queued() map[jobPriority]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[jobPriority]int{
+		priorityInteractive: len(p.waiting[priorityInteractive]),
+		priorityBatch:       len(p.waiting[priorityBatch]),
+	}
+}
@@ -0,0 +1,165 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: bulkdownload/v1/bulkdownload.proto
+
+package bulkdownloadv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	BulkDownloadService_Compress_FullMethodName = "/bulkdownload.v1.BulkDownloadService/Compress"
+	BulkDownloadService_WatchJob_FullMethodName = "/bulkdownload.v1.BulkDownloadService/WatchJob"
+)
+
+// BulkDownloadServiceClient is the client API for BulkDownloadService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// BulkDownloadService exposes the compression pipeline to internal services
+type BulkDownloadServiceClient interface {
+	// Compress receives files as a stream and starts building an archive.
+	// Each file is sent as a FileStart message followed by its data chunks.
+	Compress(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[CompressRequest, CompressResponse], error)
+	// WatchJob streams progress updates for a job until it finishes
+	WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobProgress], error)
+}
+
+type bulkDownloadServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBulkDownloadServiceClient(cc grpc.ClientConnInterface) BulkDownloadServiceClient {
+	return &bulkDownloadServiceClient{cc}
+}
+
+func (c *bulkDownloadServiceClient) Compress(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[CompressRequest, CompressResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BulkDownloadService_ServiceDesc.Streams[0], BulkDownloadService_Compress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CompressRequest, CompressResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BulkDownloadService_CompressClient = grpc.ClientStreamingClient[CompressRequest, CompressResponse]
+
+func (c *bulkDownloadServiceClient) WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BulkDownloadService_ServiceDesc.Streams[1], BulkDownloadService_WatchJob_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchJobRequest, JobProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BulkDownloadService_WatchJobClient = grpc.ServerStreamingClient[JobProgress]
+
+// BulkDownloadServiceServer is the server API for BulkDownloadService service.
+// All implementations must embed UnimplementedBulkDownloadServiceServer
+// for forward compatibility.
+//
+// BulkDownloadService exposes the compression pipeline to internal services
+type BulkDownloadServiceServer interface {
+	// Compress receives files as a stream and starts building an archive.
+	// Each file is sent as a FileStart message followed by its data chunks.
+	Compress(grpc.ClientStreamingServer[CompressRequest, CompressResponse]) error
+	// WatchJob streams progress updates for a job until it finishes
+	WatchJob(*WatchJobRequest, grpc.ServerStreamingServer[JobProgress]) error
+	mustEmbedUnimplementedBulkDownloadServiceServer()
+}
+
+// UnimplementedBulkDownloadServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBulkDownloadServiceServer struct{}
+
+func (UnimplementedBulkDownloadServiceServer) Compress(grpc.ClientStreamingServer[CompressRequest, CompressResponse]) error {
+	return status.Error(codes.Unimplemented, "method Compress not implemented")
+}
+func (UnimplementedBulkDownloadServiceServer) WatchJob(*WatchJobRequest, grpc.ServerStreamingServer[JobProgress]) error {
+	return status.Error(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedBulkDownloadServiceServer) mustEmbedUnimplementedBulkDownloadServiceServer() {}
+func (UnimplementedBulkDownloadServiceServer) testEmbeddedByValue()                             {}
+
+// UnsafeBulkDownloadServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BulkDownloadServiceServer will
+// result in compilation errors.
+type UnsafeBulkDownloadServiceServer interface {
+	mustEmbedUnimplementedBulkDownloadServiceServer()
+}
+
+func RegisterBulkDownloadServiceServer(s grpc.ServiceRegistrar, srv BulkDownloadServiceServer) {
+	// If the following call panics, it indicates UnimplementedBulkDownloadServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&BulkDownloadService_ServiceDesc, srv)
+}
+
+func _BulkDownloadService_Compress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BulkDownloadServiceServer).Compress(&grpc.GenericServerStream[CompressRequest, CompressResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BulkDownloadService_CompressServer = grpc.ClientStreamingServer[CompressRequest, CompressResponse]
+
+func _BulkDownloadService_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BulkDownloadServiceServer).WatchJob(m, &grpc.GenericServerStream[WatchJobRequest, JobProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BulkDownloadService_WatchJobServer = grpc.ServerStreamingServer[JobProgress]
+
+// BulkDownloadService_ServiceDesc is the grpc.ServiceDesc for BulkDownloadService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BulkDownloadService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bulkdownload.v1.BulkDownloadService",
+	HandlerType: (*BulkDownloadServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Compress",
+			Handler:       _BulkDownloadService_Compress_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchJob",
+			Handler:       _BulkDownloadService_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "bulkdownload/v1/bulkdownload.proto",
+}
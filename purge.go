@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// purgeRequest selects the personal data of one person. At least one of
+// User, Email or IP is required; From and To optionally bound the time range.
+type purgeRequest struct {
+	User  string    `json:"user,omitempty"`
+	Email string    `json:"email,omitempty"`
+	IP    string    `json:"ip,omitempty"`
+	From  time.Time `json:"from,omitempty"`
+	To    time.Time `json:"to,omitempty"`
+
+	// SSO users that logged in with Email, since they are named by subject
+	ssoUsers []string
+}
+
+// purgeReport lists what a purge removed
+type purgeReport struct {
+	Archives       []string `json:"archives"`
+	FreedBytes     int64    `json:"freedBytes"`
+	Jobs           int      `json:"jobs"`
+	UploadSessions int      `json:"uploadSessions"`
+	Quarantined    int      `json:"quarantined"`
+	AuditEntries   int      `json:"auditEntries"`
+	DownloadEvents int      `json:"downloadEvents"`
+}
+
+// matches reports whether a user name or client address belongs to the person
+func (p purgeRequest) matches(user, ip string) bool {
+	return (user != "" && (user == p.User || user == p.Email || slices.Contains(p.ssoUsers, user))) || (ip != "" && ip == p.IP)
+}
+
+// inRange reports whether t falls within the requested time range
+func (p purgeRequest) inRange(t time.Time) bool {
+	return (p.From.IsZero() || !t.Before(p.From)) && (p.To.IsZero() || !t.After(p.To))
+}
+
+// purgePersonalData removes the archives, jobs, upload sessions, quarantined
+// files, audit entries and download events of the person p describes
+func purgePersonalData(p purgeRequest) (purgeReport, error) {
+	report := purgeReport{Archives: []string{}}
+	if p.Email != "" {
+		users, err := oidcUsersByEmail(p.Email)
+		if err != nil {
+			return report, err
+		}
+		p.ssoUsers = users
+	}
+
+	// Archives created before their records kept the client address are
+	// found through the audit trail
+	created, err := auditedCreations(p)
+	if err != nil {
+		return report, err
+	}
+	purged := make(map[string]bool)
+	for _, a := range takeArchivesWhere(func(name string, rec archiveRecord) bool {
+		return created[name] || (p.matches(rec.Owner, rec.ClientIP) && p.inRange(rec.CreatedAt))
+	}) {
+		removeArchiveFile(a.Name, a.Path)
+		purged[a.Name] = true
+		report.Archives = append(report.Archives, a.Name)
+		report.FreedBytes += a.Size
+	}
+
+	report.Jobs = purgeJobs(func(j job) bool {
+		return purged[j.Archive] || (p.matches(j.owner, j.clientIP) && p.inRange(j.CreatedAt))
+	})
+	report.UploadSessions = purgeUploadSessions(func(s *uploadSession) bool {
+		return p.matches(s.Owner, "") && p.inRange(s.CreatedAt)
+	})
+
+	for _, q := range takeQuarantined(func(q quarantinedFile) bool {
+		return purged[q.Archive] || (p.matches(q.Owner, q.ClientIP) && p.inRange(q.CreatedAt))
+	}) {
+		os.Remove(quarantinePath(q.ID))
+		report.Quarantined++
+	}
+
+	report.DownloadEvents, err = purgeDownloadEvents(func(ev downloadEvent) bool {
+		return purged[ev.Archive] || (p.matches("", ev.ClientIP) && p.inRange(ev.Time))
+	})
+	if err != nil {
+		return report, err
+	}
+	report.AuditEntries, err = purgeAuditEvents(func(ev auditEvent) bool {
+		return purged[ev.Archive] || (p.matches(ev.User, ev.ClientIP) && p.inRange(ev.Time))
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// A purge of all time also forgets which subjects the email logged in as
+	if p.From.IsZero() && p.To.IsZero() {
+		if err := forgetOIDCLogins(p.Email, append(p.ssoUsers, p.User)); err != nil {
+			return report, err
+		}
+	}
+
+	// Note the purge itself without naming whose data it was
+	recordAudit(auditEvent{Action: "purged", Bytes: report.FreedBytes, Reason: fmt.Sprintf(
+		"data subject request: %d archives, %d jobs, %d audit entries, %d download events",
+		len(report.Archives), report.Jobs, report.AuditEntries, report.DownloadEvents)})
+	return report, nil
+}
+
+// auditedCreations returns the archives the audit trail says p created
+func auditedCreations(p purgeRequest) (map[string]bool, error) {
+	created := make(map[string]bool)
+	f, err := os.Open(auditPath())
+	if os.IsNotExist(err) || config.AuditLog == "off" {
+		return created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev auditEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) == nil && ev.Action == auditCreated &&
+			p.matches(ev.User, ev.ClientIP) && p.inRange(ev.Time) {
+			created[ev.Archive] = true
+		}
+	}
+	return created, scanner.Err()
+}
+
+// purgeAuditEvents rewrites the audit trail without the events match selects
+func purgeAuditEvents(match func(ev auditEvent) bool) (int, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile == nil {
+		return 0, nil
+	}
+	auditFile.Close()
+	auditFile = nil
+	removed, err := rewriteJSONLines(auditPath(), func(line []byte) bool {
+		var ev auditEvent
+		return json.Unmarshal(line, &ev) == nil && match(ev)
+	})
+	f, openErr := os.OpenFile(auditPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if openErr != nil {
+		return removed, errors.Join(err, openErr)
+	}
+	auditFile = f
+	return removed, err
+}
+
+// purgeDownloadEvents drops the download events match selects from memory and disk
+func purgeDownloadEvents(match func(ev downloadEvent) bool) (int, error) {
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	kept := analyticsEvents[:0]
+	for _, ev := range analyticsEvents {
+		if !match(ev) {
+			kept = append(kept, ev)
+		}
+	}
+	removed := len(analyticsEvents) - len(kept)
+	analyticsEvents = kept
+	if analyticsFile == nil {
+		return removed, nil
+	}
+
+	analyticsFile.Close()
+	analyticsFile = nil
+	_, err := rewriteJSONLines(analyticsPath(), func(line []byte) bool {
+		var ev downloadEvent
+		return json.Unmarshal(line, &ev) == nil && match(ev)
+	})
+	f, openErr := os.OpenFile(analyticsPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o640)
+	if openErr != nil {
+		return removed, errors.Join(err, openErr)
+	}
+	analyticsFile = f
+	return removed, err
+}
+
+// rewriteJSONLines replaces path with a copy lacking the lines drop selects,
+// returning how many were dropped. The copy is renamed into place so a crash
+// leaves either the old or the new file.
+func rewriteJSONLines(path string, drop func(line []byte) bool) (int, error) {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".purge-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	removed := 0
+	w := bufio.NewWriter(tmp)
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if drop(scanner.Bytes()) {
+			removed++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o640); err != nil {
+		return 0, err
+	}
+	return removed, os.Rename(tmp.Name(), path)
+}
+
+// handleAdminPurge removes the personal data of the person in the JSON body
+func handleAdminPurge(c echo.Context) error {
+	var req purgeRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "body must be JSON such as {\"user\": \"alice\"}")
+	}
+	if req.User == "" && req.Email == "" && req.IP == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "one of user, email or ip is required")
+	}
+	report, err := purgePersonalData(req)
+	if err != nil {
+		log.Printf("Data purge failed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "purge incomplete, see server log")
+	}
+	log.Printf("Data purge removed %d archives, %d jobs, %d audit entries and %d download events",
+		len(report.Archives), report.Jobs, report.AuditEntries, report.DownloadEvents)
+	return c.JSON(http.StatusOK, report)
+}
+
+// runPurgeCommand implements "bulk-download purge", which asks a running
+// server to purge a person's data and prints the report
+func runPurgeCommand(args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	var req purgeRequest
+	fs.StringVar(&req.User, "user", "", "username whose data is purged")
+	fs.StringVar(&req.Email, "email", "", "email address whose data is purged")
+	fs.StringVar(&req.IP, "ip", "", "client IP address whose data is purged")
+	from := fs.String("from", "", "only purge data from this time on (RFC 3339)")
+	to := fs.String("to", "", "only purge data up to this time (RFC 3339)")
+	server := fs.String("server", defaultServerURL(), "URL of the running server")
+	token := fs.String("token", config.AdminToken, "admin token, defaults to BULK_ADMIN_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	for _, t := range []struct {
+		value string
+		dst   *time.Time
+	}{{*from, &req.From}, {*to, &req.To}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid time %q: %v\n", t.value, err)
+			return 2
+		}
+		*t.dst = parsed
+	}
+
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*server, "/")+"/admin/purge", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	httpReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	httpReq.Header.Set(echo.HeaderAuthorization, "Bearer "+*token)
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "purge failed: %s %s\n", resp.Status, bytes.TrimSpace(out))
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// defaultServerURL is the local address the server listens on
+func defaultServerURL() string {
+	if strings.HasPrefix(config.Addr, ":") {
+		return "http://localhost" + config.Addr
+	}
+	return "http://" + config.Addr
+}
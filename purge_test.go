@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPurgeByEmailFindsSSOUsers(t *testing.T) {
+	if err := rememberOIDCEmail("Alice@Example.com", "oidc:1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rememberOIDCEmail("bob@example.com", "oidc:5678"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	storeMutex.Lock()
+	tempFileStore["purge-alice.zip"] = archiveRecord{Owner: "oidc:1234", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	tempFileStore["purge-bob.zip"] = archiveRecord{Owner: "oidc:5678", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	storeMutex.Unlock()
+	defer takeArchivesWhere(func(name string, _ archiveRecord) bool { return name == "purge-bob.zip" })
+
+	report, err := purgePersonalData(purgeRequest{Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Archives, []string{"purge-alice.zip"}) {
+		t.Errorf("purged %v, want only purge-alice.zip", report.Archives)
+	}
+
+	// The purged email is forgotten, other users' logins are kept
+	if users, err := oidcUsersByEmail("alice@example.com"); err != nil || len(users) != 0 {
+		t.Errorf("alice@example.com still maps to %v, %v", users, err)
+	}
+	if users, err := oidcUsersByEmail("bob@example.com"); err != nil || !slices.Equal(users, []string{"oidc:5678"}) {
+		t.Errorf("bob@example.com maps to %v, %v; want [oidc:5678]", users, err)
+	}
+}
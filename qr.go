@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrCodeSize is the width and height in pixels of generated QR codes
+const qrCodeSize = 256
+
+// handleQRCode renders a PNG QR code encoding the download link of an archive
+func handleQRCode(c echo.Context) error {
+	filename, _, ok := sharedArchive(c.Param("filename"))
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+
+	png, err := qrcode.Encode(absoluteURL(c, sharePath(filename)), qrcode.Medium, qrCodeSize)
+	if err != nil {
+		log.Printf("Error generating QR code for %s: %v", filename, err)
+		return htmlError(c, http.StatusInternalServerError, "Error generating QR code")
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	return c.Blob(http.StatusOK, "image/png", png)
+}
+
+// absoluteURL turns a server path into a full URL, using the configured
+// public URL or, failing that, the scheme and host of the request
+func absoluteURL(c echo.Context, path string) string {
+	if config.PublicURL != "" {
+		return strings.TrimSuffix(config.PublicURL, "/") + path
+	}
+	return c.Scheme() + "://" + c.Request().Host + path
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// scanTimeout bounds one run of BULK_SCAN_COMMAND
+const scanTimeout = time.Minute
+
+// quarantinedFile is a flagged file held back from its archive until an
+// admin approves or rejects it
+type quarantinedFile struct {
+	ID        string    `json:"id"`
+	Archive   string    `json:"archive"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Reason    string    `json:"reason"`
+	Owner     string    `json:"owner,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+	ModTime   time.Time `json:"modTime"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// quarantine holds the files awaiting review, keyed by ID
+var (
+	quarantine      = make(map[string]quarantinedFile)
+	quarantineMutex = &sync.Mutex{}
+)
+
+// quarantineDir is BULK_QUARANTINE_DIR or quarantine in the data directory
+func quarantineDir() string {
+	if config.QuarantineDir != "" {
+		return config.QuarantineDir
+	}
+	return filepath.Join(config.DataDir, "quarantine")
+}
+
+// quarantinePath is where the contents of the file with the given ID are kept
+func quarantinePath(id string) string {
+	return filepath.Join(quarantineDir(), id)
+}
+
+// screeningEnabled reports whether any check that quarantines files is configured
+func screeningEnabled() bool {
+	return len(config.SuspiciousExtensions) > 0 || len(config.SuspiciousTypes) > 0 || config.ScanCommand != ""
+}
+
+// screenEntries moves flagged entries into quarantine and returns the rest.
+// A file that can't be checked is quarantined too, so one bad file never
+// fails the whole batch.
+func screenEntries(ctx context.Context, entries []archiveEntry, archive string, opts archiveOptions) ([]archiveEntry, []quarantinedFile, error) {
+	if !screeningEnabled() || opts.Screened {
+		return entries, nil, nil
+	}
+	if err := os.MkdirAll(quarantineDir(), 0o750); err != nil {
+		return nil, nil, &archiveError{"Error preparing quarantine", err}
+	}
+
+	kept := entries[:0:0]
+	var held []quarantinedFile
+	for _, entry := range entries {
+		if entry.Open == nil || strings.HasSuffix(entry.Name, "/") {
+			kept = append(kept, entry)
+			continue
+		}
+		spooled, reason, err := screenEntry(ctx, entry)
+		if err == nil {
+			// A scan cut short says nothing about the file
+			err = ctx.Err()
+		}
+		if err != nil {
+			os.Remove(spooled)
+			discardQuarantined(held)
+			return nil, nil, err
+		}
+		if reason == "" {
+			kept = append(kept, entry)
+			continue
+		}
+
+		q := quarantinedFile{
+			ID:        newJobID(),
+			Archive:   archive,
+			Name:      entry.Name,
+			Size:      entry.Size,
+			Reason:    reason,
+			Owner:     opts.Owner,
+			ClientIP:  opts.ClientIP,
+			ModTime:   entry.ModTime,
+			CreatedAt: time.Now(),
+		}
+		if spooled == "" {
+			spooled, err = spoolEntry(entry, quarantineDir())
+		}
+		if err == nil {
+			err = os.Rename(spooled, quarantinePath(q.ID))
+		}
+		if err != nil {
+			os.Remove(spooled)
+			discardQuarantined(held)
+			return nil, nil, &archiveError{fmt.Sprintf("Error quarantining %s", entry.Name), err}
+		}
+		log.Printf("Quarantined %s for %s: %s", entry.Name, archive, reason)
+		held = append(held, q)
+	}
+	return kept, held, nil
+}
+
+// screenEntry returns the copy of entry the scanner checked, if it was kept,
+// and why entry should be quarantined, or "" when it is clean
+func screenEntry(ctx context.Context, entry archiveEntry) (string, string, error) {
+	ext := strings.ToLower(path.Ext(entry.Name))
+	for _, blocked := range config.SuspiciousExtensions {
+		if ext != "" && strings.TrimPrefix(ext, ".") == strings.TrimPrefix(strings.ToLower(blocked), ".") {
+			return "", "suspicious file extension " + ext, nil
+		}
+	}
+
+	if len(config.SuspiciousTypes) > 0 {
+		contentType, err := sniffEntry(entry)
+		if err != nil {
+			return "", "could not read file: " + err.Error(), nil
+		}
+		for _, blocked := range config.SuspiciousTypes {
+			if strings.HasPrefix(contentType, blocked) {
+				return "", "suspicious content type " + contentType, nil
+			}
+		}
+	}
+
+	if config.ScanCommand == "" {
+		return "", "", nil
+	}
+	spooled, err := spoolEntry(entry, quarantineDir())
+	if err != nil {
+		return "", "", &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), err}
+	}
+	reason := scanFile(ctx, config.ScanCommand, spooled)
+	if reason == "" {
+		os.Remove(spooled)
+		return "", "", nil
+	}
+	return spooled, reason, nil
+}
+
+// sniffEntry detects the content type of entry from its first bytes
+func sniffEntry(entry archiveEntry) (string, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// spoolEntry copies entry into a pending file in dir
+func spoolEntry(entry archiveEntry, dir string) (string, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	f, err := os.CreateTemp(dir, "pending-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// scanFile runs command, such as BULK_SCAN_COMMAND, on the file at p and
+// returns why it was flagged, or "" when the command exits cleanly. The
+// command is killed once ctx is done.
+func scanFile(ctx context.Context, command, p string) string {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	args := append(strings.Fields(command), p)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return ""
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		log.Printf("Scan command failed on %s: %v", p, err)
+		return "scan failed"
+	}
+	if line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); line != "" {
+		return "flagged by scanner: " + line
+	}
+	return fmt.Sprintf("flagged by scanner (exit status %d)", exitErr.ExitCode())
+}
+
+// holdQuarantined registers files screenEntries set aside once their archive exists
+func holdQuarantined(held []quarantinedFile) {
+	if len(held) == 0 {
+		return
+	}
+	quarantineMutex.Lock()
+	for _, q := range held {
+		quarantine[q.ID] = q
+	}
+	quarantineMutex.Unlock()
+	for _, q := range held {
+		recordAudit(auditEvent{
+			Archive:  q.Archive,
+			Action:   auditQuarantined,
+			User:     q.Owner,
+			ClientIP: q.ClientIP,
+			Files:    []string{q.Name},
+			Bytes:    q.Size,
+			Reason:   q.Reason,
+		})
+	}
+	saveQuarantine()
+}
+
+// discardQuarantined removes the contents of files that were never registered
+func discardQuarantined(held []quarantinedFile) {
+	for _, q := range held {
+		os.Remove(quarantinePath(q.ID))
+	}
+}
+
+// takeQuarantined removes and returns the quarantined files match selects
+func takeQuarantined(match func(q quarantinedFile) bool) []quarantinedFile {
+	quarantineMutex.Lock()
+	var out []quarantinedFile
+	for id, q := range quarantine {
+		if match(q) {
+			out = append(out, q)
+			delete(quarantine, id)
+		}
+	}
+	quarantineMutex.Unlock()
+	if len(out) > 0 {
+		saveQuarantine()
+	}
+	return out
+}
+
+// quarantineIndexPath is the file that lists the quarantined files
+func quarantineIndexPath() string {
+	return filepath.Join(quarantineDir(), "index.json")
+}
+
+// saveQuarantine writes the quarantine index so pending reviews survive a restart
+func saveQuarantine() {
+	quarantineMutex.Lock()
+	data, err := json.MarshalIndent(quarantine, "", "  ")
+	quarantineMutex.Unlock()
+	if err == nil {
+		tmp := quarantineIndexPath() + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, quarantineIndexPath())
+		}
+	}
+	if err != nil {
+		log.Printf("Could not save quarantine index: %v", err)
+	}
+}
+
+// loadQuarantine restores the quarantine index, skipping files that are gone
+func loadQuarantine() error {
+	data, err := os.ReadFile(quarantineIndexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading quarantine index: %w", err)
+	}
+	var saved map[string]quarantinedFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing quarantine index: %w", err)
+	}
+	quarantineMutex.Lock()
+	defer quarantineMutex.Unlock()
+	for id, q := range saved {
+		if _, err := os.Stat(quarantinePath(id)); err == nil {
+			quarantine[id] = q
+		}
+	}
+	log.Printf("Restored %d quarantined files", len(quarantine))
+	return nil
+}
+
+// quarantineListing is a quarantined file as listed by the admin API
+type quarantineListing struct {
+	quarantinedFile
+
+	// Whether the archive is still waiting to be downloaded, so approval
+	// adds the file to it instead of creating a new archive
+	ArchiveAvailable bool `json:"archiveAvailable"`
+}
+
+// handleAdminListQuarantine lists the files awaiting review, oldest first
+func handleAdminListQuarantine(c echo.Context) error {
+	quarantineMutex.Lock()
+	out := make([]quarantineListing, 0, len(quarantine))
+	for _, q := range quarantine {
+		out = append(out, quarantineListing{quarantinedFile: q})
+	}
+	quarantineMutex.Unlock()
+	for i := range out {
+		_, out[i].ArchiveAvailable = getArchive(out[i].Archive)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return c.JSON(http.StatusOK, out)
+}
+
+// approvalResponse tells the admin where an approved file ended up
+type approvalResponse struct {
+	Archive     string `json:"archive"`
+	DownloadURL string `json:"downloadUrl"`
+
+	// Set when the original archive was gone and a new one was created
+	NewArchive bool `json:"newArchive"`
+}
+
+// handleAdminApproveQuarantine releases a quarantined file into its archive,
+// or into a new archive for the same owner when the original was already
+// downloaded or deleted
+func handleAdminApproveQuarantine(c echo.Context) error {
+	id := c.Param("id")
+	taken := takeQuarantined(func(q quarantinedFile) bool { return q.ID == id })
+	if len(taken) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such quarantined file")
+	}
+	q := taken[0]
+	entry := archiveEntry{
+		Name:    q.Name,
+		Size:    q.Size,
+		Open:    func() (io.ReadCloser, error) { return os.Open(quarantinePath(q.ID)) },
+		ModTime: q.ModTime,
+	}
+
+	resp := approvalResponse{Archive: q.Archive}
+	err := appendToArchive(q.Archive, entry)
+	if errors.Is(err, errArchiveGone) {
+		var result archiveResult
+		result, err = createArchive(context.Background(), []archiveEntry{entry}, archiveOptions{
+			Owner:    q.Owner,
+			ClientIP: q.ClientIP,
+			BaseName: archiveBaseName(q.Archive),
+			Screened: true,
+		}, nil)
+		resp.Archive, resp.NewArchive = result.Name, true
+	}
+	if err != nil {
+		// Keep the file under review so the approval can be retried
+		quarantineMutex.Lock()
+		quarantine[q.ID] = q
+		quarantineMutex.Unlock()
+		saveQuarantine()
+		log.Printf("Approving quarantined %s failed: %v", q.Name, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not add the file to an archive")
+	}
+
+	os.Remove(quarantinePath(q.ID))
+	resp.DownloadURL = absoluteURL(c, downloadPath(resp.Archive))
+	log.Printf("Quarantined %s approved into %s", q.Name, resp.Archive)
+	recordAudit(auditEvent{Archive: resp.Archive, Action: auditApproved, User: currentUser(c), ClientIP: c.RealIP(), Files: []string{q.Name}})
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleAdminRejectQuarantine deletes a quarantined file
+func handleAdminRejectQuarantine(c echo.Context) error {
+	id := c.Param("id")
+	taken := takeQuarantined(func(q quarantinedFile) bool { return q.ID == id })
+	if len(taken) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such quarantined file")
+	}
+	q := taken[0]
+	os.Remove(quarantinePath(q.ID))
+	log.Printf("Quarantined %s rejected", q.Name)
+	recordAudit(auditEvent{Archive: q.Archive, Action: auditRejected, User: currentUser(c), ClientIP: c.RealIP(), Files: []string{q.Name}, Reason: q.Reason})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// errArchiveGone means the archive to add to was downloaded or deleted
+var errArchiveGone = errors.New("archive no longer available")
+
+// appendToArchive rewrites the archive registered under name with entry
+// added after its existing members, which are copied without recompressing
+func appendToArchive(name string, entry archiveEntry) error {
+	rec, ok := getArchive(name)
+	if !ok {
+		return errArchiveGone
+	}
+	src, size, err := openArchiveFile(rec)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	ra, ok := src.(io.ReaderAt)
+	if !ok {
+		return errors.New("archive file does not support random access")
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(spillDir(), "archive-*.zip")
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	w, key, err := sealArchive(tempFile)
+	if err == nil {
+		bw := bufio.NewWriter(w)
+		if err = appendZip(bw, zr, entry); err == nil {
+			err = bw.Flush()
+		}
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = tempFile.Stat()
+	}
+	if err == nil && !replaceArchiveFile(name, rec.Path, tempFile.Name(), key, info.Size()) {
+		err = errArchiveGone
+	}
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if rec.Path != "" {
+		os.Remove(rec.Path)
+	}
+	return nil
+}
+
+// appendZip writes the members of zr followed by entry as a ZIP to w
+func appendZip(w io.Writer, zr *zip.Reader, entry archiveEntry) error {
+	zw := zip.NewWriter(w)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err := zw.Copy(f); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	src, err := entry.Open()
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	defer src.Close()
+	dst, err := zw.CreateHeader(entryHeader(entry))
+	if err == nil {
+		_, err = copyPooled(dst, src)
+	}
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// quotaLimits caps what one principal may use; zero fields are unlimited
+type quotaLimits struct {
+	// Archives being built at the same time
+	Jobs int `json:"jobs,omitempty"`
+
+	// Bytes of stored archives
+	Storage int64 `json:"storage,omitempty"`
+
+	// Bytes served for the principal's archives per UTC day
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+}
+
+var (
+	errJobQuota       = errors.New("too many archives are being built for you at once, please wait for one to finish")
+	errStorageQuota   = errors.New("storage quota exceeded, delete some archives first")
+	errBandwidthQuota = errors.New("daily download quota for this archive's creator is used up")
+)
+
+// dailyUsage counts the bytes served for one principal on one UTC day
+type dailyUsage struct {
+	day   string
+	bytes int64
+}
+
+var (
+	activeJobs    = make(map[string]int)
+	bandwidthUsed = make(map[string]dailyUsage)
+	quotaMutex    = &sync.Mutex{}
+)
+
+// principal names who a quota is charged to: the user, or the client
+// address for anonymous requests
+func principal(owner, clientIP string) string {
+	if owner != "" {
+		return "user:" + owner
+	}
+	return "ip:" + clientIP
+}
+
+// requestPrincipal is the principal making a request
+func requestPrincipal(c echo.Context) string {
+	return principal(currentUser(c), c.RealIP())
+}
+
+// quotaFor returns the limits for a principal: its account's own quota, or
+// the BULK_QUOTA_* defaults
+func quotaFor(p string) quotaLimits {
+	if user, ok := strings.CutPrefix(p, "user:"); ok {
+		if a, ok := userAccounts[user]; ok && a.Quota != nil {
+			return *a.Quota
+		}
+	}
+	return quotaLimits{Jobs: config.QuotaJobs, Storage: config.QuotaStorage, Bandwidth: config.QuotaBandwidth}
+}
+
+// storedBytesFor returns the size of the archives charged to principal p,
+// including downloaded ones that can still be restored
+func storedBytesFor(p string) int64 {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var total int64
+	for _, rec := range tempFileStore {
+		if principal(rec.Owner, rec.ClientIP) == p {
+			total += rec.Size
+		}
+	}
+	for _, t := range trash {
+		if principal(t.Owner, t.ClientIP) == p {
+			total += t.Size
+		}
+	}
+	return total
+}
+
+// admitQuota checks the caller's storage quota for an upload of size bytes
+// and takes one of their concurrent job slots. release must be called once
+// the archive is built or abandoned.
+func admitQuota(c echo.Context, size int64) (release func(), err error) {
+	return admitPrincipal(requestPrincipal(c), size)
+}
+
+// admitPrincipal is admitQuota for callers outside an HTTP request, such as
+// gRPC streams
+func admitPrincipal(p string, size int64) (release func(), err error) {
+	limits := quotaFor(p)
+	if limits.Storage > 0 && storedBytesFor(p)+max(size, 0) > limits.Storage {
+		quotaExceeded(p, errStorageQuota)
+		return nil, errStorageQuota
+	}
+
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+	if limits.Jobs > 0 && activeJobs[p] >= limits.Jobs {
+		quotaExceeded(p, errJobQuota)
+		return nil, errJobQuota
+	}
+	activeJobs[p]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			quotaMutex.Lock()
+			if activeJobs[p]--; activeJobs[p] <= 0 {
+				delete(activeJobs, p)
+			}
+			quotaMutex.Unlock()
+		})
+	}, nil
+}
+
+// quotaErrorStatus returns the HTTP status for a refused quota check
+func quotaErrorStatus(err error) int {
+	if errors.Is(err, errStorageQuota) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusTooManyRequests
+}
+
+// utcDay names the UTC day t falls on
+func utcDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// nextUTCDay returns the start of the UTC day after t
+func nextUTCDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// bandwidthToday returns the bytes served for principal p so far today
+func bandwidthToday(p string) int64 {
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+	if u := bandwidthUsed[p]; u.day == utcDay(time.Now()) {
+		return u.bytes
+	}
+	return 0
+}
+
+// checkBandwidthQuota refuses a download of rec once its creator's daily
+// bandwidth is used up, telling the client when to retry
+func checkBandwidthQuota(c echo.Context, rec archiveRecord) error {
+	p := principal(rec.Owner, rec.ClientIP)
+	limits := quotaFor(p)
+	if limits.Bandwidth <= 0 || bandwidthToday(p) < limits.Bandwidth {
+		return nil
+	}
+	retry := max(int(time.Until(nextUTCDay(time.Now())).Seconds()), 1)
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retry))
+	quotaExceeded(p, errBandwidthQuota)
+	return errBandwidthQuota
+}
+
+// quotaExceeded publishes a refusal of principal p, once per cooldown for
+// each principal and quota
+func quotaExceeded(p string, err error) {
+	publish(event{Kind: eventQuotaExceeded, Text: fmt.Sprintf("%s refused: %v", p, err), key: p + " " + err.Error()})
+}
+
+// recordBandwidth charges n bytes served for rec to its creator
+func recordBandwidth(rec archiveRecord, n int64) {
+	p := principal(rec.Owner, rec.ClientIP)
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+	today := utcDay(time.Now())
+	u := bandwidthUsed[p]
+	if u.day != today {
+		u = dailyUsage{day: today}
+	}
+	u.bytes += n
+	bandwidthUsed[p] = u
+
+	// Yesterday's counters are no longer needed
+	for other, used := range bandwidthUsed {
+		if used.day != today {
+			delete(bandwidthUsed, other)
+		}
+	}
+}
+
+// quotaUsage is one quota's consumption; a limit of 0 is unlimited
+type quotaUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+// quotaStatusResponse reports the caller's quotas
+type quotaStatusResponse struct {
+	Jobs      quotaUsage `json:"jobs"`
+	Storage   quotaUsage `json:"storage"`
+	Bandwidth quotaUsage `json:"bandwidth"`
+
+	// When the bandwidth count starts over
+	BandwidthResetsAt time.Time `json:"bandwidthResetsAt"`
+}
+
+// quotaStatus reports principal p's usage against its limits
+func quotaStatus(p string) quotaStatusResponse {
+	limits := quotaFor(p)
+	quotaMutex.Lock()
+	jobs := activeJobs[p]
+	quotaMutex.Unlock()
+	return quotaStatusResponse{
+		Jobs:              quotaUsage{Used: int64(jobs), Limit: int64(limits.Jobs)},
+		Storage:           quotaUsage{Used: storedBytesFor(p), Limit: limits.Storage},
+		Bandwidth:         quotaUsage{Used: bandwidthToday(p), Limit: limits.Bandwidth},
+		BandwidthResetsAt: nextUTCDay(time.Now()),
+	}
+}
+
+// handleAPIQuota returns the caller's quota usage
+func handleAPIQuota(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, quotaStatus(requestPrincipal(c)))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+	"github.com/labstack/echo/v4"
+)
+
+// recompressOptions selects the output of a recompression
+type recompressOptions struct {
+	// One of the archiveFormats keys
+	Format string
+
+	// Compression level: 0-9 for zip, tar.gz and 7z (0 stores), 1-22 for
+	// tar.zst, none for tar; -1 uses the format's default
+	Level int
+
+	// Encrypts ZIP output with WinZip AES-256; empty leaves it unencrypted
+	ZipPassword string
+}
+
+// handleRecompress re-emits an uploaded ZIP with a different format,
+// compression level or password
+func handleRecompress(c echo.Context) error {
+	// Recompressing stores a new archive, so it counts against the same quotas
+	releaseQuota, err := admitQuota(c, c.Request().ContentLength)
+	if err != nil {
+		log.Printf("Recompression rejected by quota: %v", err)
+		return htmlError(c, quotaErrorStatus(err), "Error: %s", err)
+	}
+	defer releaseQuota()
+
+	upload, err := c.FormFile("archive")
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: No archive selected")
+	}
+	if err := checkUploadLimits([]*multipart.FileHeader{upload}); err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+
+	ropts, err := parseRecompressOptions(c)
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+
+	src, err := upload.Open()
+	if err != nil {
+		log.Printf("Error opening uploaded archive: %v", err)
+		return htmlError(c, http.StatusInternalServerError, "Error: Could not read the archive")
+	}
+	defer src.Close()
+
+	zr, err := zip.NewReader(src, upload.Size)
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s is not a valid ZIP archive", upload.Filename)
+	}
+
+	// The output holds the uncompressed contents in the worst case
+	guard := safeextract.NewGuard(inputLimits(config.MaxUploadSize), upload.Size)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := guard.Add(int64(f.CompressedSize64), int64(f.UncompressedSize64)); err != nil {
+			return htmlError(c, http.StatusBadRequest, "Error: %s", translate(c, limitError(err).msg))
+		}
+	}
+	release, err := reserveDisk(guard.Declared())
+	if err != nil {
+		return htmlError(c, http.StatusInsufficientStorage, "Error: %s", err)
+	}
+	defer release()
+
+	ttl, err := parseExpiry(c.FormValue("expires"))
+	if err != nil {
+		return htmlError(c, http.StatusBadRequest, "Error: %s", err)
+	}
+	opts := archiveOptions{Owner: currentUser(c), Password: c.FormValue("link_password"), TTL: ttl, ClientIP: c.RealIP(), APIKey: currentAPIKey(c)}
+	name := archiveName([]archiveEntry{{Name: upload.Filename}}, opts, archiveFormats[ropts.Format].Extension())
+	defer releaseArchiveName(name)
+	if err := recompressArchive(zr, name, ropts, opts); err != nil {
+		log.Printf("Recompression of %s failed: %v", upload.Filename, err)
+		return htmlError(c, http.StatusInternalServerError, "%s", translate(c, archiveErrorMessage(err)))
+	}
+
+	return c.Render(http.StatusOK, "success", downloadLinkData(c, translate(c, "Archive successfully recompressed!"), name, nil))
+}
+
+// parseRecompressOptions validates the format, level and zip_password fields
+func parseRecompressOptions(c echo.Context) (recompressOptions, error) {
+	ropts := recompressOptions{
+		Format:      c.FormValue("format"),
+		Level:       -1,
+		ZipPassword: c.FormValue("zip_password"),
+	}
+	if ropts.Format == "" {
+		ropts.Format = "zip"
+	}
+	format, ok := archiveFormats[ropts.Format]
+	if !ok {
+		return ropts, fmt.Errorf("Unsupported format %q", ropts.Format)
+	}
+
+	if v := c.FormValue("level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || !format.acceptsLevel(level) {
+			return ropts, fmt.Errorf("Invalid compression level %q for %s", v, ropts.Format)
+		}
+		ropts.Level = level
+	}
+
+	if ropts.ZipPassword != "" && !format.passwords {
+		return ropts, fmt.Errorf("Passwords are only supported for ZIP output")
+	}
+	return ropts, nil
+}
+
+// recompressArchive writes the members of zr into a new archive registered
+// under name. Member paths are checked the same way as when merging.
+func recompressArchive(zr *zip.Reader, name string, ropts recompressOptions, opts archiveOptions) error {
+	format := archiveFormats[ropts.Format]
+	tempFile, err := os.CreateTemp(spillDir(), "archive-*"+format.Extension())
+	if err != nil {
+		return &archiveError{"Error creating temporary file", err}
+	}
+	defer tempFile.Close()
+
+	w, key, err := sealArchive(tempFile)
+	var a Archiver
+	if err == nil {
+		a, err = format.newArchiver(w, archiverOptions{Level: ropts.Level, Password: ropts.ZipPassword})
+		if err != nil {
+			err = &archiveError{"Error starting compression", err}
+		}
+	}
+	if err == nil {
+		err = rewriteArchive(a, zr)
+	}
+	if err == nil {
+		err = registerArchive(name, tempFile, key, opts)
+	}
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	log.Printf("Recompressed archive created: %s (path: %s)", name, tempFile.Name())
+	members := make([]archiveEntry, len(zr.File))
+	for i, f := range zr.File {
+		members[i].Name = f.Name
+	}
+	auditCreation(name, members, opts)
+	return nil
+}
+
+// rewriteArchive copies the members of zr into a and finalizes it
+func rewriteArchive(a Archiver, zr *zip.Reader) error {
+	abort := func() {
+		if ab, ok := a.(archiveAborter); ok {
+			ab.Abort()
+		}
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		memberName, ok := checkedMember("the archive", f.Name, f.Mode())
+		if !ok {
+			continue
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			abort()
+			return &archiveError{fmt.Sprintf("Error reading %s from the archive", f.Name), err}
+		}
+		err = a.AddEntry(archiveMember{
+			Name:    memberName,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			Comment: f.Comment,
+		}, src)
+		src.Close()
+		if err != nil {
+			abort()
+			return &archiveError{fmt.Sprintf("Error adding %s to the archive", memberName), err}
+		}
+	}
+
+	if err := a.Finalize(); err != nil {
+		var ae *archiveError
+		if errors.As(err, &ae) {
+			return err
+		}
+		return &archiveError{"Error finalizing the archive", err}
+	}
+	return nil
+}
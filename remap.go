@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// parsePathMap decodes a JSON object mapping original filenames to paths
+// inside the archive. A target ending in "/" places the file, under its
+// original name, in that folder.
+func parsePathMap(raw string) (map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var paths map[string]string
+	if err := json.Unmarshal([]byte(raw), &paths); err != nil {
+		return nil, fmt.Errorf("Invalid path mapping: %v", err)
+	}
+	if err := validatePathMap(paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// validatePathMap rejects targets that are empty or would escape the archive root
+func validatePathMap(paths map[string]string) error {
+	for from, to := range paths {
+		check := to
+		if strings.HasSuffix(to, "/") {
+			check = to + path.Base(from)
+		}
+		if _, err := safeMemberName(check); err != nil || strings.TrimSpace(to) == "" {
+			return fmt.Errorf("Invalid archive path %q for %s", to, from)
+		}
+	}
+	return nil
+}
+
+// mappedName returns the archive path paths gives name, or name itself
+func mappedName(name string, paths map[string]string) string {
+	to, ok := paths[name]
+	if !ok {
+		return name
+	}
+	if strings.HasSuffix(to, "/") {
+		to += path.Base(name)
+	}
+	mapped, _ := safeMemberName(to)
+	return mapped
+}
+
+// remapEntries renames entries according to paths, leaving unmapped entries
+// as they are. Two entries ending up under the same name is an error.
+func remapEntries(entries []archiveEntry, paths map[string]string) ([]archiveEntry, error) {
+	remapped := make([]archiveEntry, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		entry.Name = mappedName(entry.Name, paths)
+		if seen[entry.Name] {
+			return nil, &archiveError{fmt.Sprintf("More than one file maps to %s", entry.Name),
+				fmt.Errorf("duplicate archive path %q", entry.Name)}
+		}
+		seen[entry.Name] = true
+		remapped = append(remapped, entry)
+	}
+	return remapped, nil
+}
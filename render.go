@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// templateRenderer renders the pages in BULK_TEMPLATE_DIR and the HTMX
+// fragments in its partials folder through Echo
+type templateRenderer struct {
+	templates *template.Template
+}
+
+// Render executes the named partial with data, translating its {{t "..."}}
+// messages into the request's locale
+func (t *templateRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	tmpl, err := t.templates.Clone()
+	if err != nil {
+		return err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"t": func(format string, args ...interface{}) string {
+			return tr(c, format, args...)
+		},
+		"fetchLog": func() string {
+			p, _ := c.Get(fetchLogContextKey).(string)
+			return p
+		},
+	})
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// loadTemplates parses every page and fragment template
+func loadTemplates() (*templateRenderer, error) {
+	funcs := template.FuncMap{
+		"formatBytes": bytes.Format,
+		"t":           fmt.Sprintf,
+		"fetchLog":    func() string { return "" },
+	}
+	tmpl, err := template.New("").Funcs(funcs).ParseGlob(filepath.Join(config.TemplateDir, "partials", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if tmpl, err = tmpl.ParseGlob(filepath.Join(config.TemplateDir, "*.html")); err != nil {
+		return nil, err
+	}
+	return &templateRenderer{templates: tmpl}, nil
+}
+
+// htmlError renders the error fragment with a translated message. Error
+// arguments are translated too when the catalog has their exact text.
+func htmlError(c echo.Context, status int, format string, args ...interface{}) error {
+	return c.Render(status, "error", errorMessage(c, format, args))
+}
+
+// htmlBatchError renders the error fragment followed by the per-file results
+func htmlBatchError(c echo.Context, status int, results []fileResult, format string, args ...interface{}) error {
+	return c.Render(status, "batch_error", batchErrorData{Message: errorMessage(c, format, args), Results: results})
+}
+
+// batchErrorData fills the batch_error fragment
+type batchErrorData struct {
+	Message string
+	Results []fileResult
+}
+
+// errorMessage translates an error format and the error arguments it is filled with
+func errorMessage(c echo.Context, format string, args []interface{}) string {
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			args[i] = translate(c, err.Error())
+		}
+	}
+	return tr(c, format, args...)
+}
+
+// successData fills the success fragment. DownloadURL is empty when no
+// local copy is kept.
+type successData struct {
+	Message     string
+	Delivered   []string
+	DownloadURL string
+	Label       string
+	QRURL       string
+	DeletePath  string
+	DeleteURL   string
+
+	// Per-file outcome, shown when some files were skipped or failed
+	Results []fileResult
+
+	// Archives of an upload split by grouping rules, in place of DownloadURL
+	Archives []groupArchive
+}
+
+// htmlSuccess renders the success fragment with just a translated message
+func htmlSuccess(c echo.Context, message string) error {
+	return c.Render(http.StatusOK, "success", successData{Message: translate(c, message)})
+}
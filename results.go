@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"mime/multipart"
+)
+
+// fileStatus is what became of one file of a batch
+type fileStatus string
+
+const (
+	fileAdded   fileStatus = "added"
+	fileSkipped fileStatus = "skipped"
+	fileFailed  fileStatus = "failed"
+)
+
+// fileResult reports what became of one file of a batch and why
+type fileResult struct {
+	Name   string     `json:"name"`
+	Status fileStatus `json:"status"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// failFunc is told about a file that could not be read or fetched. It
+// returns true to leave the file out and carry on with the rest of the
+// batch, or false to abort.
+type failFunc func(name string, err error) bool
+
+// batchFailures collects the files left out of a batch. In strict mode the
+// first failure aborts the batch instead.
+type batchFailures struct {
+	strict  bool
+	results []fileResult
+}
+
+// fail records name as failed unless the batch is strict; it is a failFunc
+func (b *batchFailures) fail(name string, err error) bool {
+	if b == nil || b.strict {
+		return false
+	}
+	log.Printf("Leaving %s out of the batch: %v", name, err)
+	b.results = append(b.results, fileResult{Name: name, Status: fileFailed, Reason: err.Error()})
+	return true
+}
+
+// countAdded counts the files that made it into the archive
+func countAdded(results []fileResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == fileAdded {
+			n++
+		}
+	}
+	return n
+}
+
+// allAdded reports whether no file was skipped or failed
+func allAdded(results []fileResult) bool {
+	return countAdded(results) == len(results)
+}
+
+// addedResults reports the selected entries as added, except those in failed,
+// which are reported with their failure instead
+func addedResults(selected []archiveEntry, failed []fileResult) []fileResult {
+	byName := make(map[string]fileResult, len(failed))
+	for _, r := range failed {
+		byName[r.Name] = r
+	}
+	out := make([]fileResult, 0, len(selected))
+	for _, e := range selected {
+		if r, ok := byName[e.Name]; ok {
+			out = append(out, r)
+			continue
+		}
+		out = append(out, fileResult{Name: e.Name, Status: fileAdded})
+	}
+	return out
+}
+
+// withoutFailed returns the entries that were not reported in failed
+func withoutFailed(entries []archiveEntry, failed []fileResult) []archiveEntry {
+	if len(failed) == 0 {
+		return entries
+	}
+	skip := make(map[string]bool, len(failed))
+	for _, r := range failed {
+		skip[r.Name] = true
+	}
+	out := make([]archiveEntry, 0, len(entries))
+	for _, e := range entries {
+		if !skip[e.Name] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// dropOversized leaves out uploads larger than BULK_MAX_FILE_SIZE, recording
+// each as failed. A strict batch returns the first one as an error instead.
+func (b *batchFailures) dropOversized(files []*multipart.FileHeader) ([]*multipart.FileHeader, error) {
+	kept := files[:0:0]
+	for _, file := range files {
+		if err := checkFileSize(file); err != nil {
+			if !b.fail(sanitizeEntryName(file.Filename), err) {
+				return nil, err
+			}
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// What happens to an archive once it has been downloaded
+const (
+	retainDelete = "delete" // the link works once
+	retainTTL    = "ttl"    // the link keeps working for BULK_AFTER_DOWNLOAD_TTL
+	retainKeep   = "keep"   // the link keeps working until the archive expires
+)
+
+// setupRetention checks the post-download retention policy
+func setupRetention() error {
+	switch config.AfterDownload {
+	case retainDelete, retainKeep:
+		return nil
+	case retainTTL:
+		if config.AfterDownloadTTL <= 0 {
+			return fmt.Errorf("BULK_AFTER_DOWNLOAD=ttl needs a positive BULK_AFTER_DOWNLOAD_TTL")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown post-download policy %q", config.AfterDownload)
+	}
+}
+
+// reusableLinks reports whether a download leaves the link working
+func reusableLinks() bool {
+	return config.AfterDownload != retainDelete
+}
+
+// claimDownload returns the unexpired archive registered under name for a
+// download. Under the delete policy the archive leaves the store so nobody
+// else can claim it; under the ttl policy the first download shortens its
+// expiry to BULK_AFTER_DOWNLOAD_TTL from now.
+func claimDownload(name string) (archiveRecord, bool) {
+	switch config.AfterDownload {
+	case retainDelete:
+		return takeArchive(name)
+	case retainTTL:
+		storeMutex.Lock()
+		defer storeMutex.Unlock()
+		now := time.Now()
+		rec, ok := tempFileStore[name]
+		if !ok || rec.expired(now) {
+			return archiveRecord{}, false
+		}
+		if until := now.Add(config.AfterDownloadTTL); rec.ExpiresAt.IsZero() || rec.ExpiresAt.After(until) {
+			rec.ExpiresAt = until
+			tempFileStore[name] = rec
+		}
+		return rec, true
+	}
+	return getArchive(name)
+}
+
+// finishDownload disposes of a claimed archive once it has been sent, keeping
+// it restorable for keep. Archives that stay in the store are left alone.
+func finishDownload(name string, rec archiveRecord, keep time.Duration) {
+	if config.AfterDownload == retainDelete {
+		discardArchive(name, rec, keep)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// robotsAgent is the product token matched against robots.txt user-agent lines
+const robotsAgent = "bulk-download"
+
+// robotsRules are the Allow/Disallow lines that apply to this fetcher on one host
+type robotsRules struct {
+	rules []robotsRule
+
+	// Set when robots.txt could not be read because of a server error
+	disallowAll bool
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins and Allow wins ties, as in RFC 9309.
+func (r *robotsRules) allowed(path string) bool {
+	if r.disallowAll {
+		return false
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if rule.pattern.MatchString(path) && (rule.length > best || (rule.length == best && rule.allow)) {
+			best, allow = rule.length, rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsCache fetches robots.txt once per host for the duration of one scrape
+type robotsCache map[string]*robotsRules
+
+// allowed reports whether u may be fetched according to its host's robots.txt
+func (rc robotsCache) allowed(ctx context.Context, u *url.URL) bool {
+	key := u.Scheme + "://" + u.Host
+	rules, ok := rc[key]
+	if !ok {
+		rules = fetchRobots(ctx, key+"/robots.txt")
+		rc[key] = rules
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return rules.allowed(p)
+}
+
+// fetchRobots downloads and parses a robots.txt. A missing file allows
+// everything; server errors and unreachable hosts disallow everything.
+func fetchRobots(ctx context.Context, robotsURL string) *robotsRules {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return &robotsRules{disallowAll: true}
+	}
+	req.Header.Set("User-Agent", robotsAgent)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return &robotsRules{disallowAll: true}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return &robotsRules{disallowAll: true}
+	case resp.StatusCode != http.StatusOK:
+		return &robotsRules{}
+	}
+	return parseRobots(io.LimitReader(resp.Body, 512*1024))
+}
+
+// parseRobots returns the rules of the group for robotsAgent, falling back to
+// the "*" group
+func parseRobots(r io.Reader) *robotsRules {
+	var specific, generic []robotsRule
+	var haveSpecific bool
+	var groupAgents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				groupAgents, inRules = nil, false
+			}
+			groupAgents = append(groupAgents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // an empty Disallow allows everything
+			}
+			rule := robotsRule{allow: key == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, agent := range groupAgents {
+				switch {
+				case agent == "*":
+					generic = append(generic, rule)
+				case strings.Contains(robotsAgent, agent):
+					specific = append(specific, rule)
+					haveSpecific = true
+				}
+			}
+		}
+	}
+
+	if haveSpecific {
+		return &robotsRules{rules: specific}
+	}
+	return &robotsRules{rules: generic}
+}
+
+// robotsPattern compiles a robots.txt path pattern, where * matches any
+// characters and a trailing $ anchors the end
+func robotsPattern(value string) *regexp.Regexp {
+	anchored := strings.HasSuffix(value, "$")
+	value = strings.TrimSuffix(value, "$")
+	parts := strings.Split(value, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
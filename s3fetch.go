@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// setupS3Fetch accepts s3:// URLs in fetch lists once BULK_FETCH_S3_BUCKETS
+// names the buckets they may read from
+func setupS3Fetch() error {
+	if len(config.FetchS3Buckets) == 0 {
+		return nil
+	}
+	if config.S3AccessKey == "" || config.S3SecretKey == "" {
+		return fmt.Errorf("BULK_FETCH_S3_BUCKETS needs BULK_S3_ACCESS_KEY and BULK_S3_SECRET_KEY")
+	}
+	fetchSchemes["s3"] = true
+	return nil
+}
+
+// fetchS3 downloads the object at u, an s3://bucket/key URL, with the
+// configured S3 credentials. Only the buckets in BULK_FETCH_S3_BUCKETS can be read.
+func fetchS3(ctx context.Context, u *url.URL, maxSize int64) (fetchedFile, error) {
+	if !slices.Contains(config.FetchS3Buckets, u.Host) {
+		return fetchedFile{}, fmt.Errorf("bucket %s is not allowed", u.Host)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return fetchedFile{}, errors.New("path is not an object")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.FetchTimeout)
+	defer cancel()
+	objectURL := *newS3Target(&url.URL{Host: u.Host}).base
+	objectURL.Path += key
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL.String(), nil)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	signS3(req, config.S3AccessKey, config.S3SecretKey, config.S3SessionToken, config.S3Region, s3UnsignedPayload, time.Now())
+
+	start := time.Now()
+	resp, err := deliveryClient.Do(req)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	defer resp.Body.Close()
+	noteFetch(ctx, func(e *fetchLogEntry) { e.Status = resp.StatusCode })
+	if resp.StatusCode != http.StatusOK {
+		return fetchedFile{}, fmt.Errorf("S3 returned %s", resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return fetchedFile{}, errFetchTooLarge
+	}
+
+	spoolPath, n, _, err := spoolBody(fetchReader(ctx, resp.Body), spillDir(), maxSize)
+	if err != nil {
+		return fetchedFile{}, err
+	}
+	log.Printf("Fetched %s (%s in %s)", u, bytes.Format(n), time.Since(start).Round(time.Millisecond))
+	return fetchedFile{URL: u.String(), Name: path.Base(key), Path: spoolPath, Size: n,
+		ModTime: lastModified(resp), temp: true}, nil
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/robfig/cron/v3"
+)
+
+// schedule is a recurring bundle job defined by an admin. Each run replaces
+// the archive served at /scheduled/<name>.
+type schedule struct {
+	Name string `json:"name"`
+
+	// Standard five-field cron expression or descriptor such as @daily
+	Cron string `json:"cron"`
+
+	// Remote files to fetch and local files to include on each run
+	URLs []string `json:"urls,omitempty"`
+	Glob string   `json:"glob,omitempty"`
+
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+	LastSize  int64      `json:"lastSize,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// scheduledJob is a registered schedule and its cron entry
+type scheduledJob struct {
+	schedule
+	entry   cron.EntryID
+	running bool
+}
+
+// scheduleNamePattern restricts names to something safe in URLs and file names
+var scheduleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	schedules     = make(map[string]*scheduledJob)
+	scheduleMutex sync.Mutex
+	scheduler     = cron.New()
+)
+
+// schedulesPath is the file schedules are persisted to
+func schedulesPath() string {
+	return filepath.Join(config.DataDir, "schedules.json")
+}
+
+// scheduledArchivePath is where the latest output of a schedule is kept
+func scheduledArchivePath(name string) string {
+	return filepath.Join(config.DataDir, "scheduled", name+".zip")
+}
+
+// startScheduler loads the saved schedules and starts running them
+func startScheduler() error {
+	scheduler.Start()
+
+	data, err := os.ReadFile(schedulesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading schedules: %w", err)
+	}
+	if err == nil {
+		var saved []schedule
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("parsing schedules: %w", err)
+		}
+		scheduleMutex.Lock()
+		for _, s := range saved {
+			if err := registerScheduleLocked(s); err != nil {
+				log.Printf("Skipping schedule %s: %v", s.Name, err)
+			}
+		}
+		pinScheduledURLsLocked()
+		scheduleMutex.Unlock()
+		log.Printf("Loaded %d schedules", len(schedules))
+	}
+	return nil
+}
+
+// stopScheduler stops starting new runs and waits for running ones to finish
+func stopScheduler() {
+	<-scheduler.Stop().Done()
+}
+
+// validateSchedule checks the name, cron expression and sources of s
+func validateSchedule(s schedule) error {
+	if !scheduleNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid schedule name %q", s.Name)
+	}
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %v", err)
+	}
+	if len(s.URLs) == 0 && s.Glob == "" {
+		return fmt.Errorf("a schedule needs urls or a glob")
+	}
+	if _, err := parseURLList(s.URLs); err != nil {
+		return err
+	}
+	if _, err := filepath.Match(s.Glob, ""); err != nil {
+		return fmt.Errorf("invalid glob: %v", err)
+	}
+	return nil
+}
+
+// registerScheduleLocked adds or replaces the cron entry for s
+func registerScheduleLocked(s schedule) error {
+	if err := validateSchedule(s); err != nil {
+		return err
+	}
+	name := s.Name
+	entry, err := scheduler.AddFunc(s.Cron, func() { runSchedule(name) })
+	if err != nil {
+		return err
+	}
+	if old, ok := schedules[name]; ok {
+		scheduler.Remove(old.entry)
+		s.LastRun, s.LastSize, s.LastError = old.LastRun, old.LastSize, old.LastError
+	}
+	schedules[name] = &scheduledJob{schedule: s, entry: entry}
+	return nil
+}
+
+// pinScheduledURLsLocked keeps the fetch cache from evicting the files of
+// scheduled bundles between runs, so each run can revalidate them instead of
+// downloading them again
+func pinScheduledURLsLocked() {
+	var urls []string
+	for _, job := range schedules {
+		for _, line := range job.URLs {
+			if t, err := parseFetchTarget(line); err == nil {
+				urls = append(urls, t.mirrors...)
+			}
+		}
+	}
+	fetchCache.pin(urls)
+}
+
+// saveSchedulesLocked persists the schedule definitions and last results
+func saveSchedulesLocked() {
+	list := listSchedulesLocked()
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(config.DataDir, 0o750)
+	}
+	if err == nil {
+		tmp := schedulesPath() + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, schedulesPath())
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving schedules: %v", err)
+	}
+}
+
+// listSchedulesLocked returns the schedules sorted by name
+func listSchedulesLocked() []schedule {
+	list := make([]schedule, 0, len(schedules))
+	for _, job := range schedules {
+		list = append(list, job.schedule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// runSchedule builds the archive for a schedule, skipping the run if the
+// previous one is still going
+func runSchedule(name string) {
+	scheduleMutex.Lock()
+	job, ok := schedules[name]
+	if !ok || job.running {
+		scheduleMutex.Unlock()
+		return
+	}
+	job.running = true
+	s := job.schedule
+	scheduleMutex.Unlock()
+
+	log.Printf("Running schedule %s", name)
+	size, err := buildScheduledArchive(s)
+	if err != nil {
+		log.Printf("Schedule %s failed: %v", name, err)
+	} else {
+		log.Printf("Schedule %s produced %s (%d bytes)", name, scheduledArchivePath(name), size)
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	job.running = false
+	now := time.Now()
+	job.LastRun = &now
+	job.LastError = ""
+	if err != nil {
+		job.LastError = err.Error()
+	} else {
+		job.LastSize = size
+	}
+	saveSchedulesLocked()
+}
+
+// buildScheduledArchive collects the files of s into a new archive and
+// swaps it in place of the previous output
+func buildScheduledArchive(s schedule) (int64, error) {
+	entries, err := globEntries(s.Glob)
+	if err != nil {
+		return 0, err
+	}
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
+	if len(s.URLs) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), config.FetchTimeout*time.Duration(len(s.URLs)))
+		defer cancel()
+		fetched, cleanup, err := fetchURLs(ctx, s.URLs, config.MaxUploadSize, defaultFetchPolicy(), nil)
+		if err != nil {
+			return 0, err
+		}
+		defer cleanup()
+		for _, entry := range fetched {
+			totalSize += entry.Size
+		}
+		entries = append(entries, fetched...)
+	}
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("no files matched")
+	}
+
+	release, err := reserveDisk(totalSize)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	dest := scheduledArchivePath(s.Name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), s.Name+"-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	releaseWorker, _ := compressWorkers.acquire(context.Background(), priorityBatch)
+	err = writeZip(tmp, entries, "", nil, nil)
+	releaseWorker()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// globEntries returns an entry for every regular file matching pattern, named
+// relative to the pattern's fixed leading directory
+func globEntries(pattern string) ([]archiveEntry, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	base := globBase(pattern)
+
+	var entries []archiveEntry
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		name, err := filepath.Rel(base, m)
+		if err != nil {
+			name = filepath.Base(m)
+		}
+		p := m
+		entries = append(entries, archiveEntry{
+			Name: filepath.ToSlash(name),
+			Size: info.Size(),
+			Open: func() (io.ReadCloser, error) { return os.Open(p) },
+
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+		})
+	}
+	return entries, nil
+}
+
+// globBase returns the directory part of pattern before the first wildcard
+func globBase(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// handleAdminListSchedules lists the defined schedules and their last results
+func handleAdminListSchedules(c echo.Context) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	return c.JSON(http.StatusOK, listSchedulesLocked())
+}
+
+// handleAdminPutSchedule creates or replaces a schedule from a JSON body
+func handleAdminPutSchedule(c echo.Context) error {
+	var s schedule
+	if err := c.Bind(&s); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body")
+	}
+	s.Name = c.Param("name")
+	s.LastRun, s.LastSize, s.LastError = nil, 0, ""
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	if err := registerScheduleLocked(s); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	pinScheduledURLsLocked()
+	saveSchedulesLocked()
+	log.Printf("Schedule %s set to %q", s.Name, s.Cron)
+	return c.JSON(http.StatusOK, schedules[s.Name].schedule)
+}
+
+// handleAdminDeleteSchedule removes a schedule and its latest archive
+func handleAdminDeleteSchedule(c echo.Context) error {
+	name := c.Param("name")
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	job, ok := schedules[name]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "schedule not found")
+	}
+	scheduler.Remove(job.entry)
+	delete(schedules, name)
+	pinScheduledURLsLocked()
+	saveSchedulesLocked()
+	os.Remove(scheduledArchivePath(name))
+	log.Printf("Schedule %s deleted", name)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleAdminRunSchedule starts a run of a schedule immediately
+func handleAdminRunSchedule(c echo.Context) error {
+	name := c.Param("name")
+	scheduleMutex.Lock()
+	_, ok := schedules[name]
+	scheduleMutex.Unlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "schedule not found")
+	}
+	go runSchedule(name)
+	return c.NoContent(http.StatusAccepted)
+}
+
+// handleScheduledDownload serves the latest archive of a schedule. Unlike
+// regular downloads the file stays in place for the next request.
+func handleScheduledDownload(c echo.Context) error {
+	name := strings.TrimSuffix(c.Param("name"), ".zip")
+	if !scheduleNamePattern.MatchString(name) {
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+	scheduleMutex.Lock()
+	_, ok := schedules[name]
+	scheduleMutex.Unlock()
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "File not found or expired")
+	}
+
+	path := scheduledArchivePath(name)
+	if _, err := os.Stat(path); err != nil {
+		return htmlError(c, http.StatusNotFound, "This schedule has not produced an archive yet")
+	}
+	// Scheduled archives are shared links, so many clients may pull one at once
+	release, err := activeDownloads.acquire(c.Request().Context(), "scheduled/"+name)
+	if err != nil {
+		log.Printf("Download of scheduled archive %s refused: %v", name, err)
+		return downloadsBusy(c)
+	}
+	defer release()
+
+	c.Response().Header().Set("Content-Disposition", contentDisposition(name+".zip"))
+	recordAudit(auditEvent{
+		Archive:  "scheduled/" + name,
+		Action:   auditDownloaded,
+		User:     currentUser(c),
+		ClientIP: c.RealIP(),
+	})
+	return c.File(path)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultScrapeLimit is how many assets a scrape collects unless the form asks for more
+const defaultScrapeLimit = 100
+
+// maxSitemapPages caps the pages and nested sitemaps a sitemap scrape visits
+const maxSitemapPages = 200
+
+// assetAttrs are the elements and attributes scanned for asset links
+var assetAttrs = map[string]string{
+	"a":      "href",
+	"img":    "src",
+	"source": "src",
+	"video":  "src",
+	"audio":  "src",
+	"embed":  "src",
+	"object": "data",
+	"link":   "href",
+}
+
+// sitemapDoc covers both a sitemap urlset and a sitemap index
+type sitemapDoc struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// scrapeFromForm scrapes the page or sitemap at rawURL using the
+// scrape_pattern and scrape_limit form fields
+func scrapeFromForm(c echo.Context, rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL: %s", redactURL(rawURL))
+	}
+
+	pattern := strings.TrimSpace(c.FormValue("scrape_pattern"))
+	if pattern == "" {
+		return nil, fmt.Errorf("Enter a pattern for the files to collect, e.g. *.pdf")
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, fmt.Errorf("Invalid pattern %q", pattern)
+	}
+
+	maxLimit := maxCrawlFiles
+	if config.MaxFiles > 0 {
+		maxLimit = config.MaxFiles
+	}
+	limit := min(defaultScrapeLimit, maxLimit)
+	if v := c.FormValue("scrape_limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxLimit {
+			return nil, fmt.Errorf("Link limit must be between 1 and %d", maxLimit)
+		}
+	}
+
+	assets, err := scrapeAssets(c.Request().Context(), u, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("No matching files found on the page")
+	}
+	return assets, nil
+}
+
+// scrapeAssets returns up to limit URLs linked from the page at start whose
+// path matches pattern. When start is a sitemap, matching entries are taken
+// directly and every other listed page is scraped in turn. Pages and assets
+// disallowed by the host's robots.txt are skipped.
+func scrapeAssets(ctx context.Context, start *url.URL, pattern string, limit int) ([]string, error) {
+	robots := robotsCache{}
+	if !robots.allowed(ctx, start) {
+		return nil, fmt.Errorf("robots.txt does not allow fetching %s", redactURL(start.String()))
+	}
+
+	var assets []string
+	seen := map[string]bool{}
+	// add records a matching asset and reports whether the limit has been reached
+	add := func(u *url.URL) bool {
+		u.Fragment = ""
+		s := u.String()
+		if seen[s] || !scrapeMatch(pattern, u) {
+			return false
+		}
+		seen[s] = true
+		if !robots.allowed(ctx, u) {
+			return false
+		}
+		assets = append(assets, s)
+		return len(assets) >= limit
+	}
+
+	queue := []string{start.String()}
+	visited := map[string]bool{}
+	for pages := 0; len(queue) > 0 && pages < maxSitemapPages; pages++ {
+		next := queue[0]
+		queue = queue[1:]
+		if visited[next] {
+			continue
+		}
+		visited[next] = true
+
+		base, ct, body, err := fetchPage(ctx, next)
+		if err != nil {
+			if pages == 0 {
+				return nil, fmt.Errorf("Error fetching %s: %v", redactURL(next), err)
+			}
+			log.Printf("Error scraping %s: %v", redactURL(next), err)
+			continue
+		}
+
+		if isSitemap(ct, body) {
+			var doc sitemapDoc
+			if err := xml.Unmarshal(body, &doc); err != nil {
+				if pages == 0 {
+					return nil, fmt.Errorf("Invalid sitemap: %v", err)
+				}
+				continue
+			}
+			queue = append(queue, doc.Sitemaps...)
+			for _, loc := range doc.URLs {
+				u, err := base.Parse(strings.TrimSpace(loc))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					continue
+				}
+				if scrapeMatch(pattern, u) {
+					if add(u) {
+						return assets, nil
+					}
+				} else if robots.allowed(ctx, u) {
+					queue = append(queue, u.String())
+				}
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(ct, "text/html") {
+			if pages == 0 {
+				return nil, fmt.Errorf("%s is not a web page or sitemap (%s)", redactURL(next), ct)
+			}
+			continue
+		}
+		links, err := extractLinks(bytes.NewReader(body), assetAttrs)
+		if err != nil {
+			log.Printf("Error parsing %s: %v", redactURL(next), err)
+		}
+		for _, link := range links {
+			u, err := base.Parse(strings.TrimSpace(link))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			if add(u) {
+				log.Printf("Scrape of %s stopped at %d links", redactURL(start.String()), limit)
+				return assets, nil
+			}
+		}
+	}
+	return assets, nil
+}
+
+// scrapeMatch reports whether an asset URL matches pattern. Patterns with a
+// slash are matched against the whole path, others against the file name;
+// matching ignores case.
+func scrapeMatch(pattern string, u *url.URL) bool {
+	p := strings.ToLower(strings.TrimPrefix(u.Path, "/"))
+	pattern = strings.ToLower(pattern)
+	if strings.Contains(pattern, "/") {
+		return matchGlob(strings.TrimPrefix(pattern, "/"), p)
+	}
+	ok, _ := path.Match(pattern, path.Base("/"+p))
+	return ok
+}
+
+// isSitemap reports whether a fetched page is an XML sitemap
+func isSitemap(contentType string, body []byte) bool {
+	if !strings.Contains(contentType, "xml") && !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<?xml")) {
+		return false
+	}
+	return bytes.Contains(body, []byte("<urlset")) || bytes.Contains(body, []byte("<sitemapindex"))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// defaultCSP allows the page's own scripts, htmx from unpkg and inline QR
+// and style use, and forbids framing
+const defaultCSP = "default-src 'self'; script-src 'self' 'unsafe-inline' https://unpkg.com; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'; base-uri 'self'"
+
+// csrfCookie holds the CSRF token that forms and htmx requests send back,
+// read by static/csrf.js
+const csrfCookie = "bulk_csrf"
+
+// csrfMiddleware rejects state-changing browser requests that don't carry
+// the token from the CSRF cookie in an X-CSRF-Token header or _csrf field
+func csrfMiddleware() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        skipCSRF,
+		TokenLookup:    "header:X-CSRF-Token,form:_csrf",
+		CookieName:     csrfCookie,
+		CookiePath:     "/",
+		CookieMaxAge:   int(config.SessionTTL.Seconds()),
+		CookieSecure:   len(config.TLSDomains) > 0,
+		CookieSameSite: http.SameSiteLaxMode,
+		ErrorHandler: func(err error, c echo.Context) error {
+			return htmlError(c, http.StatusForbidden, "Error: The page has expired, reload it and try again")
+		},
+	})
+}
+
+// skipCSRF exempts requests that can't be forged by another site: API
+// clients authenticating with a bearer token and scripts that send none of
+// the browser cookies. Safe methods always pass through so the token cookie
+// gets issued.
+func skipCSRF(c echo.Context) bool {
+	r := c.Request()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	for _, name := range []string{sessionCookie, cloudCookie, csrfCookie} {
+		if _, err := r.Cookie(name); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// secureHeaders sets CSP, nosniff, framing and referrer headers on every
+// response, plus HSTS when the server terminates TLS itself
+func secureHeaders() echo.MiddlewareFunc {
+	cfg := middleware.SecureConfig{
+		XSSProtection:         "0",
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         "DENY",
+		ContentSecurityPolicy: config.ContentSecurityPolicy,
+		ReferrerPolicy:        config.ReferrerPolicy,
+	}
+	if len(config.TLSDomains) > 0 {
+		cfg.HSTSMaxAge = 31536000
+	}
+	return middleware.SecureWithConfig(cfg)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// segment is a byte range of a remote file fetched on its own connection
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive
+
+	// Bytes of the range already written to the partial file
+	Done int64 `json:"done"`
+}
+
+// errRemoteChanged is returned when a server no longer honours a range
+// request, usually because the file was replaced since the fetch began
+var errRemoteChanged = errors.New("remote file changed during download")
+
+// rangeable reports whether a response can be fetched with Range requests and
+// resumed: the server advertises byte ranges, the length is known and there is
+// a validator to detect the file changing in between
+func rangeable(resp *http.Response) bool {
+	return resp.ContentLength > 0 && resp.Header.Get("Accept-Ranges") == "bytes" &&
+		resp.Header.Get("Content-Encoding") == "" && ifRangeValidator(resp) != ""
+}
+
+// segmentPlan splits size bytes into config.FetchSegments ranges when the file
+// is at least FetchSegmentMin long, and into a single range otherwise
+func segmentPlan(size int64) []segment {
+	n := int64(1)
+	if config.FetchSegments > 1 && size >= config.FetchSegmentMin {
+		n = int64(config.FetchSegments)
+	}
+	step := (size + n - 1) / n
+	var segs []segment
+	for start := int64(0); start < size; start += step {
+		segs = append(segs, segment{Start: start, End: min(start+step, size) - 1})
+	}
+	return segs
+}
+
+// ifRangeValidator returns the If-Range value that makes segment requests fail
+// over to a full response if the file changes mid-download
+func ifRangeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// fetchRanged downloads a rangeable response into a file in dir, in parallel
+// segments for large files. Progress is kept in a partial download, so
+// transient failures are retried from where each segment stopped and a later
+// fetch of the same URL, even after a restart, picks up the saved bytes. It
+// returns the same values as spoolBody.
+func fetchRanged(ctx context.Context, rawURL string, req *http.Request, resp *http.Response, dir string) (string, int64, string, error) {
+	validator := ifRangeValidator(resp)
+	p, err := openPartial(rawURL, validator, resp.ContentLength)
+	if errors.Is(err, errPartialBusy) {
+		// Another job is fetching the same URL; download this copy in one piece
+		return spoolBody(fetchReader(ctx, resp.Body), dir, resp.ContentLength)
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer p.release()
+
+	// A fresh download reads its first segment from the response already open
+	body := fetchReader(ctx, resp.Body)
+	if p.resumed {
+		body = nil
+		log.Printf("Resuming %s at %s of %s", redactURL(rawURL), bytes.Format(p.completed()), bytes.Format(p.Size))
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = p.download(ctx, req, validator, body)
+		body = nil
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errRemoteChanged) {
+			p.discard()
+			return "", 0, "", err
+		}
+		p.save()
+		if attempt > config.FetchRetries || !transientFetchError(ctx, err) {
+			return "", 0, "", err
+		}
+
+		wait := time.Duration(1<<(attempt-1)) * time.Second
+		noteFetch(ctx, func(e *fetchLogEntry) { e.Retries = attempt })
+		log.Printf("Fetching %s failed (%v), retrying in %s with %s of %s saved",
+			redactURL(rawURL), err, wait, bytes.Format(p.completed()), bytes.Format(p.Size))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", 0, "", ctx.Err()
+		}
+	}
+
+	if len(p.Segments) > 1 {
+		log.Printf("Fetched %s in %d segments", redactURL(rawURL), len(p.Segments))
+	}
+	return p.finish(dir)
+}
+
+// download fetches every unfinished segment of p in parallel. When body is
+// set it supplies the first segment from its start.
+func (p *partialDownload) download(ctx context.Context, req *http.Request, validator string, body io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Checkpoint progress while the download runs so a crash loses little
+	stopSaving := make(chan struct{})
+	defer close(stopSaving)
+	go func() {
+		ticker := time.NewTicker(partialSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.save()
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := range p.Segments {
+		if p.remaining(i) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if i == 0 && body != nil && p.done[0].Load() == 0 {
+				err = p.copySegment(i, body)
+			} else {
+				err = p.fetchSegment(ctx, i, req, validator)
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// fetchSegment requests the unfinished part of segment i and writes it
+func (p *partialDownload) fetchSegment(ctx context.Context, i int, req *http.Request, validator string) error {
+	seg := p.Segments[i]
+	start := seg.Start + p.done[i].Load()
+
+	r := req.Clone(ctx)
+	r.Header.Del("If-None-Match")
+	r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, seg.End))
+	r.Header.Set("If-Range", validator)
+	resp, err := fetchClient.Do(r)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return errRemoteChanged
+	case resp.StatusCode != http.StatusPartialContent:
+		return &fetchStatusError{resp.StatusCode, resp.Status}
+	}
+	want := fmt.Sprintf("bytes %d-%d/%d", start, seg.End, p.Size)
+	if resp.Header.Get("Content-Range") != want {
+		return fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	return p.copySegment(i, fetchReader(ctx, resp.Body))
+}
+
+// copySegment writes the rest of segment i from body into the partial file
+func (p *partialDownload) copySegment(i int, body io.Reader) error {
+	length := p.remaining(i)
+	w := &segmentWriter{file: p.file, off: p.Segments[i].Start + p.done[i].Load(), done: &p.done[i]}
+	n, err := io.Copy(w, io.LimitReader(body, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// segmentWriter writes sequentially at an offset of a file and counts the
+// bytes it has written into done
+type segmentWriter struct {
+	file *os.File
+	off  int64
+	done *atomic.Int64
+}
+
+func (w *segmentWriter) Write(b []byte) (int, error) {
+	n, err := w.file.WriteAt(b, w.off)
+	w.off += int64(n)
+	w.done.Add(int64(n))
+	return n, err
+}
+
+// fetchStatusError is an unexpected HTTP status from a range request
+type fetchStatusError struct {
+	code   int
+	status string
+}
+
+func (e *fetchStatusError) Error() string {
+	return "server returned " + e.status
+}
+
+// transientFetchError reports whether err is worth retrying: dropped
+// connections, timeouts and 5xx or 429 responses, as long as ctx is still live
+func transientFetchError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *fetchStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) ||
+		errors.Is(err, net.ErrClosed) || strings.Contains(err.Error(), "connection reset")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serverDirRequest is the JSON body accepted by POST /admin/dirs
+type serverDirRequest struct {
+	Path    string   `json:"path"`
+	Filter  []string `json:"filter,omitempty"`
+	Expires string   `json:"expires,omitempty"`
+	Note    string   `json:"note,omitempty"`
+}
+
+// serverDirResponse describes the archive built from a server directory
+type serverDirResponse struct {
+	Archive     string `json:"archive"`
+	Files       int    `json:"files"`
+	DownloadURL string `json:"downloadUrl"`
+	DeleteURL   string `json:"deleteUrl"`
+}
+
+// setupServerDirs resolves BULK_SERVER_DIRS to resolved absolute paths, so
+// requests can be checked against them after following symlinks
+func setupServerDirs() error {
+	for i, dir := range config.ServerDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", abs)
+		}
+		config.ServerDirs[i] = resolved
+	}
+	return nil
+}
+
+// resolveServerDir returns the resolved path of dir if it is one of
+// BULK_SERVER_DIRS or lies below one
+func resolveServerDir(dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", fmt.Errorf("path not found")
+	}
+	for _, allowed := range config.ServerDirs {
+		if rel, err := filepath.Rel(allowed, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+				return "", fmt.Errorf("path is not a directory")
+			}
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("path is not in BULK_SERVER_DIRS")
+}
+
+// dirEntries returns an entry for every regular file under root that filter
+// keeps, named relative to root. Symlinks are skipped so nothing outside the
+// directory can be pulled in.
+func dirEntries(root string, filter pathFilter) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Server directory %s: %v", root, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if !filter.match(name) {
+			return nil
+		}
+		entries = append(entries, archiveEntry{
+			Name: name,
+			Size: info.Size(),
+			Open: func() (io.ReadCloser, error) { return os.Open(p) },
+
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// handleAdminArchiveDir archives a directory on the server, such as an app's
+// log folder, and answers with its download link
+func handleAdminArchiveDir(c echo.Context) error {
+	var req serverDirRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body")
+	}
+	dir, err := resolveServerDir(req.Path)
+	if err != nil {
+		log.Printf("Server directory %q refused: %v", req.Path, err)
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	filter, err := parsePathFilter(req.Filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ttl, err := parseExpiry(req.Expires)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	note, err := parseNote(req.Note)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	entries, err := dirEntries(dir, filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if len(entries) == 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "no files matched")
+	}
+	release, err := reserveDisk(entriesSize(entries))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+	}
+	defer release()
+
+	opts := archiveOptions{
+		Owner:    currentUser(c),
+		ClientIP: c.RealIP(),
+		BaseName: filepath.Base(dir),
+		Note:     note,
+		TTL:      ttl,
+	}
+	releaseWorker, err := compressWorkers.acquire(c.Request().Context(), priorityInteractive)
+	if err != nil {
+		return err
+	}
+	result, err := createArchive(c.Request().Context(), entries, opts, nil)
+	releaseWorker()
+	if err != nil {
+		log.Printf("Archiving server directory %s failed: %v", dir, err)
+		return echo.NewHTTPError(archiveErrorStatus(err), archiveErrorMessage(err))
+	}
+	log.Printf("Archived server directory %s as %s (%d files)", dir, result.Name, len(entries))
+
+	return c.JSON(http.StatusOK, serverDirResponse{
+		Archive:     result.Name,
+		Files:       countAdded(result.Files),
+		DownloadURL: downloadPath(result.Name),
+		DeleteURL:   deletePath(result.Name),
+	})
+}
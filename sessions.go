@@ -0,0 +1,556 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+)
+
+// uploadSession collects files sent in separate requests until it is
+// finalized into one archive
+type uploadSession struct {
+	ID        string       `json:"id"`
+	Owner     string       `json:"-"`
+	Files     []stagedFile `json:"files"`
+	Size      int64        `json:"size"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// stagedFile is one file of an upload session, spooled to TempDir
+type stagedFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	path   string
+
+	// Set when the content was already held and the body wasn't read
+	reused bool
+}
+
+// sessionResponse describes an upload session and its staged files
+type sessionResponse struct {
+	uploadSession
+	FinalizeURL string `json:"finalizeUrl"`
+}
+
+// stagedFileResponse acknowledges a file added to an upload session
+type stagedFileResponse struct {
+	UploadID     string `json:"uploadId"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	Deduplicated bool   `json:"deduplicated"`
+	Files        int    `json:"files"`
+	FinalizeURL  string `json:"finalizeUrl"`
+}
+
+var (
+	uploadSessions = make(map[string]*uploadSession)
+	sessionMutex   = &sync.Mutex{}
+)
+
+// errSessionNotFound is returned for unknown, expired or foreign upload sessions
+var errSessionNotFound = errors.New("Upload session not found or expired")
+
+// errStagedFileNotFound is returned when removing a file the session doesn't hold
+var errStagedFileNotFound = errors.New("File not found in upload session")
+
+// stagingLimitError reports a file that would exceed the upload limits
+type stagingLimitError struct{ msg string }
+
+func (e stagingLimitError) Error() string { return e.msg }
+
+// newUploadSession registers an empty session for owner
+func newUploadSession(owner string) *uploadSession {
+	now := time.Now()
+	s := &uploadSession{ID: newJobID(), Owner: owner, Files: []stagedFile{}, CreatedAt: now, UpdatedAt: now}
+	sessionMutex.Lock()
+	uploadSessions[s.ID] = s
+	sessionMutex.Unlock()
+	return s
+}
+
+// sessionForLocked returns the session id belonging to owner; sessionMutex must be held
+func sessionForLocked(id, owner string) (*uploadSession, error) {
+	s, ok := uploadSessions[id]
+	if !ok || (s.Owner != "" && s.Owner != owner) {
+		return nil, errSessionNotFound
+	}
+	return s, nil
+}
+
+// errHashMismatch is returned when an upload doesn't match the hash the client sent
+var errHashMismatch = errors.New("File content does not match X-Content-SHA256")
+
+// stageFile spools body into the session as name, replacing a file staged
+// earlier under the same name. Per-file, per-session and file count limits
+// apply as for a single upload. When the client sent the content's SHA-256
+// and the session already holds it, the stored copy is reused
+// and body is never read.
+func stageFile(id, owner, name string, body io.Reader, sum string) (stagedFile, int, error) {
+	sessionMutex.Lock()
+	s, err := sessionForLocked(id, owner)
+	var held int64
+	if err == nil {
+		held = s.Size
+	}
+	sessionMutex.Unlock()
+	if err != nil {
+		return stagedFile{}, 0, err
+	}
+
+	limit := config.MaxUploadSize - held
+	if config.MaxFileSize > 0 && config.MaxFileSize < limit {
+		limit = config.MaxFileSize
+	}
+	tooLarge := stagingLimitError{fmt.Sprintf("File %s is too large (max %s)", name, bytes.Format(max(limit, 0)))}
+	f, ok := reuseContent(id, sum)
+	if ok && f.Size > max(limit, 0) {
+		os.Remove(f.path)
+		return stagedFile{}, 0, tooLarge
+	}
+	if !ok {
+		spoolPath, n, got, err := spoolBody(body, spillDir(), max(limit, 0))
+		if errors.Is(err, errFetchTooLarge) {
+			return stagedFile{}, 0, tooLarge
+		}
+		if err != nil {
+			return stagedFile{}, 0, err
+		}
+		if sum != "" && sum != got {
+			os.Remove(spoolPath)
+			return stagedFile{}, 0, errHashMismatch
+		}
+		f = stagedFile{Size: n, SHA256: got, path: spoolPath}
+	}
+	f.Name = name
+	spoolPath, n := f.path, f.Size
+
+	// Commit under the lock, rechecking limits against concurrent additions
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if s, err = sessionForLocked(id, owner); err != nil {
+		os.Remove(spoolPath)
+		return stagedFile{}, 0, err
+	}
+	replaced := -1
+	size := s.Size + n
+	for i, existing := range s.Files {
+		if existing.Name == name {
+			replaced = i
+			size -= existing.Size
+		}
+	}
+	if size > config.MaxUploadSize {
+		os.Remove(spoolPath)
+		return stagedFile{}, 0, stagingLimitError{fmt.Sprintf("Total file size too large (max %s)", bytes.Format(config.MaxUploadSize))}
+	}
+	if replaced < 0 && config.MaxFiles > 0 && len(s.Files) >= config.MaxFiles {
+		os.Remove(spoolPath)
+		return stagedFile{}, 0, stagingLimitError{fmt.Sprintf("Too many files (max %d)", config.MaxFiles)}
+	}
+
+	if replaced >= 0 {
+		os.Remove(s.Files[replaced].path)
+		s.Files[replaced] = f
+	} else {
+		s.Files = append(s.Files, f)
+	}
+	s.Size = size
+	s.UpdatedAt = time.Now()
+	return f, len(s.Files), nil
+}
+
+// reuseContent returns a private copy of content with SHA-256 sum that the
+// session already holds. Only the caller's own session is searched: a hash
+// alone proves nothing, so content staged or fetched for anyone else is
+// never handed out on the strength of it.
+func reuseContent(id, sum string) (stagedFile, bool) {
+	if sum == "" {
+		return stagedFile{}, false
+	}
+	var src string
+	var size int64
+	sessionMutex.Lock()
+	if s, ok := uploadSessions[id]; ok {
+		for _, f := range s.Files {
+			if f.SHA256 == sum {
+				src, size = f.path, f.Size
+				break
+			}
+		}
+	}
+	sessionMutex.Unlock()
+	if src == "" {
+		return stagedFile{}, false
+	}
+
+	dst, n, err := cloneFile(src)
+	if err != nil || (size > 0 && n != size) {
+		if err == nil {
+			os.Remove(dst)
+		}
+		return stagedFile{}, false
+	}
+	return stagedFile{Size: n, SHA256: sum, path: dst, reused: true}, true
+}
+
+// cloneFile gives src a second name in TempDir, hard-linking when the file
+// system allows and copying otherwise
+func cloneFile(src string) (string, int64, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", 0, err
+	}
+	dst, err := os.CreateTemp(spillDir(), "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	dstPath := dst.Name()
+	dst.Close()
+	os.Remove(dstPath)
+	if os.Link(src, dstPath) == nil {
+		return dstPath, info.Size(), nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+	spoolPath, n, _, err := spoolBody(in, spillDir(), info.Size())
+	return spoolPath, n, err
+}
+
+// getUploadSession returns a copy of the session for listing
+func getUploadSession(id, owner string) (uploadSession, error) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	s, err := sessionForLocked(id, owner)
+	if err != nil {
+		return uploadSession{}, err
+	}
+	snapshot := *s
+	snapshot.Files = append([]stagedFile{}, s.Files...)
+	return snapshot, nil
+}
+
+// unstageFile removes name from the session
+func unstageFile(id, owner, name string) error {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	s, err := sessionForLocked(id, owner)
+	if err != nil {
+		return err
+	}
+	for i, f := range s.Files {
+		if f.Name == name {
+			os.Remove(f.path)
+			s.Files = append(s.Files[:i], s.Files[i+1:]...)
+			s.Size -= f.Size
+			s.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return errStagedFileNotFound
+}
+
+// takeUploadSession removes the session from the store for finalizing
+func takeUploadSession(id, owner string) (*uploadSession, error) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	s, err := sessionForLocked(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	delete(uploadSessions, id)
+	return s, nil
+}
+
+// removeStagedFiles deletes the spooled files of a session
+func (s *uploadSession) removeStagedFiles() {
+	for _, f := range s.Files {
+		os.Remove(f.path)
+	}
+}
+
+// purgeUploadSessions drops every session match selects along with its files
+func purgeUploadSessions(match func(s *uploadSession) bool) int {
+	sessionMutex.Lock()
+	var purged []*uploadSession
+	for id, s := range uploadSessions {
+		if match(s) {
+			purged = append(purged, s)
+			delete(uploadSessions, id)
+		}
+	}
+	sessionMutex.Unlock()
+	for _, s := range purged {
+		s.removeStagedFiles()
+	}
+	return len(purged)
+}
+
+// pruneUploadSessions drops sessions nobody added to within BULK_UPLOAD_SESSION_TTL
+func pruneUploadSessions(now time.Time) {
+	sessionMutex.Lock()
+	var stale []*uploadSession
+	for id, s := range uploadSessions {
+		if now.Sub(s.UpdatedAt) > config.UploadSessionTTL {
+			stale = append(stale, s)
+			delete(uploadSessions, id)
+		}
+	}
+	sessionMutex.Unlock()
+	for _, s := range stale {
+		log.Printf("Upload session %s expired with %d files", s.ID, len(s.Files))
+		s.removeStagedFiles()
+	}
+}
+
+// handlePutFile stores a raw request body as one file of an upload session.
+// The session comes from the X-Upload-ID header or upload query parameter;
+// without one a new session is started and its ID returned.
+func handlePutFile(c echo.Context) error {
+	name, err := url.PathUnescape(c.Param("name"))
+	if err == nil {
+		name, err = safeMemberName(name)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file name")
+	}
+	if n := c.Request().ContentLength; n > 0 {
+		release, err := reserveDisk(n)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		defer release()
+	}
+
+	owner := currentUser(c)
+	id := c.Request().Header.Get("X-Upload-ID")
+	if id == "" {
+		id = c.QueryParam("upload")
+	}
+	status := http.StatusOK
+	if id == "" {
+		id = newUploadSession(owner).ID
+		status = http.StatusCreated
+	}
+
+	sum := strings.ToLower(c.Request().Header.Get("X-Content-SHA256"))
+	if sum != "" && !validSHA256(sum) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid X-Content-SHA256 header")
+	}
+
+	f, count, err := stageFile(id, owner, name, c.Request().Body, sum)
+	if err != nil {
+		return stagingError(name, err)
+	}
+	if f.reused {
+		log.Printf("Upload session %s reused stored content for %s", id, name)
+	}
+	return c.JSON(status, stagedFileResponse{
+		UploadID:     id,
+		Name:         f.Name,
+		Size:         f.Size,
+		SHA256:       f.SHA256,
+		Deduplicated: f.reused,
+		Files:        count,
+		FinalizeURL:  sessionPath(id) + "/finalize",
+	})
+}
+
+// stagingError maps a stageFile failure to an HTTP error
+func stagingError(name string, err error) error {
+	var limitErr stagingLimitError
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, errHashMismatch):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.As(err, &limitErr):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	default:
+		log.Printf("Error staging %s: %v", name, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error storing uploaded data")
+	}
+}
+
+// validSHA256 reports whether s is a lowercase hex SHA-256 digest
+func validSHA256(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// sessionPath is the API path of an upload session
+func sessionPath(id string) string {
+	return "/api/v1/uploads/" + id
+}
+
+// handleCreateSession starts an empty upload session that files can be
+// added to from any client knowing its ID
+func handleCreateSession(c echo.Context) error {
+	s := newUploadSession(currentUser(c))
+	log.Printf("Upload session %s created", s.ID)
+	c.Response().Header().Set(echo.HeaderLocation, sessionPath(s.ID))
+	return c.JSON(http.StatusCreated, sessionResponse{uploadSession: *s, FinalizeURL: sessionPath(s.ID) + "/finalize"})
+}
+
+// handleGetSession lists the files staged in an upload session
+func handleGetSession(c echo.Context) error {
+	s, err := getUploadSession(c.Param("id"), currentUser(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return c.JSON(http.StatusOK, sessionResponse{uploadSession: s, FinalizeURL: sessionPath(s.ID) + "/finalize"})
+}
+
+// handleAddSessionFiles stages the multipart files field of a request in an
+// upload session
+func handleAddSessionFiles(c echo.Context) error {
+	if n := c.Request().ContentLength; n > 0 {
+		release, err := reserveDisk(n)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+		}
+		defer release()
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not process form data")
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No files selected")
+	}
+
+	id, owner := c.Param("id"), currentUser(c)
+	for _, fh := range files {
+		name, err := safeMemberName(fh.Filename)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid file name")
+		}
+		src, err := fh.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Could not process form data")
+		}
+		_, _, err = stageFile(id, owner, name, src, "")
+		src.Close()
+		if err != nil {
+			return stagingError(name, err)
+		}
+	}
+	return handleGetSession(c)
+}
+
+// handleRemoveSessionFile drops one staged file from an upload session
+func handleRemoveSessionFile(c echo.Context) error {
+	name, err := url.PathUnescape(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file name")
+	}
+	if err := unstageFile(c.Param("id"), currentUser(c), name); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return handleGetSession(c)
+}
+
+// handleDiscardSession drops an upload session and its staged files
+func handleDiscardSession(c echo.Context) error {
+	s, err := takeUploadSession(c.Param("id"), currentUser(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	s.removeStagedFiles()
+	log.Printf("Upload session %s discarded", s.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleFinalizeUpload queues the archive for an upload session, taking the
+// same options as /api/v1/compress
+func handleFinalizeUpload(c echo.Context) error {
+	ttl, err := parseExpiry(c.FormValue("expires"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	comment := c.FormValue("comment")
+	if len(comment) > maxArchiveComment {
+		return echo.NewHTTPError(http.StatusBadRequest, "Archive comment too long")
+	}
+	note, err := parseNote(c.FormValue("note"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	priority, err := parsePriority(c.FormValue("priority"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	staged, err := getUploadSession(c.Param("id"), currentUser(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if len(staged.Files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No files selected")
+	}
+	releaseQuota, err := admitQuota(c, staged.Size)
+	if err != nil {
+		return echo.NewHTTPError(quotaErrorStatus(err), err.Error())
+	}
+	s, err := takeUploadSession(c.Param("id"), currentUser(c))
+	if err != nil {
+		releaseQuota()
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	// The last file may have been removed since the check above; the session
+	// is already taken, so there is nothing left to keep
+	if len(s.Files) == 0 {
+		releaseQuota()
+		return echo.NewHTTPError(http.StatusBadRequest, "No files selected")
+	}
+
+	entries, _, cleanup, err := collectSources(c.Request().Context(), []Source{stagedSource{session: s}}, s.Size, nil)
+	if err != nil {
+		releaseQuota()
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error storing uploaded data")
+	}
+	opts := archiveOptions{
+		Owner:    s.Owner,
+		APIKey:   currentAPIKey(c),
+		Priority: priority,
+		ClientIP: c.RealIP(),
+		Password: c.FormValue("link_password"),
+		Dedup:    formBool(c, "dedup", config.DedupUploads),
+		Flatten:  formBool(c, "flatten", config.Flatten),
+		Comment:  comment,
+		Note:     note,
+		Metadata: formBool(c, "metadata", false),
+		TTL:      ttl,
+		Strict:   formBool(c, "strict", false),
+	}
+
+	id := createJob(len(entries), opts)
+	log.Printf("Upload session %s finalized as job %s with %d files", s.ID, id, len(entries))
+	go runArchiveJob(id, entries, opts, func() {
+		cleanup()
+		releaseQuota()
+	})
+	return acceptedJob(c, id)
+}
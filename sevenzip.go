@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+)
+
+// Most of the 7z binary's output kept for the log when it fails
+const max7zOutput = 4096
+
+// setupSevenZip enables 7z output when BULK_7Z_PATH names a usable binary
+func setupSevenZip() error {
+	if config.SevenZipPath == "" {
+		return nil
+	}
+	bin, err := exec.LookPath(config.SevenZipPath)
+	if err != nil {
+		return fmt.Errorf("7z binary: %w", err)
+	}
+	config.SevenZipPath = bin
+	registerArchiveFormat(&archiveFormat{name: "7z", extension: ".7z", contentType: "application/x-7z-compressed",
+		minLevel: 0, maxLevel: 9, open: newSevenZipArchiver})
+	return nil
+}
+
+// sevenZipArchiver collects the members in a private scratch directory and
+// has the external 7z binary pack them into an archive copied to w on
+// Finalize. The binary runs in that directory with a bare environment and is
+// killed after BULK_7Z_TIMEOUT; the directory is removed afterwards either way.
+type sevenZipArchiver struct {
+	*archiveFormat
+	w       io.Writer
+	scratch string
+	in      string
+	level   int
+}
+
+func newSevenZipArchiver(f *archiveFormat, w io.Writer, opts archiverOptions) (Archiver, error) {
+	scratch, err := os.MkdirTemp(spillDir(), "7z-*")
+	if err != nil {
+		return nil, err
+	}
+	in := filepath.Join(scratch, "in")
+	if err := os.Mkdir(in, 0o700); err != nil {
+		os.RemoveAll(scratch)
+		return nil, err
+	}
+	level := opts.Level
+	if level < 0 {
+		level = 5
+	}
+	return &sevenZipArchiver{archiveFormat: f, w: w, scratch: scratch, in: in, level: level}, nil
+}
+
+func (a *sevenZipArchiver) AddEntry(m archiveMember, r io.Reader) error {
+	dst, err := safeextract.Join(a.in, m.Name)
+	if err != nil {
+		log.Printf("Skipping %q: %v", m.Name, err)
+		return nil
+	}
+	return extractMember(r, dst, m.ModTime)
+}
+
+func (a *sevenZipArchiver) Finalize() error {
+	defer a.Abort()
+	out := filepath.Join(a.scratch, "out.7z")
+	if _, err := run7z(a.scratch, a.in, "a", "-t7z", "-mx="+strconv.Itoa(a.level), "-bd", "-y", "--", out, "."); err != nil {
+		return &archiveError{"Error creating the 7z archive", err}
+	}
+
+	packed, err := os.Open(out)
+	if err != nil {
+		return &archiveError{"Error creating the 7z archive", err}
+	}
+	defer packed.Close()
+	if _, err := copyPooled(a.w, packed); err != nil {
+		return &archiveError{"Error writing archive data", err}
+	}
+	return nil
+}
+
+// Abort removes the scratch directory
+func (a *sevenZipArchiver) Abort() {
+	os.RemoveAll(a.scratch)
+}
+
+// run7z runs the 7z binary in dir with a bare environment rooted at scratch,
+// killing it after BULK_7Z_TIMEOUT, and returns its output. The tail of the
+// output is logged on failure.
+func run7z(scratch, dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.SevenZipTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, config.SevenZipPath, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + scratch, "TMPDIR=" + scratch}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if err == nil {
+		return output.Bytes(), nil
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", config.SevenZipTimeout)
+	}
+	msg := output.String()
+	if len(msg) > max7zOutput {
+		msg = msg[len(msg)-max7zOutput:]
+	}
+	log.Printf("7z failed: %v: %s", err, strings.TrimSpace(msg))
+	return nil, err
+}
+
+// extractMember writes src to dst, stamping it with modTime
+func extractMember(src io.Reader, dst string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = copyPooled(file, src)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, modTime, modTime)
+}
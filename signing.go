@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkKey signs capability URLs handed out with archives, such as deletion links
+var linkKey []byte
+
+// loadLinkKey derives the link signing key from BULK_SESSION_SECRET or, when
+// that is unset, from a random key kept in the data directory so links stay
+// valid across restarts like the archives they point to
+func loadLinkKey() error {
+	if config.SessionSecret != "" {
+		sum := sha256.Sum256([]byte("links\x00" + config.SessionSecret))
+		linkKey = sum[:]
+		return nil
+	}
+
+	path := filepath.Join(config.DataDir, "link.key")
+	if data, err := os.ReadFile(path); err == nil {
+		if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) == 32 {
+			linkKey = key
+			return nil
+		}
+	}
+
+	linkKey = make([]byte, 32)
+	rand.Read(linkKey)
+	if err := os.MkdirAll(config.DataDir, 0o750); err != nil {
+		return fmt.Errorf("creating data dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(linkKey)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("saving link key: %w", err)
+	}
+	return nil
+}
+
+// signLink returns a URL-safe signature over parts
+func signLink(parts ...string) string {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// validLinkSignature reports whether sig was produced by signLink for parts
+func validLinkSignature(sig string, parts ...string) bool {
+	return sig != "" && hmac.Equal([]byte(sig), []byte(signLink(parts...)))
+}
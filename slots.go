@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errDownloadsBusy is returned when no download slot frees up within the queue wait
+var errDownloadsBusy = errors.New("too many concurrent downloads")
+
+// downloadSlots bounds how many download streams run at once, both across
+// the server and for any one archive. Waiting requests queue for up to
+// BULK_DOWNLOAD_QUEUE_WAIT before being turned away.
+type downloadSlots struct {
+	global chan struct{} // nil when unlimited
+
+	mu     sync.Mutex
+	perKey map[string]*keySlots
+
+	// Streams currently holding a slot
+	active atomic.Int64
+}
+
+// keySlots is the semaphore for one archive, dropped once nobody holds or awaits it
+type keySlots struct {
+	ch   chan struct{}
+	refs int
+}
+
+// activeDownloads is the process-wide download slot pool
+var activeDownloads = newDownloadSlots(config.MaxDownloads)
+
+func newDownloadSlots(global int) *downloadSlots {
+	s := &downloadSlots{perKey: make(map[string]*keySlots)}
+	if global > 0 {
+		s.global = make(chan struct{}, global)
+	}
+	return s
+}
+
+// acquire waits for a slot for archive key and returns the func that gives it back
+func (s *downloadSlots) acquire(ctx context.Context, key string) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, config.DownloadQueueWait)
+	defer cancel()
+
+	var keySem *keySlots
+	if config.MaxDownloadsPerArchive > 0 {
+		s.mu.Lock()
+		keySem = s.perKey[key]
+		if keySem == nil {
+			keySem = &keySlots{ch: make(chan struct{}, config.MaxDownloadsPerArchive)}
+			s.perKey[key] = keySem
+		}
+		keySem.refs++
+		s.mu.Unlock()
+
+		select {
+		case keySem.ch <- struct{}{}:
+		case <-ctx.Done():
+			s.dropKey(key, keySem, false)
+			return nil, errDownloadsBusy
+		}
+	}
+
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-ctx.Done():
+			if keySem != nil {
+				s.dropKey(key, keySem, true)
+			}
+			return nil, errDownloadsBusy
+		}
+	}
+
+	s.active.Add(1)
+	return func() {
+		s.active.Add(-1)
+		if s.global != nil {
+			<-s.global
+		}
+		if keySem != nil {
+			s.dropKey(key, keySem, true)
+		}
+	}, nil
+}
+
+// dropKey releases a reference to an archive's semaphore, freeing its slot if held
+func (s *downloadSlots) dropKey(key string, keySem *keySlots, held bool) {
+	if held {
+		<-keySem.ch
+	}
+	s.mu.Lock()
+	keySem.refs--
+	if keySem.refs == 0 {
+		delete(s.perKey, key)
+	}
+	s.mu.Unlock()
+}
+
+// downloadsBusy answers a request that found no free download slot
+func downloadsBusy(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(max(config.DownloadQueueWait, time.Second).Seconds())))
+	return htmlError(c, http.StatusServiceUnavailable, "Error: The server is busy with other downloads, please try again shortly")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// textSnippet is pasted text to be stored as a file in the archive
+type textSnippet struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// pasteRequest is the JSON body accepted by POST /paste
+type pasteRequest struct {
+	Snippets []textSnippet     `json:"snippets"`
+	Password string            `json:"password,omitempty"`
+	Paths    map[string]string `json:"paths,omitempty"`
+	Comment  string            `json:"comment,omitempty"`
+	Note     string            `json:"note,omitempty"`
+	Metadata bool              `json:"metadata,omitempty"`
+	Flatten  *bool             `json:"flatten,omitempty"`
+	Expires  string            `json:"expires,omitempty"`
+}
+
+// pasteResponse describes the archive created from pasted snippets
+type pasteResponse struct {
+	Archive     string `json:"archive"`
+	DownloadURL string `json:"downloadUrl"`
+	DeleteURL   string `json:"deleteUrl"`
+}
+
+// formSnippets pairs up repeated snippet_name/snippet_content form fields,
+// skipping pairs whose content is empty
+func formSnippets(form map[string][]string) []textSnippet {
+	names := form["snippet_name"]
+	contents := form["snippet_content"]
+
+	var snippets []textSnippet
+	for i, content := range contents {
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+		snippets = append(snippets, textSnippet{Name: name, Content: content})
+	}
+	return snippets
+}
+
+// snippetEntries turns snippets into archive entries. Unnamed snippets become
+// note-N.txt and names without an extension get .txt.
+func snippetEntries(snippets []textSnippet) ([]archiveEntry, error) {
+	entries := make([]archiveEntry, 0, len(snippets))
+	for i, s := range snippets {
+		name := strings.TrimSpace(s.Name)
+		if name == "" {
+			name = fmt.Sprintf("note-%d.txt", i+1)
+		} else if filepath.Ext(name) == "" {
+			name += ".txt"
+		}
+
+		size := int64(len(s.Content))
+		if config.MaxFileSize > 0 && size > config.MaxFileSize {
+			return nil, fmt.Errorf("Snippet %s is too large", name)
+		}
+
+		content := s.Content
+		entries = append(entries, archiveEntry{
+			Name: name,
+			Size: size,
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil },
+		})
+	}
+	return entries, nil
+}
+
+// handlePaste builds an archive from a JSON list of name/content snippets
+func handlePaste(c echo.Context) error {
+	var req pasteRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body")
+	}
+	if len(req.Snippets) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "at least one snippet is required")
+	}
+	if config.MaxFiles > 0 && len(req.Snippets) > config.MaxFiles {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Too many snippets (%d, max %d)", len(req.Snippets), config.MaxFiles))
+	}
+
+	entries, err := snippetEntries(req.Snippets)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.Size
+	}
+	if totalSize > config.MaxUploadSize {
+		return echo.NewHTTPError(http.StatusBadRequest, "Total snippet size too large")
+	}
+
+	release, err := reserveDisk(totalSize)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+	}
+	defer release()
+	releaseQuota, err := admitQuota(c, totalSize)
+	if err != nil {
+		return echo.NewHTTPError(quotaErrorStatus(err), err.Error())
+	}
+	defer releaseQuota()
+
+	if err := validatePathMap(req.Paths); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if len(req.Comment) > maxArchiveComment {
+		return echo.NewHTTPError(http.StatusBadRequest, "Archive comment too long")
+	}
+	note, err := parseNote(req.Note)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ttl, err := parseExpiry(req.Expires)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	opts := archiveOptions{
+		Owner:    currentUser(c),
+		APIKey:   currentAPIKey(c),
+		ClientIP: c.RealIP(),
+		Password: req.Password,
+		Paths:    req.Paths,
+		Comment:  req.Comment,
+		Note:     note,
+		Metadata: req.Metadata,
+		Flatten:  config.Flatten,
+		TTL:      ttl,
+	}
+	if req.Flatten != nil {
+		opts.Flatten = *req.Flatten
+	}
+	result, err := createArchive(c.Request().Context(), entries, opts, nil)
+	if err != nil {
+		log.Printf("Paste archive failed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, archiveErrorMessage(err))
+	}
+
+	return c.JSON(http.StatusOK, pasteResponse{
+		Archive:     result.Name,
+		DownloadURL: downloadPath(result.Name),
+		DeleteURL:   deletePath(result.Name),
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Source yields the files of one kind of input as archive entries, so the
+// archive pipeline doesn't care whether they were uploaded, staged by raw
+// PUTs, fetched from URLs (HTTP, FTP, SFTP, S3) or picked from cloud storage.
+type Source interface {
+	// Kind names the source in logs, such as "upload" or "url"
+	Kind() string
+
+	// Entries returns the source's files within budget bytes. Files that
+	// can't be read are passed to failed, which decides whether they are
+	// skipped; cleanup removes any spool files once the entries are used.
+	Entries(ctx context.Context, budget int64, failed failFunc) (entries []archiveEntry, cleanup func(), err error)
+}
+
+// sizedSource is a Source whose size is known before it is read, like files
+// already received; sources read before it leave room for it in the budget
+type sizedSource interface {
+	Source
+	Size() int64
+}
+
+// collectSources reads the sources in order within limit bytes overall and
+// returns their entries, their combined size and a cleanup func for all of
+// them. When a source fails, what the earlier ones spooled is removed.
+func collectSources(ctx context.Context, sources []Source, limit int64, failed failFunc) ([]archiveEntry, int64, func(), error) {
+	var total int64
+	for _, s := range sources {
+		if sized, ok := s.(sizedSource); ok {
+			total += sized.Size()
+		}
+	}
+
+	var entries []archiveEntry
+	var cleanups []func()
+	cleanup := func() {
+		for _, fn := range cleanups {
+			fn()
+		}
+	}
+	for _, s := range sources {
+		got, done, err := s.Entries(ctx, limit-total, failed)
+		if err != nil {
+			cleanup()
+			return nil, 0, nil, &sourceError{kind: s.Kind(), err: err}
+		}
+		if done != nil {
+			cleanups = append(cleanups, done)
+		}
+		if _, ok := s.(sizedSource); !ok {
+			for _, e := range got {
+				total += e.Size
+			}
+		}
+		entries = append(entries, got...)
+	}
+	return entries, total, cleanup, nil
+}
+
+// sourceError is a failure reading one source; it reads as the underlying error
+type sourceError struct {
+	kind string
+	err  error
+}
+
+func (e *sourceError) Error() string { return e.err.Error() }
+func (e *sourceError) Unwrap() error { return e.err }
+
+// uploadSource is files from a multipart form. With spool set they are copied
+// out of the request first, for jobs that outlive it.
+type uploadSource struct {
+	files  []*multipart.FileHeader
+	mtimes map[string]time.Time
+	spool  bool
+}
+
+func (s uploadSource) Kind() string { return "upload" }
+
+func (s uploadSource) Size() int64 {
+	var n int64
+	for _, f := range s.files {
+		n += f.Size
+	}
+	return n
+}
+
+func (s uploadSource) Entries(ctx context.Context, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	if s.spool {
+		return spoolUploads(s.files)
+	}
+	entries := make([]archiveEntry, 0, len(s.files))
+	for _, file := range s.files {
+		entries = append(entries, archiveEntry{
+			Name: file.Filename,
+			Size: file.Size,
+			Open: func() (io.ReadCloser, error) { return file.Open() },
+
+			ModTime: s.mtimes[file.Filename],
+		})
+	}
+	return entries, nil, nil
+}
+
+// stagedSource is the files of an upload session, sent as raw PUTs or over a
+// WebSocket and already on disk
+type stagedSource struct {
+	session *uploadSession
+}
+
+func (s stagedSource) Kind() string { return "staged" }
+
+func (s stagedSource) Size() int64 { return s.session.Size }
+
+func (s stagedSource) Entries(ctx context.Context, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	entries := make([]archiveEntry, 0, len(s.session.Files))
+	for _, f := range s.session.Files {
+		p := f.path
+		entries = append(entries, archiveEntry{
+			Name: f.Name,
+			Size: f.Size,
+			Open: func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+	}
+	return entries, s.session.removeStagedFiles, nil
+}
+
+// urlSource is remote files fetched server-side. Entries whose URL is in
+// paths are renamed to it, keeping a crawled folder layout.
+type urlSource struct {
+	urls   []string
+	policy fetchPolicy
+	paths  map[string]string
+}
+
+func (s urlSource) Kind() string { return "url" }
+
+func (s urlSource) Entries(ctx context.Context, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	entries, cleanup, err := fetchURLs(ctx, s.urls, budget, s.policy, failed)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range entries {
+		if p, ok := s.paths[entries[i].Source]; ok {
+			entries[i].Name = p
+		}
+	}
+	return entries, cleanup, nil
+}
+
+// cloudSource is files picked from storage the browser has connected
+type cloudSource struct {
+	c    echo.Context
+	refs []string
+}
+
+func (s cloudSource) Kind() string { return "cloud" }
+
+func (s cloudSource) Entries(ctx context.Context, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	return fetchCloudFiles(s.c, s.refs, budget, failed)
+}
+
+// entrySource is entries built in memory, such as pasted snippets
+type entrySource struct {
+	kind    string
+	entries []archiveEntry
+}
+
+func (s entrySource) Kind() string { return s.kind }
+
+func (s entrySource) Size() int64 {
+	var n int64
+	for _, e := range s.entries {
+		n += e.Size
+	}
+	return n
+}
+
+func (s entrySource) Entries(ctx context.Context, budget int64, failed failFunc) ([]archiveEntry, func(), error) {
+	return s.entries, nil, nil
+}
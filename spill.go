@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempDirs returns every directory temporary files may be written to,
+// BULK_TEMP_DIR first
+func tempDirs() []string {
+	return append([]string{config.TempDir}, config.SpillDirs...)
+}
+
+// setupSpillDirs checks that the extra spill volumes are usable directories
+func setupSpillDirs() error {
+	for i, dir := range config.SpillDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", abs)
+		}
+		config.SpillDirs[i] = abs
+	}
+	return nil
+}
+
+// spillDir returns the temp directory with the most free space, so heavy
+// writes land on whichever volume can currently take them. Volumes whose free
+// space can't be read are skipped, and BULK_TEMP_DIR is the fallback.
+func spillDir() string {
+	if len(config.SpillDirs) == 0 {
+		return config.TempDir
+	}
+	best, bestFree := config.TempDir, int64(-1)
+	for _, dir := range tempDirs() {
+		free, err := diskFree(dir)
+		if err != nil {
+			continue
+		}
+		if free > bestFree {
+			best, bestFree = dir, free
+		}
+	}
+	return best
+}
+
+// mostFreeDisk returns the free space of the temp volume with the most room
+func mostFreeDisk() (int64, error) {
+	var most int64
+	var lastErr error
+	found := false
+	for _, dir := range tempDirs() {
+		free, err := diskFree(dir)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		found = true
+		most = max(most, free)
+	}
+	if !found {
+		return 0, lastErr
+	}
+	return most, nil
+}
+
+// withinDir returns path relative to dir when path lies inside it
+func withinDir(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
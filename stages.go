@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// fileStage is one step of the processing chain each file passes through
+// before it is archived. Process returns the entry to pass on, which may
+// read different content, or a reason to leave the file out.
+type fileStage interface {
+	Process(ctx context.Context, e archiveEntry) (out archiveEntry, skip string, err error)
+}
+
+// stageFinisher is implemented by stages that add entries of their own once
+// every file has passed, given the entries that end up in the archive
+type stageFinisher interface {
+	Finish(archived []archiveEntry) []archiveEntry
+}
+
+// stageCleaner is implemented by stages holding files to remove once the
+// archive is written
+type stageCleaner interface {
+	Cleanup()
+}
+
+// stageFactory builds a stage from the argument after its name in
+// BULK_FILE_STAGES. Every archive gets fresh stages.
+type stageFactory func(arg string) (fileStage, error)
+
+// fileStageKinds holds every stage by name. Site-specific stages are added
+// with registerFileStage before setupFileStages runs.
+var fileStageKinds = map[string]stageFactory{
+	"filter":    newFilterStage,
+	"scan":      newScanStage,
+	"transform": newTransformStage,
+	"hash":      newHashStage,
+}
+
+// registerFileStage makes a stage available to BULK_FILE_STAGES under name
+func registerFileStage(name string, factory stageFactory) {
+	fileStageKinds[name] = factory
+}
+
+// stageSpec is one stage configured in BULK_FILE_STAGES
+type stageSpec struct {
+	name, arg string
+}
+
+// fileStageSpecs is the configured chain, in order
+var fileStageSpecs []stageSpec
+
+// setupFileStages reads BULK_FILE_STAGES, building each stage once so a bad
+// argument fails at startup rather than on the first archive
+func setupFileStages() error {
+	for _, item := range config.FileStages {
+		name, arg, _ := strings.Cut(item, ":")
+		factory, ok := fileStageKinds[name]
+		if !ok {
+			return fmt.Errorf("unknown stage %q in BULK_FILE_STAGES", name)
+		}
+		if _, err := factory(arg); err != nil {
+			return fmt.Errorf("stage %s in BULK_FILE_STAGES: %v", name, err)
+		}
+		fileStageSpecs = append(fileStageSpecs, stageSpec{name: name, arg: arg})
+	}
+	return nil
+}
+
+// fileChain is the configured stages, built for one archive
+type fileChain struct {
+	stages []fileStage
+}
+
+// newFileChain builds the stages of BULK_FILE_STAGES
+func newFileChain() (*fileChain, error) {
+	c := &fileChain{}
+	for _, s := range fileStageSpecs {
+		stage, err := fileStageKinds[s.name](s.arg)
+		if err != nil {
+			return nil, err
+		}
+		c.stages = append(c.stages, stage)
+	}
+	return c, nil
+}
+
+// process passes each file entry through the stages in order. Files a stage
+// leaves out are returned as skipped; files a stage can't process go to
+// failed, which decides whether the batch carries on.
+func (c *fileChain) process(ctx context.Context, entries []archiveEntry, failed failFunc) ([]archiveEntry, []fileResult, error) {
+	if len(c.stages) == 0 {
+		return entries, nil, nil
+	}
+	kept := entries[:0:0]
+	var skipped []fileResult
+next:
+	for _, e := range entries {
+		if e.Open == nil || strings.HasSuffix(e.Name, "/") {
+			kept = append(kept, e)
+			continue
+		}
+		for i, stage := range c.stages {
+			out, reason, err := stage.Process(ctx, e)
+			if err != nil {
+				err = fmt.Errorf("stage %s: %w", fileStageSpecs[i].name, err)
+				// A build that was called off stops rather than skipping the rest
+				if ctx.Err() != nil || !failed(e.Name, err) {
+					return nil, nil, &archiveError{fmt.Sprintf("Error processing %s", e.Name), err}
+				}
+				continue next
+			}
+			if reason != "" {
+				log.Printf("Stage %s left out %s: %s", fileStageSpecs[i].name, e.Name, reason)
+				skipped = append(skipped, fileResult{Name: e.Name, Status: fileSkipped, Reason: reason})
+				continue next
+			}
+			e = out
+		}
+		kept = append(kept, e)
+	}
+	return kept, skipped, nil
+}
+
+// finish returns the entries the stages add to an archive of archived
+func (c *fileChain) finish(archived []archiveEntry) []archiveEntry {
+	var extra []archiveEntry
+	for _, stage := range c.stages {
+		if f, ok := stage.(stageFinisher); ok {
+			extra = append(extra, f.Finish(archived)...)
+		}
+	}
+	return extra
+}
+
+// cleanup removes what the stages spooled
+func (c *fileChain) cleanup() {
+	for _, stage := range c.stages {
+		if cl, ok := stage.(stageCleaner); ok {
+			cl.Cleanup()
+		}
+	}
+}
+
+// filterStage leaves out files by archive path, taking space-separated
+// patterns in the syntax of the filter form field
+type filterStage struct {
+	filter pathFilter
+}
+
+func newFilterStage(arg string) (fileStage, error) {
+	f, err := parsePathFilter(strings.Fields(arg))
+	if err != nil {
+		return nil, err
+	}
+	if f.empty() {
+		return nil, errors.New("needs at least one pattern")
+	}
+	return filterStage{filter: f}, nil
+}
+
+func (s filterStage) Process(ctx context.Context, e archiveEntry) (archiveEntry, string, error) {
+	if !s.filter.match(e.Name) {
+		return e, "excluded by filter", nil
+	}
+	return e, "", nil
+}
+
+// scanStage runs a command with a copy of each file's path appended and
+// leaves the file out when it exits with an error. Unlike BULK_SCAN_COMMAND
+// nothing is held for review.
+type scanStage struct {
+	command string
+}
+
+func newScanStage(arg string) (fileStage, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, errors.New("needs a command")
+	}
+	return scanStage{command: arg}, nil
+}
+
+func (s scanStage) Process(ctx context.Context, e archiveEntry) (archiveEntry, string, error) {
+	spooled, err := spoolEntry(e, spillDir())
+	if err != nil {
+		return e, "", err
+	}
+	defer os.Remove(spooled)
+	reason := scanFile(ctx, s.command, spooled)
+	if err := ctx.Err(); err != nil {
+		return e, "", err
+	}
+	return e, reason, nil
+}
+
+// transformStage pipes each file through a command and archives its output
+// in place of the original
+type transformStage struct {
+	args    []string
+	spooled []string
+}
+
+func newTransformStage(arg string) (fileStage, error) {
+	args := strings.Fields(arg)
+	if len(args) == 0 {
+		return nil, errors.New("needs a command")
+	}
+	return &transformStage{args: args}, nil
+}
+
+func (s *transformStage) Process(ctx context.Context, e archiveEntry) (archiveEntry, string, error) {
+	src, err := e.Open()
+	if err != nil {
+		return e, "", err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(spillDir(), "stage-*")
+	if err != nil {
+		return e, "", err
+	}
+	defer dst.Close()
+	s.spooled = append(s.spooled, dst.Name())
+
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = src, dst, &stderr
+	// Children of a killed command can hold its pipes open; don't wait on them
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if line, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); line != "" {
+			return e, "", fmt.Errorf("%v: %s", err, line)
+		}
+		return e, "", err
+	}
+	info, err := dst.Stat()
+	if err != nil {
+		return e, "", err
+	}
+
+	p := dst.Name()
+	e.Size = info.Size()
+	e.Open = func() (io.ReadCloser, error) { return os.Open(p) }
+	return e, "", nil
+}
+
+func (s *transformStage) Cleanup() {
+	for _, p := range s.spooled {
+		os.Remove(p)
+	}
+}
+
+// hashStage adds a listing such as SHA256SUMS, in the format sha256sum -c
+// reads, with the digest of every archived file
+type hashStage struct {
+	algo    string
+	newHash func() hash.Hash
+	sums    map[string]string
+}
+
+func newHashStage(arg string) (fileStage, error) {
+	algo := strings.ToLower(arg)
+	if algo == "" {
+		algo = "sha256"
+	}
+	newHash, ok := checksumAlgos[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown digest %q", arg)
+	}
+	return &hashStage{algo: algo, newHash: newHash, sums: make(map[string]string)}, nil
+}
+
+func (s *hashStage) Process(ctx context.Context, e archiveEntry) (archiveEntry, string, error) {
+	src, err := e.Open()
+	if err != nil {
+		return e, "", err
+	}
+	defer src.Close()
+	h := s.newHash()
+	if _, err := copyPooled(h, src); err != nil {
+		return e, "", err
+	}
+	s.sums[e.Name] = hex.EncodeToString(h.Sum(nil))
+	return e, "", nil
+}
+
+func (s *hashStage) Finish(archived []archiveEntry) []archiveEntry {
+	var lines []string
+	for _, e := range archived {
+		if sum, ok := s.sums[e.Name]; ok {
+			lines = append(lines, sum+"  "+e.Name+"\n")
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	content := strings.Join(lines, "")
+	return []archiveEntry{{
+		Name: strings.ToUpper(s.algo) + "SUMS",
+		Size: int64(len(content)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil },
+	}}
+}
@@ -0,0 +1,26 @@
+// Sends the CSRF cookie back with htmx requests and plain form posts
+(function () {
+    function token() {
+        var m = document.cookie.match(/(?:^|;\s*)bulk_csrf=([^;]+)/);
+        return m ? decodeURIComponent(m[1]) : "";
+    }
+
+    document.addEventListener("htmx:configRequest", function (e) {
+        e.detail.headers["X-CSRF-Token"] = token();
+    });
+
+    document.addEventListener("submit", function (e) {
+        var form = e.target;
+        if (form.method.toLowerCase() !== "post") {
+            return;
+        }
+        var input = form.querySelector('input[name="_csrf"]');
+        if (!input) {
+            input = document.createElement("input");
+            input.type = "hidden";
+            input.name = "_csrf";
+            form.appendChild(input);
+        }
+        input.value = token();
+    }, true);
+})();
@@ -0,0 +1,62 @@
+// Streams files into an upload session over a WebSocket, reporting progress
+// as the server acknowledges each chunk:
+//
+//   uploadOverSocket(sessionId, input.files, function (name, sent, total) { ... })
+//       .then(function (results) { ... });
+//
+// Each result is the server's "stored" or "error" message for one file.
+(function () {
+    var CHUNK = 256 * 1024;
+    var WINDOW = 4; // chunks sent ahead of the server's acks
+
+    window.uploadOverSocket = function (sessionId, files, onProgress) {
+        var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+        var ws = new WebSocket(scheme + location.host + "/api/v1/uploads/" + encodeURIComponent(sessionId) + "/socket");
+        var results = [];
+        var index = -1, file, offset, acked;
+
+        return new Promise(function (resolve, reject) {
+            function nextFile() {
+                index++;
+                if (index >= files.length) {
+                    ws.close();
+                    resolve(results);
+                    return;
+                }
+                file = files[index];
+                offset = 0;
+                acked = 0;
+                ws.send(JSON.stringify({ type: "file", name: file.name, size: file.size }));
+            }
+
+            function pump() {
+                while (offset < file.size && offset - acked < WINDOW * CHUNK) {
+                    ws.send(file.slice(offset, offset + CHUNK));
+                    offset = Math.min(offset + CHUNK, file.size);
+                }
+            }
+
+            ws.onopen = nextFile;
+            ws.onerror = function () { reject(new Error("WebSocket upload failed")); };
+            ws.onmessage = function (e) {
+                var msg = JSON.parse(e.data);
+                switch (msg.type) {
+                case "ready":
+                    pump();
+                    break;
+                case "ack":
+                    acked = msg.received;
+                    if (onProgress) onProgress(file.name, acked, file.size);
+                    pump();
+                    break;
+                case "stored":
+                case "error":
+                    if (onProgress && msg.type === "stored") onProgress(file.name, file.size, file.size);
+                    results.push(msg);
+                    nextFile();
+                    break;
+                }
+            };
+        });
+    };
+})();
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// archiveRecord describes a generated archive waiting to be downloaded
+type archiveRecord struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// When the archive is cleaned up if nobody downloads it; zero never expires
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+
+	// Username of the creator, empty for anonymous uploads
+	Owner string `json:"owner,omitempty"`
+
+	// Address of the client that created the archive
+	ClientIP string `json:"clientIp,omitempty"`
+
+	// Name of the API key the archive was created with
+	APIKey string `json:"apiKey,omitempty"`
+
+	// Why an admin disabled the link after an abuse report; empty while it works
+	Disabled string `json:"disabled,omitempty"`
+
+	// Note the uploader attached for recipients
+	Note string `json:"note,omitempty"`
+
+	// bcrypt hash of the password protecting the download link, if any
+	PasswordHash string `json:"passwordHash,omitempty"`
+
+	// Random share token that links use in place of the download name
+	Token string `json:"token,omitempty"`
+
+	// Quoted SHA-256 of the archive contents, filled in on first download
+	ETag string `json:"etag,omitempty"`
+
+	// Hex AES-256 key the file is encrypted with; empty for plain files.
+	// Saved to state.json wrapped under BULK_SESSION_SECRET.
+	Key string `json:"key,omitempty"`
+
+	// Contents of a small archive held in memory instead of at Path
+	Data []byte `json:"-"`
+}
+
+// expired reports whether the archive's download link has lapsed
+func (r archiveRecord) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// tempFileStore holds references to generated ZIP files
+var (
+	tempFileStore = make(map[string]archiveRecord)
+	storeMutex    = &sync.Mutex{}
+)
+
+// putArchive registers a finished archive under its download name
+func putArchive(name string, rec archiveRecord) {
+	storeMutex.Lock()
+	storeArchiveLocked(name, rec)
+	storeMutex.Unlock()
+}
+
+// getArchive returns the unexpired archive registered under name without removing it
+func getArchive(name string) (archiveRecord, bool) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	rec, ok := tempFileStore[name]
+	if !ok || rec.expired(time.Now()) {
+		return archiveRecord{}, false
+	}
+	return rec, true
+}
+
+// takeArchive removes and returns the unexpired archive registered under name
+func takeArchive(name string) (archiveRecord, bool) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	rec, ok := tempFileStore[name]
+	if !ok || rec.expired(time.Now()) {
+		return archiveRecord{}, false
+	}
+	forgetArchiveLocked(name)
+	return rec, true
+}
+
+// storedBytes returns the combined size of all archives currently held on
+// disk, including downloaded ones that can still be restored
+func storedBytes() int64 {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var total int64
+	for _, rec := range tempFileStore {
+		if rec.Data == nil {
+			total += rec.Size
+		}
+	}
+	for _, t := range trash {
+		if t.Data == nil {
+			total += t.Size
+		}
+	}
+	return total
+}
+
+// storedArchive pairs an archive record with its download name
+type storedArchive struct {
+	Name string
+	archiveRecord
+}
+
+// listArchives returns a snapshot of all held archives, oldest first
+func listArchives() []storedArchive {
+	storeMutex.Lock()
+	out := make([]storedArchive, 0, len(tempFileStore))
+	for name, rec := range tempFileStore {
+		out = append(out, storedArchive{Name: name, archiveRecord: rec})
+	}
+	storeMutex.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+// takeArchivesOlderThan removes and returns every archive created before cutoff
+func takeArchivesOlderThan(cutoff time.Time) []storedArchive {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var out []storedArchive
+	for name, rec := range tempFileStore {
+		if rec.CreatedAt.Before(cutoff) {
+			out = append(out, storedArchive{Name: name, archiveRecord: rec})
+			forgetArchiveLocked(name)
+		}
+	}
+	return out
+}
+
+// takeArchivesWhere removes and returns every archive match selects, including
+// downloaded ones that can still be restored
+func takeArchivesWhere(match func(name string, rec archiveRecord) bool) []storedArchive {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var out []storedArchive
+	for name, rec := range tempFileStore {
+		if match(name, rec) {
+			out = append(out, storedArchive{Name: name, archiveRecord: rec})
+			forgetArchiveLocked(name)
+		}
+	}
+	return append(out, takeTrashedLocked(func(name string, t trashedArchive) bool {
+		return match(name, t.archiveRecord)
+	})...)
+}
+
+// takeExpiredArchives removes and returns every archive whose link has lapsed
+func takeExpiredArchives(now time.Time) []storedArchive {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	var out []storedArchive
+	for name, rec := range tempFileStore {
+		if rec.expired(now) {
+			out = append(out, storedArchive{Name: name, archiveRecord: rec})
+			forgetArchiveLocked(name)
+		}
+	}
+	return out
+}
+
+// replaceArchiveFile points the archive registered under name at a new file,
+// provided it still refers to the file at oldPath. The record's path and key
+// are updated in place so its link, owner and expiry stay the same.
+func replaceArchiveFile(name, oldPath, path, key string, size int64) bool {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	rec, ok := tempFileStore[name]
+	if !ok || rec.Path != oldPath {
+		return false
+	}
+	rec.Path, rec.Key, rec.Size, rec.Data, rec.ETag = path, key, size, nil, ""
+	tempFileStore[name] = rec
+	return true
+}
+
+// removeArchiveFile deletes an archive from disk, logging any failure. In-memory
+// archives have no path and are gone once their record is.
+func removeArchiveFile(name, path string) {
+	if path == "" {
+		log.Printf("Archive removed: %s (in memory)", name)
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing archive %s (%s): %v", name, path, err)
+		return
+	}
+	log.Printf("Archive removed: %s (path: %s)", name, path)
+}
+
+// runJanitor deletes expired archives every interval until stop is closed
+func runJanitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, a := range takeExpiredArchives(time.Now()) {
+				log.Printf("Archive expired: %s", a.Name)
+				removeArchiveFile(a.Name, a.Path)
+				auditDeletion(nil, a.Name, "expired")
+			}
+			for _, a := range takePurgeableArchives(time.Now()) {
+				removeArchiveFile(a.Name, a.Path)
+			}
+			prunePartials(config.FetchPartialTTL)
+			pruneUploadSessions(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// storeState is the on-disk form of the archive store
+type storeState struct {
+	SavedAt  time.Time                 `json:"savedAt"`
+	Archives map[string]archiveRecord  `json:"archives"`
+	Trash    map[string]trashedArchive `json:"trash,omitempty"`
+}
+
+// storeStatePath is the state file inside the data directory
+func storeStatePath() string {
+	return filepath.Join(config.DataDir, "state.json")
+}
+
+// saveStoreState writes the archive store to the state file so outstanding
+// download links survive a restart
+func saveStoreState() error {
+	storeMutex.Lock()
+	state := storeState{SavedAt: time.Now(), Archives: make(map[string]archiveRecord, len(tempFileStore))}
+	for name, rec := range tempFileStore {
+		state.Archives[name] = rec
+	}
+	if len(trash) > 0 {
+		state.Trash = make(map[string]trashedArchive, len(trash))
+		for name, t := range trash {
+			state.Trash[name] = t
+		}
+	}
+	storeMutex.Unlock()
+
+	// In-memory archives would be lost with the process, so write them out
+	for name, rec := range state.Archives {
+		if rec.Data == nil {
+			continue
+		}
+		path, key, err := spillArchive(rec.Data)
+		if err != nil {
+			log.Printf("Dropping in-memory archive %s: %v", name, err)
+			delete(state.Archives, name)
+			continue
+		}
+		rec.Path, rec.Key, rec.Data = path, key, nil
+		state.Archives[name] = rec
+	}
+	for name, t := range state.Trash {
+		if t.Data == nil {
+			continue
+		}
+		path, key, err := spillArchive(t.Data)
+		if err != nil {
+			log.Printf("Dropping in-memory archive %s: %v", name, err)
+			delete(state.Trash, name)
+			continue
+		}
+		t.Path, t.Key, t.Data = path, key, nil
+		state.Trash[name] = t
+	}
+
+	// Keys only leave memory wrapped, so state.json can't open the files
+	for name, rec := range state.Archives {
+		key, err := wrapArchiveKey(rec.Key)
+		if err != nil {
+			return fmt.Errorf("wrapping key of %s: %w", name, err)
+		}
+		rec.Key = key
+		state.Archives[name] = rec
+	}
+	for name, t := range state.Trash {
+		key, err := wrapArchiveKey(t.Key)
+		if err != nil {
+			return fmt.Errorf("wrapping key of %s: %w", name, err)
+		}
+		t.Key = key
+		state.Trash[name] = t
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding store state: %w", err)
+	}
+	if err := os.MkdirAll(config.DataDir, 0o750); err != nil {
+		return fmt.Errorf("creating data dir: %w", err)
+	}
+	tmp := storeStatePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing store state: %w", err)
+	}
+	if err := os.Rename(tmp, storeStatePath()); err != nil {
+		return fmt.Errorf("replacing store state: %w", err)
+	}
+
+	log.Printf("Saved %d archives to %s", len(state.Archives), storeStatePath())
+	return nil
+}
+
+// loadStoreState restores archives from the state file, skipping any that
+// have expired or whose file is gone
+func loadStoreState() error {
+	data, err := os.ReadFile(storeStatePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading store state: %w", err)
+	}
+
+	var state storeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing store state: %w", err)
+	}
+
+	now := time.Now()
+	restored := 0
+	storeMutex.Lock()
+	for name, rec := range state.Archives {
+		if rec.expired(now) {
+			removeArchiveFile(name, rec.Path)
+			continue
+		}
+		if _, err := os.Stat(rec.Path); err != nil {
+			log.Printf("Dropping archive %s, file missing: %v", name, err)
+			continue
+		}
+		key, err := unwrapArchiveKey(rec.Key)
+		if err != nil {
+			log.Printf("Dropping archive %s: %v", name, err)
+			continue
+		}
+		rec.Key = key
+		storeArchiveLocked(name, rec)
+		restored++
+	}
+	for name, t := range state.Trash {
+		if now.After(t.PurgeAt) {
+			removeArchiveFile(name, t.Path)
+			continue
+		}
+		if _, err := os.Stat(t.Path); err != nil {
+			continue
+		}
+		key, err := unwrapArchiveKey(t.Key)
+		if err != nil {
+			log.Printf("Dropping archive %s: %v", name, err)
+			continue
+		}
+		t.Key = key
+		trash[name] = t
+	}
+	storeMutex.Unlock()
+
+	log.Printf("Restored %d archives from %s", restored, storeStatePath())
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleChunk caps how many bytes a throttled reader asks the buckets for at once
+const maxThrottleChunk = 64 * 1024
+
+// globalDownloadLimiter is shared by every download; nil when unlimited
+var globalDownloadLimiter *rate.Limiter
+
+// globalFetchLimiter is shared by every remote fetch; nil when unlimited
+var globalFetchLimiter *rate.Limiter
+
+// fetchLimiterKey holds a job's fetch limiter in the context of its fetches
+type fetchLimiterKey struct{}
+
+// withFetchLimiter makes fetches under ctx share l, which may be nil
+func withFetchLimiter(ctx context.Context, l *rate.Limiter) context.Context {
+	return context.WithValue(ctx, fetchLimiterKey{}, l)
+}
+
+// fetchReader throttles a remote response body to the job's rate from ctx
+// and the global fetch rate
+func fetchReader(ctx context.Context, r io.Reader) io.Reader {
+	job, _ := ctx.Value(fetchLimiterKey{}).(*rate.Limiter)
+	return newThrottledReader(ctx, r, job, globalFetchLimiter)
+}
+
+// newByteLimiter returns a token bucket allowing bytesPerSec, or nil when unlimited
+func newByteLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := bytesPerSec
+	if burst > maxThrottleChunk {
+		burst = maxThrottleChunk
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(burst))
+}
+
+// throttledReader delays reads so they stay within every attached token bucket
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+// newThrottledReader wraps r with the given limiters, skipping nil ones.
+// When no limiter applies r is returned unchanged.
+func newThrottledReader(ctx context.Context, r io.Reader, limiters ...*rate.Limiter) io.Reader {
+	t := &throttledReader{ctx: ctx, r: r, chunk: maxThrottleChunk}
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		t.limiters = append(t.limiters, l)
+		if l.Burst() < t.chunk {
+			t.chunk = l.Burst()
+		}
+	}
+	if len(t.limiters) == 0 {
+		return r
+	}
+	return t
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		if werr := l.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
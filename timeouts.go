@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// configureServer applies the configured read, write and idle timeouts to s,
+// so stalled clients can't hold a connection and its goroutine forever
+func configureServer(s *http.Server) {
+	s.ReadHeaderTimeout = config.ReadHeaderTimeout
+	s.ReadTimeout = config.ReadTimeout
+	s.WriteTimeout = config.WriteTimeout
+	s.IdleTimeout = config.IdleTimeout
+}
+
+// buildContext bounds one archive build by BULK_JOB_TIMEOUT
+func buildContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if config.JobTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, config.JobTimeout)
+}
+
+// entriesWithContext makes every read from entries fail once ctx is done, so
+// an archive build stuck on a slow source gives up and its temp file is
+// removed. Opening still succeeds so a lenient batch aborts rather than
+// skipping the remaining files.
+func entriesWithContext(ctx context.Context, entries []archiveEntry) []archiveEntry {
+	wrapped := make([]archiveEntry, len(entries))
+	for i, entry := range entries {
+		open := entry.Open
+		entry.Open = func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return &ctxReader{ctx: ctx, ReadCloser: rc}, nil
+		}
+		wrapped[i] = entry
+	}
+	return wrapped
+}
+
+// ctxReader stops reading once its context is done
+type ctxReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// isTimeout reports whether an archive build ran out of time
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// startServer runs e over plain HTTP, or over HTTPS with Let's Encrypt
+// certificates when TLS domains are configured
+func startServer(e *echo.Echo) error {
+	configureServer(e.Server)
+	configureServer(e.TLSServer)
+	if len(config.TLSDomains) == 0 {
+		return e.Start(config.Addr)
+	}
+
+	cacheDir := config.TLSCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(config.DataDir, "autocert")
+	}
+
+	e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(config.TLSDomains...)
+	e.AutoTLSManager.Cache = autocert.DirCache(cacheDir)
+	e.AutoTLSManager.Email = config.TLSEmail
+
+	// The plain HTTP listener answers ACME challenges and redirects everything else
+	if config.HTTPRedirectAddr != "off" {
+		redirect := &http.Server{
+			Addr:    config.HTTPRedirectAddr,
+			Handler: e.AutoTLSManager.HTTPHandler(http.HandlerFunc(redirectToHTTPS)),
+		}
+		configureServer(redirect)
+		go func() {
+			log.Printf("HTTP redirect listener on %s", config.HTTPRedirectAddr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP redirect listener stopped: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Serving HTTPS for %v (certificate cache: %s)", config.TLSDomains, cacheDir)
+	return e.StartAutoTLS(config.TLSAddr)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the TLS listener
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(config.TLSAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net/url"
+)
+
+// shareTokenAlphabet leaves out characters that are easily confused when a
+// link is read out or typed: 0/O, 1/l/I
+const shareTokenAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// shareTokenLength is the number of characters in a share token, giving
+// about 10^14 possible links
+const shareTokenLength = 8
+
+// shareTokens maps share tokens to the download names they stand for; it is
+// guarded by storeMutex along with tempFileStore
+var shareTokens = make(map[string]string)
+
+// newShareToken returns a random share token
+func newShareToken() string {
+	b := make([]byte, shareTokenLength)
+	max := big.NewInt(int64(len(shareTokenAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = shareTokenAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// storeArchiveLocked registers rec under name, giving it a share token
+// unless it already has one; storeMutex must be held
+func storeArchiveLocked(name string, rec archiveRecord) {
+	if old, ok := tempFileStore[name]; ok && old.Token != rec.Token {
+		delete(shareTokens, old.Token)
+	}
+	for rec.Token == "" || (shareTokens[rec.Token] != "" && shareTokens[rec.Token] != name) {
+		rec.Token = newShareToken()
+	}
+	shareTokens[rec.Token] = name
+	tempFileStore[name] = rec
+}
+
+// forgetArchiveLocked removes the archive registered under name and its
+// share token; storeMutex must be held
+func forgetArchiveLocked(name string) {
+	if rec, ok := tempFileStore[name]; ok {
+		delete(shareTokens, rec.Token)
+	}
+	delete(tempFileStore, name)
+}
+
+// resolveShareKey returns the download name a link's path segment refers to.
+// Links carry share tokens; bare download names are only accepted with
+// BULK_NAME_LINKS, for links handed out before tokens existed.
+func resolveShareKey(key string) (string, bool) {
+	storeMutex.Lock()
+	name, ok := shareTokens[key]
+	storeMutex.Unlock()
+	if ok {
+		return name, true
+	}
+	return key, config.NameLinks
+}
+
+// shareKey returns the path segment that links to the archive registered
+// under name: its share token, or the name itself if it has none
+func shareKey(name string) string {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if rec, ok := tempFileStore[name]; ok && rec.Token != "" {
+		return url.PathEscape(rec.Token)
+	}
+	return url.PathEscape(name)
+}
+
+// sharedArchive looks up the unexpired archive a link's path segment refers
+// to. A link disabled after an abuse report isn't ok, but its record is
+// still returned so the caller can say why.
+func sharedArchive(key string) (string, archiveRecord, bool) {
+	name, ok := resolveShareKey(key)
+	if !ok {
+		return "", archiveRecord{}, false
+	}
+	rec, ok := getArchive(name)
+	return name, rec, ok && rec.Disabled == ""
+}
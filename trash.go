@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// trashedArchive is a downloaded archive whose file is kept until PurgeAt, so
+// a link consumed by a scanning proxy rather than the recipient can be restored
+type trashedArchive struct {
+	archiveRecord
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+// trash holds downloaded archives awaiting removal; guarded by storeMutex
+var trash = make(map[string]trashedArchive)
+
+var (
+	errNotTrashed = errors.New("archive is not awaiting removal")
+	errNameTaken  = errors.New("a newer archive has the same name")
+)
+
+// discardArchive keeps a downloaded archive restorable for keep before its
+// file is removed, or removes it right away when keep is zero
+func discardArchive(name string, rec archiveRecord, keep time.Duration) {
+	if keep <= 0 {
+		removeArchiveFile(name, rec.Path)
+		return
+	}
+	now := time.Now()
+	storeMutex.Lock()
+	old, replaced := trash[name]
+	trash[name] = trashedArchive{archiveRecord: rec, DeletedAt: now, PurgeAt: now.Add(keep)}
+	storeMutex.Unlock()
+
+	if replaced && old.Path != rec.Path {
+		removeArchiveFile(name, old.Path)
+	}
+	log.Printf("Archive %s can be restored until %s", name, now.Add(keep).Format(time.RFC3339))
+}
+
+// restoreArchive puts a trashed archive back in the store under its old link.
+// The link is given at least another BULK_RESTORE_WINDOW before it expires.
+func restoreArchive(name string) (archiveRecord, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	now := time.Now()
+	t, ok := trash[name]
+	if !ok || now.After(t.PurgeAt) {
+		return archiveRecord{}, errNotTrashed
+	}
+	if _, taken := tempFileStore[name]; taken {
+		return archiveRecord{}, errNameTaken
+	}
+	rec := t.archiveRecord
+	if until := now.Add(config.RestoreWindow); !rec.ExpiresAt.IsZero() && rec.ExpiresAt.Before(until) {
+		rec.ExpiresAt = until
+	}
+	delete(trash, name)
+	storeArchiveLocked(name, rec)
+	return rec, nil
+}
+
+// trashedByKey looks up the trashed archive a link's path segment refers to
+func trashedByKey(key string) (string, trashedArchive, bool) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	now := time.Now()
+	for name, t := range trash {
+		if now.After(t.PurgeAt) {
+			continue
+		}
+		if (t.Token != "" && t.Token == key) || (config.NameLinks && name == key) {
+			return name, t, true
+		}
+	}
+	return "", trashedArchive{}, false
+}
+
+// takeTrashedLocked removes and returns every trashed archive match selects;
+// storeMutex must be held
+func takeTrashedLocked(match func(name string, t trashedArchive) bool) []storedArchive {
+	var out []storedArchive
+	for name, t := range trash {
+		if match(name, t) {
+			out = append(out, storedArchive{Name: name, archiveRecord: t.archiveRecord})
+			delete(trash, name)
+		}
+	}
+	return out
+}
+
+// takePurgeableArchives removes and returns every trashed archive whose
+// restore window has ended
+func takePurgeableArchives(now time.Time) []storedArchive {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	return takeTrashedLocked(func(_ string, t trashedArchive) bool {
+		return now.After(t.PurgeAt)
+	})
+}
+
+// handleRestoreArchive brings back a downloaded archive through its signed
+// deletion link. GET shows a confirmation page; POST restores the archive.
+func handleRestoreArchive(c echo.Context) error {
+	if c.Request().Method == http.MethodGet && c.QueryParam("restored") != "" {
+		return renderPage(c, "restore_archive.html")
+	}
+
+	name, t, ok := trashedByKey(c.Param("filename"))
+	if !ok {
+		return htmlError(c, http.StatusNotFound, "Archive can no longer be restored")
+	}
+	if !validLinkSignature(c.QueryParam("token"), "delete", name, strconv.FormatInt(t.CreatedAt.UnixNano(), 10)) {
+		log.Printf("Invalid restore token for %s", name)
+		return htmlError(c, http.StatusForbidden, "Error: Invalid restore link")
+	}
+
+	if c.Request().Method == http.MethodGet {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return renderPage(c, "restore_archive.html")
+	}
+
+	if _, err := restoreArchive(name); err != nil {
+		log.Printf("Restoring %s failed: %v", name, err)
+		return htmlError(c, http.StatusConflict, "Archive can no longer be restored")
+	}
+	log.Printf("Archive restored by its creator: %s", name)
+	auditRestore(c, name, "deletion link")
+
+	if c.Request().Header.Get("HX-Request") != "" {
+		return htmlSuccess(c, "Archive restored. The download link works again.")
+	}
+	return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?restored=1")
+}
+
+// adminTrashed is one entry in the admin trash listing
+type adminTrashed struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path,omitempty"`
+	Size      int64     `json:"size"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+// handleAdminListTrash lists downloaded archives that can still be restored
+func handleAdminListTrash(c echo.Context) error {
+	now := time.Now()
+	storeMutex.Lock()
+	out := make([]adminTrashed, 0, len(trash))
+	for name, t := range trash {
+		if now.After(t.PurgeAt) {
+			continue
+		}
+		out = append(out, adminTrashed{
+			ID:        name,
+			Path:      t.Path,
+			Size:      t.Size,
+			Owner:     t.Owner,
+			CreatedAt: t.CreatedAt,
+			DeletedAt: t.DeletedAt,
+			PurgeAt:   t.PurgeAt,
+		})
+	}
+	storeMutex.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].DeletedAt.After(out[j].DeletedAt)
+	})
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleAdminRestoreArchive puts a downloaded archive back under its old link
+func handleAdminRestoreArchive(c echo.Context) error {
+	id := c.Param("id")
+	rec, err := restoreArchive(id)
+	switch {
+	case errors.Is(err, errNotTrashed):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+
+	log.Printf("Archive restored by an admin: %s", id)
+	auditRestore(c, id, "admin")
+	return c.JSON(http.StatusOK, adminArchive{
+		ID:         id,
+		Path:       rec.Path,
+		Size:       rec.Size,
+		CreatedAt:  rec.CreatedAt,
+		ExpiresAt:  rec.ExpiresAt,
+		AgeSeconds: int64(time.Since(rec.CreatedAt).Seconds()),
+	})
+}
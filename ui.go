@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+
+	"github.com/labstack/echo/v4"
+)
+
+// uiSettings is the branding applied to every page and served at /config/ui
+type uiSettings struct {
+	Title       string       `json:"title"`
+	LogoURL     string       `json:"logoUrl,omitempty"`
+	Theme       string       `json:"theme"`
+	AccentColor template.CSS `json:"accentColor,omitempty"`
+	Footer      string       `json:"footer,omitempty"`
+
+	// Whether uploads without a flatten field are flattened
+	Flatten bool `json:"flatten"`
+
+	// CAPTCHA widget shown on the upload form, set by setupCaptcha
+	CaptchaProvider string `json:"captchaProvider,omitempty"`
+	CaptchaSiteKey  string `json:"captchaSiteKey,omitempty"`
+	CaptchaScript   string `json:"-"`
+	CaptchaWidget   string `json:"-"`
+}
+
+// ui holds the branding from the configuration, validated by loadUISettings
+var ui uiSettings
+
+// cssColor matches hex colors and named colors, the only accent values
+// allowed into the inline style
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// loadUISettings validates the branding options
+func loadUISettings() error {
+	switch config.UITheme {
+	case "auto", "light", "dark":
+	default:
+		return fmt.Errorf("unknown UI theme %q, want auto, light or dark", config.UITheme)
+	}
+	if config.UIAccentColor != "" && !cssColor.MatchString(config.UIAccentColor) {
+		return fmt.Errorf("invalid UI accent color %q", config.UIAccentColor)
+	}
+	ui = uiSettings{
+		Title:       config.UITitle,
+		LogoURL:     config.UILogoURL,
+		Theme:       config.UITheme,
+		AccentColor: template.CSS(config.UIAccentColor),
+		Footer:      config.UIFooter,
+		Flatten:     config.Flatten,
+	}
+	return nil
+}
+
+// renderPage renders a full page template with the branding
+func renderPage(c echo.Context, name string) error {
+	return c.Render(http.StatusOK, name, ui)
+}
+
+// handleUIConfig returns the branding for clients that build their own UI
+func handleUIConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, ui)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Michael-Ralph/bulk-download/internal/safeextract"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// archiveKind reports which input format a file name looks like, or "" for
+// files that are not unpacked when merging. 7z needs BULK_7Z_PATH.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return "tar.zst"
+	case strings.HasSuffix(lower, ".rar"):
+		return "rar"
+	case strings.HasSuffix(lower, ".7z") && config.SevenZipPath != "":
+		return "7z"
+	}
+	return ""
+}
+
+// mergeInputFormats lists the archive formats mergeArchives unpacks
+func mergeInputFormats() []string {
+	formats := []string{"rar", "tar", "tar.gz", "tar.zst", "zip"}
+	if config.SevenZipPath != "" {
+		formats = append([]string{"7z"}, formats...)
+	}
+	return formats
+}
+
+// scratchDir is a temporary directory removed when the merge is cleaned up
+type scratchDir string
+
+func (d scratchDir) Close() error { return os.RemoveAll(string(d)) }
+
+// unpackedMember is a file spooled out of a streamed archive
+type unpackedMember struct {
+	name    string
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// unpackArchive extracts a tar, rar or 7z entry into a scratch directory,
+// since those formats cannot be read member by member on demand like a ZIP.
+// budget is the number of bytes the unpacked files may still use.
+func unpackArchive(entry archiveEntry, kind string, budget int64) ([]archiveEntry, scratchDir, error) {
+	limits := inputLimits(budget)
+	tmp, err := os.MkdirTemp(spillDir(), "unpack-*")
+	if err != nil {
+		return nil, "", &archiveError{"Error creating temporary file", err}
+	}
+	dir := scratchDir(tmp)
+
+	var files []string
+	var members []unpackedMember
+	if kind == "7z" {
+		files, members, err = unpack7z(entry, tmp, limits)
+	} else {
+		files, members, err = unpackStream(entry, kind, tmp, safeextract.NewGuard(limits, entry.Size))
+	}
+	if err != nil {
+		dir.Close()
+		return nil, "", err
+	}
+
+	var out []archiveEntry
+	for i, m := range members {
+		file := files[i]
+		info, err := os.Stat(file)
+		if err != nil {
+			dir.Close()
+			return nil, "", &archiveError{fmt.Sprintf("Error reading %s from the archive", m.name), err}
+		}
+		out = append(out, archiveEntry{
+			Name: m.name,
+			Size: info.Size(),
+			Open: func() (io.ReadCloser, error) { return os.Open(file) },
+
+			ModTime: m.modTime,
+			Mode:    m.mode,
+		})
+	}
+	return out, dir, nil
+}
+
+// memberReader yields the regular files of a streamed archive in order
+type memberReader interface {
+	next() (*unpackedMember, int64, io.Reader, error)
+}
+
+// unpackStream spools every regular file of a tar or rar entry to dir
+func unpackStream(entry archiveEntry, kind, dir string, guard *safeextract.Guard) ([]string, []unpackedMember, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return nil, nil, &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), err}
+	}
+	defer src.Close()
+
+	var members memberReader
+	switch kind {
+	case "rar":
+		rr, err := rardecode.NewReader(src)
+		if err != nil {
+			return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid RAR archive", entry.Name), err}
+		}
+		members = rarMembers{entry.Name, rr}
+	case "tar.gz":
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid tar archive", entry.Name), err}
+		}
+		defer gz.Close()
+		members = tarMembers{entry.Name, tar.NewReader(gz)}
+	case "tar.zst":
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid tar archive", entry.Name), err}
+		}
+		defer zr.Close()
+		members = tarMembers{entry.Name, tar.NewReader(zr)}
+	default:
+		members = tarMembers{entry.Name, tar.NewReader(src)}
+	}
+
+	var files []string
+	var unpacked []unpackedMember
+	for {
+		m, size, body, err := members.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, &archiveError{fmt.Sprintf("Error reading %s", entry.Name), err}
+		}
+		if m == nil {
+			continue
+		}
+		if err := guard.Add(0, size); err != nil {
+			return nil, nil, limitError(err)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%05d", len(files)))
+		if err := spoolMember(file, guard.Reader(body)); err != nil {
+			if isLimitError(err) {
+				return nil, nil, limitError(err)
+			}
+			return nil, nil, &archiveError{fmt.Sprintf("Error reading %s from %s", m.name, entry.Name), err}
+		}
+		files = append(files, file)
+		unpacked = append(unpacked, *m)
+	}
+	return files, unpacked, nil
+}
+
+// spoolMember copies body into a new file
+func spoolMember(file string, body io.Reader) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = copyPooled(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type tarMembers struct {
+	archive string
+	r       *tar.Reader
+}
+
+func (t tarMembers) next() (*unpackedMember, int64, io.Reader, error) {
+	hdr, err := t.r.Next()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	mode := hdr.FileInfo().Mode()
+	if hdr.Typeflag == tar.TypeLink {
+		// Hard links look like empty regular files and are rejected as links
+		mode |= fs.ModeSymlink
+	}
+	if mode.IsDir() {
+		return nil, 0, nil, nil
+	}
+	name, ok := checkedMember(t.archive, hdr.Name, mode)
+	if !ok {
+		return nil, 0, nil, nil
+	}
+	return &unpackedMember{name, hdr.ModTime, mode.Perm()}, hdr.Size, t.r, nil
+}
+
+type rarMembers struct {
+	archive string
+	r       *rardecode.Reader
+}
+
+func (r rarMembers) next() (*unpackedMember, int64, io.Reader, error) {
+	hdr, err := r.r.Next()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if hdr.IsDir {
+		return nil, 0, nil, nil
+	}
+	mode := hdr.Mode()
+	if hdr.LinkType != 0 {
+		// Symbolic links, hard links and junctions all carry a LinkType
+		mode |= fs.ModeSymlink
+	}
+	name, ok := checkedMember(r.archive, hdr.Name, mode)
+	if !ok {
+		return nil, 0, nil, nil
+	}
+	var size int64
+	if !hdr.UnKnownSize {
+		size = hdr.UnPackedSize
+	}
+	return &unpackedMember{name, hdr.ModificationTime, mode.Perm()}, size, r.r, nil
+}
+
+// unpack7z extracts a 7z entry with the configured 7z binary. The listing is
+// checked against limits before anything is extracted, and the extracted
+// files again afterwards. Only regular files are kept, so links the archive
+// creates are ignored.
+func unpack7z(entry archiveEntry, dir string, limits safeextract.Limits) ([]string, []unpackedMember, error) {
+	src := filepath.Join(dir, "in.7z")
+	body, err := entry.Open()
+	if err != nil {
+		return nil, nil, &archiveError{fmt.Sprintf("Error opening file: %s", entry.Name), err}
+	}
+	err = spoolMember(src, body)
+	body.Close()
+	if err != nil {
+		return nil, nil, &archiveError{"Error creating temporary file", err}
+	}
+
+	listing, err := run7z(dir, dir, "l", "-slt", "-bd", "--", src)
+	if err != nil {
+		return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid 7z archive", entry.Name), err}
+	}
+	if err := check7zListing(listing, safeextract.NewGuard(limits, entry.Size)); err != nil {
+		if isLimitError(err) {
+			return nil, nil, limitError(err)
+		}
+		return nil, nil, &archiveError{"Archive contains unsafe paths or links", err}
+	}
+
+	out := filepath.Join(dir, "out")
+	if _, err := run7z(dir, dir, "x", "-y", "-bd", "-o"+out, "--", src); err != nil {
+		return nil, nil, &archiveError{fmt.Sprintf("%s is not a valid 7z archive", entry.Name), err}
+	}
+	os.Remove(src)
+
+	guard := safeextract.NewGuard(limits, entry.Size)
+	var files []string
+	var members []unpackedMember
+	err = filepath.WalkDir(out, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(out, file)
+		if err != nil {
+			return err
+		}
+		name, ok := checkedMember(entry.Name, filepath.ToSlash(rel), d.Type())
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := guard.Add(0, info.Size()); err != nil {
+			return err
+		}
+		files = append(files, file)
+		members = append(members, unpackedMember{name, info.ModTime(), info.Mode().Perm()})
+		return nil
+	})
+	if isLimitError(err) {
+		return nil, nil, limitError(err)
+	}
+	if err != nil {
+		return nil, nil, &archiveError{fmt.Sprintf("Error reading %s", entry.Name), err}
+	}
+	return files, members, nil
+}
+
+// check7zListing adds the files of a `7z l -slt` listing to guard. Entries
+// follow the "----------" separator as blocks of "Key = value" lines. Since
+// `7z x` writes members before anything else sees them, a path that escapes
+// the output directory or a link, which a later member could be written
+// through, rejects the whole archive here.
+func check7zListing(listing []byte, guard *safeextract.Guard) error {
+	_, entries, ok := strings.Cut(string(listing), "\n----------\n")
+	if !ok {
+		return nil
+	}
+	for _, block := range strings.Split(entries, "\n\n") {
+		var size int64
+		var folder, seen bool
+		for _, line := range strings.Split(block, "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), " = ")
+			switch {
+			case !ok:
+			case key == "Path":
+				if _, err := safeextract.Clean(value); err != nil {
+					return fmt.Errorf("%w: %q", err, value)
+				}
+				seen = true
+			case key == "Attributes":
+				if err := check7zAttributes(value); err != nil {
+					return fmt.Errorf("%w: %q", err, value)
+				}
+			case key == "Size":
+				size, _ = strconv.ParseInt(value, 10, 64)
+			case key == "Folder":
+				folder = value == "+"
+			}
+		}
+		if !seen || folder {
+			continue
+		}
+		if err := guard.Add(0, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check7zAttributes rejects the attributes of a link or special file: the
+// Windows reparse point flag, or a Unix mode such as "lrwxrwxrwx" after the
+// flags, as in "A_ -rw-r--r--"
+func check7zAttributes(attrs string) error {
+	flags, mode, _ := strings.Cut(attrs, " ")
+	if strings.Contains(flags, "L") {
+		return safeextract.ErrLink
+	}
+	if mode == "" {
+		return nil
+	}
+	switch mode[0] {
+	case '-', 'd':
+		return nil
+	case 'l':
+		return safeextract.ErrLink
+	}
+	return safeextract.ErrSpecial
+}
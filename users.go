@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionCookie is the name of the signed cookie identifying a logged-in user
+const sessionCookie = "bulk_session"
+
+// Keys under which loadSession stores the current user on the echo context
+const (
+	userContextKey   = "user"
+	groupsContextKey = "groups"
+)
+
+// userAccount is an entry in the users file
+type userAccount struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"`
+
+	// Limits replacing the BULK_QUOTA_* defaults for this account
+	Quota *quotaLimits `json:"quota,omitempty"`
+}
+
+// userAccounts maps usernames to their accounts, loaded once at startup
+var userAccounts = make(map[string]userAccount)
+
+// sessionKey signs session cookies
+var sessionKey []byte
+
+// loadUsers reads the users file and prepares the session signing key
+func loadUsers() error {
+	if config.SessionSecret != "" {
+		sessionKey = []byte(config.SessionSecret)
+	} else {
+		// Without a configured secret sessions only last until the next restart
+		sessionKey = make([]byte, 32)
+		rand.Read(sessionKey)
+	}
+
+	if config.UsersFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config.UsersFile)
+	if err != nil {
+		return fmt.Errorf("reading users file: %w", err)
+	}
+	var accounts []userAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("parsing users file: %w", err)
+	}
+	for _, a := range accounts {
+		if strings.HasPrefix(a.Username, oidcUserPrefix) {
+			return fmt.Errorf("users file: username %q uses the %s prefix reserved for SSO users", a.Username, oidcUserPrefix)
+		}
+		userAccounts[a.Username] = a
+	}
+	log.Printf("Loaded %d user accounts from %s", len(userAccounts), config.UsersFile)
+	return nil
+}
+
+// sessionPayload is the signed content of a session cookie
+type sessionPayload struct {
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Expires int64    `json:"e"`
+}
+
+// signSession encodes and signs a session for user
+func signSession(user string, groups []string, expires time.Time) string {
+	payload, _ := json.Marshal(sessionPayload{User: user, Groups: groups, Expires: expires.Unix()})
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySession decodes a valid, unexpired session cookie value
+func verifySession(value string) (sessionPayload, error) {
+	body, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return sessionPayload{}, errors.New("malformed session")
+	}
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(body))
+	want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return sessionPayload{}, errors.New("invalid session signature")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return sessionPayload{}, errors.New("malformed session")
+	}
+	var p sessionPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return sessionPayload{}, errors.New("malformed session")
+	}
+	if time.Now().Unix() > p.Expires {
+		return sessionPayload{}, errors.New("session expired")
+	}
+	return p, nil
+}
+
+// loadSession is middleware that records the logged-in user, if any, on the context
+func loadSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			if session, err := verifySession(cookie.Value); err == nil {
+				c.Set(userContextKey, session.User)
+				c.Set(groupsContextKey, session.Groups)
+			}
+		}
+		return next(c)
+	}
+}
+
+// currentUser returns the logged-in username, or "" for anonymous requests
+func currentUser(c echo.Context) string {
+	user, _ := c.Get(userContextKey).(string)
+	return user
+}
+
+// currentGroups returns the identity provider groups of the logged-in user
+func currentGroups(c echo.Context) []string {
+	groups, _ := c.Get(groupsContextKey).([]string)
+	return groups
+}
+
+// startSession sets the session cookie for user
+func startSession(c echo.Context, user string, groups []string) {
+	expires := time.Now().Add(config.SessionTTL)
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    signSession(user, groups, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   len(config.TLSDomains) > 0,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// serveLogin renders the login page, going straight to SSO when there are no local accounts
+func serveLogin(c echo.Context) error {
+	if oidcProvider != nil && len(userAccounts) == 0 {
+		return c.Redirect(http.StatusFound, "/auth/oidc/login")
+	}
+	return renderPage(c, "login.html")
+}
+
+// requireLogin is middleware that sends anonymous visitors to the login page
+func requireLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if currentUser(c) != "" {
+			return next(c)
+		}
+		if c.Request().Method == http.MethodGet && c.Request().Header.Get("HX-Request") == "" {
+			return c.Redirect(http.StatusFound, "/login")
+		}
+		c.Response().Header().Set("HX-Redirect", "/login")
+		return htmlError(c, http.StatusUnauthorized, "Error: Please log in first")
+	}
+}
+
+// handleLogin checks a username and password and starts a session
+func handleLogin(c echo.Context) error {
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+
+	account, ok := userAccounts[username]
+	if !ok || bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		log.Printf("Failed login for %q", username)
+		return htmlError(c, http.StatusUnauthorized, "Error: Invalid username or password")
+	}
+
+	startSession(c, username, nil)
+	log.Printf("User logged in: %s", username)
+
+	// HTMX follows HX-Redirect; plain form posts follow the Location header
+	c.Response().Header().Set("HX-Redirect", "/")
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// handleLogout clears the session cookie
+func handleLogout(c echo.Context) error {
+	c.SetCookie(&http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	c.Response().Header().Set("HX-Redirect", "/")
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// handleMyArchives returns an HTML fragment listing the current user's archives
+func handleMyArchives(c echo.Context) error {
+	user := currentUser(c)
+	if user == "" {
+		return c.Render(http.StatusOK, "my_archives", map[string]interface{}{"User": ""})
+	}
+
+	type ownedArchive struct {
+		storedArchive
+		URL string
+	}
+	var owned []ownedArchive
+	for _, a := range listArchives() {
+		if a.Owner == user {
+			owned = append(owned, ownedArchive{a, sharePath(a.Name)})
+		}
+	}
+	return c.Render(http.StatusOK, "my_archives", map[string]interface{}{"User": user, "Archives": owned})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchProcessedDir is where ingested items are moved, inside the watch folder
+const watchProcessedDir = ".processed"
+
+// watchFolder archives what is dropped into a directory once it has been
+// quiet for the settle period: each new subdirectory becomes one archive and
+// loose files arriving together become one batch
+type watchFolder struct {
+	dir     string
+	settle  time.Duration
+	watcher *fsnotify.Watcher
+
+	mu sync.Mutex
+	// Top-level item name to the time of its latest change
+	pending map[string]time.Time
+}
+
+// startWatchFolder watches config.WatchDir until stop is closed
+func startWatchFolder(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &watchFolder{
+		dir:     config.WatchDir,
+		settle:  config.WatchSettle,
+		watcher: watcher,
+		pending: make(map[string]time.Time),
+	}
+	if err := os.MkdirAll(filepath.Join(w.dir, watchProcessedDir), 0o750); err != nil {
+		watcher.Close()
+		return err
+	}
+	if err := watcher.Add(w.dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// Pick up whatever arrived while the server was down
+	items, err := os.ReadDir(w.dir)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	now := time.Now()
+	for _, item := range items {
+		if item.Name() == watchProcessedDir {
+			continue
+		}
+		w.pending[item.Name()] = now
+		if item.IsDir() {
+			w.addTree(filepath.Join(w.dir, item.Name()))
+		}
+	}
+
+	log.Printf("Watching %s for new files (settle time %s)", w.dir, w.settle)
+	go w.run(stop)
+	return nil
+}
+
+// run dispatches file system events and flushes settled items
+func (w *watchFolder) run(stop <-chan struct{}) {
+	defer w.watcher.Close()
+	interval := w.settle / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handleEvent(ev)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watch folder error: %v", err)
+		case <-ticker.C:
+			w.flush()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleEvent marks the top-level item containing the changed path as active
+func (w *watchFolder) handleEvent(ev fsnotify.Event) {
+	rel, err := filepath.Rel(w.dir, ev.Name)
+	if err != nil || rel == "." {
+		return
+	}
+	top := strings.Split(filepath.ToSlash(rel), "/")[0]
+	if top == watchProcessedDir || strings.HasPrefix(top, ".") {
+		return
+	}
+
+	// New directories need their own watches to see the files written into them
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			w.addTree(ev.Name)
+		}
+	}
+
+	w.mu.Lock()
+	w.pending[top] = time.Now()
+	w.mu.Unlock()
+}
+
+// addTree watches dir and every directory below it
+func (w *watchFolder) addTree(dir string) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			if err := w.watcher.Add(p); err != nil {
+				log.Printf("Cannot watch %s: %v", p, err)
+			}
+		}
+		return nil
+	})
+}
+
+// removeTree drops the watches on dir and the directories below it
+func (w *watchFolder) removeTree(dir string) {
+	for _, p := range w.watcher.WatchList() {
+		if p == dir || strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			w.watcher.Remove(p)
+		}
+	}
+}
+
+// flush archives every item that has been quiet for the settle period. Loose
+// files are only archived once all pending files have settled, so a batch
+// copied in together ends up in one archive.
+func (w *watchFolder) flush() {
+	now := time.Now()
+	var dirs, files []string
+	filesSettled := true
+
+	w.mu.Lock()
+	for name, last := range w.pending {
+		info, err := os.Stat(filepath.Join(w.dir, name))
+		if err != nil {
+			delete(w.pending, name) // removed before it settled
+			continue
+		}
+		settled := now.Sub(last) >= w.settle
+		switch {
+		case info.IsDir() && settled:
+			dirs = append(dirs, name)
+			delete(w.pending, name)
+		case !info.IsDir():
+			files = append(files, name)
+			filesSettled = filesSettled && settled
+		}
+	}
+	if filesSettled {
+		for _, name := range files {
+			delete(w.pending, name)
+		}
+	} else {
+		files = nil
+	}
+	w.mu.Unlock()
+
+	for _, name := range dirs {
+		w.ingest(name, []string{name})
+	}
+	if len(files) > 0 {
+		sort.Strings(files)
+		base := "batch"
+		if len(files) == 1 {
+			base = strings.TrimSuffix(files[0], filepath.Ext(files[0]))
+		}
+		w.ingest(base, files)
+	}
+}
+
+// ingest archives the named top-level items under base and moves them out of the way
+func (w *watchFolder) ingest(base string, items []string) {
+	var entries []archiveEntry
+	var totalSize int64
+	for _, item := range items {
+		root := filepath.Join(w.dir, item)
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			name, _ := filepath.Rel(root, p)
+			if name == "." {
+				name = d.Name()
+			}
+			entries = append(entries, archiveEntry{
+				Name: filepath.ToSlash(name),
+				Size: info.Size(),
+				Open: func() (io.ReadCloser, error) { return os.Open(p) },
+
+				ModTime: info.ModTime(),
+				Mode:    info.Mode(),
+			})
+			totalSize += info.Size()
+			return nil
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	release, err := reserveDisk(totalSize)
+	if err != nil {
+		log.Printf("Watch folder: cannot archive %s: %v", base, err)
+		return
+	}
+	defer release()
+
+	releaseWorker, _ := compressWorkers.acquire(context.Background(), priorityBatch)
+	result, err := createArchive(context.Background(), entries, archiveOptions{Dedup: config.DedupUploads, BaseName: base, Priority: priorityBatch}, nil)
+	releaseWorker()
+	if err != nil {
+		log.Printf("Watch folder: archiving %s failed: %v", base, err)
+		return
+	}
+
+	// Move the originals aside so they aren't ingested again, and leave the
+	// download link next to them
+	done := filepath.Join(w.dir, watchProcessedDir, strings.TrimSuffix(result.Name, ".zip"))
+	if err := os.MkdirAll(done, 0o750); err != nil {
+		log.Printf("Watch folder: %v", err)
+	}
+	for _, item := range items {
+		w.removeTree(filepath.Join(w.dir, item))
+		if err := os.Rename(filepath.Join(w.dir, item), filepath.Join(done, item)); err != nil {
+			log.Printf("Watch folder: moving %s: %v", item, err)
+		}
+	}
+	link := downloadPath(result.Name)
+	if config.PublicURL != "" {
+		link = strings.TrimSuffix(config.PublicURL, "/") + link
+	}
+	os.WriteFile(filepath.Join(done, "download-link.txt"), []byte(link+"\n"), 0o640)
+	log.Printf("Watch folder: archived %d files from %s as %s", len(entries), strings.Join(items, ", "), link)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/bytes"
+	"golang.org/x/net/websocket"
+)
+
+// wsMaxChunk caps one binary frame of a WebSocket upload
+const wsMaxChunk = 1 << 20
+
+// wsMessage is a text frame of the WebSocket upload protocol. Clients send
+// "file" to announce a file and then its bytes as binary frames; the server
+// answers "ready" (or "stored" straight away when it already holds the
+// content), "ack" after every chunk it has written, "stored" once the file is
+// complete and "error" when it gives up on a file.
+type wsMessage struct {
+	Type     string `json:"type"`
+	Name     string `json:"name,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Received int64  `json:"received,omitempty"`
+
+	// Set on "stored", as for PUT /api/v1/files
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	Files        int    `json:"files,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// wsFrame is one received frame and whether it was binary
+type wsFrame struct {
+	binary bool
+	data   []byte
+}
+
+// wsFrames reads raw frames, keeping their type
+var wsFrames = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		*v.(*wsFrame) = wsFrame{binary: payloadType == websocket.BinaryFrame, data: data}
+		return nil
+	},
+}
+
+// errChunkTooLong is sent when a chunk runs past the size the file was announced with
+var errChunkTooLong = errors.New("Chunk exceeds the announced file size")
+
+// handleUploadSocket streams files into an upload session over a WebSocket.
+// Each binary chunk is written to the spool before it is acknowledged, so a
+// client that waits for acks never has more in flight than it chose to.
+func handleUploadSocket(c echo.Context) error {
+	id, owner := c.Param("id"), currentUser(c)
+	if _, err := getUploadSession(id, owner); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	server := websocket.Server{
+		Handshake: checkSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = wsMaxChunk
+			if err := receiveUploads(ws, id, owner); err != nil && !errors.Is(err, io.EOF) {
+				log.Printf("WebSocket upload to session %s ended: %v", id, err)
+			}
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// checkSocketOrigin refuses WebSocket handshakes from pages on other sites,
+// which browsers would otherwise let ride on the user's cookies
+func checkSocketOrigin(cfg *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host == r.Host || slices.Contains(config.CORSOrigins, origin) || slices.Contains(config.CORSOrigins, "*") {
+		return nil
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+// receiveUploads runs the upload protocol until the client closes the socket
+func receiveUploads(ws *websocket.Conn, id, owner string) error {
+	var current *socketFile
+	defer func() {
+		if current != nil {
+			current.abort(io.ErrUnexpectedEOF)
+		}
+	}()
+	for {
+		if config.IdleTimeout > 0 {
+			ws.SetReadDeadline(time.Now().Add(config.IdleTimeout))
+		}
+		var frame wsFrame
+		if err := wsFrames.Receive(ws, &frame); err != nil {
+			return err
+		}
+
+		if frame.binary {
+			// Chunks still in flight after a failed file are dropped
+			if current == nil {
+				continue
+			}
+			reply, done := current.write(frame.data)
+			if done {
+				current = nil
+			}
+			if err := websocket.JSON.Send(ws, reply); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(frame.data, &msg); err != nil || msg.Type != "file" {
+			return websocket.JSON.Send(ws, wsMessage{Type: "error", Message: "Expected a file message"})
+		}
+		if current != nil {
+			current.abort(io.ErrUnexpectedEOF)
+			current = nil
+		}
+		reply, f := startSocketFile(id, owner, msg)
+		current = f
+		if err := websocket.JSON.Send(ws, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// socketFile is a file being received over a WebSocket, piped into stageFile
+type socketFile struct {
+	name     string
+	size     int64
+	received int64
+	pw       *io.PipeWriter
+	result   chan stageResult
+}
+
+// stageResult is what stageFile returned for a socket upload
+type stageResult struct {
+	file  stagedFile
+	count int
+	err   error
+}
+
+// startSocketFile begins staging the announced file. It returns the reply to
+// send and, when chunks should follow, the file to write them to.
+func startSocketFile(id, owner string, msg wsMessage) (wsMessage, *socketFile) {
+	name, err := safeMemberName(msg.Name)
+	if err != nil {
+		return wsMessage{Type: "error", Name: msg.Name, Message: "Invalid file name"}, nil
+	}
+	sum := strings.ToLower(msg.SHA256)
+	if sum != "" && !validSHA256(sum) {
+		return wsMessage{Type: "error", Name: name, Message: "Invalid sha256"}, nil
+	}
+	if msg.Size < 0 {
+		return wsMessage{Type: "error", Name: name, Message: "Invalid size"}, nil
+	}
+	limit := config.MaxUploadSize
+	if config.MaxFileSize > 0 && config.MaxFileSize < limit {
+		limit = config.MaxFileSize
+	}
+	if msg.Size > limit {
+		return wsMessage{Type: "error", Name: name, Message: fmt.Sprintf("File %s is too large (max %s)", name, bytes.Format(limit))}, nil
+	}
+
+	pr, pw := io.Pipe()
+	f := &socketFile{name: name, size: msg.Size, pw: pw, result: make(chan stageResult, 1)}
+	ready := make(chan struct{})
+	body := &firstReadSignal{r: pr, ready: ready}
+	go func() {
+		sf, count, err := stageFile(id, owner, name, body, sum)
+		pr.CloseWithError(err)
+		f.result <- stageResult{sf, count, err}
+	}()
+
+	// An empty file has no chunks to wait for
+	if msg.Size == 0 {
+		pw.Close()
+		return f.stored(), nil
+	}
+	select {
+	case <-ready:
+		return wsMessage{Type: "ready", Name: name, Size: msg.Size}, f
+	case res := <-f.result:
+		f.result <- res
+		return f.stored(), nil
+	}
+}
+
+// write passes one chunk on and returns the reply, with done set once the
+// file is finished or has failed
+func (f *socketFile) write(chunk []byte) (wsMessage, bool) {
+	if int64(len(chunk)) > f.size-f.received {
+		f.abort(errChunkTooLong)
+		<-f.result
+		return wsMessage{Type: "error", Name: f.name, Message: errChunkTooLong.Error()}, true
+	}
+	if _, err := f.pw.Write(chunk); err != nil {
+		return f.stored(), true
+	}
+	f.received += int64(len(chunk))
+	if f.received < f.size {
+		return wsMessage{Type: "ack", Name: f.name, Received: f.received}, false
+	}
+	f.pw.Close()
+	return f.stored(), true
+}
+
+// stored waits for stageFile and reports its outcome
+func (f *socketFile) stored() wsMessage {
+	res := <-f.result
+	if res.err != nil {
+		var limitErr stagingLimitError
+		msg := "Error storing uploaded data"
+		switch {
+		case errors.Is(res.err, errSessionNotFound), errors.Is(res.err, errHashMismatch), errors.As(res.err, &limitErr):
+			msg = res.err.Error()
+		default:
+			log.Printf("Error staging %s: %v", f.name, res.err)
+		}
+		return wsMessage{Type: "error", Name: f.name, Message: msg}
+	}
+	return wsMessage{
+		Type:         "stored",
+		Name:         res.file.Name,
+		Size:         res.file.Size,
+		SHA256:       res.file.SHA256,
+		Deduplicated: res.file.reused,
+		Files:        res.count,
+	}
+}
+
+// abort stops staging the file, discarding what was spooled
+func (f *socketFile) abort(err error) {
+	f.pw.CloseWithError(err)
+}
+
+// firstReadSignal closes ready the first time the body is read, which tells
+// the protocol that stageFile wants the chunks
+type firstReadSignal struct {
+	r     io.Reader
+	ready chan struct{}
+	once  sync.Once
+}
+
+func (s *firstReadSignal) Read(p []byte) (int, error) {
+	s.once.Do(func() { close(s.ready) })
+	return s.r.Read(p)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// WinZip AES (AE-2) constants, see https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES      = 99
+	zipExtraAES       = 0x9901
+	zipAESKeySize     = 32 // AES-256
+	zipAESSaltSize    = 16
+	zipAESIterations  = 1000
+	zipAESAuthCodeLen = 10
+)
+
+// writeEncryptedEntry adds src to zw as a WinZip AES-256 encrypted entry,
+// compressed with method (zip.Store or zip.Deflate) at the given flate level.
+// The ciphertext is staged in a temp file because the local header needs the
+// final size up front.
+func writeEncryptedEntry(zw *zip.Writer, fh zip.FileHeader, src io.Reader, password string, method uint16, level int) error {
+	salt := make([]byte, zipAESSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	keys := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+2, sha1.New)
+	encKey, authKey, verifier := keys[:zipAESKeySize], keys[zipAESKeySize:2*zipAESKeySize], keys[2*zipAESKeySize:]
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return err
+	}
+
+	staging, err := os.CreateTemp(spillDir(), "zipaes-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		staging.Close()
+		os.Remove(staging.Name())
+	}()
+
+	enc := &zipAESWriter{w: staging, block: block, mac: hmac.New(sha1.New, authKey)}
+	var uncompressed countingWriter
+	var sink io.Writer = enc
+	var fw *flate.Writer
+	if method == zip.Deflate {
+		if fw, err = flate.NewWriter(enc, level); err != nil {
+			return err
+		}
+		sink = fw
+	}
+	if _, err := copyPooled(io.MultiWriter(sink, &uncompressed), src); err != nil {
+		return err
+	}
+	if fw != nil {
+		if err := fw.Close(); err != nil {
+			return err
+		}
+	}
+
+	// AE-2 entries carry no CRC; integrity comes from the HMAC instead
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2
+	copy(extra[6:], "AE")
+	extra[8] = 3 // AES-256
+	binary.LittleEndian.PutUint16(extra[9:], method)
+
+	fh.Method = zipMethodAES
+	fh.Flags |= 0x1
+	fh.CRC32 = 0
+	fh.Extra = append(fh.Extra, extra...)
+	fh.UncompressedSize64 = uint64(uncompressed)
+	fh.CompressedSize64 = uint64(zipAESSaltSize + len(verifier) + int(enc.n) + zipAESAuthCodeLen)
+
+	w, err := zw.CreateRaw(&fh)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return err
+	}
+	if _, err := w.Write(verifier); err != nil {
+		return err
+	}
+	if _, err := staging.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := copyPooled(w, staging); err != nil {
+		return err
+	}
+	_, err = w.Write(enc.mac.Sum(nil)[:zipAESAuthCodeLen])
+	return err
+}
+
+// zipAESWriter encrypts with AES in CTR mode using WinZip's little-endian
+// counter starting at 1, and MACs the ciphertext
+type zipAESWriter struct {
+	w       io.Writer
+	block   cipher.Block
+	mac     hash.Hash
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // bytes of stream consumed
+	n       int64
+	started bool
+}
+
+func (z *zipAESWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i := range p {
+		if !z.started || z.used == aes.BlockSize {
+			z.nextBlock()
+		}
+		buf[i] = p[i] ^ z.stream[z.used]
+		z.used++
+	}
+	z.mac.Write(buf)
+	n, err := z.w.Write(buf)
+	z.n += int64(n)
+	return n, err
+}
+
+// nextBlock increments the little-endian counter and encrypts it into the keystream
+func (z *zipAESWriter) nextBlock() {
+	z.started = true
+	for i := range z.counter {
+		z.counter[i]++
+		if z.counter[i] != 0 {
+			break
+		}
+	}
+	z.block.Encrypt(z.stream[:], z.counter[:])
+	z.used = 0
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}